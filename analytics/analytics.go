@@ -0,0 +1,245 @@
+package analytics
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/metrics"
+	"github.com/krau/btts/types"
+)
+
+const (
+	SourceBot          = "bot"
+	SourceInline       = "inline"
+	SourceSubBot       = "subbot"
+	SourceSubBotInline = "subbot_inline"
+	SourceAPI          = "api"
+	SourceAPIExport    = "api_export"
+)
+
+// UserRequester 返回 telegram 用户作为搜索者的标识, 开启用户哈希时使用 ID 的哈希.
+// userID 为 0 (如频道中的消息) 时返回 "anonymous"
+func UserRequester(userID int64) string {
+	if userID == 0 {
+		return "anonymous"
+	}
+	id := strconv.FormatInt(userID, 10)
+	if config.C().Analytics.HashUsers {
+		id = hash(id)[:16]
+	}
+	return "user:" + id
+}
+
+// APIKeyRequester 返回 API key 作为搜索者的标识, key 为 nil 时表示 master key
+func APIKeyRequester(key *database.ApiKey) string {
+	if key == nil {
+		return "apikey:master"
+	}
+	return "apikey:" + strconv.FormatUint(uint64(key.ID), 10)
+}
+
+type filters struct {
+	Types        []string `json:"types,omitempty"`
+	Users        []string `json:"users,omitempty"`
+	DisableOcred bool     `json:"disable_ocred,omitempty"`
+}
+
+// RecordSearch 在后台记录一次搜索, 失败时仅记录错误, 不影响调用方.
+// 翻页 (req.Offset > 0) 不记录, 以免同一次搜索被重复统计, 但仍计入 metrics 中的搜索耗时
+func RecordSearch(ctx context.Context, source, requester string, req types.SearchRequest, resp *types.SearchResponse) {
+	if resp != nil {
+		metrics.ObserveSearch(source, resp.ProcessingTimeMs)
+	}
+	if config.C().Analytics.Disable || resp == nil || req.Offset > 0 {
+		return
+	}
+	saveSearchLog(log.FromContext(ctx), newSearchLog(source, requester, req, resp))
+}
+
+// inline 查询在用户输入时每次按键都会触发, 同一用户在 inlineDebounce 内的连续查询只记录最后一次
+const inlineDebounce = 3 * time.Second
+
+type pendingSearch struct {
+	timer *time.Timer
+	entry *database.SearchLog
+}
+
+var (
+	pendingInline   = make(map[string]*pendingSearch)
+	pendingInlineMu sync.Mutex
+)
+
+// RecordInlineSearch 记录一次 inline 搜索. 与 RecordSearch 不同, 用户停止输入 inlineDebounce 后才记录最后一次查询,
+// 以免输入过程中的每个前缀都被统计为一次搜索, 空查询不记录
+func RecordInlineSearch(ctx context.Context, source, requester string, req types.SearchRequest, resp *types.SearchResponse) {
+	if resp != nil {
+		metrics.ObserveSearch(source, resp.ProcessingTimeMs)
+	}
+	if config.C().Analytics.Disable || resp == nil || req.Offset > 0 || normalizeQuery(req.Query) == "" {
+		return
+	}
+	entry := newSearchLog(source, requester, req, resp)
+	logger := log.FromContext(ctx)
+	key := source + "\x00" + requester
+	pendingInlineMu.Lock()
+	defer pendingInlineMu.Unlock()
+	if prev, ok := pendingInline[key]; ok {
+		prev.timer.Stop()
+	}
+	p := &pendingSearch{entry: entry}
+	p.timer = time.AfterFunc(inlineDebounce, func() {
+		pendingInlineMu.Lock()
+		if pendingInline[key] == p {
+			delete(pendingInline, key)
+		}
+		pendingInlineMu.Unlock()
+		saveSearchLog(logger, p.entry)
+	})
+	pendingInline[key] = p
+}
+
+func newSearchLog(source, requester string, req types.SearchRequest, resp *types.SearchResponse) *database.SearchLog {
+	query := normalizeQuery(req.Query)
+	entry := &database.SearchLog{
+		CreatedAt: time.Now(),
+		Source:    source,
+		Requester: requester,
+		QueryHash: hash(query),
+		Hits:      max(resp.EstimatedTotalHits, int64(len(resp.Hits))),
+		LatencyMs: resp.ProcessingTimeMs,
+	}
+	if !config.C().Analytics.HashQueries {
+		entry.Query = query
+	}
+	switch {
+	case req.AllChats:
+	case req.ChatID != 0:
+		entry.ChatID, entry.ChatCount = req.ChatID, 1
+	case len(req.ChatIDs) == 1:
+		entry.ChatID, entry.ChatCount = req.ChatIDs[0], 1
+	default:
+		entry.ChatCount = len(req.ChatIDs)
+	}
+	f := filters{DisableOcred: req.DisableOcred}
+	for _, t := range req.TypeFilters {
+		f.Types = append(f.Types, types.MessageTypeToString[t])
+	}
+	for _, userID := range req.UserFilters {
+		// 与搜索者使用相同的表示, 以便开启用户哈希时不泄露被筛选的用户
+		f.Users = append(f.Users, strings.TrimPrefix(UserRequester(userID), "user:"))
+	}
+	if len(f.Types) > 0 || len(f.Users) > 0 || f.DisableOcred {
+		data, err := json.Marshal(f)
+		if err == nil {
+			entry.Filters = string(data)
+		}
+	}
+	return entry
+}
+
+// saveSearchLog 在后台写入搜索记录.
+// 调用方的 ctx 可能在返回后被回收 (如 fiber 的请求上下文), 因此只传入 logger
+func saveSearchLog(logger *log.Logger, entry *database.SearchLog) {
+	go func() {
+		if err := database.CreateSearchLog(context.Background(), entry); err != nil {
+			logger.Error("Failed to record search log", "source", entry.Source, "error", err)
+		}
+	}()
+}
+
+// normalizeQuery 将查询转为小写并合并空白, 使只有大小写或空格不同的查询被视为同一个
+func normalizeQuery(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+// DisplayQuery 返回统计中显示的查询, 只保存了哈希的查询显示为哈希前缀
+func DisplayQuery(q *database.SearchQueryStat) string {
+	switch {
+	case q.QueryHash == hash(""):
+		return "(empty)"
+	case q.Query != "":
+		return q.Query
+	}
+	return "#" + q.QueryHash[:min(8, len(q.QueryHash))]
+}
+
+func hash(s string) string {
+	salt := config.C().Analytics.Salt
+	if salt == "" {
+		salt = config.C().BotToken
+	}
+	sum := sha256.Sum256([]byte(salt + "\x00" + s))
+	return hex.EncodeToString(sum[:])
+}
+
+// Report 是一段时间内的搜索统计
+type Report struct {
+	Since             time.Time                   `json:"since"`
+	Summary           *database.SearchSummary     `json:"summary"`
+	TopQueries        []*database.SearchQueryStat `json:"top_queries"`
+	ZeroResultQueries []*database.SearchQueryStat `json:"zero_result_queries"`
+	Chats             []*database.SearchChatStat  `json:"chats"`
+}
+
+// GetReport 统计最近 days 天的搜索, 每个列表最多 limit 项
+func GetReport(ctx context.Context, days, limit int) (*Report, error) {
+	since := time.Now().AddDate(0, 0, -days)
+	summary, err := database.GetSearchSummary(ctx, since)
+	if err != nil {
+		return nil, err
+	}
+	top, err := database.GetTopSearchQueries(ctx, since, false, limit)
+	if err != nil {
+		return nil, err
+	}
+	zero, err := database.GetTopSearchQueries(ctx, since, true, limit)
+	if err != nil {
+		return nil, err
+	}
+	chats, err := database.GetSearchChatStats(ctx, since, limit)
+	if err != nil {
+		return nil, err
+	}
+	return &Report{
+		Since:             since,
+		Summary:           summary,
+		TopQueries:        top,
+		ZeroResultQueries: zero,
+		Chats:             chats,
+	}, nil
+}
+
+// StartRetention 定期清理超过保留期限的搜索记录
+func StartRetention(ctx context.Context) {
+	days := config.C().Analytics.RetentionDays
+	if days <= 0 {
+		return
+	}
+	retention := time.Duration(days) * 24 * time.Hour
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			deleted, err := database.DeleteSearchLogsBefore(ctx, time.Now().Add(-retention))
+			if err != nil {
+				log.FromContext(ctx).Error("Failed to clean up search logs", "error", err)
+			} else if deleted > 0 {
+				log.FromContext(ctx).Info("Cleaned up search logs", "deleted", deleted)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
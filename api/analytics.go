@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/analytics"
+)
+
+// GetAnalytics 查询搜索统计
+//
+//	@Summary		查询搜索统计
+//	@Description	统计最近一段时间的热门查询、无结果查询和各聊天的搜索次数, 需要 master API key
+//	@Tags			Analytics
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			days	query		int		false	"统计最近的天数, 默认 7"
+//	@Param			limit	query		int		false	"每个列表的数量限制, 默认 20, 最大 200"
+//	@Success		200		{object}	object{status=string,analytics=analytics.Report}	"成功响应"
+//	@Failure		401		{object}	map[string]string	"未授权"
+//	@Failure		403		{object}	map[string]string	"需要 master API key"
+//	@Failure		500		{object}	map[string]string	"服务器内部错误"
+//	@Router			/analytics [get]
+func GetAnalytics(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	days := fiber.Query(c, "days", 7)
+	if days <= 0 || days > 3650 {
+		days = 7
+	}
+	limit := fiber.Query(c, "limit", 20)
+	if limit <= 0 || limit > 200 {
+		limit = 20
+	}
+	report, err := analytics.GetReport(c.RequestCtx(), days, limit)
+	if err != nil {
+		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+	return c.JSON(fiber.Map{
+		"status":    "success",
+		"analytics": report,
+	})
+}
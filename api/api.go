@@ -6,6 +6,9 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
 
 	"github.com/go-playground/validator/v10"
 	swagger "github.com/gofiber/contrib/v3/swaggo"
@@ -14,12 +17,14 @@ import (
 	"github.com/krau/btts/webembed"
 
 	"github.com/gofiber/fiber/v3/extractors"
+	"github.com/gofiber/fiber/v3/middleware/adaptor"
 	"github.com/gofiber/fiber/v3/middleware/cors"
 	"github.com/gofiber/fiber/v3/middleware/keyauth"
 	"github.com/gofiber/fiber/v3/middleware/logger"
 	"github.com/gofiber/fiber/v3/middleware/static"
 	"github.com/krau/btts/config"
 	"github.com/krau/btts/database"
+	"github.com/krau/btts/metrics"
 	"gorm.io/gorm"
 )
 
@@ -28,7 +33,7 @@ var validate = validator.New()
 
 func validateApiKey(ctx fiber.Ctx, key string) (bool, error) {
 	// 未配置主 API key 时，保持原有行为：不要求鉴权
-	if config.C.Api.Key == "" {
+	if config.C().Api.Key == "" {
 		return true, nil
 	}
 	if key == "" {
@@ -50,10 +55,45 @@ func validateApiKey(ctx fiber.Ctx, key string) (bool, error) {
 		}
 		return false, err
 	}
+	now := time.Now()
+	if err := checkApiKeyState(apiKey, now); err != nil {
+		return false, err
+	}
+	// 每分钟最多记录一次最后使用时间, 避免每个请求都写数据库
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > time.Minute || apiKey.LastUsedIP != ctx.IP() {
+		if err := database.TouchApiKey(ctx.RequestCtx(), apiKey.ID, now, ctx.IP()); err != nil {
+			log.Error("Failed to update api key last used", "api_key_id", apiKey.ID, "error", err)
+		}
+	}
+	setApiKeyLocals(ctx, apiKey)
+	return true, nil
+}
+
+func setApiKeyLocals(ctx fiber.Ctx, apiKey *database.ApiKey) {
 	ctx.Locals("api_master", false)
 	ctx.Locals("api_key_id", apiKey.ID)
 	ctx.Locals("api_key_chats", apiKey.ChatIDs())
-	return true, nil
+	ctx.Locals("api_key", apiKey)
+}
+
+// checkApiKeyState 检查子 API key 是否被禁用或已过期
+func checkApiKeyState(apiKey *database.ApiKey, now time.Time) error {
+	if apiKey.Disabled {
+		return &fiber.Error{Code: fiber.StatusUnauthorized, Message: "API key is disabled"}
+	}
+	if apiKey.Expired(now) {
+		return &fiber.Error{Code: fiber.StatusUnauthorized, Message: "API key has expired"}
+	}
+	return nil
+}
+
+// apiKeyErrorHandler 鉴权失败时返回 validator 给出的 fiber.Error, 其他错误统一为 401
+func apiKeyErrorHandler(c fiber.Ctx, err error) error {
+	var fe *fiber.Error
+	if errors.As(err, &fe) {
+		return fe
+	}
+	return &fiber.Error{Code: fiber.StatusUnauthorized, Message: keyauth.ErrMissingOrMalformedAPIKey.Error()}
 }
 
 // @title						BTTS API
@@ -83,37 +123,52 @@ func Serve(addr string) {
 	app.Use(cors.New())
 
 	app.Get("/docs/*", swagger.HandlerDefault)
+	app.Get("/healthz", Healthz)
+	app.Get("/readyz", Readyz)
+	if config.C().Metrics.Enable && config.C().Metrics.Addr == "" {
+		app.Get(config.C().Metrics.Path, adaptor.HTTPHandler(metrics.Handler()))
+	}
 	rg := app.Group("/api")
-	if config.C.Api.Key != "" {
+	if config.C().Api.Key != "" {
 		rg.Use(keyauth.New(keyauth.Config{
-			Validator: validateApiKey,
+			Validator:    validateApiKey,
+			ErrorHandler: apiKeyErrorHandler,
 			Next: func(c fiber.Ctx) bool {
-				return c.Path() == "/api/client/filestream"
+				return isMediaPath(c.Path())
 			},
 		}))
-		sum := sha256.Sum256([]byte(config.C.Api.Key))
+		sum := sha256.Sum256([]byte(config.C().Api.Key))
 		storedKeyHash = sum[:]
 	}
-	rg.Get("/indexed", GetIndexed)
-	rg.Get("/index/:chat_id<int>", GetIndexInfo)
-	rg.Post("/index/multi-search", SearchOnMultiChatByPost)
-	rg.Get("/index/:chat_id<int>/search", SearchOnChatByGet)
-	rg.Post("/index/:chat_id<int>/search", SearchOnChatByPost)
-	rg.Post("/index/:chat_id<int>/msgs/fetch", FetchMessages)
-	rg.Post("/client/reply", ReplyMessage)
-	rg.Post("/client/forward", ForwardMessages)
-	rg.Use("/client/filestream", keyauth.New(keyauth.Config{
+	// 文件流和缩略图可以通过签名链接, reqtoken 或 API key 访问, 以便直接在 <img>, <video> 中使用
+	rg.Use(keyauth.New(keyauth.Config{
+		Next: func(c fiber.Ctx) bool {
+			return !isMediaPath(c.Path())
+		},
 		Validator: func(c fiber.Ctx, s string) (bool, error) {
-			if config.C.Api.Key == "" {
+			if config.C().Api.Key == "" {
 				return true, nil
 			}
 			if s == "" {
 				return false, keyauth.ErrMissingOrMalformedAPIKey
 			}
+			if c.Query("sig") != "" {
+				return validateSignedStream(c)
+			}
+			if c.Query("reqtoken") == "" {
+				// 也允许直接使用 API key, 以便按子 key 统计和限制流量
+				if ok, err := validateApiKey(c, s); !ok {
+					return false, err
+				}
+				if err := ensureChatAllowed(c, fiber.Query[int64](c, "chat_id", 0)); err != nil {
+					return false, err
+				}
+				return true, nil
+			}
 			if c.Query("chat_id", "") == "" || c.Query("message_id", "") == "" {
 				return false, keyauth.ErrMissingOrMalformedAPIKey
 			}
-			validKeyStr := fmt.Sprintf("%s:%s:%s", config.C.Api.Key, c.Query("chat_id", "Hatsune"), c.Query("message_id", "Miku"))
+			validKeyStr := fmt.Sprintf("%s:%s:%s", config.C().Api.Key, c.Query("chat_id", "Hatsune"), c.Query("message_id", "Miku"))
 			validKeyHash := sha256.Sum256([]byte(validKeyStr))
 			hexValidKey := hex.EncodeToString(validKeyHash[:])
 			if len(s) != len(hexValidKey) {
@@ -124,10 +179,34 @@ func Serve(addr string) {
 			}
 			return true, nil
 		},
-		Extractor: extractors.FromQuery("reqtoken"),
+		Extractor:    extractors.Chain(extractors.FromQuery("sig"), extractors.FromQuery("reqtoken"), extractors.FromAuthHeader("Bearer")),
+		ErrorHandler: apiKeyErrorHandler,
 	}))
-	rg.Get("/client/filestream", StreamFile)
+	rg.Use(auditMiddleware)
+	rg.Use(newRateLimiter())
+	rg.Use(usageMiddleware)
+	rg.Get("/indexed", requireOperation(database.ApiKeyOpSearch), GetIndexed)
+	rg.Get("/index/:chat_id<int>", requireOperation(database.ApiKeyOpSearch), GetIndexInfo)
+	rg.Post("/index/multi-search", requireOperation(database.ApiKeyOpSearch), SearchOnMultiChatByPost)
+	rg.Get("/index/:chat_id<int>/search", requireOperation(database.ApiKeyOpSearch), SearchOnChatByGet)
+	rg.Post("/index/:chat_id<int>/search", requireOperation(database.ApiKeyOpSearch), SearchOnChatByPost)
+	rg.Post("/index/:chat_id<int>/msgs/fetch", requireOperation(database.ApiKeyOpFetch), FetchMessages)
+	rg.Post("/client/reply", requireOperation(database.ApiKeyOpReply), ReplyMessage)
+	rg.Post("/client/forward", requireOperation(database.ApiKeyOpForward), ForwardMessages)
+	rg.Get("/client/filestream", requireOperation(database.ApiKeyOpFileStream), StreamFile)
+	rg.Post("/client/filestream/sign", requireOperation(database.ApiKeyOpFileStream), SignFileStream)
+	rg.Get("/client/thumb", requireOperation(database.ApiKeyOpFileStream), GetThumbnail)
 	rg.Post("/client/callexten/:exten<string>", CallClientExtension)
+	rg.Get("/audit", GetAuditLogs)
+	rg.Get("/analytics", GetAnalytics)
+	rg.Get("/health", GetHealth)
+	rg.Get("/cache", GetFileCache)
+	rg.Delete("/cache", PurgeFileCache)
+	rg.Delete("/users/:user_id<int>", ForgetUser)
+	rg.Post("/downloads", CreateDownload)
+	rg.Get("/downloads", ListDownloads)
+	rg.Get("/downloads/:id", GetDownload)
+	rg.Delete("/downloads/:id", CancelDownload)
 
 	app.Use("/", static.New("", static.Config{
 		FS: webembed.Static,
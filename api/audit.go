@@ -0,0 +1,107 @@
+package api
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/audit"
+	"github.com/krau/btts/database"
+)
+
+// auditMiddleware 记录所有使用 master API key 的请求, 修改数据的请求同时记录脱敏后的请求体
+func auditMiddleware(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return c.Next()
+	}
+	err := c.Next()
+	entry := &database.AuditLog{
+		Source: audit.SourceAPI,
+		Actor:  c.IP(),
+		Action: c.Method() + " " + c.Path(),
+		Target: c.Query("chat_id"),
+		Args:   audit.RedactQuery(string(c.Request().URI().QueryString())),
+		Result: audit.ResultOK,
+	}
+	if method := c.Method(); method != fiber.MethodGet && method != fiber.MethodHead {
+		if body := audit.RedactBody(c.Body()); body != "" {
+			entry.Args = strings.TrimSpace(entry.Args + " " + body)
+		}
+	}
+	status := c.Response().StatusCode()
+	if err != nil {
+		entry.Error = err.Error()
+		status = fiber.StatusInternalServerError
+		var fe *fiber.Error
+		if errors.As(err, &fe) {
+			status = fe.Code
+		}
+	}
+	switch {
+	case status == fiber.StatusUnauthorized || status == fiber.StatusForbidden:
+		entry.Result = audit.ResultDenied
+	case status >= fiber.StatusBadRequest:
+		entry.Result = audit.ResultError
+	}
+	audit.Record(c.RequestCtx(), entry)
+	return err
+}
+
+// GetAuditLogs 查询审计日志
+//
+//	@Summary		查询审计日志
+//	@Description	按时间倒序查询管理操作的审计日志, 需要 master API key
+//	@Tags			Audit
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			source		query		string	false	"来源 (bot/api)"
+//	@Param			action		query		string	false	"操作"
+//	@Param			actor_id	query		int		false	"执行者 ID"
+//	@Param			since		query		string	false	"起始时间 (RFC3339)"
+//	@Param			until		query		string	false	"结束时间 (RFC3339)"
+//	@Param			limit		query		int		false	"数量限制, 默认 50, 最大 500"
+//	@Param			offset		query		int		false	"偏移量"
+//	@Success		200			{object}	object{status=string,total=int,logs=[]database.AuditLog}	"成功响应"
+//	@Failure		400			{object}	map[string]string	"请求参数错误"
+//	@Failure		401			{object}	map[string]string	"未授权"
+//	@Failure		403			{object}	map[string]string	"需要 master API key"
+//	@Failure		500			{object}	map[string]string	"服务器内部错误"
+//	@Router			/audit [get]
+func GetAuditLogs(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	query := database.AuditLogQuery{
+		Source:  c.Query("source"),
+		Action:  c.Query("action"),
+		ActorID: fiber.Query[int64](c, "actor_id", 0),
+		Limit:   fiber.Query(c, "limit", 50),
+		Offset:  fiber.Query(c, "offset", 0),
+	}
+	if query.Limit <= 0 || query.Limit > 500 {
+		query.Limit = 50
+	}
+	if query.Offset < 0 {
+		query.Offset = 0
+	}
+	for key, t := range map[string]*time.Time{"since": &query.Since, "until": &query.Until} {
+		if v := c.Query(key); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid " + key + " time, RFC3339 expected"}
+			}
+			*t = parsed
+		}
+	}
+	logs, total, err := database.QueryAuditLogs(c.RequestCtx(), query)
+	if err != nil {
+		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"total":  total,
+		"logs":   logs,
+	})
+}
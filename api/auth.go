@@ -2,13 +2,16 @@ package api
 
 import (
 	"slices"
+	"time"
 
 	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/database"
 )
 
 const (
 	ctxKeyAPIMaster = "api_master"
 	ctxKeyAPIChats  = "api_key_chats"
+	ctxKeyAPIKey    = "api_key"
 )
 
 func isMasterAPIKey(c fiber.Ctx) bool {
@@ -20,6 +23,16 @@ func isMasterAPIKey(c fiber.Ctx) bool {
 	return false
 }
 
+// getAPIKey 返回当前请求使用的子 API key, master key 或未鉴权时返回 nil
+func getAPIKey(c fiber.Ctx) *database.ApiKey {
+	if v := c.Locals(ctxKeyAPIKey); v != nil {
+		if key, ok := v.(*database.ApiKey); ok {
+			return key
+		}
+	}
+	return nil
+}
+
 func getScopedChats(c fiber.Ctx) []int64 {
 	if v := c.Locals(ctxKeyAPIChats); v != nil {
 		if chats, ok := v.([]int64); ok {
@@ -31,6 +44,9 @@ func getScopedChats(c fiber.Ctx) []int64 {
 
 // ensureChatAllowed 确保当前 API key 允许访问指定 chat
 func ensureChatAllowed(c fiber.Ctx, chatID int64) error {
+	if key := getAPIKey(c); key != nil && (key.Disabled || key.Expired(time.Now())) {
+		return &fiber.Error{Code: fiber.StatusUnauthorized, Message: "API key is disabled or expired"}
+	}
 	if chatID == 0 || isMasterAPIKey(c) {
 		return nil
 	}
@@ -68,3 +84,13 @@ func filterAllowedChats(c fiber.Ctx, chatIDs []int64) ([]int64, error) {
 	}
 	return res, nil
 }
+
+// requireOperation 要求子 API key 被授予 op 操作, master key 不受限制
+func requireOperation(op string) fiber.Handler {
+	return func(c fiber.Ctx) error {
+		if key := getAPIKey(c); key != nil && !key.HasOperation(op) {
+			return &fiber.Error{Code: fiber.StatusForbidden, Message: "Operation " + op + " not allowed for this API key"}
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/service"
+)
+
+// GetFileCache 查看文件缓存
+//
+//	@Summary		查看文件缓存
+//	@Description	获取文件缓存的统计信息和缓存项列表, 需要 master API key
+//	@Tags			Cache
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	object{status=string,stats=service.FileCacheStats,entries=[]service.FileCacheEntry}	"成功响应"
+//	@Failure		401	{object}	map[string]string																	"未授权"
+//	@Failure		403	{object}	map[string]string																	"需要 master API key"
+//	@Router			/cache [get]
+func GetFileCache(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	return c.JSON(fiber.Map{
+		"status":  "success",
+		"stats":   service.GetFileCacheStats(),
+		"entries": service.GetFileCacheEntries(),
+	})
+}
+
+// PurgeFileCache 清除文件缓存
+//
+//	@Summary		清除文件缓存
+//	@Description	清除文件缓存, 不指定 chat_id 时清除全部, 只指定 chat_id 时清除该聊天的全部缓存. 需要 master API key
+//	@Tags			Cache
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			chat_id		query		int64							false	"聊天ID"
+//	@Param			message_id	query		int								false	"消息ID"
+//	@Success		200			{object}	object{status=string,purged=int}	"成功响应"
+//	@Failure		401			{object}	map[string]string					"未授权"
+//	@Failure		403			{object}	map[string]string					"需要 master API key"
+//	@Router			/cache [delete]
+func PurgeFileCache(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	chatID := fiber.Query[int64](c, "chat_id", 0)
+	messageID := fiber.Query(c, "message_id", 0)
+	if chatID == 0 && messageID != 0 {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "chat_id is required when message_id is set"}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"purged": service.PurgeFileCache(chatID, messageID),
+	})
+}
@@ -13,7 +13,7 @@ import (
 // ReplyMessage 回复指定消息
 //
 //	@Summary		回复指定消息
-//	@Description	向指定聊天中的指定消息发送回复
+//	@Description	向指定聊天中的指定消息发送回复, 子 API key 需要 reply 权限且聊天在其作用域内
 //	@Tags			Client
 //	@Accept			json
 //	@Produce		json
@@ -23,12 +23,10 @@ import (
 //	@Success		200		{object}	object{status=string,message=string,data=object}	"成功响应示例"
 //	@Failure		400		{object}	map[string]string									"请求参数错误"
 //	@Failure		401		{object}	map[string]string									"未授权"
+//	@Failure		403		{object}	map[string]string								"无权访问该聊天"
 //	@Failure		500		{object}	map[string]string									"服务器内部错误"
 //	@Router			/client/reply [post]
 func ReplyMessage(c fiber.Ctx) error {
-	if !isMasterAPIKey(c) {
-		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
-	}
 	var req ReplyMessageRequest
 	if err := c.Bind().Body(&req); err != nil {
 		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid request body"}
@@ -36,7 +34,10 @@ func ReplyMessage(c fiber.Ctx) error {
 	if err := validate.StructCtx(c.RequestCtx(), &req); err != nil {
 		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Validation failed: " + err.Error()}
 	}
-	msg, err := userclient.GetUserClient().ReplyMessage(c.RequestCtx(), req.ChatID, req.MessageID, req.Text)
+	if err := ensureChatAllowed(c, req.ChatID); err != nil {
+		return err
+	}
+	msg, err := userclient.GetUserClientForChat(req.ChatID).ReplyMessage(c.RequestCtx(), req.ChatID, req.MessageID, req.Text)
 	if err != nil {
 		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
@@ -50,7 +51,7 @@ func ReplyMessage(c fiber.Ctx) error {
 // ForwardMessages 转发消息
 //
 //	@Summary		转发消息
-//	@Description	将指定聊天中的消息转发到目标聊天
+//	@Description	将指定聊天中的消息转发到目标聊天, 子 API key 需要 forward 权限且来源和目标聊天都在其作用域内
 //	@Tags			Client
 //	@Accept			json
 //	@Produce		json
@@ -60,12 +61,10 @@ func ReplyMessage(c fiber.Ctx) error {
 //	@Success		200		{object}	object{status=string,message=string}			"成功响应示例"
 //	@Failure		400		{object}	map[string]string								"请求参数错误"
 //	@Failure		401		{object}	map[string]string								"未授权"
+//	@Failure		403		{object}	map[string]string								"无权访问该聊天"
 //	@Failure		500		{object}	map[string]string								"服务器内部错误"
 //	@Router			/client/forward [post]
 func ForwardMessages(c fiber.Ctx) error {
-	if !isMasterAPIKey(c) {
-		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
-	}
 	var req ForwardMessagesRequest
 	if err := c.Bind().Body(&req); err != nil {
 		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid request body"}
@@ -73,7 +72,14 @@ func ForwardMessages(c fiber.Ctx) error {
 	if err := validate.StructCtx(c.RequestCtx(), &req); err != nil {
 		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Validation failed: " + err.Error()}
 	}
-	if err := userclient.GetUserClient().ForwardMessages(c.RequestCtx(), req.FromChatID, req.ToChatID, req.MessageIDs); err != nil {
+	// 来源和目标聊天都必须在子 key 的作用域内
+	if err := ensureChatAllowed(c, req.FromChatID); err != nil {
+		return err
+	}
+	if err := ensureChatAllowed(c, req.ToChatID); err != nil {
+		return err
+	}
+	if err := userclient.GetUserClientForChat(req.FromChatID).ForwardMessages(c.RequestCtx(), req.FromChatID, req.ToChatID, req.MessageIDs); err != nil {
 		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
 	return c.JSON(fiber.Map{
@@ -91,7 +97,9 @@ func ForwardMessages(c fiber.Ctx) error {
 //	@Security		ApiKeyAuth
 //	@Param			chat_id		query	int64	true	"聊天ID"
 //	@Param			message_id	query	int	true	"消息ID"
+//	@Param			Range		header	string	false	"字节范围, 如 bytes=0-1048575"
 //	@Success		200			{file}	file	"文件流"
+//	@Success		206			{file}	file	"部分文件流"
 //	@Failure		400			{object}	map[string]string	"请求参数错误"
 //	@Failure		401			{object}	map[string]string	"未授权"
 //	@Failure		500			{object}	map[string]string	"服务器内部错误"
@@ -102,7 +110,25 @@ func StreamFile(c fiber.Ctx) error {
 	if chatID <= 0 || messageID <= 0 {
 		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid chat_id or message_id"}
 	}
-	file, err := service.GetTGFileReader(c.RequestCtx(), int64(chatID), messageID)
+	// 未缓存的文件按 Range 只从 Telegram 下载需要的部分, 以便浏览器首次播放视频时也能拖动进度
+	var offset, length int64
+	if c.Get(fiber.HeaderRange) != "" {
+		info, err := service.GetTGFileInfo(c.RequestCtx(), int64(chatID), messageID)
+		if err != nil {
+			return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+		}
+		if info.Size > 0 {
+			ranges, err := c.Range(info.Size)
+			if err != nil || len(ranges.Ranges) == 0 {
+				c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", info.Size))
+				return &fiber.Error{Code: fiber.StatusRequestedRangeNotSatisfiable, Message: "Invalid range"}
+			}
+			// 多个区间时只返回第一个
+			offset = ranges.Ranges[0].Start
+			length = ranges.Ranges[0].End - offset + 1
+		}
+	}
+	file, err := service.GetTGFileReader(c.RequestCtx(), int64(chatID), messageID, offset, length)
 	if err != nil {
 		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
@@ -126,9 +152,18 @@ func StreamFile(c fiber.Ctx) error {
 		})
 	}
 
-	// Non-seekable stream (live download from Telegram): send as simple stream.
+	// Live download from Telegram: send as stream, or as 206 when a range was requested.
 	c.Set("Content-Type", mt)
 	c.Set("Content-Disposition", fmt.Sprintf("inline; filename=%q", file.Name))
+	if file.Size > 0 {
+		c.Set(fiber.HeaderAcceptRanges, "bytes")
+	}
+	if file.Length > 0 && file.Length < file.Size {
+		c.Status(fiber.StatusPartialContent)
+		c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", file.Offset, file.Offset+file.Length-1, file.Size))
+		c.Set("Content-Length", fmt.Sprintf("%d", file.Length))
+		return c.SendStream(file, int(file.Length))
+	}
 	if file.Size > 0 {
 		c.Set("Content-Length", fmt.Sprintf("%d", file.Size))
 	}
@@ -0,0 +1,112 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/download"
+)
+
+// CreateDownload 创建媒体下载任务
+//
+//	@Summary		创建下载任务
+//	@Description	在后台下载聊天中指定范围或搜索结果中的媒体到服务器的下载目录, 按文件 ID 去重, 重复创建相同的任务时会跳过已下载的文件并继续未完成的文件. 需要 master API key
+//	@Tags			Download
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		download.Request								true	"下载参数"
+//	@Success		200		{object}	object{status=string,job=download.Progress}	"成功响应"
+//	@Failure		400		{object}	map[string]string								"请求参数错误"
+//	@Failure		401		{object}	map[string]string								"未授权"
+//	@Failure		403		{object}	map[string]string								"需要 master API key"
+//	@Router			/downloads [post]
+func CreateDownload(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	var req download.Request
+	if err := c.Bind().Body(&req); err != nil {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid request body"}
+	}
+	job, err := download.Start(c.RequestCtx(), req)
+	if err != nil {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: err.Error()}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"job":    job.Progress(),
+	})
+}
+
+// ListDownloads 列出下载任务
+//
+//	@Summary		列出下载任务
+//	@Description	列出本次运行中创建的所有下载任务及其进度, 需要 master API key
+//	@Tags			Download
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	object{status=string,jobs=[]download.Progress}	"成功响应"
+//	@Failure		401	{object}	map[string]string								"未授权"
+//	@Failure		403	{object}	map[string]string								"需要 master API key"
+//	@Router			/downloads [get]
+func ListDownloads(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"jobs":   download.ListJobs(),
+	})
+}
+
+// GetDownload 查看下载任务
+//
+//	@Summary		查看下载任务
+//	@Description	获取指定下载任务的进度, 需要 master API key
+//	@Tags			Download
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string										true	"任务ID"
+//	@Success		200	{object}	object{status=string,job=download.Progress}	"成功响应"
+//	@Failure		403	{object}	map[string]string							"需要 master API key"
+//	@Failure		404	{object}	map[string]string							"任务不存在"
+//	@Router			/downloads/{id} [get]
+func GetDownload(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	job, ok := download.GetJob(c.Params("id"))
+	if !ok {
+		return &fiber.Error{Code: fiber.StatusNotFound, Message: "Download job not found"}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"job":    job.Progress(),
+	})
+}
+
+// CancelDownload 取消下载任务
+//
+//	@Summary		取消下载任务
+//	@Description	取消指定的下载任务, 已下载的文件会保留. 需要 master API key
+//	@Tags			Download
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string										true	"任务ID"
+//	@Success		200	{object}	object{status=string,job=download.Progress}	"成功响应"
+//	@Failure		403	{object}	map[string]string							"需要 master API key"
+//	@Failure		404	{object}	map[string]string							"任务不存在"
+//	@Router			/downloads/{id} [delete]
+func CancelDownload(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	job, ok := download.GetJob(c.Params("id"))
+	if !ok {
+		return &fiber.Error{Code: fiber.StatusNotFound, Message: "Download job not found"}
+	}
+	job.Cancel()
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"job":    job.Progress(),
+	})
+}
@@ -0,0 +1,37 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/analytics"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/export"
+	"github.com/krau/btts/types"
+)
+
+// exportSearch 遍历 req 的所有分页并以附件形式返回导出文件, 格式由 export 查询参数指定
+func exportSearch(c fiber.Ctx, req types.SearchRequest) error {
+	format, err := export.ParseFormat(c.Query("export"))
+	if err != nil {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: err.Error()}
+	}
+	start := time.Now()
+	hits, meta, err := export.Collect(c.RequestCtx(), engine.GetEngine(), req)
+	if err != nil {
+		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+	analytics.RecordSearch(c.RequestCtx(), analytics.SourceAPIExport, analytics.APIKeyRequester(getAPIKey(c)), req, &types.SearchResponse{
+		ProcessingTimeMs:   time.Since(start).Milliseconds(),
+		EstimatedTotalHits: meta.Total,
+	})
+	var buf bytes.Buffer
+	if err := export.Render(&buf, format, meta, hits); err != nil {
+		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+	c.Set(fiber.HeaderContentType, format.ContentType())
+	c.Set(fiber.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="%s"`, format.FileName(meta.ExportedAt)))
+	return c.Send(buf.Bytes())
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/keyauth"
+	"github.com/google/uuid"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultStreamLinkTTL = time.Hour
+	maxStreamLinkTTL     = 7 * 24 * time.Hour
+)
+
+// isMediaPath 判断请求是否为文件流或缩略图, 它们使用单独的鉴权方式
+func isMediaPath(path string) bool {
+	return path == "/api/client/filestream" || path == "/api/client/thumb"
+}
+
+// signStream 计算文件流链接的签名, 签名密钥为 master API key
+func signStream(chatID int64, messageID int, exp int64, kid uint, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(config.C().Api.Key))
+	fmt.Fprintf(mac, "%d:%d:%d:%d:%s", chatID, messageID, exp, kid, nonce)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// signedMediaQuery 构造签名链接的查询参数, 同一签名可用于文件流和缩略图
+func signedMediaQuery(chatID int64, messageID int, expiresAt time.Time, kid uint, nonce string) url.Values {
+	query := url.Values{}
+	query.Set("chat_id", strconv.FormatInt(chatID, 10))
+	query.Set("message_id", strconv.Itoa(messageID))
+	query.Set("exp", strconv.FormatInt(expiresAt.Unix(), 10))
+	query.Set("kid", strconv.FormatUint(uint64(kid), 10))
+	if nonce != "" {
+		query.Set("nonce", nonce)
+	}
+	query.Set("sig", signStream(chatID, messageID, expiresAt.Unix(), kid, nonce))
+	return query
+}
+
+// validateSignedStream 校验签名的文件流链接: 签名, 过期时间, 签发 key 的状态和作用域, 以及一次性 nonce.
+// 一次性链接在第一次访问时绑定到访问者的 IP, 过期前同一 IP 的后续请求 (如播放器拖动进度时的 Range 请求) 仍然有效
+func validateSignedStream(c fiber.Ctx) (bool, error) {
+	chatID := fiber.Query[int64](c, "chat_id", 0)
+	messageID := fiber.Query(c, "message_id", 0)
+	exp := fiber.Query[int64](c, "exp", 0)
+	kid := fiber.Query[uint](c, "kid", 0)
+	nonce := c.Query("nonce")
+	if chatID == 0 || messageID == 0 || exp == 0 {
+		return false, keyauth.ErrMissingOrMalformedAPIKey
+	}
+	expected := signStream(chatID, messageID, exp, kid, nonce)
+	if !hmac.Equal([]byte(c.Query("sig")), []byte(expected)) {
+		return false, keyauth.ErrMissingOrMalformedAPIKey
+	}
+	now := time.Now()
+	if now.Unix() >= exp {
+		return false, &fiber.Error{Code: fiber.StatusUnauthorized, Message: "Link has expired"}
+	}
+	if kid != 0 {
+		// 每次访问时重新检查签发 key, 禁用或删除 key 后其签发的链接随之失效
+		apiKey, err := database.GetApiKeyByID(c.RequestCtx(), kid)
+		if err != nil {
+			return false, &fiber.Error{Code: fiber.StatusUnauthorized, Message: "Issuing API key not found"}
+		}
+		if err := checkApiKeyState(apiKey, now); err != nil {
+			return false, err
+		}
+		setApiKeyLocals(c, apiKey)
+		if err := ensureChatAllowed(c, chatID); err != nil {
+			return false, err
+		}
+	}
+	if nonce != "" {
+		ok, err := database.UseStreamNonce(c.RequestCtx(), nonce, c.IP())
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, &fiber.Error{Code: fiber.StatusUnauthorized, Message: "Link is no longer valid"}
+		}
+		if err != nil {
+			return false, &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+		}
+		if !ok {
+			return false, &fiber.Error{Code: fiber.StatusUnauthorized, Message: "Link has already been used"}
+		}
+	}
+	return true, nil
+}
+
+// SignFileStream 签发文件流链接
+//
+//	@Summary		签发文件流链接
+//	@Description	签发一个带过期时间的文件流链接, 可选为一次性链接 (第一次访问后只有同一 IP 可以继续访问). 子 API key 签发的链接只在 key 有效且聊天在其作用域内时可用
+//	@Tags			Client
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		SignFileStreamRequest										true	"签发参数"
+//	@Success		200		{object}	object{status=string,url=string,expires_at=string}		"成功响应"
+//	@Failure		400		{object}	map[string]string											"请求参数错误"
+//	@Failure		401		{object}	map[string]string											"未授权"
+//	@Failure		403		{object}	map[string]string											"无权访问该聊天"
+//	@Failure		500		{object}	map[string]string											"服务器内部错误"
+//	@Router			/client/filestream/sign [post]
+func SignFileStream(c fiber.Ctx) error {
+	if config.C().Api.Key == "" {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "API key is not configured, file stream does not require signing"}
+	}
+	var req SignFileStreamRequest
+	if err := c.Bind().Body(&req); err != nil {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid request body"}
+	}
+	if err := validate.StructCtx(c.RequestCtx(), &req); err != nil {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Validation failed: " + err.Error()}
+	}
+	if err := ensureChatAllowed(c, req.ChatID); err != nil {
+		return err
+	}
+	ttl := defaultStreamLinkTTL
+	if req.TTL > 0 {
+		ttl = min(time.Duration(req.TTL)*time.Second, maxStreamLinkTTL)
+	}
+	var kid uint
+	if key := getAPIKey(c); key != nil {
+		kid = key.ID
+		// 链接不能比签发它的 key 活得更久
+		if key.ExpiresAt != nil && time.Until(*key.ExpiresAt) < ttl {
+			ttl = time.Until(*key.ExpiresAt)
+		}
+	}
+	expiresAt := time.Now().Add(ttl)
+	nonce := ""
+	if req.SingleUse {
+		nonce = uuid.NewString()
+		if err := database.CreateStreamNonce(c.RequestCtx(), nonce, expiresAt); err != nil {
+			return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+		}
+	}
+	return c.JSON(fiber.Map{
+		"status":     "success",
+		"url":        c.BaseURL() + "/api/client/filestream?" + signedMediaQuery(req.ChatID, req.MessageID, expiresAt, kid, nonce).Encode(),
+		"expires_at": expiresAt,
+	})
+}
@@ -0,0 +1,199 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/database"
+)
+
+func TestMain(m *testing.M) {
+	// 签发 key 和一次性 nonce 保存在数据库中, 在临时目录中初始化
+	dir, err := os.MkdirTemp("", "btts-api-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir("data", 0o755); err != nil {
+		panic(err)
+	}
+	if err := database.InitDatabase(context.Background()); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newSignedStreamApp 返回只校验签名链接的 app, 错误与 keyauth 中间件一样处理, 访问者 IP 取自 X-Real-IP
+func newSignedStreamApp() *fiber.App {
+	app := fiber.New(fiber.Config{
+		ProxyHeader:      "X-Real-IP",
+		TrustProxy:       true,
+		TrustProxyConfig: fiber.TrustProxyConfig{Proxies: []string{"0.0.0.0"}},
+	})
+	app.Get("/api/client/filestream", func(c fiber.Ctx) error {
+		if _, err := validateSignedStream(c); err != nil {
+			return apiKeyErrorHandler(c, err)
+		}
+		return c.SendString("ok")
+	})
+	return app
+}
+
+func requestSignedStream(t *testing.T, app *fiber.App, rawQuery, ip string) int {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/api/client/filestream?"+rawQuery, nil)
+	req.Header.Set("X-Real-IP", ip)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode
+}
+
+func TestValidateSignedStream(t *testing.T) {
+	ctx := context.Background()
+	const allowedChat, otherChat = 1001, 1002
+	scoped := &database.ApiKey{Name: "scoped", KeyHash: "scoped", Chats: []database.IndexChat{{ChatID: allowedChat}}}
+	disabled := &database.ApiKey{Name: "disabled", KeyHash: "disabled", Disabled: true, Chats: []database.IndexChat{{ChatID: otherChat}}}
+	for _, key := range []*database.ApiKey{scoped, disabled} {
+		if err := database.UpsertApiKey(ctx, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	valid := time.Now().Add(time.Hour)
+
+	tests := []struct {
+		name     string
+		query    func() string
+		expected int
+	}{
+		{
+			name: "Master key link",
+			query: func() string {
+				return signedMediaQuery(otherChat, 1, valid, 0, "").Encode()
+			},
+			expected: fiber.StatusOK,
+		},
+		{
+			name: "Tampered message ID",
+			query: func() string {
+				q := signedMediaQuery(otherChat, 1, valid, 0, "")
+				q.Set("message_id", "2")
+				return q.Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+		{
+			name: "Tampered expiry",
+			query: func() string {
+				q := signedMediaQuery(otherChat, 1, time.Now().Add(-time.Minute), 0, "")
+				q.Set("exp", "9999999999")
+				return q.Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+		{
+			name: "Expired",
+			query: func() string {
+				return signedMediaQuery(otherChat, 1, time.Now().Add(-time.Minute), 0, "").Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+		{
+			name: "Sub key, chat in scope",
+			query: func() string {
+				return signedMediaQuery(allowedChat, 1, valid, scoped.ID, "").Encode()
+			},
+			expected: fiber.StatusOK,
+		},
+		{
+			name: "Sub key, chat out of scope",
+			query: func() string {
+				return signedMediaQuery(otherChat, 1, valid, scoped.ID, "").Encode()
+			},
+			expected: fiber.StatusForbidden,
+		},
+		{
+			name: "Sub key disabled",
+			query: func() string {
+				return signedMediaQuery(otherChat, 1, valid, disabled.ID, "").Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+		{
+			name: "Sub key deleted",
+			query: func() string {
+				return signedMediaQuery(allowedChat, 1, valid, 9999, "").Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+		{
+			name: "Kid swapped to master",
+			query: func() string {
+				q := signedMediaQuery(otherChat, 1, valid, scoped.ID, "")
+				q.Set("kid", "0")
+				return q.Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+		{
+			name: "Unknown nonce",
+			query: func() string {
+				return signedMediaQuery(otherChat, 1, valid, 0, "not-issued").Encode()
+			},
+			expected: fiber.StatusUnauthorized,
+		},
+	}
+	app := newSignedStreamApp()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestSignedStream(t, app, tt.query(), "192.0.2.1"); got != tt.expected {
+				t.Errorf("status = %d, expected %d", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestValidateSignedStreamNonce(t *testing.T) {
+	ctx := context.Background()
+	expiresAt := time.Now().Add(time.Hour)
+	if err := database.CreateStreamNonce(ctx, "single-use", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	query := signedMediaQuery(1001, 1, expiresAt, 0, "single-use").Encode()
+	app := newSignedStreamApp()
+
+	steps := []struct {
+		name     string
+		ip       string
+		expected int
+	}{
+		{name: "First use", ip: "192.0.2.1", expected: fiber.StatusOK},
+		{name: "Range request from the same client", ip: "192.0.2.1", expected: fiber.StatusOK},
+		{name: "Reuse from another client", ip: "198.51.100.7", expected: fiber.StatusUnauthorized},
+	}
+	for _, step := range steps {
+		if got := requestSignedStream(t, app, query, step.ip); got != step.expected {
+			t.Errorf("%s: status = %d, expected %d", step.name, got, step.expected)
+		}
+	}
+
+	// 数据库中已过期的 nonce 即使未使用也无效
+	expired := time.Now().Add(time.Second)
+	if err := database.CreateStreamNonce(ctx, "expiring", expired); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Until(expired) + 10*time.Millisecond)
+	query = signedMediaQuery(1001, 1, time.Now().Add(time.Hour), 0, "expiring").Encode()
+	if got := requestSignedStream(t, app, query, "192.0.2.1"); got != fiber.StatusUnauthorized {
+		t.Errorf("Expired nonce: status = %d, expected %d", got, fiber.StatusUnauthorized)
+	}
+}
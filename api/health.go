@@ -0,0 +1,42 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/health"
+)
+
+// Healthz 返回整体健康状态, 只要服务在运行就返回 200. 不需要 api key, 因此不包含各组件的详情
+func Healthz(c fiber.Ctx) error {
+	report := health.Latest(c.RequestCtx())
+	return c.JSON(fiber.Map{"status": report.Status, "ready": report.Ready})
+}
+
+// Readyz 与 Healthz 相同, 但必需的组件 (搜索引擎、数据库、主用户账号和 bot) 不可用时返回 503
+func Readyz(c fiber.Ctx) error {
+	report := health.Latest(c.RequestCtx())
+	if !report.Ready {
+		c.Status(fiber.StatusServiceUnavailable)
+	}
+	return c.JSON(fiber.Map{"status": report.Status, "ready": report.Ready})
+}
+
+// GetHealth 查看各组件的健康状态
+//
+//	@Summary		查看健康状态
+//	@Description	获取各组件最近一次健康检查的结果, 包括错误信息. 需要 master API key
+//	@Tags			Health
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	object{status=string,report=health.Report}	"成功响应"
+//	@Failure		401	{object}	map[string]string							"未授权"
+//	@Failure		403	{object}	map[string]string							"需要 master API key"
+//	@Router			/health [get]
+func GetHealth(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"report": health.Latest(c.RequestCtx()),
+	})
+}
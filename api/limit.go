@@ -0,0 +1,87 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/limiter"
+	"github.com/krau/btts/database"
+)
+
+// newRateLimiter 按子 API key 的 RateLimit 限制每分钟请求数, master key 和未设置限制的 key 不受限
+func newRateLimiter() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Next: func(c fiber.Ctx) bool {
+			key := getAPIKey(c)
+			return key == nil || key.RateLimit <= 0
+		},
+		KeyGenerator: func(c fiber.Ctx) string {
+			return "apikey:" + strconv.FormatUint(uint64(getAPIKey(c).ID), 10)
+		},
+		MaxFunc: func(c fiber.Ctx) int {
+			return getAPIKey(c).RateLimit
+		},
+		Expiration: time.Minute,
+		LimitReached: func(c fiber.Ctx) error {
+			return &fiber.Error{Code: fiber.StatusTooManyRequests, Message: "Rate limit exceeded for this API key"}
+		},
+	})
+}
+
+func isSearchRequest(c fiber.Ctx) bool {
+	return c.Path() == "/api/index/multi-search" || strings.HasSuffix(c.Path(), "/search")
+}
+
+func isStreamRequest(c fiber.Ctx) bool {
+	return isMediaPath(c.Path())
+}
+
+// usageMiddleware 统计子 API key 的每日用量, 并在超出每日配额时返回 429
+func usageMiddleware(c fiber.Ctx) error {
+	key := getAPIKey(c)
+	if key == nil {
+		return c.Next()
+	}
+	day := database.UsageDay(time.Now())
+	search, stream := isSearchRequest(c), isStreamRequest(c)
+	if (search && key.DailySearchQuota > 0) || (stream && key.DailyStreamBytes > 0) {
+		usage, err := database.GetApiKeyUsage(c.RequestCtx(), key.ID, day)
+		if err != nil {
+			return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+		}
+		if search && key.DailySearchQuota > 0 && usage.Searches >= int64(key.DailySearchQuota) {
+			return quotaExceeded(c, "Daily search quota exceeded for this API key")
+		}
+		if stream && key.DailyStreamBytes > 0 && usage.StreamBytes >= key.DailyStreamBytes {
+			return quotaExceeded(c, "Daily stream quota exceeded for this API key")
+		}
+	}
+	err := c.Next()
+	delta := &database.ApiKeyUsage{ApiKeyID: key.ID, Day: day, Requests: 1}
+	if err == nil && c.Response().StatusCode() < fiber.StatusBadRequest {
+		if search {
+			delta.Searches = 1
+		}
+		if stream {
+			// 流式响应在 handler 返回后才会发送, 这里按响应的 Content-Length 计算
+			if n := c.Response().Header.ContentLength(); n > 0 {
+				delta.StreamBytes = int64(n)
+			}
+		}
+	}
+	if err := database.AddApiKeyUsage(c.RequestCtx(), delta); err != nil {
+		log.Error("Failed to record api key usage", "api_key_id", key.ID, "error", err)
+	}
+	return err
+}
+
+// quotaExceeded 返回 429, Retry-After 为距离下一个统计日 (UTC 零点) 的秒数
+func quotaExceeded(c fiber.Ctx, message string) error {
+	now := time.Now().UTC()
+	reset := now.Truncate(24 * time.Hour).Add(24 * time.Hour)
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(reset.Sub(now).Seconds())+1))
+	return &fiber.Error{Code: fiber.StatusTooManyRequests, Message: message}
+}
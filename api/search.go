@@ -6,6 +6,7 @@ import (
 
 	"github.com/duke-git/lancet/v2/slice"
 	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/analytics"
 	"github.com/krau/btts/engine"
 	"github.com/krau/btts/types"
 )
@@ -24,6 +25,7 @@ import (
 //	@Param			limit	query		int												false	"限制数量，默认为10"	default(10)
 //	@Param			users	query		string											false	"用户ID列表，逗号分隔"	example("123456,789012")
 //	@Param			types	query		string											false	"消息类型列表，逗号分隔"	example("text,photo,video")	Enums(text,photo,video,document,voice,audio,poll,story)
+//	@Param			export	query		string											false	"导出全部分页的结果为文件, 而不是返回 JSON"	Enums(json,csv,html,md)
 //	@Success		200		{object}	map[string]interface{}							"成功响应"
 //	@Success		200		{object}	object{status=string,results=SearchResponse}	"成功响应示例"
 //	@Failure		400		{object}	map[string]string								"请求参数错误"
@@ -72,10 +74,14 @@ func SearchOnChatByGet(c fiber.Ctx) error {
 			req.TypeFilters = msgTypes
 		}
 	}
+	if c.Query("export") != "" {
+		return exportSearch(c, req)
+	}
 	results, err := engine.GetEngine().Search(c.RequestCtx(), req)
 	if err != nil {
 		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
+	analytics.RecordSearch(c.RequestCtx(), analytics.SourceAPI, analytics.APIKeyRequester(getAPIKey(c)), req, results)
 	return ResponseSearch(c, results)
 }
 
@@ -89,6 +95,7 @@ func SearchOnChatByGet(c fiber.Ctx) error {
 //	@Security		ApiKeyAuth
 //	@Param			chat_id	path		int												true	"聊天ID"
 //	@Param			request	body		SearchOnChatByPostRequest						true	"搜索请求参数"
+//	@Param			export	query		string											false	"导出全部分页的结果为文件, 而不是返回 JSON"	Enums(json,csv,html,md)
 //	@Success		200		{object}	map[string]interface{}							"成功响应"
 //	@Success		200		{object}	object{status=string,results=SearchResponse}	"成功响应示例"
 //	@Failure		400		{object}	map[string]string								"请求参数错误"
@@ -127,10 +134,14 @@ func SearchOnChatByPost(c fiber.Ctx) error {
 			req.TypeFilters = msgTypes
 		}
 	}
+	if c.Query("export") != "" {
+		return exportSearch(c, req)
+	}
 	results, err := engine.GetEngine().Search(c.RequestCtx(), req)
 	if err != nil {
 		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
+	analytics.RecordSearch(c.RequestCtx(), analytics.SourceAPI, analytics.APIKeyRequester(getAPIKey(c)), req, results)
 	return ResponseSearch(c, results)
 
 }
@@ -144,6 +155,7 @@ func SearchOnChatByPost(c fiber.Ctx) error {
 //	@Produce		json
 //	@Security		ApiKeyAuth
 //	@Param			request	body		SearchOnMultiChatByPostRequest					true	"多聊天搜索请求参数"
+//	@Param			export	query		string											false	"导出全部分页的结果为文件, 而不是返回 JSON"	Enums(json,csv,html,md)
 //	@Success		200		{object}	map[string]interface{}							"成功响应"
 //	@Success		200		{object}	object{status=string,results=SearchResponse}	"成功响应示例"
 //	@Failure		400		{object}	map[string]string								"请求参数错误"
@@ -181,9 +193,13 @@ func SearchOnMultiChatByPost(c fiber.Ctx) error {
 			req.TypeFilters = msgTypes
 		}
 	}
+	if c.Query("export") != "" {
+		return exportSearch(c, req)
+	}
 	results, err := engine.GetEngine().Search(c.RequestCtx(), req)
 	if err != nil {
 		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
 	}
+	analytics.RecordSearch(c.RequestCtx(), analytics.SourceAPI, analytics.APIKeyRequester(getAPIKey(c)), req, results)
 	return ResponseSearch(c, results)
 }
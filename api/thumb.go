@@ -0,0 +1,85 @@
+package api
+
+import (
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gabriel-vasile/mimetype"
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/service"
+	"github.com/krau/btts/types"
+)
+
+// GetThumbnail 获取消息的缩略图
+//
+//	@Summary		获取缩略图
+//	@Description	获取图片或视频等文档消息的缩略图, 返回长边不小于 size 的最小尺寸, 都小于时返回最大的尺寸. 可使用文件流的签名链接访问
+//	@Tags			Client
+//	@Produce		image/jpeg
+//	@Security		ApiKeyAuth
+//	@Param			chat_id		query	int64	true	"聊天ID"
+//	@Param			message_id	query	int		true	"消息ID"
+//	@Param			size		query	int		false	"期望的长边像素, 默认 320"
+//	@Success		200			{file}	file	"缩略图"
+//	@Failure		400			{object}	map[string]string	"请求参数错误"
+//	@Failure		401			{object}	map[string]string	"未授权"
+//	@Failure		404			{object}	map[string]string	"消息没有缩略图"
+//	@Failure		500			{object}	map[string]string	"服务器内部错误"
+//	@Router			/client/thumb [get]
+func GetThumbnail(c fiber.Ctx) error {
+	chatID := fiber.Query[int64](c, "chat_id", 0)
+	messageID := fiber.Query(c, "message_id", 0)
+	if chatID == 0 || messageID <= 0 {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "Invalid chat_id or message_id"}
+	}
+	if err := ensureChatAllowed(c, chatID); err != nil {
+		return err
+	}
+	data, err := service.GetThumbnail(c.RequestCtx(), chatID, messageID, fiber.Query(c, "size", 0))
+	if err != nil {
+		if errors.Is(err, service.ErrNoThumbnail) {
+			return &fiber.Error{Code: fiber.StatusNotFound, Message: err.Error()}
+		}
+		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+	c.Set(fiber.HeaderContentType, mimetype.Detect(data).String())
+	c.Set(fiber.HeaderCacheControl, "private, max-age=86400")
+	return c.Send(data)
+}
+
+// thumbTypes 是可能带有缩略图的消息类型
+var thumbTypes = map[types.MessageType]bool{
+	types.MessageTypePhoto:    true,
+	types.MessageTypeVideo:    true,
+	types.MessageTypeDocument: true,
+}
+
+// thumbURL 返回搜索结果中媒体消息的缩略图链接, 设置了 API key 时使用当前请求的 key 签名, 以便直接用于 <img>
+func thumbURL(c fiber.Ctx, msgType int, chatID int64, messageID int64) string {
+	if !thumbTypes[types.MessageType(msgType)] {
+		return ""
+	}
+	base := c.BaseURL() + "/api/client/thumb?"
+	if config.C().Api.Key == "" {
+		query := url.Values{}
+		query.Set("chat_id", strconv.FormatInt(chatID, 10))
+		query.Set("message_id", strconv.FormatInt(messageID, 10))
+		return base + query.Encode()
+	}
+	ttl := defaultStreamLinkTTL
+	var kid uint
+	if key := getAPIKey(c); key != nil {
+		if !key.HasOperation(database.ApiKeyOpFileStream) {
+			return ""
+		}
+		kid = key.ID
+		if key.ExpiresAt != nil && time.Until(*key.ExpiresAt) < ttl {
+			ttl = time.Until(*key.ExpiresAt)
+		}
+	}
+	return base + signedMediaQuery(chatID, int(messageID), time.Now().Add(ttl), kid, "").Encode()
+}
@@ -58,6 +58,10 @@ type SearchHitResponse struct {
 	UserFullName      string                   `json:"user_full_name,omitempty"` // The full name of the user who sent the message, if available
 	ChatTitle         string                   `json:"chat_title,omitempty"`     // The title of the chat, if available
 	Timestamp         int64                    `json:"timestamp"`
+	GroupedID         int64                    `json:"grouped_id,omitempty"`    // The Telegram grouped ID of the album, if the message belongs to one
+	AlbumCaption      string                   `json:"album_caption,omitempty"` // The caption shared by the album
+	AlbumSize         int                      `json:"album_size,omitempty"`    // The number of items in the album, hits of the same album are collapsed into one
+	ThumbURL          string                   `json:"thumb_url,omitempty"`     // The thumbnail URL for media hits, the message may still have no thumbnail
 	Formatted         types.SearchHitFormatted `json:"_formatted"`
 }
 
@@ -104,8 +108,12 @@ func ResponseSearch(c fiber.Ctx, rawResp *types.SearchResponse) error {
 			ChatID:            hit.ChatID,
 			ChatTitle:         ChatTitle,
 			Timestamp:         hit.Timestamp,
+			GroupedID:         hit.GroupedID,
+			AlbumCaption:      hit.AlbumCaption,
+			AlbumSize:         hit.AlbumSize,
+			ThumbURL:          thumbURL(c, hit.Type, hit.ChatID, hit.ID),
 			Formatted:         hit.Formatted,
-			FullText:          strings.TrimSpace(hit.Message + " " + hit.Ocred + " " + hit.AIGenerated),
+			FullText:          hit.FullText(),
 			FullFormattedText: hit.FullFormattedText(),
 		}
 	}
@@ -145,12 +153,15 @@ func ResponseDocuments(c fiber.Ctx, docs []*types.MessageDocument) error {
 			Message:      doc.Message,
 			Ocred:        doc.Ocred,
 			AIGenerated:  doc.AIGenerated,
-			FullText:     strings.TrimSpace(doc.Message + " " + doc.Ocred + " " + doc.AIGenerated),
+			FullText:     doc.FullText(),
 			UserID:       doc.UserID,
 			UserFullName: userFullName,
 			ChatID:       doc.ChatID,
 			ChatTitle:    chatTitle,
 			Timestamp:    doc.Timestamp,
+			GroupedID:    doc.GroupedID,
+			AlbumCaption: doc.AlbumCaption,
+			ThumbURL:     thumbURL(c, doc.Type, doc.ChatID, doc.ID),
 		}
 	}
 	return c.JSON(fiber.Map{
@@ -176,6 +187,13 @@ type StreamFileRequest struct {
 	MessageID int   `json:"message_id" validate:"required" example:"987654321"` // 消息ID
 }
 
+type SignFileStreamRequest struct {
+	ChatID    int64 `json:"chat_id" validate:"required" example:"123456789"`    // 聊天ID
+	MessageID int   `json:"message_id" validate:"required" example:"987654321"` // 消息ID
+	TTL       int   `json:"ttl" validate:"min=0" example:"3600"`                // 有效期 (秒), 默认 3600, 最长 7 天
+	SingleUse bool  `json:"single_use" example:"false"`                         // 是否为一次性链接
+}
+
 type FetchMessagesRequest struct {
 	IDs []int `json:"ids" validate:"required" example:"123456789,987654321"` // 消息ID列表
 }
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/forget"
+)
+
+// ForgetUser 删除一个用户的全部索引数据
+//
+//	@Summary		删除用户数据
+//	@Description	删除用户在所有聊天中已索引的消息、用户信息和成员记录, never_index 为 true 时之后不再索引该用户的消息. 需要 master API key
+//	@Tags			User
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			user_id		path		int64												true	"用户ID"
+//	@Param			never_index	query		bool												false	"加入永不索引列表"
+//	@Success		200			{object}	object{status=string,result=forget.Result}			"成功响应"
+//	@Failure		400			{object}	map[string]string									"用户ID是必需的"
+//	@Failure		401			{object}	map[string]string									"未授权"
+//	@Failure		403			{object}	map[string]string									"需要 master API key"
+//	@Failure		500			{object}	map[string]string									"服务器内部错误"
+//	@Router			/users/{user_id} [delete]
+func ForgetUser(c fiber.Ctx) error {
+	if !isMasterAPIKey(c) {
+		return &fiber.Error{Code: fiber.StatusForbidden, Message: "This operation requires master API key"}
+	}
+	userID := fiber.Params[int64](c, "user_id", 0)
+	if userID == 0 {
+		return &fiber.Error{Code: fiber.StatusBadRequest, Message: "User ID is required"}
+	}
+	res, err := forget.Forget(c.RequestCtx(), engine.GetEngine(), userID, fiber.Query(c, "never_index", false))
+	if err != nil {
+		return &fiber.Error{Code: fiber.StatusInternalServerError, Message: err.Error()}
+	}
+	return c.JSON(fiber.Map{
+		"status": "success",
+		"result": res,
+	})
+}
@@ -0,0 +1,181 @@
+package archive
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/download"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/export"
+	"github.com/krau/btts/types"
+)
+
+// listPageSize 是从搜索引擎分页读取文档时每页的大小
+const listPageSize = 1000
+
+type Options struct {
+	ChatID int64
+	// 输出目录, 为空时使用 data/archive/<chat_id>
+	Dir string
+	// 通过下载器将媒体下载到输出目录的 media 子目录, 使存档可以离线浏览
+	Media   bool
+	Workers int
+}
+
+// ProgressFunc 报告存档进度, stage 为 "documents", "media" 或 "pages"
+type ProgressFunc func(stage string, current, total int)
+
+type message struct {
+	ID        int64
+	Time      time.Time
+	Sender    string
+	Text      string
+	Type      string
+	Emoji     string
+	Link      string
+	Media     string // 本地媒体文件相对于页面的路径
+	Image     bool
+	Video     bool
+	GroupedID int64
+}
+
+type day struct {
+	Date     string
+	Messages []message
+}
+
+// Build 将聊天的全部已索引消息生成为按天分页的静态 HTML 站点, 返回输出目录
+func Build(ctx context.Context, searcher engine.Searcher, opts Options, progress ProgressFunc) (string, error) {
+	if progress == nil {
+		progress = func(string, int, int) {}
+	}
+	chat, err := database.GetIndexChat(ctx, opts.ChatID)
+	if err != nil {
+		return "", fmt.Errorf("chat %d is not indexed: %w", opts.ChatID, err)
+	}
+	dir := opts.Dir
+	if dir == "" {
+		dir = filepath.Join("data", "archive", fmt.Sprintf("%d", opts.ChatID))
+	}
+
+	docs, err := listAllDocuments(ctx, searcher, opts.ChatID, progress)
+	if err != nil {
+		return "", err
+	}
+	if len(docs) == 0 {
+		return "", fmt.Errorf("chat %d has no indexed messages", opts.ChatID)
+	}
+	slices.SortFunc(docs, func(a, b *types.MessageDocument) int {
+		return cmp.Or(cmp.Compare(a.Timestamp, b.Timestamp), cmp.Compare(a.ID, b.ID))
+	})
+
+	var job *download.Job
+	if opts.Media {
+		job, err = downloadMedia(ctx, opts, dir, docs, progress)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	names := export.NewNames()
+	chatTitle := names.Chat(ctx, opts.ChatID)
+	days := make([]day, 0)
+	for _, doc := range docs {
+		t := time.Unix(doc.Timestamp, 0)
+		date := t.Format(time.DateOnly)
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, day{Date: date})
+		}
+		msgType := types.MessageType(doc.Type)
+		m := message{
+			ID:        doc.ID,
+			Time:      t,
+			Sender:    chatTitle,
+			Text:      doc.FullText(),
+			Type:      types.MessageTypeToDisplayString[msgType],
+			Emoji:     types.MessageTypeToEmoji[msgType],
+			Link:      doc.MessageLink(),
+			GroupedID: doc.GroupedID,
+		}
+		if doc.UserID != doc.ChatID {
+			m.Sender = names.User(ctx, doc.UserID)
+		}
+		if job != nil {
+			if rel, ok := job.File(doc.ChatID, int(doc.ID)); ok {
+				m.Media = "../media/" + filepath.ToSlash(rel)
+				m.Image = msgType == types.MessageTypePhoto
+				m.Video = msgType == types.MessageTypeVideo
+			}
+		}
+		days[len(days)-1].Messages = append(days[len(days)-1].Messages, m)
+	}
+
+	if err := writeSite(dir, chat, chatTitle, days, progress); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+func listAllDocuments(ctx context.Context, searcher engine.Searcher, chatID int64, progress ProgressFunc) ([]*types.MessageDocument, error) {
+	var docs []*types.MessageDocument
+	var offset int64
+	for {
+		page, total, err := searcher.ListDocuments(ctx, chatID, offset, listPageSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list documents: %w", err)
+		}
+		docs = append(docs, page...)
+		offset += int64(len(page))
+		progress("documents", len(docs), int(total))
+		if len(page) == 0 || offset >= total {
+			return docs, nil
+		}
+	}
+}
+
+// downloadMedia 使用下载器下载存档范围内的媒体, 已下载过的文件会被跳过
+func downloadMedia(ctx context.Context, opts Options, dir string, docs []*types.MessageDocument, progress ProgressFunc) (*download.Job, error) {
+	startID, endID := docs[0].ID, docs[0].ID
+	for _, doc := range docs {
+		startID = min(startID, doc.ID)
+		endID = max(endID, doc.ID)
+	}
+	job, err := download.Start(ctx, download.Request{
+		ChatID:  opts.ChatID,
+		StartID: int(startID),
+		EndID:   int(endID),
+		Dir:     filepath.Join(dir, "media"),
+		Workers: opts.Workers,
+	})
+	if err != nil {
+		return nil, err
+	}
+	done := make(chan download.Progress, 1)
+	go func() { done <- job.Wait() }()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			job.Cancel()
+			<-done
+			return nil, ctx.Err()
+		case <-ticker.C:
+			p := job.Progress()
+			progress("media", p.Done+p.Skipped+p.Failed, p.Total)
+		case p := <-done:
+			progress("media", p.Done+p.Skipped+p.Failed, p.Total)
+			if p.Status != download.StatusDone {
+				// 媒体下载失败时仍然生成存档, 未下载的媒体链接到 Telegram
+				log.FromContext(ctx).Warn("Media download did not complete", "status", p.Status, "error", p.Error)
+			}
+			return job, nil
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package archive
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/krau/btts/database"
+)
+
+const styleCSS = `body { font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; max-width: 860px; margin: 0 auto; padding: 24px; color: #222; background: #f5f5f5; }
+h1 { font-size: 1.4em; }
+h2 { font-size: 1.1em; margin-top: 24px; }
+nav { display: flex; justify-content: space-between; margin: 12px 0 20px; }
+a { color: #2b6cb0; text-decoration: none; }
+a:hover { text-decoration: underline; }
+.days a { display: inline-block; margin: 0 8px 6px 0; padding: 2px 8px; background: #fff; border-radius: 4px; }
+.days .count { color: #888; font-size: .8em; }
+.msg { background: #fff; border-radius: 8px; padding: 10px 14px; margin-bottom: 10px; box-shadow: 0 1px 2px rgba(0,0,0,.08); }
+.msg.album { border-left: 3px solid #90cdf4; }
+.head { font-size: .85em; color: #666; margin-bottom: 4px; }
+.sender { font-weight: 600; color: #2b6cb0; }
+.text { white-space: pre-wrap; word-break: break-word; }
+.media { margin-top: 6px; }
+.media img, .media video { max-width: 100%; max-height: 480px; border-radius: 4px; }
+.tg { float: right; font-size: .8em; }
+`
+
+var funcs = template.FuncMap{
+	"clock": func(t time.Time) string { return t.Format(time.TimeOnly) },
+}
+
+var indexTemplate = template.Must(template.New("index").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<div>共 {{.Total}} 条消息, {{.First}} 至 {{.Last}}{{if .Username}}, <a href="https://t.me/{{.Username}}" target="_blank" rel="noopener">@{{.Username}}</a>{{end}}</div>
+<div>生成于 {{.GeneratedAt}}</div>
+{{range .Months}}<h2>{{.Month}}</h2>
+<div class="days">{{range .Days}}<a href="days/{{.Date}}.html">{{.Date}} <span class="count">{{len .Messages}}</span></a>{{end}}</div>
+{{end}}</body>
+</html>
+`))
+
+var dayTemplate = template.Must(template.New("day").Funcs(funcs).Parse(`<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - {{.Day.Date}}</title>
+<link rel="stylesheet" href="../style.css">
+</head>
+<body>
+<h1>{{.Title}} - {{.Day.Date}}</h1>
+<nav><span>{{if .Prev}}<a href="{{.Prev}}.html">&larr; {{.Prev}}</a>{{end}}</span><a href="../index.html">目录</a><span>{{if .Next}}<a href="{{.Next}}.html">{{.Next}} &rarr;</a>{{end}}</span></nav>
+{{range .Day.Messages}}<div class="msg{{if .GroupedID}} album{{end}}" id="m{{.ID}}">
+<div class="head"><span class="sender">{{.Sender}}</span> · {{clock .Time}} · {{.Emoji}} {{.Type}}<a class="tg" href="{{.Link}}" target="_blank" rel="noopener">#{{.ID}}</a></div>
+{{if .Text}}<div class="text">{{.Text}}</div>{{end}}
+{{if .Media}}<div class="media">{{if .Image}}<a href="{{.Media}}"><img src="{{.Media}}" loading="lazy"></a>{{else if .Video}}<video src="{{.Media}}" controls preload="none"></video>{{else}}<a href="{{.Media}}">下载文件</a>{{end}}</div>{{end}}
+</div>
+{{end}}<nav><span>{{if .Prev}}<a href="{{.Prev}}.html">&larr; {{.Prev}}</a>{{end}}</span><a href="../index.html">目录</a><span>{{if .Next}}<a href="{{.Next}}.html">{{.Next}} &rarr;</a>{{end}}</span></nav>
+</body>
+</html>
+`))
+
+type month struct {
+	Month string
+	Days  []day
+}
+
+func writeSite(dir string, chat *database.IndexChat, title string, days []day, progress ProgressFunc) error {
+	if err := os.MkdirAll(filepath.Join(dir, "days"), 0755); err != nil {
+		return fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "style.css"), []byte(styleCSS), 0644); err != nil {
+		return err
+	}
+
+	total := 0
+	months := make([]month, 0)
+	for i, d := range days {
+		total += len(d.Messages)
+		// 日期格式为 2006-01-02, 前 7 个字符即月份
+		if len(months) == 0 || months[len(months)-1].Month != d.Date[:7] {
+			months = append(months, month{Month: d.Date[:7]})
+		}
+		months[len(months)-1].Days = append(months[len(months)-1].Days, d)
+
+		data := struct {
+			Title      string
+			Day        day
+			Prev, Next string
+		}{Title: title, Day: d}
+		if i > 0 {
+			data.Prev = days[i-1].Date
+		}
+		if i < len(days)-1 {
+			data.Next = days[i+1].Date
+		}
+		if err := writeTemplate(filepath.Join(dir, "days", d.Date+".html"), dayTemplate, data); err != nil {
+			return err
+		}
+		progress("pages", i+1, len(days))
+	}
+
+	return writeTemplate(filepath.Join(dir, "index.html"), indexTemplate, struct {
+		Title       string
+		Username    string
+		Total       int
+		First, Last string
+		GeneratedAt string
+		Months      []month
+	}{
+		Title:       title,
+		Username:    chat.Username,
+		Total:       total,
+		First:       days[0].Date,
+		Last:        days[len(days)-1].Date,
+		GeneratedAt: time.Now().Format(time.DateTime),
+		Months:      months,
+	})
+}
+
+func writeTemplate(path string, tmpl *template.Template, data any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := tmpl.Execute(f, data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to render %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,139 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+)
+
+const (
+	SourceBot = "bot"
+	SourceAPI = "api"
+
+	ResultOK     = "ok"
+	ResultDenied = "denied"
+	ResultError  = "error"
+
+	redacted = "[REDACTED]"
+
+	// maxBodyLen 是记录的请求体的最大长度, 超出部分截断
+	maxBodyLen = 1024
+)
+
+// 命令参数中敏感信息的位置 (不含命令本身)
+var secretCommandArgs = map[string][]int{
+	"addapikey":    {1}, // /addapikey <name> <key> <chat_ids...>
+	"addsub":       {0}, // /addsub <token> <chat_ids...>
+	"rotateapikey": {1}, // /rotateapikey <id> [new_key]
+}
+
+// 需要脱敏的 query 参数和 JSON 字段
+var secretQueryKeys = []string{"key", "token", "reqtoken", "api_key", "sig"}
+
+// Record 写入一条审计日志, 失败时仅记录错误, 不影响调用方
+func Record(ctx context.Context, entry *database.AuditLog) {
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+	if err := database.CreateAuditLog(ctx, entry); err != nil {
+		log.FromContext(ctx).Error("Failed to record audit log", "action", entry.Action, "error", err)
+	}
+}
+
+// RedactCommandArgs 返回脱敏后的命令参数
+func RedactCommandArgs(cmd string, args []string) []string {
+	res := slices.Clone(args)
+	for _, i := range secretCommandArgs[cmd] {
+		if i < len(res) {
+			res[i] = redacted
+		}
+	}
+	return res
+}
+
+// RedactQuery 返回脱敏后的 query string
+func RedactQuery(rawQuery string) string {
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return ""
+	}
+	for key := range values {
+		if slices.Contains(secretQueryKeys, key) {
+			values.Set(key, redacted)
+		}
+	}
+	return values.Encode()
+}
+
+// RedactBody 返回脱敏后的 JSON 请求体, 所有层级中名称为敏感参数的字段都会被替换.
+// 不是 JSON 的请求体只记录其长度
+func RedactBody(body []byte) string {
+	if len(body) == 0 {
+		return ""
+	}
+	var v any
+	if err := json.Unmarshal(body, &v); err != nil {
+		return fmt.Sprintf("[%d bytes]", len(body))
+	}
+	data, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return ""
+	}
+	s := string(data)
+	if len(s) > maxBodyLen {
+		s = strings.ToValidUTF8(s[:maxBodyLen], "") + "..."
+	}
+	return s
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if slices.Contains(secretQueryKeys, strings.ToLower(key)) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+// StartRetention 定期清理超过保留期限的审计日志
+func StartRetention(ctx context.Context) {
+	days := config.C().Audit.RetentionDays
+	if days <= 0 {
+		return
+	}
+	retention := time.Duration(days) * 24 * time.Hour
+	go func() {
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			deleted, err := database.DeleteAuditLogsBefore(ctx, time.Now().Add(-retention))
+			if err != nil {
+				log.FromContext(ctx).Error("Failed to clean up audit logs", "error", err)
+			} else if deleted > 0 {
+				log.FromContext(ctx).Info("Cleaned up audit logs", "deleted", deleted)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestRedactCommandArgs(t *testing.T) {
+	tests := []struct {
+		name     string
+		cmd      string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "No secrets",
+			cmd:      "watch",
+			args:     []string{"123"},
+			expected: []string{"123"},
+		},
+		{
+			name:     "API key",
+			cmd:      "addapikey",
+			args:     []string{"name", "secret", "1", "2"},
+			expected: []string{"name", redacted, "1", "2"},
+		},
+		{
+			name:     "Sub bot token",
+			cmd:      "addsub",
+			args:     []string{"123:token", "1"},
+			expected: []string{redacted, "1"},
+		},
+		{
+			name:     "Rotate without new key",
+			cmd:      "rotateapikey",
+			args:     []string{"3"},
+			expected: []string{"3"},
+		},
+		{
+			name:     "Rotate with new key",
+			cmd:      "rotateapikey",
+			args:     []string{"3", "secret"},
+			expected: []string{"3", redacted},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args := slices.Clone(tt.args)
+			if got := RedactCommandArgs(tt.cmd, args); !slices.Equal(got, tt.expected) {
+				t.Errorf("RedactCommandArgs() = %v, expected %v", got, tt.expected)
+			}
+			if !slices.Equal(args, tt.args) {
+				t.Errorf("RedactCommandArgs() modified its input: %v", args)
+			}
+		})
+	}
+}
+
+func TestRedactQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+	}{
+		{name: "Empty", query: "", expected: ""},
+		{name: "No secrets", query: "chat_id=1&q=hello", expected: "chat_id=1&q=hello"},
+		{name: "Key", query: "key=secret&chat_id=1", expected: "chat_id=1&key=%5BREDACTED%5D"},
+		{name: "Signed link", query: "sig=abc&reqtoken=def&exp=1", expected: "exp=1&reqtoken=%5BREDACTED%5D&sig=%5BREDACTED%5D"},
+		{name: "Repeated secret", query: "token=a&token=b", expected: "token=%5BREDACTED%5D"},
+		{name: "Invalid", query: "a=%zz", expected: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactQuery(tt.query); got != tt.expected {
+				t.Errorf("RedactQuery() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestRedactBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		expected string
+	}{
+		{name: "Empty", body: "", expected: ""},
+		{name: "No secrets", body: `{"chat_id":1,"message_ids":[1,2]}`, expected: `{"chat_id":1,"message_ids":[1,2]}`},
+		{name: "Top-level secret", body: `{"key":"secret","name":"bot"}`, expected: `{"key":"[REDACTED]","name":"bot"}`},
+		{name: "Nested secret", body: `{"args":[{"Token":"secret"}]}`, expected: `{"args":[{"Token":"[REDACTED]"}]}`},
+		{name: "Not JSON", body: "key=secret", expected: "[10 bytes]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RedactBody([]byte(tt.body)); got != tt.expected {
+				t.Errorf("RedactBody() = %q, expected %q", got, tt.expected)
+			}
+		})
+	}
+
+	long := `{"text":"` + strings.Repeat("a", 2*maxBodyLen) + `"}`
+	if got := RedactBody([]byte(long)); len(got) != maxBodyLen+len("...") || !strings.HasSuffix(got, "...") {
+		t.Errorf("RedactBody() of a long body has length %d, expected it to be truncated to %d", len(got), maxBodyLen)
+	}
+}
@@ -0,0 +1,52 @@
+package bot
+
+import (
+	"fmt"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/userclient"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+func AccountsHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	accounts, err := database.GetAllUserAccounts(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get user accounts", "error", err)
+		replyFailure(ctx, update, "Failed to get user accounts")
+		return dispatcher.EndGroups
+	}
+	chats, err := database.GetAllIndexChats(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get index chats", "error", err)
+		replyFailure(ctx, update, "Failed to get index chats")
+		return dispatcher.EndGroups
+	}
+	owned := make(map[int64]int)
+	for _, chat := range chats {
+		owned[userclient.GetUserClientForChat(chat.ChatID).ID()]++
+	}
+	stylings := []styling.StyledTextOption{styling.Bold("用户账号:\n")}
+	for _, account := range accounts {
+		status := "offline"
+		if userclient.IsAccount(account.UserID) {
+			status = "online"
+		}
+		name := account.FirstName
+		if account.LastName != "" {
+			name += " " + account.LastName
+		}
+		stylings = append(stylings,
+			styling.Code(account.Name),
+			styling.Plain(fmt.Sprintf(" - %s (%d) [%s] chats: %d\n", name, account.UserID, status, owned[account.UserID])),
+		)
+	}
+	stylings = append(stylings, styling.Plain("\n使用 btts account add <name> 登录新账号, 使用 /add <chat> <account> 指定负责聊天的账号"))
+	ctx.Reply(update, ext.ReplyTextStyledTextArray(stylings), nil)
+	return dispatcher.EndGroups
+}
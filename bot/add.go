@@ -10,6 +10,9 @@ import (
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ocr"
+	"github.com/krau/btts/rules"
+	"github.com/krau/btts/userclient"
 	"github.com/krau/mygotg/dispatcher"
 	"github.com/krau/mygotg/ext"
 )
@@ -20,20 +23,29 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	args := update.Args()
 	if len(args) < 2 {
-		ctx.Reply(update, ext.ReplyTextString("Usage: /add <chat>"), nil)
+		ctx.Reply(update, ext.ReplyTextString("Usage: /add <chat> [account]"), nil)
 		return dispatcher.EndGroups
 	}
 	log := log.FromContext(ctx)
 
 	chatArg := args[1]
+	account := bi.UserClient
+	if len(args) > 2 {
+		var ok bool
+		account, ok = userclient.FindUserClient(args[2])
+		if !ok {
+			ctx.Reply(update, ext.ReplyTextString("Account not found: "+args[2]), nil)
+			return dispatcher.EndGroups
+		}
+	}
 	var inputPeer tg.InputPeerClass
-	utclient := bi.UserClient.TClient
+	utclient := account.TClient
 
 	chatId, err := strconv.ParseInt(chatArg, 10, 64)
 	if err != nil {
 		effChat, err := utclient.CreateContext().ResolveUsername(strings.TrimPrefix(chatArg, "@"))
 		if err != nil {
-			ctx.Reply(update, ext.ReplyTextString("Failed to resolve username: "+err.Error()), nil)
+			replyFailure(ctx, update, "Failed to resolve username: "+err.Error())
 			return dispatcher.EndGroups
 		}
 		inputPeer = effChat.GetInputPeer()
@@ -45,6 +57,9 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString("Chat not found"), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatId) {
+		return dispatcher.EndGroups
+	}
 
 	_, err = database.GetIndexChat(ctx, chatId)
 	if err == nil {
@@ -53,7 +68,7 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 
 	if err := bi.Engine.CreateIndex(ctx, chatId); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to create index: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to create index: "+err.Error())
 		return dispatcher.EndGroups
 	}
 
@@ -73,6 +88,7 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 		ChatID:   chatId,
 		Watching: true,
 		Public:   false,
+		OwnerID:  account.ID(),
 	}
 
 	switch inp := inputPeer.(type) {
@@ -88,21 +104,24 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 
 	if gerr = database.UpsertIndexChat(ctx, indexChat); gerr != nil {
 		log.Errorf("Failed to upsert index chat: %v", gerr)
-		ctx.Reply(update, ext.ReplyTextString("Failed to add chat"), nil)
+		replyFailure(ctx, update, "Failed to add chat")
 		return dispatcher.EndGroups
 	}
 
-	log.Infof("Adding chat: %s", chatArg)
+	log.Infof("Adding chat: %s, account: %s", chatArg, account.Name)
 
 	queryHistoryBuilder := query.Messages(utclient.API()).GetHistory(inputPeer).BatchSize(100)
 	total, err := queryHistoryBuilder.Count(ctx)
 	if err != nil {
 		total = -1
-		ctx.Reply(update, ext.ReplyTextString("Failed to count messages: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to count messages: "+err.Error())
 	}
 
 	ctx.Reply(update, ext.ReplyTextString("Total messages: "+strconv.Itoa(total)), nil)
 
+	enableOcr := ocr.Enabled(indexChat)
+	rule := rules.Get(ctx, chatId)
+	bots := make(rules.Bots)
 	messageBatch := make([]*tg.Message, 0, 100)
 	iter := queryHistoryBuilder.Iter()
 	processed := 0
@@ -112,11 +131,19 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 		switch msg := msg.(type) {
 		case *tg.Message:
 			messageBatch = append(messageBatch, msg)
+			for _, user := range value.Entities.Users() {
+				bots.Add(user)
+			}
 			if len(messageBatch) >= 100 {
 				log.Debugf("Adding batch of messages %d/%d", processed, total)
-				docs := engine.DocumentsFromMessages(ctx, messageBatch, chatId, utclient.Self.ID, bi.UserClient.GetContext(), false)
+				indexable := rule.Filter(messageBatch, utclient.Self.ID, bots)
+				docs := engine.DocumentsFromMessages(ctx, indexable, chatId, utclient.Self.ID, enableOcr)
 				if err := bi.Engine.AddDocuments(ctx, chatId, docs); err != nil {
 					log.Errorf("Failed to add documents: %v", err)
+				} else if enableOcr {
+					if err := ocr.SubmitWait(ctx, account.GetContext(), chatId, indexable); err != nil {
+						log.Warnf("Failed to submit OCR jobs: %v", err)
+					}
 				}
 				messageBatch = messageBatch[:0]
 			}
@@ -127,14 +154,19 @@ func AddHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	if err := iter.Err(); err != nil {
 		gerr = err
-		ctx.Reply(update, ext.ReplyTextString("Error: "+err.Error()), nil)
+		replyFailure(ctx, update, "Error: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	if len(messageBatch) > 0 {
 		log.Debugf("Adding final batch of messages %d/%d", processed, total)
-		docs := engine.DocumentsFromMessages(ctx, messageBatch, chatId, utclient.Self.ID, bi.UserClient.GetContext(), false)
+		indexable := rule.Filter(messageBatch, utclient.Self.ID, bots)
+		docs := engine.DocumentsFromMessages(ctx, indexable, chatId, utclient.Self.ID, enableOcr)
 		if err := bi.Engine.AddDocuments(ctx, chatId, docs); err != nil {
 			log.Errorf("Failed to add documents: %v", err)
+		} else if enableOcr {
+			if err := ocr.SubmitWait(ctx, account.GetContext(), chatId, indexable); err != nil {
+				log.Warnf("Failed to submit OCR jobs: %v", err)
+			}
 		}
 	}
 
@@ -0,0 +1,80 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/krau/btts/analytics"
+	"github.com/krau/btts/database"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+const searchStatsLimit = 10
+
+// SearchStatsHandler 查看搜索统计
+//
+//	/searchstats [days]
+func SearchStatsHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	days := 7
+	if args := update.Args(); len(args) > 1 {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 || n > 3650 {
+			ctx.Reply(update, ext.ReplyTextString("Usage: /searchstats [days]"), nil)
+			return dispatcher.EndGroups
+		}
+		days = n
+	}
+	report, err := analytics.GetReport(ctx, days, searchStatsLimit)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get search stats", "error", err)
+		replyFailure(ctx, update, "Failed to get search stats")
+		return dispatcher.EndGroups
+	}
+	if report.Summary.Searches == 0 {
+		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("No searches in the last %d days", days)), nil)
+		return dispatcher.EndGroups
+	}
+
+	s := report.Summary
+	stylings := []styling.StyledTextOption{
+		styling.Bold(fmt.Sprintf("搜索统计 (最近 %d 天)\n", days)),
+		styling.Plain(fmt.Sprintf("共 %d 次搜索, %d 次无结果, %d 位搜索者, 平均耗时 %.0f ms\n",
+			s.Searches, s.ZeroResults, s.Requesters, s.AvgLatencyMs)),
+	}
+	writeQueries := func(title string, stats []*database.SearchQueryStat) {
+		if len(stats) == 0 {
+			return
+		}
+		stylings = append(stylings, styling.Bold("\n"+title+"\n"))
+		for i, q := range stats {
+			stylings = append(stylings,
+				styling.Plain(fmt.Sprintf("%d. ", i+1)),
+				styling.Code(analytics.DisplayQuery(q)),
+				styling.Plain(fmt.Sprintf(" × %d | %d 人 | 平均 %.0f 条结果\n", q.Count, q.Requesters, q.AvgHits)),
+			)
+		}
+	}
+	writeQueries("热门搜索", report.TopQueries)
+	writeQueries("无结果搜索", report.ZeroResultQueries)
+
+	stylings = append(stylings, styling.Bold("\n聊天\n"))
+	for _, c := range report.Chats {
+		title := "多个聊天"
+		if c.ChatID != 0 {
+			title = strconv.FormatInt(c.ChatID, 10)
+			if chat, err := database.GetIndexChat(ctx, c.ChatID); err == nil && chat.Title != "" {
+				title = fmt.Sprintf("%s [%d]", chat.Title, c.ChatID)
+			}
+		}
+		stylings = append(stylings, styling.Plain(fmt.Sprintf("%s: %d 次 | %d 次无结果 | %d 人\n",
+			title, c.Count, c.ZeroResults, c.Requesters)))
+	}
+	ctx.Reply(update, ext.ReplyTextStyledTextArray(stylings), nil)
+	return dispatcher.EndGroups
+}
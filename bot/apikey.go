@@ -3,8 +3,14 @@ package bot
 import (
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/charmbracelet/log"
+	"github.com/dustin/go-humanize"
 	"github.com/google/uuid"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/krau/btts/database"
@@ -42,12 +48,13 @@ func AddApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 		chats = append(chats, database.IndexChat{ChatID: id})
 	}
 	apiKey := &database.ApiKey{
-		Name:    name,
-		KeyHash: hash,
-		Chats:   chats,
+		Name:      name,
+		KeyHash:   hash,
+		Chats:     chats,
+		CreatedBy: userIDFromUpdate(update),
 	}
 	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to save api key: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to save api key: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	st := []styling.StyledTextOption{
@@ -99,7 +106,7 @@ func DelApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 		return dispatcher.EndGroups
 	}
 	if err = database.DeleteApiKey(ctx, uint(idVal)); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to delete api key: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to delete api key: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Api key deleted"), nil)
@@ -113,13 +120,18 @@ func ListApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	keys, err := database.GetAllApiKeys(ctx)
 	if err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to list api keys: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to list api keys: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	if len(keys) == 0 {
 		ctx.Reply(update, ext.ReplyTextString("No api keys"), nil)
 		return dispatcher.EndGroups
 	}
+	usages, err := database.GetApiKeyUsagesByDay(ctx, database.UsageDay(time.Now()))
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get api key usages", "error", err)
+		usages = map[uint]*database.ApiKeyUsage{}
+	}
 	st := []styling.StyledTextOption{
 		styling.Bold("API keys:\n"),
 	}
@@ -135,11 +147,267 @@ func ListApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 			}
 			st = append(st, styling.Code(strconv.FormatInt(id, 10)))
 		}
+		status := "active"
+		switch {
+		case k.Disabled:
+			status = "disabled"
+		case k.Expired(time.Now()):
+			status = "expired"
+		}
+		expires := "never"
+		if k.ExpiresAt != nil {
+			expires = k.ExpiresAt.Format(time.DateTime)
+		}
+		lastUsed := "never"
+		if k.LastUsedAt != nil {
+			lastUsed = fmt.Sprintf("%s from %s", k.LastUsedAt.Format(time.DateTime), k.LastUsedIP)
+		}
+		st = append(st, styling.Plain(fmt.Sprintf("\n  Status: %s, Expires: %s, Ops: %s\n  Created by %d, Last used: %s",
+			status, expires, strings.Join(k.AllowedOperations(), ","), k.CreatedBy, lastUsed)))
+		usage := usages[k.ID]
+		if usage == nil {
+			usage = &database.ApiKeyUsage{}
+		}
+		st = append(st, styling.Plain(fmt.Sprintf("\n  Today: %d requests, %s searches, %s streamed; Limit: %s req/min",
+			usage.Requests,
+			formatQuota(usage.Searches, int64(k.DailySearchQuota), func(n int64) string { return strconv.FormatInt(n, 10) }),
+			formatQuota(usage.StreamBytes, k.DailyStreamBytes, func(n int64) string { return humanize.IBytes(uint64(n)) }),
+			formatLimit(k.RateLimit),
+		)))
 	}
 	ctx.Reply(update, ext.ReplyTextStyledTextArray(st), nil)
 	return dispatcher.EndGroups
 }
 
+// getApiKeyArg 解析命令中的 api key id 并获取对应的 key, 失败时会回复用户
+func getApiKeyArg(ctx *ext.Context, update *ext.Update, minArgs int, usage string) (*database.ApiKey, []string, bool) {
+	args := update.Args()
+	if len(args) < minArgs {
+		ctx.Reply(update, ext.ReplyTextString("Usage: "+usage), nil)
+		return nil, nil, false
+	}
+	idVal, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid api key id"), nil)
+		return nil, nil, false
+	}
+	apiKey, err := database.GetApiKeyByID(ctx, uint(idVal))
+	if err != nil {
+		replyFailure(ctx, update, "Failed to get api key: "+err.Error())
+		return nil, nil, false
+	}
+	return apiKey, args, true
+}
+
+// /rotateapikey <id> [new_key]
+// 更换 key 的密钥, 保留作用域和其他设置. 未指定新 key 时随机生成
+func RotateApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	apiKey, args, ok := getApiKeyArg(ctx, update, 2, "/rotateapikey <id> [new_key]")
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	plainKey := uuid.NewString()
+	if len(args) > 2 {
+		plainKey = args[2]
+	}
+	sum := sha256.Sum256([]byte(plainKey))
+	apiKey.KeyHash = hex.EncodeToString(sum[:])
+	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
+		replyFailure(ctx, update, "Failed to update api key: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	st := []styling.StyledTextOption{
+		styling.Bold("API key rotated:\n"),
+		styling.Plain("ID: "),
+		styling.Code(strconv.FormatUint(uint64(apiKey.ID), 10)),
+		styling.Plain("\nNew key: "), // 仅此处展示明文 key
+		styling.Code(plainKey),
+	}
+	ctx.Reply(update, ext.ReplyTextStyledTextArray(st), nil)
+	return dispatcher.EndGroups
+}
+
+// /disableapikey <id>
+func DisableApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
+	return setApiKeyDisabled(ctx, update, true)
+}
+
+// /enableapikey <id>
+func EnableApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
+	return setApiKeyDisabled(ctx, update, false)
+}
+
+func setApiKeyDisabled(ctx *ext.Context, update *ext.Update, disabled bool) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	apiKey, _, ok := getApiKeyArg(ctx, update, 2, "/"+commandFromUpdate(update)+" <id>")
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	apiKey.Disabled = disabled
+	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
+		replyFailure(ctx, update, "Failed to update api key: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	if disabled {
+		ctx.Reply(update, ext.ReplyTextString("Api key disabled"), nil)
+	} else {
+		ctx.Reply(update, ext.ReplyTextString("Api key enabled"), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// /expireapikey <id> <duration|date|never>
+func ExpireApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	apiKey, args, ok := getApiKeyArg(ctx, update, 3, "/expireapikey <id> <duration|date|never>\ne.g. 30d, 12h, 2026-12-31")
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	expiresAt, err := parseExpiry(args[2], time.Now())
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid expiry: "+err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	apiKey.ExpiresAt = expiresAt
+	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
+		replyFailure(ctx, update, "Failed to update api key: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	if expiresAt == nil {
+		ctx.Reply(update, ext.ReplyTextString("Api key never expires now"), nil)
+	} else {
+		ctx.Reply(update, ext.ReplyTextString("Api key expires at "+expiresAt.Format(time.DateTime)), nil)
+	}
+	return dispatcher.EndGroups
+}
+
+// parseExpiry 解析过期时间, 支持 never, 时长 (如 30d, 12h) 和日期 (2006-01-02 或 RFC3339)
+func parseExpiry(s string, now time.Time) (*time.Time, error) {
+	if strings.EqualFold(s, "never") {
+		return nil, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n > 0 {
+			t := now.AddDate(0, 0, n)
+			return &t, nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		t := now.Add(d)
+		return &t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return &t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return &t, nil
+	}
+	return nil, fmt.Errorf("cannot parse %q", s)
+}
+
+// /opsapikey <id> <ops...|default>
+func OpsApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	usage := fmt.Sprintf("/opsapikey <id> <ops...|default>\nAvailable ops: %s\nDefault: %s",
+		strings.Join(database.ApiKeyOperations, " "), strings.Join(database.DefaultApiKeyOperations, " "))
+	apiKey, args, ok := getApiKeyArg(ctx, update, 3, usage)
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	ops := make([]string, 0, len(args)-2)
+	for _, op := range args[2:] {
+		op = strings.ToLower(op)
+		if op == "default" {
+			ops = nil
+			break
+		}
+		if !slices.Contains(database.ApiKeyOperations, op) {
+			ctx.Reply(update, ext.ReplyTextString("Unknown operation: "+op+"\nUsage: "+usage), nil)
+			return dispatcher.EndGroups
+		}
+		if !slices.Contains(ops, op) {
+			ops = append(ops, op)
+		}
+	}
+	apiKey.Operations = ops
+	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
+		replyFailure(ctx, update, "Failed to update api key: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(update, ext.ReplyTextString("Api key operations updated: "+strings.Join(apiKey.AllowedOperations(), " ")), nil)
+	return dispatcher.EndGroups
+}
+
+// /limitapikey <id> <req_per_min> <daily_searches> <daily_stream_bytes>
+func LimitApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()
+	if len(args) != 5 {
+		ctx.Reply(update, ext.ReplyTextString("Usage: /limitapikey <id> <req_per_min> <daily_searches> <daily_stream_bytes>\n0 means unlimited, bytes accept units like 500MB, 2GiB"), nil)
+		return dispatcher.EndGroups
+	}
+	idVal, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid api key id"), nil)
+		return dispatcher.EndGroups
+	}
+	rateLimit, err := strconv.Atoi(args[2])
+	if err != nil || rateLimit < 0 {
+		ctx.Reply(update, ext.ReplyTextString("Invalid request limit: "+args[2]), nil)
+		return dispatcher.EndGroups
+	}
+	searchQuota, err := strconv.Atoi(args[3])
+	if err != nil || searchQuota < 0 {
+		ctx.Reply(update, ext.ReplyTextString("Invalid search quota: "+args[3]), nil)
+		return dispatcher.EndGroups
+	}
+	streamBytes, err := humanize.ParseBytes(args[4])
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid stream quota: "+args[4]), nil)
+		return dispatcher.EndGroups
+	}
+	apiKey, err := database.GetApiKeyByID(ctx, uint(idVal))
+	if err != nil {
+		replyFailure(ctx, update, "Failed to get api key: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	apiKey.RateLimit = rateLimit
+	apiKey.DailySearchQuota = searchQuota
+	apiKey.DailyStreamBytes = int64(streamBytes)
+	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
+		replyFailure(ctx, update, "Failed to update api key: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(update, ext.ReplyTextString("Api key limits updated"), nil)
+	return dispatcher.EndGroups
+}
+
+func formatLimit(limit int) string {
+	if limit <= 0 {
+		return "unlimited"
+	}
+	return strconv.Itoa(limit)
+}
+
+// formatQuota 格式化为 "已用/配额", 配额为 0 时仅显示已用量
+func formatQuota(used, quota int64, format func(int64) string) string {
+	if quota <= 0 {
+		return format(used)
+	}
+	return format(used) + "/" + format(quota)
+}
+
 // /setapikey <id> <chat_ids...>
 func SetApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 	if !CheckPermission(ctx, update) {
@@ -157,7 +425,7 @@ func SetApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	apiKey, err := database.GetApiKeyByID(ctx, uint(idVal))
 	if err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to get api key: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to get api key: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	chatIDsArgs := args[2:]
@@ -172,7 +440,7 @@ func SetApiKeyHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	apiKey.Chats = chats
 	if err := database.UpsertApiKey(ctx, apiKey); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to update api key: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to update api key: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Api key chats updated"), nil)
@@ -0,0 +1,148 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/krau/btts/audit"
+	"github.com/krau/btts/database"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+const auditPageSize = 20
+
+// auditCommand 包装一个命令 handler, 记录管理命令的执行者、参数和结果. 公开命令不记录
+func auditCommand(cmd string, handler func(ctx *ext.Context, update *ext.Update) error) func(ctx *ext.Context, update *ext.Update) error {
+	if slices.Contains(publicCommands, cmd) {
+		return handler
+	}
+	return func(ctx *ext.Context, update *ext.Update) error {
+		entry := &database.AuditLog{
+			Source:  audit.SourceBot,
+			ActorID: userIDFromUpdate(update),
+			Action:  cmd,
+		}
+		if user := update.EffectiveUser(); user != nil {
+			entry.Actor = strings.TrimSpace(user.FirstName + " " + user.LastName)
+			if user.Username != "" {
+				entry.Actor += " (@" + user.Username + ")"
+			}
+		}
+		if args := update.Args(); len(args) > 1 {
+			redactedArgs := audit.RedactCommandArgs(cmd, args[1:])
+			entry.Target = redactedArgs[0]
+			entry.Args = strings.Join(redactedArgs, " ")
+		}
+		if !CheckCommandPermission(ctx, update, cmd) {
+			entry.Result = audit.ResultDenied
+			audit.Record(ctx, entry)
+			return handler(ctx, update)
+		}
+		outcome := &auditOutcome{result: audit.ResultOK}
+		parent := ctx.Context
+		ctx.Context = context.WithValue(parent, auditOutcomeKey{}, outcome)
+		err := handler(ctx, update)
+		ctx.Context = parent
+		entry.Result, entry.Error = outcome.result, outcome.err
+		if err != nil && !errors.Is(err, dispatcher.EndGroups) && !errors.Is(err, dispatcher.ContinueGroups) {
+			entry.Result = audit.ResultError
+			entry.Error = err.Error()
+		}
+		audit.Record(ctx, entry)
+		return err
+	}
+}
+
+type auditOutcomeKey struct{}
+
+// auditOutcome 是 handler 报告的执行结果. handler 回复用户后总是返回 EndGroups,
+// 因此被拒绝或失败的操作需要通过 auditDenied 和 auditFailed 报告
+type auditOutcome struct {
+	result string
+	err    string
+}
+
+// auditDenied 将当前命令记录为被拒绝, 不在 auditCommand 中时不做任何事
+func auditDenied(ctx context.Context, reason string) {
+	if outcome, ok := ctx.Value(auditOutcomeKey{}).(*auditOutcome); ok {
+		outcome.result, outcome.err = audit.ResultDenied, reason
+	}
+}
+
+// auditFailed 将当前命令记录为失败, 不在 auditCommand 中时不做任何事
+func auditFailed(ctx context.Context, reason string) {
+	if outcome, ok := ctx.Value(auditOutcomeKey{}).(*auditOutcome); ok {
+		outcome.result, outcome.err = audit.ResultError, reason
+	}
+}
+
+// replyFailure 回复失败信息并将当前命令记录为失败
+func replyFailure(ctx *ext.Context, update *ext.Update, text string) {
+	auditFailed(ctx, text)
+	ctx.Reply(update, ext.ReplyTextString(text), nil)
+}
+
+// AuditHandler 查看最近的审计日志
+//
+//	/audit [action|user_id] [page]
+func AuditHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	query := database.AuditLogQuery{Limit: auditPageSize}
+	page := 1
+	for _, arg := range update.Args()[1:] {
+		if n, err := strconv.ParseInt(arg, 10, 64); err == nil {
+			if n > 0 && n < 10000 {
+				page = int(n)
+			} else {
+				query.ActorID = n
+			}
+			continue
+		}
+		query.Action = strings.TrimPrefix(strings.ToLower(arg), "/")
+	}
+	query.Offset = (page - 1) * auditPageSize
+	logs, total, err := database.QueryAuditLogs(ctx, query)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to query audit logs", "error", err)
+		replyFailure(ctx, update, "Failed to query audit logs")
+		return dispatcher.EndGroups
+	}
+	if len(logs) == 0 {
+		ctx.Reply(update, ext.ReplyTextString("No audit logs"), nil)
+		return dispatcher.EndGroups
+	}
+	totalPages := (int(total) + auditPageSize - 1) / auditPageSize
+	stylings := []styling.StyledTextOption{
+		styling.Bold(fmt.Sprintf("审计日志 (第 %d/%d 页, 共 %d 条)\n\n", page, totalPages, total)),
+	}
+	for _, l := range logs {
+		actor := l.Actor
+		if l.ActorID != 0 {
+			actor = fmt.Sprintf("%s [%d]", l.Actor, l.ActorID)
+		}
+		stylings = append(stylings,
+			styling.Plain(l.CreatedAt.Format(time.DateTime)+" "),
+			styling.Code(l.Action),
+			styling.Plain(fmt.Sprintf(" %s | %s | %s", l.Result, l.Source, strings.TrimSpace(actor))),
+		)
+		if l.Args != "" {
+			stylings = append(stylings, styling.Plain(" | "), styling.Code(l.Args))
+		}
+		if l.Error != "" {
+			stylings = append(stylings, styling.Plain(" | "+l.Error))
+		}
+		stylings = append(stylings, styling.Plain("\n"))
+	}
+	ctx.Reply(update, ext.ReplyTextStyledTextArray(stylings), nil)
+	return dispatcher.EndGroups
+}
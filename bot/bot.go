@@ -45,20 +45,25 @@ func (b *Bot) Start(ctx context.Context) {
 
 	b.RegisterHandlers(ctx)
 
-	b.UserClient.StartWatch(ctx)
+	for _, client := range userclient.GetUserClients() {
+		client.StartWatch(ctx)
+	}
 	err := subbot.StartStored(ctx)
 	if err != nil {
 		log.Errorf("Failed to start sub bots: %v", err)
 	}
 	for _, sb := range subbot.GetAll() {
-		b.UserClient.AddGlobalIgnoreUser(sb.ID)
+		userclient.AddGlobalIgnoreUser(sb.ID)
 	}
+	b.startHealthMonitor(ctx)
 
 	log.Info("Bot started.")
 	<-ctx.Done()
 	log.Info("Exiting...")
-	if err := b.UserClient.Close(); err != nil {
-		log.Errorf("Failed to close user client: %v", err)
+	for _, client := range userclient.GetUserClients() {
+		if err := client.Close(); err != nil {
+			log.Errorf("Failed to close user client %s: %v", client.Name, err)
+		}
 	}
 	for _, sb := range subbot.GetAll() {
 		sb.Stop()
@@ -84,9 +89,9 @@ func NewBot(ctx context.Context, userClient *userclient.UserClient, engine engin
 	})
 	go func() {
 		tclient, err := mygotg.NewClient(
-			config.C.AppID,
-			config.C.AppHash,
-			mygotg.ClientTypeBot(config.C.BotToken),
+			config.C().AppID,
+			config.C().AppHash,
+			mygotg.ClientTypeBot(config.C().BotToken),
 			&mygotg.ClientOpts{
 				AutoFetchReply:   true,
 				Session:          session.SqlSession(gormlite.Open("data/session_bot.db")),
@@ -117,7 +122,7 @@ func NewBot(ctx context.Context, userClient *userclient.UserClient, engine engin
 		if b.Client.Self.ID == 0 {
 			log.Fatalf("Failed to get bot ID")
 		}
-		userClient.AddGlobalIgnoreUser(b.Client.Self.ID)
+		userclient.AddGlobalIgnoreUser(b.Client.Self.ID)
 		return bi, nil
 	}
 }
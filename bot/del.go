@@ -24,16 +24,25 @@ func DelHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString("Invalid chat ID"), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, int64(chatID)) {
+		return dispatcher.EndGroups
+	}
 	if err := database.DeleteIndexChat(ctx, int64(chatID)); err != nil {
 		log.FromContext(ctx).Error("Failed to delete chat", "chat_id", chatID, "error", err)
-		ctx.Reply(update, ext.ReplyTextString("Failed to delete chat"), nil)
+		replyFailure(ctx, update, "Failed to delete chat")
 		return dispatcher.EndGroups
 	}
 	if err := bi.Engine.DeleteIndex(ctx, int64(chatID)); err != nil {
 		log.FromContext(ctx).Error("Failed to delete index", "chat_id", chatID, "error", err)
-		ctx.Reply(update, ext.ReplyTextString("Failed to delete index"), nil)
+		replyFailure(ctx, update, "Failed to delete index")
 		return dispatcher.EndGroups
 	}
+	if err := database.DeleteIndexRule(ctx, int64(chatID)); err != nil {
+		log.FromContext(ctx).Warn("Failed to delete index rule", "chat_id", chatID, "error", err)
+	}
+	if err := database.DeleteRetentionPolicy(ctx, int64(chatID)); err != nil {
+		log.FromContext(ctx).Warn("Failed to delete retention policy", "chat_id", chatID, "error", err)
+	}
 	ctx.Reply(update, ext.ReplyTextString("Deleted chat and index"), nil)
 	return dispatcher.EndGroups
 }
@@ -8,6 +8,9 @@ import (
 	"github.com/charmbracelet/log"
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ocr"
+	"github.com/krau/btts/rules"
+	"github.com/krau/btts/userclient"
 	"github.com/krau/btts/utils"
 	"github.com/krau/mygotg/dispatcher"
 	"github.com/krau/mygotg/ext"
@@ -22,6 +25,9 @@ func DownloadHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /dl <chat_id> <message_range>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	if len(update.Args()) < 3 {
 		ctx.Reply(update, ext.ReplyTextString("Usage: /dl <chat_id> <message_range>"), nil)
 		return dispatcher.EndGroups
@@ -52,14 +58,17 @@ func DownloadHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	chatID := chatDB.ChatID
 
-	uapi := bi.UserClient.TClient.API()
-	upeer := bi.UserClient.TClient.PeerStorage
+	account := userclient.GetUserClientForChat(chatID)
+	uapi := account.TClient.API()
+	upeer := account.TClient.PeerStorage
 	inputPeer := upeer.GetInputPeerById(chatID)
 	if inputPeer == nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to get input peer"), nil)
+		replyFailure(ctx, update, "Failed to get input peer")
 		return dispatcher.EndGroups
 	}
 
+	enableOcr := ocr.Enabled(chatDB)
+	rule := rules.Get(ctx, chatID)
 	total := endMsgID - startMsgID
 	processed := 0
 	for i := 0; i < total; i += 100 {
@@ -73,18 +82,22 @@ func DownloadHandler(ctx *ext.Context, update *ext.Update) error {
 			Limit:     100,
 		})
 		if err != nil {
-			ctx.Reply(update, ext.ReplyTextString("Failed to get messages: "+err.Error()), nil)
+			replyFailure(ctx, update, "Failed to get messages: "+err.Error())
 			return dispatcher.EndGroups
 		}
 
 		var msgClass []tg.MessageClass
+		bots := make(rules.Bots)
 		switch msgsv := msgs.(type) {
 		case *tg.MessagesMessages:
 			msgClass = msgsv.GetMessages()
+			bots.Add(msgsv.GetUsers()...)
 		case *tg.MessagesMessagesSlice:
 			msgClass = msgsv.GetMessages()
+			bots.Add(msgsv.GetUsers()...)
 		case *tg.MessagesChannelMessages:
 			msgClass = msgsv.GetMessages()
+			bots.Add(msgsv.GetUsers()...)
 		default:
 			log.FromContext(ctx).Errorf("Unsupported message type: %T", msgsv)
 			continue
@@ -107,9 +120,14 @@ func DownloadHandler(ctx *ext.Context, update *ext.Update) error {
 		if len(messageBatch) > 0 {
 			processed += len(messageBatch)
 			log.FromContext(ctx).Debugf("Adding batch of messages %d/%d", processed, total)
-			docs := engine.DocumentsFromMessages(ctx, messageBatch, chatID, bi.UserClient.TClient.Self.ID, bi.UserClient.GetContext(), false)
+			indexable := rule.Filter(messageBatch, account.ID(), bots)
+			docs := engine.DocumentsFromMessages(ctx, indexable, chatID, account.ID(), enableOcr)
 			if err := bi.Engine.AddDocuments(ctx, chatID, docs); err != nil {
 				log.FromContext(ctx).Errorf("Failed to add documents: %v", err)
+			} else if enableOcr {
+				if err := ocr.SubmitWait(ctx, account.GetContext(), chatID, indexable); err != nil {
+					log.FromContext(ctx).Warnf("Failed to submit OCR jobs: %v", err)
+				}
 			}
 		}
 	}
@@ -0,0 +1,182 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/download"
+	"github.com/krau/btts/types"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+const downloadUsage = `Usage:
+/download <chat_id> [start-end] [zip] - 下载聊天中的媒体, 不指定范围时下载整个聊天
+/download search <query> [zip] - 下载搜索结果中的媒体`
+
+// DownloadMediaHandler 创建媒体下载任务, 文件保存到配置的下载目录
+//
+//	/download <chat_id> [start-end] [zip]
+//	/download search <query> [zip]
+func DownloadMediaHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()[1:]
+	if len(args) == 0 {
+		ctx.Reply(update, ext.ReplyTextString(downloadUsage), nil)
+		return dispatcher.EndGroups
+	}
+	var req download.Request
+	if n := len(args); n > 1 && strings.EqualFold(args[n-1], "zip") {
+		req.Zip = true
+		args = args[:n-1]
+	}
+	if args[0] == "search" {
+		query := strings.TrimSpace(strings.Join(args[1:], " "))
+		if query == "" {
+			ctx.Reply(update, ext.ReplyTextString(downloadUsage), nil)
+			return dispatcher.EndGroups
+		}
+		// 只在用户可以管理的聊天中搜索
+		all, chatIDs := managedChats(ctx, userIDFromUpdate(update))
+		if !all && len(chatIDs) == 0 {
+			ctx.Reply(update, ext.ReplyTextString("No index chats found"), nil)
+			return dispatcher.EndGroups
+		}
+		req.Search = &types.SearchRequest{Query: query, AllChats: all, ChatIDs: chatIDs}
+	} else {
+		chatID, err := strconv.ParseInt(args[0], 10, 64)
+		if err != nil {
+			ctx.Reply(update, ext.ReplyTextString("Invalid chat ID\n"+downloadUsage), nil)
+			return dispatcher.EndGroups
+		}
+		if !CheckChatPermission(ctx, update, chatID) {
+			return dispatcher.EndGroups
+		}
+		req.ChatID = chatID
+		if len(args) > 1 {
+			start, end, ok := strings.Cut(args[1], "-")
+			startID, err1 := strconv.Atoi(start)
+			endID, err2 := strconv.Atoi(end)
+			if !ok || err1 != nil || err2 != nil {
+				ctx.Reply(update, ext.ReplyTextString("Invalid message range, expected <start>-<end>"), nil)
+				return dispatcher.EndGroups
+			}
+			req.StartID, req.EndID = startID, endID
+		}
+	}
+	job, err := download.Start(ctx, req)
+	if err != nil {
+		replyFailure(ctx, update, "Failed to start download: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	msg, err := ctx.Reply(update, ext.ReplyTextString(formatDownloadProgress(job.Progress())), nil)
+	if err != nil {
+		return dispatcher.EndGroups
+	}
+	chatID := update.EffectiveChat().GetID()
+	go watchDownloadJob(job, chatID, msg.ID)
+	return dispatcher.EndGroups
+}
+
+// watchDownloadJob 定期将任务进度更新到消息中, 直到任务结束
+func watchDownloadJob(job *download.Job, chatID int64, msgID int) {
+	bctx := bi.GetContext()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	last := ""
+	edit := func(p download.Progress) {
+		text := formatDownloadProgress(p)
+		if text == last {
+			return
+		}
+		last = text
+		bctx.EditMessage(chatID, &tg.MessagesEditMessageRequest{ID: msgID, Message: text})
+	}
+	done := make(chan download.Progress, 1)
+	go func() { done <- job.Wait() }()
+	for {
+		select {
+		case p := <-done:
+			edit(p)
+			return
+		case <-ticker.C:
+			edit(job.Progress())
+		}
+	}
+}
+
+func formatDownloadProgress(p download.Progress) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Download job %s: %s\n", p.ID, p.Status)
+	fmt.Fprintf(&sb, "Found: %d, downloaded: %d, skipped: %d, failed: %d, %s\n",
+		p.Total, p.Done, p.Skipped, p.Failed, humanize.IBytes(uint64(p.Bytes)))
+	fmt.Fprintf(&sb, "Dir: %s\n", p.Request.Dir)
+	if p.ZipPath != "" {
+		fmt.Fprintf(&sb, "Zip: %s\n", p.ZipPath)
+	}
+	if p.Error != "" {
+		fmt.Fprintf(&sb, "Error: %s\n", p.Error)
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// ListDownloadsHandler 列出下载任务, 或查看指定任务的进度
+//
+//	/downloads [job_id]
+func ListDownloadsHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	if args := update.Args(); len(args) > 1 {
+		job, ok := download.GetJob(args[1])
+		if !ok {
+			ctx.Reply(update, ext.ReplyTextString("Download job not found"), nil)
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(update, ext.ReplyTextString(formatDownloadProgress(job.Progress())), nil)
+		return dispatcher.EndGroups
+	}
+	jobs := download.ListJobs()
+	if len(jobs) == 0 {
+		ctx.Reply(update, ext.ReplyTextString("No download jobs"), nil)
+		return dispatcher.EndGroups
+	}
+	var sb strings.Builder
+	for i, p := range jobs {
+		if i >= 20 {
+			break
+		}
+		fmt.Fprintf(&sb, "%s [%s] %d/%d, skipped %d, failed %d, %s\n",
+			p.ID, p.Status, p.Done, p.Total, p.Skipped, p.Failed, humanize.IBytes(uint64(p.Bytes)))
+	}
+	ctx.Reply(update, ext.ReplyTextString(sb.String()), nil)
+	return dispatcher.EndGroups
+}
+
+// CancelDownloadHandler 取消下载任务, 已下载的文件会保留
+//
+//	/canceldownload <job_id>
+func CancelDownloadHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()
+	if len(args) < 2 {
+		ctx.Reply(update, ext.ReplyTextString("Usage: /canceldownload <job_id>"), nil)
+		return dispatcher.EndGroups
+	}
+	job, ok := download.GetJob(args[1])
+	if !ok {
+		ctx.Reply(update, ext.ReplyTextString("Download job not found"), nil)
+		return dispatcher.EndGroups
+	}
+	job.Cancel()
+	ctx.Reply(update, ext.ReplyTextString("Download job canceled"), nil)
+	return dispatcher.EndGroups
+}
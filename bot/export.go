@@ -0,0 +1,87 @@
+package bot
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/telegram/uploader"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/export"
+	"github.com/krau/btts/types"
+	"github.com/krau/btts/utils/cache"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+// ExportCallbackHandler 将搜索结果的全部分页导出为文件并以文档形式发送
+//
+//	export <format> <cacheid>
+func ExportCallbackHandler(ctx *ext.Context, update *ext.Update) error {
+	args := update.Args()
+	if len(args) < 3 {
+		return dispatcher.EndGroups
+	}
+	data, ok := cache.Get[types.SearchRequest](args[2])
+	if !ok {
+		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			QueryID:   update.CallbackQuery.GetQueryID(),
+			Message:   "查询已过期",
+			Alert:     true,
+			CacheTime: 60,
+		})
+		return dispatcher.EndGroups
+	}
+	format, err := export.ParseFormat(args[1])
+	if err != nil {
+		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+			QueryID: update.CallbackQuery.GetQueryID(),
+			Message: err.Error(),
+			Alert:   true,
+		})
+		return dispatcher.EndGroups
+	}
+	ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
+		QueryID: update.CallbackQuery.GetQueryID(),
+		Message: "正在导出...",
+	})
+
+	data.Offset = 0
+	hits, meta, err := export.Collect(ctx, bi.Engine, data)
+	if err != nil {
+		log.FromContext(ctx).Errorf("Failed to collect search results for export: %v", err)
+		ctx.SendMessage(update.EffectiveChat().GetID(), &tg.MessagesSendMessageRequest{Message: "Failed to export: " + err.Error()})
+		return dispatcher.EndGroups
+	}
+	var buf bytes.Buffer
+	if err := export.Render(&buf, format, meta, hits); err != nil {
+		log.FromContext(ctx).Errorf("Failed to render export: %v", err)
+		ctx.SendMessage(update.EffectiveChat().GetID(), &tg.MessagesSendMessageRequest{Message: "Failed to export: " + err.Error()})
+		return dispatcher.EndGroups
+	}
+	fileName := format.FileName(meta.ExportedAt)
+	file, err := uploader.NewUploader(ctx.Raw).FromBytes(ctx, fileName, buf.Bytes())
+	if err != nil {
+		log.FromContext(ctx).Errorf("Failed to upload export file: %v", err)
+		ctx.SendMessage(update.EffectiveChat().GetID(), &tg.MessagesSendMessageRequest{Message: "Failed to upload export file"})
+		return dispatcher.EndGroups
+	}
+	mimeType, _, _ := strings.Cut(format.ContentType(), ";")
+	caption := fmt.Sprintf("搜索 \"%s\" 的结果, 共 %d 条", meta.Query, len(hits))
+	if meta.Truncated {
+		caption += fmt.Sprintf(" (仅包含前 %d 条)", export.MaxHits)
+	}
+	if _, err := ctx.SendMedia(update.EffectiveChat().GetID(), &tg.MessagesSendMediaRequest{
+		Media: &tg.InputMediaUploadedDocument{
+			File:       file,
+			MimeType:   mimeType,
+			Attributes: []tg.DocumentAttributeClass{&tg.DocumentAttributeFilename{FileName: fileName}},
+			ForceFile:  true,
+		},
+		Message: caption,
+	}); err != nil {
+		log.FromContext(ctx).Errorf("Failed to send export file: %v", err)
+	}
+	return dispatcher.EndGroups
+}
@@ -0,0 +1,94 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/forget"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+const forgetUsage = `Usage:
+/forget <user_id> - 删除用户在所有聊天中已索引的消息、用户信息和成员记录
+/forget <user_id> never - 同上, 并且之后不再索引该用户的消息
+/forget list - 列出永不索引的用户
+/forget allow <user_id> - 将用户移出永不索引列表`
+
+// ForgetHandler 删除一个用户的全部索引数据, 或管理永不索引列表
+//
+//	/forget <user_id> [never]
+//	/forget list
+//	/forget allow <user_id>
+//
+// 删除和永不索引列表都作用于所有聊天, 因此只有可以访问所有聊天的用户可以使用
+func ForgetHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) || !CheckAllChatsPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()[1:]
+	if len(args) == 0 {
+		ctx.Reply(update, ext.ReplyTextString(forgetUsage), nil)
+		return dispatcher.EndGroups
+	}
+	switch strings.ToLower(args[0]) {
+	case "list":
+		users, err := database.GetAllNeverIndexUsers(ctx)
+		if err != nil {
+			log.FromContext(ctx).Error("Failed to get never-index users", "error", err)
+			replyFailure(ctx, update, "Failed to get never-index users")
+			return dispatcher.EndGroups
+		}
+		if len(users) == 0 {
+			ctx.Reply(update, ext.ReplyTextString("No users in the never-index list"), nil)
+			return dispatcher.EndGroups
+		}
+		var sb strings.Builder
+		sb.WriteString("Never-index users:\n")
+		for _, user := range users {
+			fmt.Fprintf(&sb, "%d (since %s)\n", user.UserID, user.CreatedAt.Format(time.DateTime))
+		}
+		ctx.Reply(update, ext.ReplyTextString(strings.TrimSpace(sb.String())), nil)
+		return dispatcher.EndGroups
+	case "allow":
+		if len(args) < 2 {
+			ctx.Reply(update, ext.ReplyTextString(forgetUsage), nil)
+			return dispatcher.EndGroups
+		}
+		userID, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			ctx.Reply(update, ext.ReplyTextString("Invalid user ID"), nil)
+			return dispatcher.EndGroups
+		}
+		if err := database.RemoveNeverIndexUser(ctx, userID); err != nil {
+			log.FromContext(ctx).Error("Failed to remove never-index user", "user_id", userID, "error", err)
+			replyFailure(ctx, update, "Failed to remove user from the never-index list")
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("User %d removed from the never-index list, new messages will be indexed", userID)), nil)
+		return dispatcher.EndGroups
+	}
+
+	userID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid user ID\n"+forgetUsage), nil)
+		return dispatcher.EndGroups
+	}
+	neverIndex := len(args) > 1 && strings.ToLower(args[1]) == "never"
+	res, err := forget.Forget(ctx, bi.Engine, userID, neverIndex)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to forget user", "user_id", userID, "error", err)
+		replyFailure(ctx, update, "Failed to forget user: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	reply := fmt.Sprintf("Deleted %d documents and the user info of %d", res.Deleted, userID)
+	if res.NeverIndex {
+		reply += "\nThe user is in the never-index list, new messages will not be indexed"
+	}
+	ctx.Reply(update, ext.ReplyTextString(reply), nil)
+	return dispatcher.EndGroups
+}
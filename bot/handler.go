@@ -3,10 +3,7 @@ package bot
 import (
 	"context"
 
-	"github.com/charmbracelet/log"
-	"github.com/duke-git/lancet/v2/slice"
 	"github.com/gotd/td/tg"
-	"github.com/krau/btts/config"
 
 	"github.com/krau/mygotg/dispatcher/handlers"
 	"github.com/krau/mygotg/dispatcher/handlers/filters"
@@ -14,62 +11,74 @@ import (
 	"github.com/krau/mygotg/types"
 )
 
-func CheckPermission(ctx *ext.Context, update *ext.Update) bool {
-	userID := update.GetUserChat().GetID()
-	if userID == 0 {
-		userID = update.InlineQuery.GetUserID()
-	}
-	if userID == bi.UserClient.TClient.Self.ID {
-		return true
-	}
-	if slice.Contain(config.C.Admins, userID) {
-		return true
-	}
-	return false
-}
-
 type commandHandler struct {
 	handlerFunc func(ctx *ext.Context, update *ext.Update) error
 	cmd         string
 	help        string
 }
 
-var commandHandlers = []commandHandler{
-	{StartHandler, "start", "开始使用"},
-	{SearchHandler, "search", "搜索消息"},
-	{ListHandler, "ls", "列出已索引聊天"},
-	{AddHandler, "add", "添加聊天到索引"},
-	{DelHandler, "del", "删除聊天索引"},
-	{PubHandler, "pub", "将一个聊天设为公开"},
-	{UnPubHandler, "unpub", "将一个聊天设为私有"},
-	{WatchHandler, "watch", "监听一个聊天"},
-	{UnWatchHandler, "unwatch", "取消监听一个聊天"},
-	{WatchDelHandler, "watchdel", "监听一个聊天的删除事件"},
-	{UnWatchDelHandler, "unwatchdel", "取消监听一个聊天的删除事件"},
-	{OcrHandler, "ocrable", "开启一个聊天的 OCR"},
-	{UnOcrHandler, "unocrable", "关闭一个聊天的 OCR"},
-	{DownloadHandler, "dl", "下载消息"},
-	{AddSubHandler, "addsub", "添加子 bot"},
-	{DelSubHandler, "delsub", "删除子 bot"},
-	{ListSubHandler, "lssub", "列出子 bot"},
-	{GenApiKeyHandler, "genapikey", "生成随机 api key"},
-	{AddApiKeyHandler, "addapikey", "添加子 api key"},
-	{DelApiKeyHandler, "delapikey", "删除子 api key"},
-	{ListApiKeyHandler, "lsapikey", "列出子 api key"},
-	{SetApiKeyHandler, "setapikey", "设置子 api key 作用域"},
-	{StartHandler, "help", "帮助"},
+var commandHandlers []commandHandler
+
+// 在 init 中初始化以避免 handler 引用 commandHandlers 时产生初始化循环
+func init() {
+	commandHandlers = []commandHandler{
+		{StartHandler, "start", "开始使用"},
+		{SearchHandler, "search", "搜索消息"},
+		{ListHandler, "ls", "列出已索引聊天"},
+		{AddHandler, "add", "添加聊天到索引"},
+		{DelHandler, "del", "删除聊天索引"},
+		{PubHandler, "pub", "将一个聊天设为公开"},
+		{UnPubHandler, "unpub", "将一个聊天设为私有"},
+		{WatchHandler, "watch", "监听一个聊天"},
+		{UnWatchHandler, "unwatch", "取消监听一个聊天"},
+		{WatchDelHandler, "watchdel", "监听一个聊天的删除事件"},
+		{UnWatchDelHandler, "unwatchdel", "取消监听一个聊天的删除事件"},
+		{OcrHandler, "ocrable", "开启一个聊天的 OCR"},
+		{UnOcrHandler, "unocrable", "关闭一个聊天的 OCR"},
+		{ReindexHandler, "reindex", "重新获取聊天的消息并更新索引"},
+		{RulesHandler, "rules", "查看或设置聊天的索引规则"},
+		{RetentionHandler, "retention", "查看或设置消息保留策略"},
+		{ForgetHandler, "forget", "删除一个用户的全部索引数据"},
+		{DownloadHandler, "dl", "下载消息"},
+		{DownloadMediaHandler, "download", "下载聊天或搜索结果中的媒体"},
+		{ListDownloadsHandler, "downloads", "查看下载任务"},
+		{CancelDownloadHandler, "canceldownload", "取消下载任务"},
+		{AccountsHandler, "accounts", "列出用户账号"},
+		{AddSubHandler, "addsub", "添加子 bot"},
+		{DelSubHandler, "delsub", "删除子 bot"},
+		{ListSubHandler, "lssub", "列出子 bot"},
+		{GenApiKeyHandler, "genapikey", "生成随机 api key"},
+		{AddApiKeyHandler, "addapikey", "添加子 api key"},
+		{DelApiKeyHandler, "delapikey", "删除子 api key"},
+		{ListApiKeyHandler, "lsapikey", "列出子 api key"},
+		{SetApiKeyHandler, "setapikey", "设置子 api key 作用域"},
+		{LimitApiKeyHandler, "limitapikey", "设置子 api key 的速率限制和每日配额"},
+		{RotateApiKeyHandler, "rotateapikey", "更换子 api key 的密钥"},
+		{DisableApiKeyHandler, "disableapikey", "禁用子 api key"},
+		{EnableApiKeyHandler, "enableapikey", "启用子 api key"},
+		{ExpireApiKeyHandler, "expireapikey", "设置子 api key 的过期时间"},
+		{OpsApiKeyHandler, "opsapikey", "设置子 api key 允许的操作"},
+		{GrantHandler, "grant", "授予用户角色"},
+		{RevokeHandler, "revoke", "撤销用户角色"},
+		{RolesHandler, "roles", "列出或设置角色"},
+		{AuditHandler, "audit", "查看审计日志"},
+		{SearchStatsHandler, "searchstats", "查看搜索统计"},
+		{ReloadConfigHandler, "reload", "重新加载配置文件"},
+		{StartHandler, "help", "帮助"},
+	}
 }
 
 func (b *Bot) RegisterHandlers(ctx context.Context) {
 	disp := b.Client.Dispatcher
 	for _, cmdHandler := range commandHandlers {
-		disp.AddHandler(handlers.NewCommand(cmdHandler.cmd, cmdHandler.handlerFunc))
+		disp.AddHandler(handlers.NewCommand(cmdHandler.cmd, auditCommand(cmdHandler.cmd, cmdHandler.handlerFunc)))
 	}
 	disp.AddHandlerToGroup(handlers.NewInlineQuery(filters.InlineQuery.All, InlineQueryHandler), 1)
-	disp.AddHandler(handlers.NewCommand("syncpeers", SyncPeersHandler))
+	disp.AddHandler(handlers.NewCommand("syncpeers", auditCommand("syncpeers", SyncPeersHandler)))
 	disp.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("search"), SearchCallbackHandler))
 	disp.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("filter"), FilterCallbackHandler))
 	disp.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("list"), ListCallbackHandler))
+	disp.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("export"), ExportCallbackHandler))
 	disp.AddHandler(handlers.NewCallbackQuery(filters.CallbackQuery.Prefix("select"), SelectCallbackHandler))
 	disp.AddHandler(handlers.NewMessage(filters.Message.ChatType(filters.ChatTypeUser), SearchHandler))
 	disp.AddHandler(handlers.NewMessage(func(m *types.Message) bool {
@@ -85,33 +94,5 @@ func (b *Bot) RegisterHandlers(ctx context.Context) {
 		}
 	}, SearchHandler))
 
-	_, err := b.Client.API().BotsSetBotCommands(ctx, &tg.BotsSetBotCommandsRequest{
-		Scope: &tg.BotCommandScopeDefault{},
-		Commands: []tg.BotCommand{
-			{Command: "search", Description: "搜索消息"},
-			{Command: "ls", Description: "列出已索引聊天"},
-			{Command: "start", Description: "开始使用"},
-			{Command: "help", Description: "帮助"},
-		},
-	})
-	if err != nil {
-		log.FromContext(ctx).Error("Failed to set bot commands", "error", err)
-	}
-	if peer := b.Client.PeerStorage.GetInputPeerById(b.UserClient.TClient.Self.ID); peer != nil {
-		var botCmds []tg.BotCommand
-		for _, cmdHandler := range commandHandlers {
-			botCmds = append(botCmds, tg.BotCommand{
-				Command:     cmdHandler.cmd,
-				Description: cmdHandler.help,
-			})
-		}
-		if _, err = b.Client.API().BotsSetBotCommands(ctx, &tg.BotsSetBotCommandsRequest{
-			Scope: &tg.BotCommandScopePeer{
-				Peer: peer,
-			},
-			Commands: botCmds,
-		}); err != nil {
-			log.FromContext(ctx).Error("Failed to set bot commands", "error", err)
-		}
-	}
+	b.setCommandMenus(ctx)
 }
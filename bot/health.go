@@ -0,0 +1,74 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/health"
+	"github.com/krau/btts/subbot"
+	"github.com/krau/btts/userclient"
+	"github.com/krau/btts/utils"
+	"github.com/krau/mygotg"
+)
+
+// startHealthMonitor 注册各组件的健康检查并开始定期检查
+func (b *Bot) startHealthMonitor(ctx context.Context) {
+	health.Register(func() []health.Check {
+		checks := []health.Check{
+			{Name: "engine", Required: true, Func: b.Engine.Health},
+			{Name: "database", Required: true, Func: database.Ping},
+			{Name: "bot", Required: true, Func: pingTelegram(b.Client)},
+			{Name: "ocr", Func: func(ctx context.Context) error {
+				if !config.C().Ocr.Enable {
+					return health.ErrDisabled
+				}
+				return utils.PingOcr(ctx)
+			}},
+		}
+		for _, client := range userclient.GetUserClients() {
+			checks = append(checks, health.Check{
+				Name:     "userclient:" + client.Name,
+				Required: client.Primary,
+				Func:     pingTelegram(client.TClient),
+			})
+		}
+		for _, sb := range subbot.GetAll() {
+			checks = append(checks, health.Check{
+				Name: "subbot:" + sb.Name,
+				Func: pingTelegram(sb.Client),
+			})
+		}
+		return checks
+	})
+	health.Start(ctx, b.NotifyAdmins)
+}
+
+// pingTelegram 通过 updates.getState 检查客户端的连接和会话是否有效
+func pingTelegram(client *mygotg.Client) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if client == nil {
+			return fmt.Errorf("client is not started")
+		}
+		_, err := client.API().UpdatesGetState(ctx)
+		return err
+	}
+}
+
+// NotifyAdmins 向配置文件中的 admins 和所有用户账号发送消息
+func (b *Bot) NotifyAdmins(ctx context.Context, text string) {
+	userIDs := slices.Clone(config.C().Admins)
+	for _, client := range userclient.GetUserClients() {
+		userIDs = append(userIDs, client.ID())
+	}
+	slices.Sort(userIDs)
+	for _, userID := range slices.Compact(userIDs) {
+		if _, err := b.GetContext().SendMessage(userID, &tg.MessagesSendMessageRequest{Message: text}); err != nil {
+			log.FromContext(ctx).Error("Failed to notify admin", "user_id", userID, "error", err)
+		}
+	}
+}
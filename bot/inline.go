@@ -6,6 +6,7 @@ import (
 	"github.com/duke-git/lancet/v2/strutil"
 	"github.com/gotd/td/telegram/message/inline"
 	"github.com/gotd/td/tg"
+	"github.com/krau/btts/analytics"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/types"
 	"github.com/krau/mygotg/dispatcher"
@@ -13,19 +14,25 @@ import (
 )
 
 func InlineQueryHandler(ctx *ext.Context, update *ext.Update) error {
-	if !CheckPermission(ctx, update) {
+	allChats, chatIDs, err := searchScope(ctx, update.InlineQuery.GetUserID())
+	if err != nil {
+		return err
+	}
+	if !allChats && len(chatIDs) == 0 {
 		return dispatcher.EndGroups
 	}
 	query := update.InlineQuery.GetQuery()
-	resp, err := bi.Engine.Search(ctx,
-		types.SearchRequest{
-			Query:    query,
-			Limit:    48,
-			AllChats: true,
-		})
+	req := types.SearchRequest{
+		Query:    query,
+		Limit:    48,
+		AllChats: allChats,
+		ChatIDs:  chatIDs,
+	}
+	resp, err := bi.Engine.Search(ctx, req)
 	if err != nil {
 		return err
 	}
+	analytics.RecordInlineSearch(ctx, analytics.SourceInline, analytics.UserRequester(update.InlineQuery.GetUserID()), req, resp)
 	results := make([]inline.ResultOption, 0, len(resp.Hits))
 	for _, hit := range resp.Hits {
 		userName := hit.Formatted.UserID
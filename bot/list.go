@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"context"
 	"fmt"
 	"strconv"
 
@@ -17,17 +18,10 @@ import (
 const listPageSize = 16
 
 func ListHandler(ctx *ext.Context, update *ext.Update) error {
-	hasPermission := CheckPermission(ctx, update)
-	var chats []*database.IndexChat
-	var err error
-	if hasPermission {
-		chats, err = database.GetAllIndexChats(ctx)
-	} else {
-		chats, err = database.GetAllPublicIndexChats(ctx)
-	}
+	hasPermission, chats, err := listScopedChats(ctx, userIDFromUpdate(update))
 	if err != nil {
 		log.FromContext(ctx).Error("Failed to list chats", "error", err)
-		ctx.Reply(update, ext.ReplyTextString("Failed to list chats"), nil)
+		replyFailure(ctx, update, "Failed to list chats")
 		return dispatcher.EndGroups
 	}
 	if len(chats) == 0 {
@@ -60,13 +54,7 @@ func ListCallbackHandler(ctx *ext.Context, update *ext.Update) error {
 		})
 		return dispatcher.EndGroups
 	}
-	hasPermission := CheckPermission(ctx, update)
-	var chats []*database.IndexChat
-	if hasPermission {
-		chats, err = database.GetAllIndexChats(ctx)
-	} else {
-		chats, err = database.GetAllPublicIndexChats(ctx)
-	}
+	hasPermission, chats, err := listScopedChats(ctx, userIDFromUpdate(update))
 	if err != nil {
 		log.FromContext(ctx).Error("Failed to list chats", "error", err)
 		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
@@ -105,6 +93,26 @@ func ListCallbackHandler(ctx *ext.Context, update *ext.Update) error {
 	return dispatcher.EndGroups
 }
 
+// listScopedChats 返回用户可以搜索的聊天, all 为 true 时表示用户可以查看所有聊天的详细信息
+func listScopedChats(ctx context.Context, userID int64) (all bool, chats []*database.IndexChat, err error) {
+	all, chatIDs, err := searchScope(ctx, userID)
+	if err != nil {
+		return false, nil, err
+	}
+	if all {
+		chats, err = database.GetAllIndexChats(ctx)
+		return true, chats, err
+	}
+	for _, chatID := range chatIDs {
+		chat, err := database.GetIndexChat(ctx, chatID)
+		if err != nil {
+			return false, nil, err
+		}
+		chats = append(chats, chat)
+	}
+	return false, chats, nil
+}
+
 func buildListPage(chats []*database.IndexChat, page int, hasPermission bool) ([]styling.StyledTextOption, *tg.ReplyInlineMarkup) {
 	if page < 1 {
 		page = 1
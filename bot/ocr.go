@@ -18,9 +18,12 @@ func OcrHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /ocrable <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.NoOcr = false
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to enable OCR"), nil)
+		replyFailure(ctx, update, "Failed to enable OCR")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("OCR enabled"), nil)
@@ -36,9 +39,12 @@ func UnOcrHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /unocrable <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.NoOcr = true
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to disable OCR"), nil)
+		replyFailure(ctx, update, "Failed to disable OCR")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("OCR disabled"), nil)
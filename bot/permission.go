@@ -0,0 +1,186 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/duke-git/lancet/v2/slice"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/userclient"
+	"github.com/krau/mygotg/ext"
+	"gorm.io/gorm"
+)
+
+// 只有 owner 可以使用的命令, 不受角色配置影响
+var ownerOnlyCommands = []string{"grant", "revoke", "roles"}
+
+// 不在命令菜单中, 但同样受角色控制的动作
+var extraCommands = []string{"syncpeers", "fav"}
+
+// userPermission 描述一个用户的角色和可搜索的聊天范围
+type userPermission struct {
+	Role    *database.Role
+	ChatIDs []int64
+}
+
+func (p *userPermission) CanRun(cmd string) bool {
+	if p == nil || p.Role == nil {
+		return false
+	}
+	if slices.Contains(ownerOnlyCommands, cmd) {
+		return p.Role.Name == database.RoleOwner
+	}
+	return p.Role.HasCommand(cmd)
+}
+
+// CanAccessChat 判断用户是否可以对 chatID 执行管理命令.
+// 授权时指定了聊天的用户只能访问这些聊天 (即使角色可以访问所有聊天), 否则取决于角色的 AllChats
+func (p *userPermission) CanAccessChat(chatID int64) bool {
+	if p == nil || p.Role == nil {
+		return false
+	}
+	if len(p.ChatIDs) > 0 {
+		return slices.Contains(p.ChatIDs, chatID)
+	}
+	return p.Role.AllChats
+}
+
+// CanAccessAllChats 判断用户是否可以访问所有聊天
+func (p *userPermission) CanAccessAllChats() bool {
+	return p != nil && p.Role != nil && p.Role.AllChats && len(p.ChatIDs) == 0
+}
+
+// managedChats 返回用户可以管理的已索引聊天, all 为 true 时可以管理所有聊天
+func managedChats(ctx context.Context, userID int64) (all bool, chatIDs []int64) {
+	perm := getUserPermission(ctx, userID)
+	if perm.CanAccessAllChats() {
+		return true, nil
+	}
+	if perm == nil {
+		return false, nil
+	}
+	for _, chatID := range perm.ChatIDs {
+		if database.Indexed(chatID) {
+			chatIDs = append(chatIDs, chatID)
+		}
+	}
+	return false, chatIDs
+}
+
+// getUserPermission 获取用户的权限, 没有任何角色时返回 nil.
+// 用户账号本身和配置文件中的 admins 始终为 owner
+func getUserPermission(ctx context.Context, userID int64) *userPermission {
+	if userID == 0 {
+		return nil
+	}
+	roleName := ""
+	var chatIDs []int64
+	if userclient.IsAccount(userID) || slice.Contain(config.C().Admins, userID) {
+		roleName = database.RoleOwner
+	} else {
+		userRole, err := database.GetUserRole(ctx, userID)
+		if err != nil {
+			if !errors.Is(err, gorm.ErrRecordNotFound) {
+				log.FromContext(ctx).Error("Failed to get user role", "user_id", userID, "error", err)
+			}
+			return nil
+		}
+		roleName = userRole.Role
+		chatIDs = userRole.ChatIDs
+	}
+	role, err := database.GetRole(ctx, roleName)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get role", "role", roleName, "error", err)
+		return nil
+	}
+	return &userPermission{Role: role, ChatIDs: chatIDs}
+}
+
+func userIDFromUpdate(update *ext.Update) int64 {
+	userID := update.GetUserChat().GetID()
+	if userID == 0 && update.InlineQuery != nil {
+		userID = update.InlineQuery.GetUserID()
+	}
+	if userID == 0 && update.CallbackQuery != nil {
+		userID = update.CallbackQuery.GetUserID()
+	}
+	return userID
+}
+
+// commandFromUpdate 从消息中解析出命令名称 (不含 / 和 @bot)
+func commandFromUpdate(update *ext.Update) string {
+	if update.EffectiveMessage == nil {
+		return ""
+	}
+	text := update.EffectiveMessage.GetMessage()
+	if !strings.HasPrefix(text, "/") {
+		return ""
+	}
+	cmd := strings.TrimPrefix(strings.Fields(text)[0], "/")
+	cmd, _, _ = strings.Cut(cmd, "@")
+	return strings.ToLower(cmd)
+}
+
+// CheckPermission 检查用户是否有权限使用当前消息中的命令
+func CheckPermission(ctx *ext.Context, update *ext.Update) bool {
+	return CheckCommandPermission(ctx, update, commandFromUpdate(update))
+}
+
+// CheckCommandPermission 检查用户是否有权限执行 cmd, cmd 可以是 commandHandlers 之外的动作名称
+func CheckCommandPermission(ctx *ext.Context, update *ext.Update, cmd string) bool {
+	if cmd == "" {
+		return false
+	}
+	return getUserPermission(ctx, userIDFromUpdate(update)).CanRun(cmd)
+}
+
+// CheckChatPermission 检查用户是否可以对 chatID 执行命令, 没有权限时回复用户
+func CheckChatPermission(ctx *ext.Context, update *ext.Update, chatID int64) bool {
+	if getUserPermission(ctx, userIDFromUpdate(update)).CanAccessChat(chatID) {
+		return true
+	}
+	text := fmt.Sprintf("No permission to access chat %d", chatID)
+	auditDenied(ctx, text)
+	ctx.Reply(update, ext.ReplyTextString(text), nil)
+	return false
+}
+
+// CheckAllChatsPermission 检查用户是否可以访问所有聊天, 用于影响所有聊天的命令, 没有权限时回复用户
+func CheckAllChatsPermission(ctx *ext.Context, update *ext.Update) bool {
+	if getUserPermission(ctx, userIDFromUpdate(update)).CanAccessAllChats() {
+		return true
+	}
+	text := "No permission, this command affects all chats"
+	auditDenied(ctx, text)
+	ctx.Reply(update, ext.ReplyTextString(text), nil)
+	return false
+}
+
+// searchScope 返回用户可搜索的聊天范围.
+// all 为 true 时可以搜索所有聊天, 否则只能搜索 chatIDs 中的聊天 (公开聊天 + 授权的聊天)
+func searchScope(ctx context.Context, userID int64) (all bool, chatIDs []int64, err error) {
+	perm := getUserPermission(ctx, userID)
+	if perm.CanRun("search") && perm.CanAccessAllChats() {
+		return true, nil, nil
+	}
+	publicChats, err := database.GetAllPublicIndexChats(ctx)
+	if err != nil {
+		return false, nil, err
+	}
+	for _, chat := range publicChats {
+		chatIDs = append(chatIDs, chat.ChatID)
+	}
+	if perm.CanRun("search") {
+		for _, chatID := range perm.ChatIDs {
+			if database.Indexed(chatID) && !slices.Contains(chatIDs, chatID) {
+				chatIDs = append(chatIDs, chatID)
+			}
+		}
+	}
+	return false, chatIDs, nil
+}
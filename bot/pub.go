@@ -18,9 +18,12 @@ func PubHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /pub <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.Public = true
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to pub chat"), nil)
+		replyFailure(ctx, update, "Failed to pub chat")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Pub chat"), nil)
@@ -36,9 +39,12 @@ func UnPubHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /unpub <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.Public = false
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to unpub chat"), nil)
+		replyFailure(ctx, update, "Failed to unpub chat")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Unpub chat"), nil)
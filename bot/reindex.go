@@ -0,0 +1,136 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/reindex"
+	"github.com/krau/btts/utils"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+const reindexUsage = `Usage:
+/reindex <chat_id> [--ocr] [--since YYYY-MM-DD] - 重新获取聊天的消息并更新索引, 中断后使用相同参数重新运行会继续
+/reindex <chat_id> status - 查看任务进度
+/reindex <chat_id> cancel - 取消任务`
+
+// ReindexHandler 重新获取聊天的消息并更新索引, --ocr 时重新对图片进行 OCR
+//
+//	/reindex <chat_id> [--ocr] [--since YYYY-MM-DD]
+//	/reindex <chat_id> status|cancel
+func ReindexHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	chatDB, err := utils.GetChatDBFromUpdateArgs(ctx, update)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("%s\n%s", reindexUsage, err.Error())), nil)
+		return dispatcher.EndGroups
+	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()[2:]
+	if len(args) == 1 && (args[0] == "status" || args[0] == "cancel") {
+		job, ok := reindex.GetJob(chatDB.ChatID)
+		if !ok {
+			ctx.Reply(update, ext.ReplyTextString("No reindex job for this chat"), nil)
+			return dispatcher.EndGroups
+		}
+		if args[0] == "cancel" {
+			job.Cancel()
+			ctx.Reply(update, ext.ReplyTextString("Reindex job canceled, run the same command again to resume"), nil)
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(update, ext.ReplyTextString(formatReindexProgress(job.Progress())), nil)
+		return dispatcher.EndGroups
+	}
+
+	opts := reindex.Options{ChatID: chatDB.ChatID}
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--ocr":
+			opts.Ocr = true
+		case "--since":
+			if i+1 >= len(args) {
+				ctx.Reply(update, ext.ReplyTextString(reindexUsage), nil)
+				return dispatcher.EndGroups
+			}
+			i++
+			since, err := time.ParseInLocation(time.DateOnly, args[i], time.Local)
+			if err != nil {
+				ctx.Reply(update, ext.ReplyTextString("Invalid date, expected YYYY-MM-DD"), nil)
+				return dispatcher.EndGroups
+			}
+			opts.Since = since
+		default:
+			ctx.Reply(update, ext.ReplyTextString("Unknown argument: "+args[i]+"\n"+reindexUsage), nil)
+			return dispatcher.EndGroups
+		}
+	}
+
+	job, err := reindex.Start(ctx, bi.Engine, opts)
+	if err != nil {
+		replyFailure(ctx, update, "Failed to start reindex: "+err.Error())
+		return dispatcher.EndGroups
+	}
+	msg, err := ctx.Reply(update, ext.ReplyTextString(formatReindexProgress(job.Progress())), nil)
+	if err != nil {
+		return dispatcher.EndGroups
+	}
+	go watchReindexJob(job, update.EffectiveChat().GetID(), msg.ID)
+	return dispatcher.EndGroups
+}
+
+// watchReindexJob 定期将任务进度更新到消息中, 直到任务结束
+func watchReindexJob(job *reindex.Job, chatID int64, msgID int) {
+	bctx := bi.GetContext()
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	last := ""
+	edit := func(p reindex.Progress) {
+		text := formatReindexProgress(p)
+		if text == last {
+			return
+		}
+		last = text
+		bctx.EditMessage(chatID, &tg.MessagesEditMessageRequest{ID: msgID, Message: text})
+	}
+	done := make(chan reindex.Progress, 1)
+	go func() { done <- job.Wait() }()
+	for {
+		select {
+		case p := <-done:
+			edit(p)
+			return
+		case <-ticker.C:
+			edit(job.Progress())
+		}
+	}
+}
+
+func formatReindexProgress(p reindex.Progress) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Reindex chat %d: %s\n", p.ChatID, p.Status)
+	if p.Resumed {
+		sb.WriteString("Resumed from the previous run\n")
+	}
+	fmt.Fprintf(&sb, "Messages: %d, indexed: %d", p.Processed, p.Indexed)
+	if p.Ocr {
+		fmt.Fprintf(&sb, ", OCR: %d", p.Ocred)
+	}
+	if p.LastID > 0 {
+		fmt.Fprintf(&sb, ", at message %d", p.LastID)
+	}
+	sb.WriteString("\n")
+	if !p.Since.IsZero() {
+		fmt.Fprintf(&sb, "Since: %s\n", p.Since.Format(time.DateOnly))
+	}
+	if p.Error != "" {
+		fmt.Fprintf(&sb, "Error: %s\n", p.Error)
+	}
+	return strings.TrimSpace(sb.String())
+}
@@ -0,0 +1,39 @@
+package bot
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+// ReloadConfigHandler 重新读取 config.toml, 应用可以在运行时修改的配置项
+func ReloadConfigHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	res, err := config.Reload()
+	if err != nil {
+		log.FromContext(ctx).Warn("Failed to reload config", "error", err)
+		replyFailure(ctx, update, "Config not reloaded:\n"+err.Error())
+		return dispatcher.EndGroups
+	}
+	log.FromContext(ctx).Info("Config reloaded", "applied", res.Applied, "restart_required", res.RestartRequired)
+	if len(res.Applied) == 0 && len(res.RestartRequired) == 0 {
+		ctx.Reply(update, ext.ReplyTextString("Config reloaded, nothing changed"), nil)
+		return dispatcher.EndGroups
+	}
+	var sb strings.Builder
+	sb.WriteString("Config reloaded\n")
+	if len(res.Applied) > 0 {
+		fmt.Fprintf(&sb, "Applied: %s\n", strings.Join(res.Applied, ", "))
+	}
+	if len(res.RestartRequired) > 0 {
+		fmt.Fprintf(&sb, "Requires restart, old values are kept until then: %s\n", strings.Join(res.RestartRequired, ", "))
+	}
+	ctx.Reply(update, ext.ReplyTextString(strings.TrimSpace(sb.String())), nil)
+	return dispatcher.EndGroups
+}
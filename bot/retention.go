@@ -0,0 +1,156 @@
+package bot
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/retention"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+)
+
+const retentionUsage = `Usage:
+/retention - 预演所有聊天的保留策略, 列出将会删除的文档数
+/retention <chat_id> - 查看聊天的保留策略并预演
+/retention <chat_id> <max_age_days> [max_documents] - 为聊天单独设置保留策略, 0 表示不限制
+/retention <chat_id> default - 使用配置文件中的默认策略
+/retention run [chat_id] - 立即执行保留策略`
+
+// RetentionHandler 查看、设置或执行消息保留策略
+//
+//	/retention [chat_id]
+//	/retention <chat_id> <max_age_days> [max_documents]|default
+//	/retention run [chat_id]
+func RetentionHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()[1:]
+	// 没有指定聊天时只处理用户可以管理的聊天
+	all, managed := managedChats(ctx, userIDFromUpdate(update))
+	if !all && len(managed) == 0 && (len(args) == 0 || (args[0] == "run" && len(args) == 1)) {
+		ctx.Reply(update, ext.ReplyTextString("No index chats found"), nil)
+		return dispatcher.EndGroups
+	}
+	if len(args) == 0 {
+		results := retention.Run(ctx, bi.Engine, true, managed...)
+		ctx.Reply(update, ext.ReplyTextString(formatRetentionResults(ctx, results, true)), nil)
+		return dispatcher.EndGroups
+	}
+	if args[0] == "run" {
+		chatIDs := managed
+		if len(args) > 1 {
+			chatID, ok := parseIndexedChatID(ctx, update, args[1])
+			if !ok {
+				return dispatcher.EndGroups
+			}
+			chatIDs = []int64{chatID}
+		}
+		results := retention.Run(ctx, bi.Engine, false, chatIDs...)
+		ctx.Reply(update, ext.ReplyTextString(formatRetentionResults(ctx, results, false)), nil)
+		return dispatcher.EndGroups
+	}
+
+	chatID, ok := parseIndexedChatID(ctx, update, args[0])
+	if !ok {
+		return dispatcher.EndGroups
+	}
+	saved := "Retention policy saved"
+	switch {
+	case len(args) == 1:
+		results := retention.Run(ctx, bi.Engine, true, chatID)
+		ctx.Reply(update, ext.ReplyTextString(formatRetentionResults(ctx, results, true)), nil)
+		return dispatcher.EndGroups
+	case args[1] == "default":
+		if err := database.DeleteRetentionPolicy(ctx, chatID); err != nil {
+			log.FromContext(ctx).Error("Failed to delete retention policy", "chat_id", chatID, "error", err)
+			replyFailure(ctx, update, "Failed to reset retention policy")
+			return dispatcher.EndGroups
+		}
+		saved = "Retention policy reset to default"
+	default:
+		policy := &database.RetentionPolicy{ChatID: chatID}
+		var err error
+		if policy.MaxAgeDays, err = strconv.Atoi(args[1]); err != nil || policy.MaxAgeDays < 0 {
+			ctx.Reply(update, ext.ReplyTextString("Invalid max age days\n"+retentionUsage), nil)
+			return dispatcher.EndGroups
+		}
+		if len(args) > 2 {
+			if policy.MaxDocuments, err = strconv.ParseInt(args[2], 10, 64); err != nil || policy.MaxDocuments < 0 {
+				ctx.Reply(update, ext.ReplyTextString("Invalid max documents\n"+retentionUsage), nil)
+				return dispatcher.EndGroups
+			}
+		}
+		if err := database.UpsertRetentionPolicy(ctx, policy); err != nil {
+			log.FromContext(ctx).Error("Failed to save retention policy", "chat_id", chatID, "error", err)
+			replyFailure(ctx, update, "Failed to save retention policy")
+			return dispatcher.EndGroups
+		}
+	}
+	// 设置后预演一次, 让用户确认下次执行时会删除多少文档
+	results := retention.Run(ctx, bi.Engine, true, chatID)
+	ctx.Reply(update, ext.ReplyTextString(saved+"\n"+formatRetentionResults(ctx, results, true)), nil)
+	return dispatcher.EndGroups
+}
+
+func parseIndexedChatID(ctx *ext.Context, update *ext.Update, arg string) (int64, bool) {
+	chatID, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid chat ID\n"+retentionUsage), nil)
+		return 0, false
+	}
+	if !database.Indexed(chatID) {
+		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Chat %d is not indexed", chatID)), nil)
+		return 0, false
+	}
+	if !CheckChatPermission(ctx, update, chatID) {
+		return 0, false
+	}
+	return chatID, true
+}
+
+func formatRetentionResults(ctx *ext.Context, results []retention.Result, dryRun bool) string {
+	var sb strings.Builder
+	if dryRun {
+		sb.WriteString("Dry run, nothing is deleted\n")
+	}
+	var total int64
+	for _, res := range results {
+		// 检查所有聊天时只列出有变化或出错的聊天
+		if len(results) > 1 && res.Deleted == 0 && res.Error == "" {
+			continue
+		}
+		title := strconv.FormatInt(res.ChatID, 10)
+		if chat, err := database.GetIndexChat(ctx, res.ChatID); err == nil && chat.Title != "" {
+			title = fmt.Sprintf("%s (%d)", chat.Title, res.ChatID)
+		}
+		source := "default"
+		if res.Policy.Custom {
+			source = "custom"
+		}
+		fmt.Fprintf(&sb, "\n%s\nPolicy: %s [%s]\n", title, res.Policy, source)
+		switch {
+		case res.Error != "":
+			fmt.Fprintf(&sb, "Error: %s\n", res.Error)
+		case res.Deleted > 0 && dryRun:
+			fmt.Fprintf(&sb, "Would delete %d documents before %s\n", res.Deleted, res.Cutoff.Format(time.DateTime))
+		case res.Deleted > 0:
+			fmt.Fprintf(&sb, "Deleted %d documents before %s\n", res.Deleted, res.Cutoff.Format(time.DateTime))
+		default:
+			sb.WriteString("Nothing to delete\n")
+		}
+		total += res.Deleted
+	}
+	if len(results) > 1 {
+		verb := "Deleted"
+		if dryRun {
+			verb = "Would delete"
+		}
+		fmt.Fprintf(&sb, "\n%s %d documents in total, %d chats checked", verb, total, len(results))
+	}
+	return strings.TrimSpace(sb.String())
+}
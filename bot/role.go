@@ -0,0 +1,260 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/telegram/message/styling"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/userclient"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+	"gorm.io/gorm"
+)
+
+// 未授予角色的用户可以看到的命令
+var publicCommands = []string{"search", "ls", "start", "help"}
+
+func GrantHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()
+	if len(args) < 3 {
+		ctx.Reply(update, ext.ReplyTextString("Usage: /grant <user_id> <role> [chat_id...]"), nil)
+		return dispatcher.EndGroups
+	}
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid user ID"), nil)
+		return dispatcher.EndGroups
+	}
+	roleName := strings.ToLower(args[2])
+	if _, err := database.GetRole(ctx, roleName); err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Role not found: "+roleName), nil)
+		return dispatcher.EndGroups
+	}
+	chatIDs := make([]int64, 0, len(args)-3)
+	for _, arg := range args[3:] {
+		chatID, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			ctx.Reply(update, ext.ReplyTextString("Invalid chat ID: "+arg), nil)
+			return dispatcher.EndGroups
+		}
+		if !database.Indexed(chatID) {
+			ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Chat %d is not indexed", chatID)), nil)
+			return dispatcher.EndGroups
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	if err := database.UpsertUserRole(ctx, &database.UserRole{
+		UserID:  userID,
+		Role:    roleName,
+		ChatIDs: chatIDs,
+	}); err != nil {
+		log.FromContext(ctx).Error("Failed to grant role", "user_id", userID, "role", roleName, "error", err)
+		replyFailure(ctx, update, "Failed to grant role")
+		return dispatcher.EndGroups
+	}
+	bi.setUserCommandMenu(ctx, userID)
+	ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Granted role %s to user %d", roleName, userID)), nil)
+	return dispatcher.EndGroups
+}
+
+func RevokeHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()
+	if len(args) < 2 {
+		ctx.Reply(update, ext.ReplyTextString("Usage: /revoke <user_id>"), nil)
+		return dispatcher.EndGroups
+	}
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid user ID"), nil)
+		return dispatcher.EndGroups
+	}
+	if _, err := database.GetUserRole(ctx, userID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			ctx.Reply(update, ext.ReplyTextString("User has no role"), nil)
+			return dispatcher.EndGroups
+		}
+		replyFailure(ctx, update, "Failed to get user role")
+		return dispatcher.EndGroups
+	}
+	if err := database.DeleteUserRole(ctx, userID); err != nil {
+		log.FromContext(ctx).Error("Failed to revoke role", "user_id", userID, "error", err)
+		replyFailure(ctx, update, "Failed to revoke role")
+		return dispatcher.EndGroups
+	}
+	bi.setUserCommandMenu(ctx, userID)
+	ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Revoked role of user %d", userID)), nil)
+	return dispatcher.EndGroups
+}
+
+// RolesHandler 列出所有角色和授权, 或者创建/修改一个角色
+//
+//	/roles
+//	/roles <name> [+all] <cmd...>
+func RolesHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()
+	if len(args) == 1 {
+		return listRoles(ctx, update)
+	}
+	if len(args) < 3 {
+		ctx.Reply(update, ext.ReplyTextString("Usage: /roles <name> [+all] <cmd...>\n+all: allow searching all chats, use * for all commands"), nil)
+		return dispatcher.EndGroups
+	}
+	role := &database.Role{Name: strings.ToLower(args[1])}
+	if role.Name == database.RoleOwner {
+		ctx.Reply(update, ext.ReplyTextString("Role owner cannot be modified"), nil)
+		return dispatcher.EndGroups
+	}
+	for _, arg := range args[2:] {
+		arg = strings.TrimPrefix(strings.ToLower(arg), "/")
+		if arg == "+all" {
+			role.AllChats = true
+			continue
+		}
+		if arg != "*" && !slices.Contains(extraCommands, arg) &&
+			!slices.ContainsFunc(commandHandlers, func(h commandHandler) bool { return h.cmd == arg }) {
+			ctx.Reply(update, ext.ReplyTextString("Unknown command: "+arg), nil)
+			return dispatcher.EndGroups
+		}
+		if !slices.Contains(role.Commands, arg) {
+			role.Commands = append(role.Commands, arg)
+		}
+	}
+	if err := database.UpsertRole(ctx, role); err != nil {
+		log.FromContext(ctx).Error("Failed to save role", "role", role.Name, "error", err)
+		replyFailure(ctx, update, "Failed to save role")
+		return dispatcher.EndGroups
+	}
+	userRoles, err := database.GetAllUserRoles(ctx)
+	if err == nil {
+		for _, ur := range userRoles {
+			if ur.Role == role.Name {
+				bi.setUserCommandMenu(ctx, ur.UserID)
+			}
+		}
+	}
+	ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Role %s saved: %s", role.Name, strings.Join(role.Commands, " "))), nil)
+	return dispatcher.EndGroups
+}
+
+func listRoles(ctx *ext.Context, update *ext.Update) error {
+	roles, err := database.GetAllRoles(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get roles", "error", err)
+		replyFailure(ctx, update, "Failed to get roles")
+		return dispatcher.EndGroups
+	}
+	userRoles, err := database.GetAllUserRoles(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get user roles", "error", err)
+		replyFailure(ctx, update, "Failed to get user roles")
+		return dispatcher.EndGroups
+	}
+	stylings := []styling.StyledTextOption{styling.Bold("角色:\n")}
+	for _, role := range roles {
+		scope := "授权的聊天"
+		if role.AllChats {
+			scope = "全部聊天"
+		}
+		stylings = append(stylings,
+			styling.Code(role.Name),
+			styling.Plain(fmt.Sprintf(" [%s]: %s\n", scope, strings.Join(role.Commands, " "))),
+		)
+	}
+	stylings = append(stylings, styling.Bold("\n授权:\n"))
+	if len(userRoles) == 0 {
+		stylings = append(stylings, styling.Plain("无\n"))
+	}
+	for _, ur := range userRoles {
+		userDisplay := strconv.FormatInt(ur.UserID, 10)
+		if user, err := database.GetUserInfo(ctx, ur.UserID); err == nil && user.FullName() != "" {
+			userDisplay = fmt.Sprintf("%s (%d)", user.FullName(), ur.UserID)
+		}
+		stylings = append(stylings, styling.Plain(fmt.Sprintf("%s - %s", userDisplay, ur.Role)))
+		if len(ur.ChatIDs) > 0 {
+			stylings = append(stylings, styling.Plain(fmt.Sprintf(" chats: %v", ur.ChatIDs)))
+		}
+		stylings = append(stylings, styling.Plain("\n"))
+	}
+	ctx.Reply(update, ext.ReplyTextStyledTextArray(stylings), nil)
+	return dispatcher.EndGroups
+}
+
+func botCommandsFor(perm *userPermission) []tg.BotCommand {
+	cmds := make([]tg.BotCommand, 0)
+	for _, cmdHandler := range commandHandlers {
+		if perm.CanRun(cmdHandler.cmd) || (perm == nil && slices.Contains(publicCommands, cmdHandler.cmd)) {
+			cmds = append(cmds, tg.BotCommand{
+				Command:     cmdHandler.cmd,
+				Description: cmdHandler.help,
+			})
+		}
+	}
+	return cmds
+}
+
+// setCommandMenus 为所有已授权的用户设置与其角色对应的命令菜单
+func (b *Bot) setCommandMenus(ctx context.Context) {
+	if _, err := b.Client.API().BotsSetBotCommands(ctx, &tg.BotsSetBotCommandsRequest{
+		Scope:    &tg.BotCommandScopeDefault{},
+		Commands: botCommandsFor(nil),
+	}); err != nil {
+		log.FromContext(ctx).Error("Failed to set bot commands", "error", err)
+	}
+	userIDs := make([]int64, 0)
+	for _, client := range userclient.GetUserClients() {
+		userIDs = append(userIDs, client.ID())
+	}
+	userIDs = append(userIDs, config.C().Admins...)
+	userRoles, err := database.GetAllUserRoles(ctx)
+	if err != nil {
+		log.FromContext(ctx).Error("Failed to get user roles", "error", err)
+	}
+	for _, ur := range userRoles {
+		userIDs = append(userIDs, ur.UserID)
+	}
+	for _, userID := range userIDs {
+		b.setUserCommandMenu(ctx, userID)
+	}
+}
+
+// setUserCommandMenu 根据用户当前的角色设置命令菜单, 没有角色时恢复为默认菜单
+func (b *Bot) setUserCommandMenu(ctx context.Context, userID int64) {
+	peer := b.Client.PeerStorage.GetInputPeerById(userID)
+	if peer == nil {
+		log.FromContext(ctx).Debug("Peer not found, skip setting bot commands", "user_id", userID)
+		return
+	}
+	scope := &tg.BotCommandScopePeer{Peer: peer}
+	perm := getUserPermission(ctx, userID)
+	if perm == nil {
+		if _, err := b.Client.API().BotsResetBotCommands(ctx, &tg.BotsResetBotCommandsRequest{
+			Scope: scope,
+		}); err != nil {
+			log.FromContext(ctx).Error("Failed to reset bot commands", "user_id", userID, "error", err)
+		}
+		return
+	}
+	if _, err := b.Client.API().BotsSetBotCommands(ctx, &tg.BotsSetBotCommandsRequest{
+		Scope:    scope,
+		Commands: botCommandsFor(perm),
+	}); err != nil {
+		log.FromContext(ctx).Error("Failed to set bot commands", "user_id", userID, "error", err)
+	}
+}
@@ -0,0 +1,162 @@
+package bot
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/rules"
+	"github.com/krau/btts/types"
+	"github.com/krau/mygotg/dispatcher"
+	"github.com/krau/mygotg/ext"
+	"gorm.io/gorm"
+)
+
+const rulesUsage = `Usage:
+/rules <chat_id> - 查看聊天的索引规则
+/rules <chat_id> ignore <user_id...> - 不索引这些用户的消息
+/rules <chat_id> unignore <user_id...> - 取消忽略用户
+/rules <chat_id> bots on|off - 是否忽略 bot 的消息
+/rules <chat_id> types <type...>|all - 只索引这些类型的消息: text photo video document voice audio poll story
+/rules <chat_id> minlen <n> - 文本少于 n 个字符的消息不索引, 0 表示不限制
+/rules <chat_id> include <regex>|off - 只索引匹配正则的消息
+/rules <chat_id> exclude <regex>|off - 不索引匹配正则的消息
+/rules <chat_id> clear - 删除所有规则
+规则只对之后索引的消息生效, 可以在 /add 之前设置`
+
+// RulesHandler 查看或修改聊天的索引规则
+//
+//	/rules <chat_id> [ignore|unignore|bots|types|minlen|include|exclude|clear] [args...]
+func RulesHandler(ctx *ext.Context, update *ext.Update) error {
+	if !CheckPermission(ctx, update) {
+		return dispatcher.EndGroups
+	}
+	args := update.Args()
+	if len(args) < 2 {
+		ctx.Reply(update, ext.ReplyTextString(rulesUsage), nil)
+		return dispatcher.EndGroups
+	}
+	chatID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString("Invalid chat ID"), nil)
+		return dispatcher.EndGroups
+	}
+	if !CheckChatPermission(ctx, update, chatID) {
+		return dispatcher.EndGroups
+	}
+	rule, err := database.GetIndexRule(ctx, chatID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			log.FromContext(ctx).Error("Failed to get index rule", "chat_id", chatID, "error", err)
+			replyFailure(ctx, update, "Failed to get rules")
+			return dispatcher.EndGroups
+		}
+		rule = &database.IndexRule{ChatID: chatID}
+	}
+	if len(args) == 2 {
+		compiled, err := rules.Compile(rule)
+		if err != nil {
+			ctx.Reply(update, ext.ReplyTextString("Invalid rules, they are ignored: "+err.Error()), nil)
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Rules of chat %d:\n%s", chatID, compiled)), nil)
+		return dispatcher.EndGroups
+	}
+
+	op, params := strings.ToLower(args[2]), args[3:]
+	if op == "clear" {
+		if err := database.DeleteIndexRule(ctx, chatID); err != nil {
+			log.FromContext(ctx).Error("Failed to delete index rule", "chat_id", chatID, "error", err)
+			replyFailure(ctx, update, "Failed to clear rules")
+			return dispatcher.EndGroups
+		}
+		ctx.Reply(update, ext.ReplyTextString("Rules cleared, all messages will be indexed"), nil)
+		return dispatcher.EndGroups
+	}
+	if len(params) == 0 {
+		ctx.Reply(update, ext.ReplyTextString(rulesUsage), nil)
+		return dispatcher.EndGroups
+	}
+	if err := applyRuleChange(rule, op, params); err != nil {
+		ctx.Reply(update, ext.ReplyTextString(err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	compiled, err := rules.Compile(rule)
+	if err != nil {
+		ctx.Reply(update, ext.ReplyTextString(err.Error()), nil)
+		return dispatcher.EndGroups
+	}
+	if err := database.UpsertIndexRule(ctx, rule); err != nil {
+		log.FromContext(ctx).Error("Failed to save index rule", "chat_id", chatID, "error", err)
+		replyFailure(ctx, update, "Failed to save rules")
+		return dispatcher.EndGroups
+	}
+	ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Rules of chat %d saved:\n%s", chatID, compiled)), nil)
+	return dispatcher.EndGroups
+}
+
+// applyRuleChange 将 /rules 的修改应用到 rule 上, 正则在 Compile 时校验
+func applyRuleChange(rule *database.IndexRule, op string, params []string) error {
+	switch op {
+	case "ignore", "unignore":
+		for _, param := range params {
+			userID, err := strconv.ParseInt(param, 10, 64)
+			if err != nil {
+				return fmt.Errorf("invalid user ID: %s", param)
+			}
+			idx := slices.Index(rule.IgnoreUsers, userID)
+			if op == "ignore" && idx == -1 {
+				rule.IgnoreUsers = append(rule.IgnoreUsers, userID)
+			} else if op == "unignore" && idx != -1 {
+				rule.IgnoreUsers = slices.Delete(rule.IgnoreUsers, idx, idx+1)
+			}
+		}
+	case "bots":
+		switch strings.ToLower(params[0]) {
+		case "on":
+			rule.IgnoreBots = true
+		case "off":
+			rule.IgnoreBots = false
+		default:
+			return errors.New("Usage: /rules <chat_id> bots on|off")
+		}
+	case "types":
+		if len(params) == 1 && strings.ToLower(params[0]) == "all" {
+			rule.Types = nil
+			return nil
+		}
+		rule.Types = rule.Types[:0]
+		for _, param := range params {
+			name := strings.ToLower(param)
+			if _, ok := types.MessageTypeFromString[name]; !ok {
+				return fmt.Errorf("unknown message type: %s", param)
+			}
+			if !slices.Contains(rule.Types, name) {
+				rule.Types = append(rule.Types, name)
+			}
+		}
+	case "minlen":
+		n, err := strconv.Atoi(params[0])
+		if err != nil || n < 0 {
+			return errors.New("min length must be a non-negative integer")
+		}
+		rule.MinLength = n
+	case "include", "exclude":
+		pattern := strings.Join(params, " ")
+		if strings.ToLower(pattern) == "off" {
+			pattern = ""
+		}
+		if op == "include" {
+			rule.Include = pattern
+		} else {
+			rule.Exclude = pattern
+		}
+	default:
+		return errors.New("Unknown rule: " + op + "\n" + rulesUsage)
+	}
+	return nil
+}
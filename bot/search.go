@@ -1,6 +1,7 @@
 package bot
 
 import (
+	"slices"
 	"strconv"
 	"strings"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/gotd/td/telegram/message/entity"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
+	"github.com/krau/btts/analytics"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/types"
 	"github.com/krau/btts/utils"
@@ -32,22 +34,21 @@ func SearchHandler(ctx *ext.Context, update *ext.Update) error {
 			return dispatcher.EndGroups
 		}
 
-		resp, err := bi.Engine.Search(ctx, types.SearchRequest{
+		req := types.SearchRequest{
 			ChatID: channelID,
 			Query:  query,
-		})
+		}
+		resp, err := bi.Engine.Search(ctx, req)
 		if err != nil {
 			ctx.Reply(update, ext.ReplyTextString("Error: "+err.Error()), nil)
 			return dispatcher.EndGroups
 		}
+		analytics.RecordSearch(ctx, analytics.SourceBot, analytics.UserRequester(userIDFromUpdate(update)), req, resp)
 		if len(resp.Hits) == 0 {
 			ctx.Reply(update, ext.ReplyTextString("No results found"), nil)
 			return dispatcher.EndGroups
 		}
-		markup, err := utils.BuildSearchReplyMarkup(ctx, 1, types.SearchRequest{
-			ChatID: channelID,
-			Query:  query,
-		})
+		markup, err := utils.BuildSearchReplyMarkup(ctx, 1, req, true)
 		if err != nil {
 			log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 			return dispatcher.EndGroups
@@ -58,45 +59,42 @@ func SearchHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	req := &types.SearchRequest{Query: query}
 
+	allChats, scopeChatIDs, err := searchScope(ctx, userIDFromUpdate(update))
+	if err != nil {
+		log.FromContext(ctx).Errorf("Failed to get search scope: %v", err)
+		ctx.Reply(update, ext.ReplyTextString("Error Happened"), nil)
+		return dispatcher.EndGroups
+	}
+
 	var chats []*database.IndexChat
 	if rm := update.EffectiveMessage.ReplyToMessage; rm != nil {
 		if selectChatID, ok := cache.Get[int64](strconv.Itoa(rm.GetID())); ok {
-			selectChat, err := database.GetIndexChat(ctx, selectChatID)
-			if err != nil {
-				ctx.Reply(update, ext.ReplyTextString("Failed to get chat"), nil)
+			if !allChats && !slices.Contains(scopeChatIDs, selectChatID) {
+				auditDenied(ctx, "No permission to search this chat")
+				ctx.Reply(update, ext.ReplyTextString("No permission to search this chat"), nil)
 				return dispatcher.EndGroups
 			}
-			chats = append(chats, selectChat)
-		}
-	}
-
-	if len(chats) == 0 {
-		if CheckPermission(ctx, update) {
-			req.AllChats = true
-		} else {
-			var err error
-			chats, err = database.GetAllPublicIndexChats(ctx)
+			selectChat, err := database.GetIndexChat(ctx, selectChatID)
 			if err != nil {
-				log.FromContext(ctx).Errorf("Failed to get index chats: %v", err)
-				ctx.Reply(update, ext.ReplyTextString("Error Happened"), nil)
+				replyFailure(ctx, update, "Failed to get chat")
 				return dispatcher.EndGroups
 			}
+			chats = append(chats, selectChat)
 		}
 	}
 
-	if len(chats) == 0 && !req.AllChats {
-		ctx.Reply(update, ext.ReplyTextString("No index chats found"), nil)
-		return dispatcher.EndGroups
-	}
-
 	switch {
-	case !req.AllChats && len(chats) == 1:
+	case len(chats) == 1:
 		req.ChatID = chats[0].ChatID
-	case !req.AllChats && len(chats) > 1:
-		req.ChatIDs = make([]int64, 0, len(chats))
-		for _, chat := range chats {
-			req.ChatIDs = append(req.ChatIDs, chat.ChatID)
-		}
+	case allChats:
+		req.AllChats = true
+	case len(scopeChatIDs) == 0:
+		ctx.Reply(update, ext.ReplyTextString("No index chats found"), nil)
+		return dispatcher.EndGroups
+	case len(scopeChatIDs) == 1:
+		req.ChatID = scopeChatIDs[0]
+	default:
+		req.ChatIDs = scopeChatIDs
 	}
 	resp, err := bi.Engine.Search(ctx, *req)
 	if err != nil {
@@ -104,11 +102,12 @@ func SearchHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString("Error Happened"), nil)
 		return dispatcher.EndGroups
 	}
+	analytics.RecordSearch(ctx, analytics.SourceBot, analytics.UserRequester(userIDFromUpdate(update)), *req, resp)
 	if len(resp.Hits) == 0 {
 		ctx.Reply(update, ext.ReplyTextString("No results found"), nil)
 		return dispatcher.EndGroups
 	}
-	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, *req)
+	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, *req, true)
 	if err != nil {
 		log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 		return dispatcher.EndGroups
@@ -188,7 +187,7 @@ func SearchCallbackHandler(ctx *ext.Context, update *ext.Update) error {
 	text, entities := eb.Complete()
 	editReq.SetEntities(entities)
 	editReq.SetMessage(text)
-	markup, err := utils.BuildSearchReplyMarkup(ctx, page, data)
+	markup, err := utils.BuildSearchReplyMarkup(ctx, page, data, true)
 	if err != nil {
 		log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
@@ -290,7 +289,7 @@ func FilterCallbackHandler(ctx *ext.Context, update *ext.Update) error {
 	text, entities := eb.Complete()
 	editReq.SetEntities(entities)
 	editReq.SetMessage(text)
-	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, data)
+	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, data, true)
 	if err != nil {
 		log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
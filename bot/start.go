@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/krau/btts/userclient"
 	"github.com/krau/mygotg/dispatcher"
 	"github.com/krau/mygotg/ext"
 )
@@ -19,7 +20,7 @@ func StartHandler(ctx *ext.Context, update *ext.Update) error {
 	payload := update.Args()[1]
 	action := strings.Split(payload, "_")[0]
 	args := strings.Split(payload, "_")[1:]
-	if !CheckPermission(ctx, update) {
+	if !CheckCommandPermission(ctx, update, action) {
 		ctx.Reply(update, ext.ReplyTextString("Yet Another Bot For Telegram Search..."), nil)
 		return dispatcher.EndGroups
 	}
@@ -41,9 +42,12 @@ func StartHandler(ctx *ext.Context, update *ext.Update) error {
 			ctx.Reply(update, ext.ReplyTextString("Invalid message ID"), nil)
 			return dispatcher.EndGroups
 		}
-		if err := bi.UserClient.ForwardMessagesToFav(ctx, chatID, []int{int(messageID)}); err != nil {
+		if !CheckChatPermission(ctx, update, chatID) {
+			return dispatcher.EndGroups
+		}
+		if err := userclient.GetUserClientForChat(chatID).ForwardMessagesToFav(ctx, chatID, []int{int(messageID)}); err != nil {
 			log.FromContext(ctx).Errorf("Failed to forward message: %v", err)
-			ctx.Reply(update, ext.ReplyTextString("Failed to forward message"), nil)
+			replyFailure(ctx, update, "Failed to forward message")
 			return dispatcher.EndGroups
 		}
 		msg, err := ctx.Reply(update, ext.ReplyTextString("Message forwarded to favorites"), nil)
@@ -34,7 +34,7 @@ func AddSubHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	sb, err := subbot.NewSubBot(ctx, token, chatIDs)
 	if err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to create sub bot: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to create sub bot: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	sb.Start()
@@ -58,7 +58,7 @@ func DelSubHandler(ctx *ext.Context, update *ext.Update) error {
 		return dispatcher.EndGroups
 	}
 	if err = subbot.DelSubBot(ctx, botID); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to delete sub bot: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to delete sub bot: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Sub bot stopped successfully"), nil)
@@ -71,7 +71,7 @@ func ListSubHandler(ctx *ext.Context, update *ext.Update) error {
 	}
 	sbs, err := database.GetAllSubBots(ctx)
 	if err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to get sub bots: "+err.Error()), nil)
+		replyFailure(ctx, update, "Failed to get sub bots: "+err.Error())
 		return dispatcher.EndGroups
 	}
 	runningSbs := subbot.GetAll()
@@ -86,7 +86,7 @@ func ListSubHandler(ctx *ext.Context, update *ext.Update) error {
 		botDb, err := database.GetSubBot(ctx, sb.ID)
 		if err != nil {
 			log.FromContext(ctx).Errorf("Failed to get sub bot from db: %v", err)
-			ctx.Reply(update, ext.ReplyTextString("Failed to get sub bot from db: "+err.Error()), nil)
+			replyFailure(ctx, update, "Failed to get sub bot from db: "+err.Error())
 			return dispatcher.EndGroups
 		}
 		botChats := make([]*database.IndexChat, 0)
@@ -94,7 +94,7 @@ func ListSubHandler(ctx *ext.Context, update *ext.Update) error {
 			chat, err := database.GetIndexChat(ctx, chatID)
 			if err != nil {
 				log.FromContext(ctx).Errorf("Failed to get index chat from db: %v", err)
-				ctx.Reply(update, ext.ReplyTextString("Failed to get index chat from db: "+err.Error()), nil)
+				replyFailure(ctx, update, "Failed to get index chat from db: "+err.Error())
 				return dispatcher.EndGroups
 			}
 			botChats = append(botChats, chat)
@@ -1,6 +1,9 @@
 package bot
 
 import (
+	"fmt"
+
+	"github.com/krau/btts/userclient"
 	"github.com/krau/mygotg/dispatcher"
 	"github.com/krau/mygotg/ext"
 )
@@ -9,9 +12,11 @@ func SyncPeersHandler(ctx *ext.Context, update *ext.Update) error {
 	if !CheckPermission(ctx, update) {
 		return dispatcher.EndGroups
 	}
-	if err := bi.UserClient.SyncPeers(ctx); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to synchronize peers: "+err.Error()), nil)
-		return dispatcher.EndGroups
+	for _, client := range userclient.GetUserClients() {
+		if err := client.SyncPeers(ctx); err != nil {
+			replyFailure(ctx, update, fmt.Sprintf("Failed to synchronize peers of account %s: %s", client.Name, err.Error()))
+			return dispatcher.EndGroups
+		}
 	}
 	ctx.Reply(update, ext.ReplyTextString("Peers synchronized successfully"), nil)
 	return dispatcher.EndGroups
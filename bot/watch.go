@@ -9,6 +9,7 @@ import (
 	"github.com/charmbracelet/log"
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/database"
+	"github.com/krau/btts/userclient"
 	"github.com/krau/btts/utils"
 	"github.com/krau/mygotg/dispatcher"
 	"github.com/krau/mygotg/ext"
@@ -22,7 +23,7 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 
 	args := update.Args()
 	if len(args) < 2 {
-		ctx.Reply(update, ext.ReplyTextString("Usage: /watch <chat_id>"), nil)
+		ctx.Reply(update, ext.ReplyTextString("Usage: /watch <chat_id> [account]"), nil)
 		return dispatcher.EndGroups
 	}
 
@@ -32,6 +33,9 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString("Invalid chat ID"), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatID) {
+		return dispatcher.EndGroups
+	}
 
 	logger := log.FromContext(ctx)
 
@@ -41,7 +45,16 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 		// 聊天不存在，自动创建
 		logger.Infof("Chat %d not found in database, creating new index", chatID)
 
-		utclient := bi.UserClient.TClient
+		account := bi.UserClient
+		if len(args) > 2 {
+			var ok bool
+			account, ok = userclient.FindUserClient(args[2])
+			if !ok {
+				ctx.Reply(update, ext.ReplyTextString("Account not found: "+args[2]), nil)
+				return dispatcher.EndGroups
+			}
+		}
+		utclient := account.TClient
 		inputPeer := utclient.PeerStorage.GetInputPeerById(chatID)
 		if inputPeer == nil {
 			// 尝试通过 username 解析
@@ -52,6 +65,9 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 			}
 			inputPeer = effChat.GetInputPeer()
 			chatID = effChat.GetID()
+			if !CheckChatPermission(ctx, update, chatID) {
+				return dispatcher.EndGroups
+			}
 		}
 
 		if inputPeer == nil {
@@ -61,7 +77,7 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 
 		// 创建索引
 		if err := bi.Engine.CreateIndex(ctx, chatID); err != nil {
-			ctx.Reply(update, ext.ReplyTextString("Failed to create index: "+err.Error()), nil)
+			replyFailure(ctx, update, "Failed to create index: "+err.Error())
 			return dispatcher.EndGroups
 		}
 
@@ -70,6 +86,7 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 			ChatID:   chatID,
 			Watching: true,
 			Public:   false,
+			OwnerID:  account.ID(),
 		}
 
 		// 确定聊天类型
@@ -94,7 +111,7 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 			if err := bi.Engine.DeleteIndex(ctx, chatID); err != nil {
 				logger.Errorf("Failed to delete index: %v", err)
 			}
-			ctx.Reply(update, ext.ReplyTextString("Failed to create chat index"), nil)
+			replyFailure(ctx, update, "Failed to create chat index")
 			return dispatcher.EndGroups
 		}
 
@@ -102,14 +119,14 @@ func WatchHandler(ctx *ext.Context, update *ext.Update) error {
 		return dispatcher.EndGroups
 	} else if err != nil {
 		logger.Errorf("Failed to get index chat: %v", err)
-		ctx.Reply(update, ext.ReplyTextString("Failed to get chat from database"), nil)
+		replyFailure(ctx, update, "Failed to get chat from database")
 		return dispatcher.EndGroups
 	}
 
 	// 聊天已存在，只更新 Watching 状态
 	chatDB.Watching = true
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to watch chat"), nil)
+		replyFailure(ctx, update, "Failed to watch chat")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Watching chat"), nil)
@@ -125,9 +142,12 @@ func UnWatchHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /unwatch <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.Watching = false
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
-		ctx.Reply(update, ext.ReplyTextString("Failed to unwatch chat"), nil)
+		replyFailure(ctx, update, "Failed to unwatch chat")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Unwatched chat"), nil)
@@ -143,10 +163,13 @@ func WatchDelHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /watchdel <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.NoDelete = false
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
 		log.FromContext(ctx).Error("Failed to update chat", "error", err)
-		ctx.Reply(update, ext.ReplyTextString("Failed to update chat"), nil)
+		replyFailure(ctx, update, "Failed to update chat")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Watched chat delete event"), nil)
@@ -162,10 +185,13 @@ func UnWatchDelHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString(fmt.Sprintf("Usage: /unwatchdel <chat_id>\n%s", err.Error())), nil)
 		return dispatcher.EndGroups
 	}
+	if !CheckChatPermission(ctx, update, chatDB.ChatID) {
+		return dispatcher.EndGroups
+	}
 	chatDB.NoDelete = true
 	if err := database.UpsertIndexChat(ctx, chatDB); err != nil {
 		log.FromContext(ctx).Error("Failed to update chat", "error", err)
-		ctx.Reply(update, ext.ReplyTextString("Failed to update chat"), nil)
+		replyFailure(ctx, update, "Failed to update chat")
 		return dispatcher.EndGroups
 	}
 	ctx.Reply(update, ext.ReplyTextString("Unwatched chat delete event"), nil)
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/userclient"
+	"github.com/spf13/cobra"
+)
+
+func RegisterAccountCmd(root *cobra.Command) {
+	accountCmd := &cobra.Command{
+		Use:   "account",
+		Short: "Manage user accounts used for indexing",
+	}
+
+	addCmd := &cobra.Command{
+		Use:   "add <name>",
+		Short: "Login a new user account",
+		Long: `Login a new Telegram user account interactively.
+The session is stored in data/session_user_<name>.db and the account will be started
+together with the main account on next run. Use "/add <chat> <name>" in the bot to
+index chats with this account.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			logger := log.FromContext(ctx)
+			config.Init()
+			if err := os.MkdirAll("data", os.ModePerm); err != nil {
+				logger.Fatal("Failed to create data directory", "error", err)
+			}
+			if err := database.InitDatabase(ctx); err != nil {
+				logger.Fatal("Failed to initialize database", "error", err)
+			}
+			client, err := userclient.LoginAccount(ctx, args[0])
+			if err != nil {
+				logger.Fatal("Failed to login account", "error", err)
+			}
+			self := client.TClient.Self
+			fmt.Printf("Account %s logged in as %s %s (%d)\n", client.Name, self.FirstName, self.LastName, self.ID)
+		},
+	}
+
+	lsCmd := &cobra.Command{
+		Use:   "ls",
+		Short: "List user accounts",
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx := cmd.Context()
+			logger := log.FromContext(ctx)
+			config.Init()
+			if err := database.InitDatabase(ctx); err != nil {
+				logger.Fatal("Failed to initialize database", "error", err)
+			}
+			accounts, err := database.GetAllUserAccounts(ctx)
+			if err != nil {
+				logger.Fatal("Failed to get user accounts", "error", err)
+			}
+			for _, account := range accounts {
+				primary := ""
+				if account.IsPrimary {
+					primary = " (primary)"
+				}
+				fmt.Printf("%s\t%d\t@%s\t%s %s%s\n", account.Name, account.UserID, account.Username, account.FirstName, account.LastName, primary)
+			}
+		},
+	}
+
+	accountCmd.AddCommand(addCmd, lsCmd)
+	root.AddCommand(accountCmd)
+}
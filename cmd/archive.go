@@ -0,0 +1,81 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/archive"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/userclient"
+	"github.com/spf13/cobra"
+)
+
+func RegisterArchiveCmd(root *cobra.Command) {
+	var dir string
+	var media bool
+	var workers int
+	archiveCmd := &cobra.Command{
+		Use:   "archive <chat_id>",
+		Short: "Archive an indexed chat as a static HTML site",
+		Long: `Generate a browsable static HTML site from all indexed messages of a chat,
+with one page per day and an index page grouped by month.
+
+With --media, media files are downloaded into the media directory of the archive
+using the user accounts, so the archive can be browsed offline. Already downloaded
+files are skipped when running again.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+			logger := log.FromContext(ctx)
+			chatID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				logger.Fatal("Invalid chat ID", "chat_id", args[0])
+			}
+			config.Init()
+			if err := database.InitDatabase(ctx); err != nil {
+				logger.Fatal("Failed to initialize database", "error", err)
+			}
+			searcher, err := engine.NewEngine(ctx)
+			if err != nil {
+				logger.Fatal("Failed to initialize search engine", "error", err)
+			}
+			if media {
+				if _, err := userclient.NewUserClient(ctx); err != nil {
+					logger.Fatal("Failed to initialize user client", "error", err)
+				}
+				if err := userclient.StartAccounts(ctx); err != nil {
+					logger.Warn("Failed to start some user accounts", "error", err)
+				}
+			}
+
+			out, err := archive.Build(ctx, searcher, archive.Options{
+				ChatID:  chatID,
+				Dir:     dir,
+				Media:   media,
+				Workers: workers,
+			}, func(stage string, current, total int) {
+				fmt.Printf("\r%-10s %d/%d", stage, current, total)
+				if current == total {
+					fmt.Println()
+				}
+			})
+			if err != nil {
+				fmt.Println()
+				logger.Fatal("Failed to archive chat", "error", err)
+			}
+			fmt.Printf("Archive created in %s\n", out)
+		},
+	}
+
+	archiveCmd.Flags().StringVar(&dir, "dir", "", "Output directory (default: data/archive/<chat_id>)")
+	archiveCmd.Flags().BoolVar(&media, "media", false, "Download media files so the archive works offline")
+	archiveCmd.Flags().IntVar(&workers, "workers", 0, "Number of concurrent media downloads (default: download.workers in config)")
+
+	root.AddCommand(archiveCmd)
+}
@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func RegisterConfigCmd(root *cobra.Command) {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Check or generate the config file",
+	}
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Validate config.toml and check that the configured services are reachable",
+		Long: `Validate config.toml in the current directory (and BTTS_* environment variables),
+then probe the search engine, the OCR service and the file cache directory.
+
+Exits with status 1 if any error is found, warnings do not affect the status.`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !checkConfig(cmd.Context()) {
+				os.Exit(1)
+			}
+		},
+	}
+
+	var output string
+	var force bool
+	initCmd := &cobra.Command{
+		Use:   "init",
+		Short: "Generate an annotated default config file",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := config.WriteTemplate(output, force); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				os.Exit(1)
+			}
+			fmt.Printf("Config written to %s, fill in app_id, app_hash and bot_token, then run `btts config check`\n", output)
+		},
+	}
+	initCmd.Flags().StringVarP(&output, "output", "o", "config.toml", "Path of the generated config file")
+	initCmd.Flags().BoolVarP(&force, "force", "f", false, "Overwrite the file if it already exists")
+
+	configCmd.AddCommand(checkCmd, initCmd)
+	root.AddCommand(configCmd)
+}
+
+// checkConfig 校验配置并探测依赖的服务, 输出每一项的结果, 没有错误时返回 true
+func checkConfig(ctx context.Context) bool {
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Printf("[error] %s\n", err)
+		if errors.As(err, new(viper.ConfigFileNotFoundError)) {
+			fmt.Println("Run `btts config init` to generate a default config file")
+		}
+		return false
+	}
+	ok := true
+	if err := cfg.Validate(); err != nil {
+		ok = false
+		errs := []error{err}
+		if joined, isJoined := err.(interface{ Unwrap() []error }); isJoined {
+			errs = joined.Unwrap()
+		}
+		for _, e := range errs {
+			fmt.Printf("[error] %s\n", e)
+		}
+	} else {
+		fmt.Println("[ok] config is valid")
+	}
+	for _, warning := range cfg.Warnings() {
+		fmt.Printf("[warn] %s\n", warning)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if strings.ToLower(cfg.Engine.Type) == "meilisearch" && cfg.Engine.Url != "" {
+		exists, err := engine.ProbeMeilisearch(ctx, cfg.Engine.Url, cfg.Engine.Key, cfg.Engine.Index)
+		switch {
+		case err != nil:
+			ok = false
+			fmt.Printf("[error] engine.url: %s\n", err)
+		case !exists:
+			fmt.Printf("[ok] meilisearch is reachable, index %q will be created on start\n", cfg.Engine.Index)
+		default:
+			fmt.Printf("[ok] meilisearch is reachable, index %q exists\n", cfg.Engine.Index)
+		}
+	}
+
+	if cfg.Ocr.Enable && cfg.Ocr.Paddle.Url != "" {
+		if err := utils.PingPaddleOcr(ctx, cfg.Ocr.Paddle.Url); err != nil {
+			ok = false
+			fmt.Printf("[error] ocr.paddle.url: OCR service is not reachable: %s\n", err)
+		} else {
+			fmt.Println("[ok] OCR service is reachable")
+		}
+	}
+
+	if !cfg.FileCache.Disable {
+		if err := checkWritableDir(cfg.FileCache.Dir); err != nil {
+			ok = false
+			fmt.Printf("[error] file_cache.dir: %s\n", err)
+		} else {
+			fmt.Printf("[ok] file cache directory %s is writable\n", cfg.FileCache.Dir)
+		}
+	}
+	return ok
+}
+
+// checkWritableDir 创建目录 (如果不存在) 并尝试写入一个临时文件
+func checkWritableDir(dir string) error {
+	if dir == "" {
+		return errors.New("must not be empty")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	f, err := os.CreateTemp(dir, ".btts-check-*")
+	if err != nil {
+		return fmt.Errorf("directory is not writable: %w", err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
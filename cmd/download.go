@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/dustin/go-humanize"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/download"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/types"
+	"github.com/krau/btts/userclient"
+	"github.com/spf13/cobra"
+)
+
+func RegisterDownloadCmd(root *cobra.Command) {
+	var chatID int64
+	var msgRange, query, dir string
+	var workers int
+	var limit int64
+	var zip bool
+	downloadCmd := &cobra.Command{
+		Use:   "download",
+		Short: "Download media of a chat, a message range or search results to a local directory",
+		Long: `Download media files to a local directory using the user accounts.
+Files are saved as <dir>/<chat_id>/<message_id>_<file name>. Files with the same
+Telegram file ID are downloaded only once. Interrupted downloads can be resumed by
+running the same command again.
+
+Examples:
+  btts download --chat 123456 --range 100-200
+  btts download --query "keyword" --zip`,
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+			logger := log.FromContext(ctx)
+			config.Init()
+			if err := database.InitDatabase(ctx); err != nil {
+				logger.Fatal("Failed to initialize database", "error", err)
+			}
+
+			req := download.Request{ChatID: chatID, Dir: dir, Workers: workers, Zip: zip}
+			if query != "" {
+				search := &types.SearchRequest{Query: query, Limit: limit}
+				if chatID != 0 {
+					search.ChatIDs = []int64{chatID}
+				} else {
+					search.AllChats = true
+				}
+				req.Search = search
+				if _, err := engine.NewEngine(ctx); err != nil {
+					logger.Fatal("Failed to initialize search engine", "error", err)
+				}
+			} else if msgRange != "" {
+				start, end, ok := strings.Cut(msgRange, "-")
+				startID, err1 := strconv.Atoi(start)
+				endID, err2 := strconv.Atoi(end)
+				if !ok || err1 != nil || err2 != nil {
+					logger.Fatal("Invalid message range, expected <start>-<end>", "range", msgRange)
+				}
+				req.StartID, req.EndID = startID, endID
+			}
+
+			if _, err := userclient.NewUserClient(ctx); err != nil {
+				logger.Fatal("Failed to initialize user client", "error", err)
+			}
+			if err := userclient.StartAccounts(ctx); err != nil {
+				logger.Warn("Failed to start some user accounts", "error", err)
+			}
+
+			job, err := download.Start(ctx, req)
+			if err != nil {
+				logger.Fatal("Failed to start download", "error", err)
+			}
+			go func() {
+				<-ctx.Done()
+				job.Cancel()
+			}()
+			done := make(chan download.Progress, 1)
+			go func() { done <- job.Wait() }()
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case p := <-done:
+					printDownloadProgress(p)
+					fmt.Println()
+					if p.ZipPath != "" {
+						fmt.Printf("Zip: %s\n", p.ZipPath)
+					}
+					if p.Status != download.StatusDone {
+						logger.Fatal("Download did not complete", "status", p.Status, "error", p.Error)
+					}
+					return
+				case <-ticker.C:
+					printDownloadProgress(job.Progress())
+				}
+			}
+		},
+	}
+
+	downloadCmd.Flags().Int64Var(&chatID, "chat", 0, "Chat ID to download from, also limits --query to this chat")
+	downloadCmd.Flags().StringVar(&msgRange, "range", "", "Message ID range <start>-<end>, the whole chat is downloaded if empty")
+	downloadCmd.Flags().StringVar(&query, "query", "", "Download media of search results instead of a message range")
+	downloadCmd.Flags().Int64Var(&limit, "limit", 0, "Max number of search results to download (default and max 1000)")
+	downloadCmd.Flags().StringVar(&dir, "dir", "", "Directory to save files (default: download.dir in config)")
+	downloadCmd.Flags().IntVar(&workers, "workers", 0, "Number of concurrent downloads (default: download.workers in config)")
+	downloadCmd.Flags().BoolVar(&zip, "zip", false, "Pack downloaded files into a zip archive")
+	downloadCmd.MarkFlagsMutuallyExclusive("range", "query")
+
+	root.AddCommand(downloadCmd)
+}
+
+func printDownloadProgress(p download.Progress) {
+	fmt.Printf("\r[%s] found %d, downloaded %d, skipped %d, failed %d, %s",
+		p.Status, p.Total, p.Done, p.Skipped, p.Failed, humanize.IBytes(uint64(p.Bytes)))
+}
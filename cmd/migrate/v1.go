@@ -46,7 +46,7 @@ func indexKey(chatID int64) string {
 func MigrateToV1(ctx context.Context, dropOld bool) error {
 	logger := log.FromContext(ctx)
 	logger.Info("Starting migration to v1 format")
-	cfg := config.C
+	cfg := config.C()
 	if cfg.Engine.Type != "meilisearch" {
 		return fmt.Errorf("migration to v1 is only supported for meilisearch engine")
 	}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/reindex"
+	"github.com/krau/btts/userclient"
+	"github.com/spf13/cobra"
+)
+
+func RegisterReindexCmd(root *cobra.Command) {
+	var ocr bool
+	var since string
+	reindexCmd := &cobra.Command{
+		Use:   "reindex <chat_id>",
+		Short: "Re-fetch messages of an indexed chat and update their documents",
+		Long: `Re-fetch messages of an indexed chat from newest to oldest using the user accounts,
+extract their text again and update the documents in the search engine.
+
+With --ocr, photos are OCR'd again, otherwise existing OCR text is kept.
+Progress is saved after every batch, an interrupted reindex continues from where
+it stopped when running the same command again.
+
+Examples:
+  btts reindex 123456 --ocr
+  btts reindex 123456 --since 2024-01-01`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, cancel := signal.NotifyContext(cmd.Context(), os.Interrupt)
+			defer cancel()
+			logger := log.FromContext(ctx)
+			chatID, err := strconv.ParseInt(args[0], 10, 64)
+			if err != nil {
+				logger.Fatal("Invalid chat ID", "chat_id", args[0])
+			}
+			opts := reindex.Options{ChatID: chatID, Ocr: ocr}
+			if since != "" {
+				opts.Since, err = time.ParseInLocation(time.DateOnly, since, time.Local)
+				if err != nil {
+					logger.Fatal("Invalid date, expected YYYY-MM-DD", "since", since)
+				}
+			}
+			config.Init()
+			if err := database.InitDatabase(ctx); err != nil {
+				logger.Fatal("Failed to initialize database", "error", err)
+			}
+			searcher, err := engine.NewEngine(ctx)
+			if err != nil {
+				logger.Fatal("Failed to initialize search engine", "error", err)
+			}
+			if _, err := userclient.NewUserClient(ctx); err != nil {
+				logger.Fatal("Failed to initialize user client", "error", err)
+			}
+			if err := userclient.StartAccounts(ctx); err != nil {
+				logger.Warn("Failed to start some user accounts", "error", err)
+			}
+
+			job, err := reindex.Start(ctx, searcher, opts)
+			if err != nil {
+				logger.Fatal("Failed to start reindex", "error", err)
+			}
+			if job.Progress().Resumed {
+				fmt.Printf("Resuming from message %d\n", job.Progress().LastID)
+			}
+			go func() {
+				<-ctx.Done()
+				job.Cancel()
+			}()
+			done := make(chan reindex.Progress, 1)
+			go func() { done <- job.Wait() }()
+			ticker := time.NewTicker(2 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case p := <-done:
+					printReindexProgress(p)
+					fmt.Println()
+					if p.Status != reindex.StatusDone {
+						logger.Fatal("Reindex did not complete, run the same command again to resume", "status", p.Status, "error", p.Error)
+					}
+					return
+				case <-ticker.C:
+					printReindexProgress(job.Progress())
+				}
+			}
+		},
+	}
+
+	reindexCmd.Flags().BoolVar(&ocr, "ocr", false, "OCR photos again")
+	reindexCmd.Flags().StringVar(&since, "since", "", "Only reindex messages sent after this date (YYYY-MM-DD)")
+
+	root.AddCommand(reindexCmd)
+}
+
+func printReindexProgress(p reindex.Progress) {
+	fmt.Printf("\r[%s] messages %d, indexed %d, ocr %d, at message %d",
+		p.Status, p.Processed, p.Indexed, p.Ocred, p.LastID)
+}
@@ -23,6 +23,11 @@ func init() {
 	rootCmd.Flags().BoolVar(&backgroundMigrateDropOld, "migrate-drop-old", false, "Drop old indexes after background migration")
 	migrate.RegisterCmd(rootCmd)
 	RegisterTakeoutCmd(rootCmd)
+	RegisterAccountCmd(rootCmd)
+	RegisterDownloadCmd(rootCmd)
+	RegisterArchiveCmd(rootCmd)
+	RegisterReindexCmd(rootCmd)
+	RegisterConfigCmd(rootCmd)
 }
 
 func Execute() {
@@ -4,17 +4,24 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	_ "github.com/ncruces/go-sqlite3/embed"
 
 	"github.com/charmbracelet/log"
+	"github.com/krau/btts/analytics"
 	"github.com/krau/btts/api"
+	"github.com/krau/btts/audit"
 	"github.com/krau/btts/bot"
 	"github.com/krau/btts/cmd/migrate"
 	"github.com/krau/btts/config"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ingest"
+	"github.com/krau/btts/metrics"
+	"github.com/krau/btts/ocr"
+	"github.com/krau/btts/retention"
 	"github.com/krau/btts/userclient"
 )
 
@@ -39,18 +46,31 @@ func run() {
 		log.Errorf("Failed to initialize database: %v", err)
 		return
 	}
+	audit.StartRetention(ctx)
+	analytics.StartRetention(ctx)
+	metrics.Serve(ctx)
+	// 在用户账号启动前启动, 以便对启动时同步的错过消息进行 OCR
+	ocr.Start(ctx)
 
 	userClient, err := userclient.NewUserClient(ctx)
 	if err != nil {
 		log.Errorf("Failed to create user client: %v", err)
 		return
 	}
+	if err := userclient.StartAccounts(ctx); err != nil {
+		log.Errorf("Failed to start some user accounts: %v", err)
+	}
 
 	engine, err := engine.NewEngine(ctx)
 	if err != nil {
 		log.Errorf("Failed to create engine: %v", err)
 		return
 	}
+	if err := ingest.Start(ctx, engine); err != nil {
+		log.Errorf("Failed to start ingestion queue: %v", err)
+		return
+	}
+	retention.Start(ctx, engine)
 
 	bot, err := bot.NewBot(ctx, userClient, engine)
 	if err != nil {
@@ -68,9 +88,32 @@ func run() {
 		}()
 	}
 
-	if config.C.Api.Enable {
-		api.Serve(config.C.Api.Addr)
-		log.Infof("API server started at %s", config.C.Api.Addr)
+	if config.C().Api.Enable {
+		api.Serve(config.C().Api.Addr)
+		log.Infof("API server started at %s", config.C().Api.Addr)
 	}
+	reloadOnSignal(ctx)
 	bot.Start(ctx)
 }
+
+// reloadOnSignal 收到 SIGHUP 时重新加载配置文件
+func reloadOnSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				res, err := config.Reload()
+				if err != nil {
+					log.FromContext(ctx).Error("Failed to reload config", "error", err)
+					continue
+				}
+				log.FromContext(ctx).Info("Config reloaded", "applied", res.Applied, "restart_required", res.RestartRequired)
+			}
+		}
+	}()
+}
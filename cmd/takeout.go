@@ -11,6 +11,7 @@ import (
 
 func RegisterTakeoutCmd(root *cobra.Command) {
 	var enableWatching bool
+	var account string
 	var noUsers, noChats, noMegagroups, noChannels bool
 	takeoutCmd := &cobra.Command{
 		Use:   "takeout",
@@ -37,7 +38,7 @@ Note: This is a one-time export operation and may take a long time depending on
 			}
 
 			// 初始化 UserClient
-			uc, err := userclient.NewUserClient(ctx)
+			uc, err := userclient.LoginAccount(ctx, account)
 			if err != nil {
 				logger.Fatal("Failed to initialize user client", "error", err)
 				return
@@ -65,6 +66,7 @@ Note: This is a one-time export operation and may take a long time depending on
 
 	// 是否将导出的聊天设置为默认监听
 	takeoutCmd.Flags().BoolVar(&enableWatching, "watch", false, "Mark exported chats as watching (default: false)")
+	takeoutCmd.Flags().StringVar(&account, "account", userclient.PrimaryAccountName, "Name of the user account to export from")
 	takeoutCmd.Flags().BoolVar(&noUsers, "no-users", false, "Do not export messages from private chats with users")
 	takeoutCmd.Flags().BoolVar(&noChats, "no-chats", false, "Do not export messages from group chats")
 	takeoutCmd.Flags().BoolVar(&noMegagroups, "no-megagroups", false, "Do not export messages from megagroups")
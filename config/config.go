@@ -1,7 +1,9 @@
 package config
 
 import (
+	"fmt"
 	"strings"
+	"sync/atomic"
 
 	"github.com/charmbracelet/log"
 
@@ -40,6 +42,10 @@ type AppConfig struct {
 			Url       string  `toml:"url" mapstructure:"url"`
 			Threshold float64 `toml:"threshold" mapstructure:"threshold"`
 		}
+		// 并发进行 OCR 的 worker 数
+		Workers int `toml:"workers" mapstructure:"workers"`
+		// 等待 OCR 的图片数上限, 实时消息在队列满时跳过 OCR
+		QueueSize int `toml:"queue_size" mapstructure:"queue_size"`
 	}
 	Api struct {
 		Enable bool   `toml:"enable" mapstructure:"enable"`
@@ -50,34 +56,122 @@ type AppConfig struct {
 		Disable bool   `toml:"disable" mapstructure:"disable"`
 		Dir     string `toml:"dir" mapstructure:"dir"`
 		TTL     string `toml:"ttl" mapstructure:"ttl"`
+		// 缓存目录的最大容量, 如 "10GiB", 为空表示不限制
+		MaxSize string `toml:"max_size" mapstructure:"max_size"`
+		// 超出容量时的淘汰策略, "lru" 或 "lfu"
+		Eviction string `toml:"eviction" mapstructure:"eviction"`
 	} `toml:"file_cache" mapstructure:"file_cache"`
+	Download struct {
+		// 媒体下载任务的默认保存目录
+		Dir string `toml:"dir" mapstructure:"dir"`
+		// 每个下载任务的默认并发数
+		Workers int `toml:"workers" mapstructure:"workers"`
+	} `toml:"download" mapstructure:"download"`
+	Audit struct {
+		// 审计日志保留天数, 0 表示永久保留
+		RetentionDays int `toml:"retention_days" mapstructure:"retention_days"`
+	} `toml:"audit" mapstructure:"audit"`
+	Analytics struct {
+		// 关闭搜索记录
+		Disable bool `toml:"disable" mapstructure:"disable"`
+		// 搜索记录保留天数, 0 表示永久保留
+		RetentionDays int `toml:"retention_days" mapstructure:"retention_days"`
+		// 只保存查询的哈希, 统计中不显示查询原文
+		HashQueries bool `toml:"hash_queries" mapstructure:"hash_queries"`
+		// 保存搜索者 user ID 的哈希而不是原始 ID
+		HashUsers bool `toml:"hash_users" mapstructure:"hash_users"`
+		// 哈希使用的盐, 为空时使用 bot_token
+		Salt string `toml:"salt" mapstructure:"salt"`
+	} `toml:"analytics" mapstructure:"analytics"`
+	Metrics struct {
+		Enable bool `toml:"enable" mapstructure:"enable"`
+		// 独立的监听地址, 为空时在 api 服务上提供
+		Addr string `toml:"addr" mapstructure:"addr"`
+		Path string `toml:"path" mapstructure:"path"`
+	} `toml:"metrics" mapstructure:"metrics"`
+	Health struct {
+		// 健康检查间隔, 如 "30s"
+		Interval string `toml:"interval" mapstructure:"interval"`
+		// 组件持续不可用多久后向 admins 发送告警, 如 "5m"
+		AlertAfter   string `toml:"alert_after" mapstructure:"alert_after"`
+		DisableAlert bool   `toml:"disable_alert" mapstructure:"disable_alert"`
+	} `toml:"health" mapstructure:"health"`
+	Ingest struct {
+		// 队列中等待写入搜索引擎的最大操作数, 达到后新消息的处理会等待, 0 表示不限制
+		MaxPending int `toml:"max_pending" mapstructure:"max_pending"`
+	} `toml:"ingest" mapstructure:"ingest"`
+	Retention struct {
+		// 默认的消息保留天数, 超过的文档会从索引中删除, 0 表示永久保留. 可用 /retention 为单个聊天设置
+		MaxAgeDays int `toml:"max_age_days" mapstructure:"max_age_days"`
+		// 默认每个聊天最多保留的文档数, 超出时删除最旧的文档, 0 表示不限制
+		MaxDocuments int64 `toml:"max_documents" mapstructure:"max_documents"`
+		// 检查间隔, 如 "1h"
+		Interval string `toml:"interval" mapstructure:"interval"`
+	} `toml:"retention" mapstructure:"retention"`
 }
 
-var C AppConfig
+var current atomic.Pointer[AppConfig]
+
+func init() {
+	current.Store(&AppConfig{})
+}
+
+// C 返回当前生效的配置. 配置重载时整体替换, 不要修改返回值
+func C() *AppConfig {
+	return current.Load()
+}
 
 func Init() {
-	viper.SetConfigName("config")
-	viper.SetConfigType("toml")
-	viper.AddConfigPath(".")
-	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	viper.SetEnvPrefix("btts")
-	viper.AutomaticEnv()
+	cfg, err := Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Warn("Invalid config, run `btts config check` for details", "error", err)
+	}
+	for _, warning := range cfg.Warnings() {
+		log.Warn(warning)
+	}
+	current.Store(cfg)
+}
 
-	viper.SetDefault("engine.index", "btts")
-	viper.SetDefault("engine.type", "meilisearch")
-	viper.SetDefault("file_cache.disable", false)
-	viper.SetDefault("file_cache.dir", "data/file_cache")
-	viper.SetDefault("file_cache.ttl", "24h")
+// Load 读取当前目录的 config.toml 和环境变量, 不校验也不替换当前配置
+func Load() (*AppConfig, error) {
+	v := viper.New()
+	v.SetConfigName("config")
+	v.SetConfigType("toml")
+	v.AddConfigPath(".")
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.SetEnvPrefix("btts")
+	v.AutomaticEnv()
 
-	viper.SetDefault("plugin.prefixes", []string{","})
+	v.SetDefault("engine.index", "btts")
+	v.SetDefault("engine.type", "meilisearch")
+	v.SetDefault("file_cache.disable", false)
+	v.SetDefault("file_cache.dir", "data/file_cache")
+	v.SetDefault("file_cache.ttl", "24h")
+	v.SetDefault("file_cache.max_size", "10GiB")
+	v.SetDefault("file_cache.eviction", "lru")
+	v.SetDefault("audit.retention_days", 90)
+	v.SetDefault("analytics.retention_days", 30)
+	v.SetDefault("metrics.path", "/metrics")
+	v.SetDefault("health.interval", "30s")
+	v.SetDefault("health.alert_after", "5m")
+	v.SetDefault("ingest.max_pending", 100000)
+	v.SetDefault("ocr.workers", 2)
+	v.SetDefault("ocr.queue_size", 1000)
+	v.SetDefault("download.dir", "data/downloads")
+	v.SetDefault("download.workers", 4)
+	v.SetDefault("retention.interval", "1h")
 
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalf("Error reading config file, %s", err)
-	}
-	if err := viper.Unmarshal(&C); err != nil {
-		log.Fatalf("Unable to decode into struct, %v", err)
+	v.SetDefault("plugin.prefixes", []string{","})
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config file: %w", err)
 	}
-	if C.Api.Enable && C.Api.Key == "" {
-		log.Warn("API is enabled but API key is not set!\nThis should only be used for testing purposes!")
+	var cfg AppConfig
+	if err := v.Unmarshal(&cfg); err != nil {
+		return nil, fmt.Errorf("unable to decode config: %w", err)
 	}
+	return &cfg, nil
 }
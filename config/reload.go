@@ -0,0 +1,88 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// reloadable 是可以在运行时修改的配置项 (或其前缀), 其余配置项修改后需要重启才能生效
+var reloadable = []string{
+	"admins",
+	"plugin.prefixes",
+	"ocr.type",
+	"ocr.paddle",
+	"analytics.disable",
+	"analytics.hash_queries",
+	"analytics.hash_users",
+	"analytics.salt",
+	"health.disable_alert",
+	"ingest.max_pending",
+	"download",
+	"retention.max_age_days",
+	"retention.max_documents",
+}
+
+func isReloadable(key string) bool {
+	return slices.ContainsFunc(reloadable, func(p string) bool {
+		return key == p || strings.HasPrefix(key, p+".")
+	})
+}
+
+// ReloadResult 描述一次配置重载中发生变化的配置项
+type ReloadResult struct {
+	// 已经生效的配置项
+	Applied []string
+	// 需要重启才能生效的配置项, 在重启前保持原值
+	RestartRequired []string
+}
+
+var reloadMu sync.Mutex
+
+// Reload 重新读取并校验配置文件, 然后整体替换当前配置. 校验失败时不做任何修改,
+// 不能在运行时修改的配置项保持原值并在结果中列出
+func Reload() (*ReloadResult, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	next, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	if err := next.Validate(); err != nil {
+		return nil, err
+	}
+	res := &ReloadResult{}
+	mergeChanges(reflect.ValueOf(C()).Elem(), reflect.ValueOf(next).Elem(), "", res)
+	current.Store(next)
+	return res, nil
+}
+
+// mergeChanges 比较新旧配置, 将不可重载的配置项恢复为旧值. 配置项名称与配置文件中的 key 相同
+func mergeChanges(old, next reflect.Value, prefix string, res *ReloadResult) {
+	t := old.Type()
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("mapstructure"), ",")
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		o, n := old.Field(i), next.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			mergeChanges(o, n, name, res)
+			continue
+		}
+		if reflect.DeepEqual(o.Interface(), n.Interface()) {
+			continue
+		}
+		if isReloadable(name) {
+			res.Applied = append(res.Applied, name)
+			continue
+		}
+		n.Set(o)
+		res.RestartRequired = append(res.RestartRequired, name)
+	}
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestMergeChanges(t *testing.T) {
+	tests := []struct {
+		name            string
+		change          func(c *AppConfig)
+		applied         []string
+		restartRequired []string
+	}{
+		{
+			name:   "No changes",
+			change: func(c *AppConfig) {},
+		},
+		{
+			name:    "Top-level reloadable key",
+			change:  func(c *AppConfig) { c.Admins = []int64{1, 2} },
+			applied: []string{"admins"},
+		},
+		{
+			name:    "Nested reloadable key",
+			change:  func(c *AppConfig) { c.Analytics.HashUsers = true },
+			applied: []string{"analytics.hash_users"},
+		},
+		{
+			name:    "Reloadable prefix",
+			change:  func(c *AppConfig) { c.Ocr.Paddle.Url = "http://ocr:8000"; c.Download.Workers = 8 },
+			applied: []string{"ocr.paddle.url", "download.workers"},
+		},
+		{
+			name:            "Restart-required keys",
+			change:          func(c *AppConfig) { c.BotToken = "new"; c.Engine.Embedder.Model = "new"; c.Api.Addr = ":9000" },
+			restartRequired: []string{"bot_token", "engine.embedder.model", "api.addr"},
+		},
+		{
+			name:            "Same prefix is not reloadable",
+			change:          func(c *AppConfig) { c.Ocr.Enable = true; c.Ocr.Workers = 4 },
+			restartRequired: []string{"ocr.enable", "ocr.workers"},
+		},
+		{
+			name: "Mixed",
+			change: func(c *AppConfig) {
+				c.Ingest.MaxPending = 10
+				c.Retention.Interval = "2h"
+				c.Retention.MaxAgeDays = 30
+			},
+			applied:         []string{"ingest.max_pending", "retention.max_age_days"},
+			restartRequired: []string{"retention.interval"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := &AppConfig{}
+			old.BotToken = "old"
+			old.Api.Addr = ":8080"
+			old.Retention.Interval = "1h"
+			next := &AppConfig{}
+			*next = *old
+			tt.change(next)
+			expected := *next
+
+			res := &ReloadResult{}
+			mergeChanges(reflect.ValueOf(old).Elem(), reflect.ValueOf(next).Elem(), "", res)
+			if !slices.Equal(res.Applied, tt.applied) {
+				t.Errorf("Applied = %v, expected %v", res.Applied, tt.applied)
+			}
+			if !slices.Equal(res.RestartRequired, tt.restartRequired) {
+				t.Errorf("RestartRequired = %v, expected %v", res.RestartRequired, tt.restartRequired)
+			}
+			// 需要重启的配置项恢复为旧值, 可重载的配置项保持新值
+			for _, key := range tt.restartRequired {
+				if got, want := field(next, key), field(old, key); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, expected the old value %v", key, got, want)
+				}
+			}
+			for _, key := range tt.applied {
+				if got, want := field(next, key), field(&expected, key); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s = %v, expected the new value %v", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+// field 按配置文件中的 key 获取配置项的值
+func field(c *AppConfig, key string) any {
+	v := reflect.ValueOf(c).Elem()
+	for _, name := range strings.Split(key, ".") {
+		t := v.Type()
+		for i := range t.NumField() {
+			if tag := t.Field(i).Tag.Get("mapstructure"); tag == name {
+				v = v.Field(i)
+				break
+			}
+		}
+	}
+	return v.Interface()
+}
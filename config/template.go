@@ -0,0 +1,39 @@
+package config
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Template 是带注释的默认配置文件
+//
+//go:embed template.toml
+var Template string
+
+// WriteTemplate 将默认配置写入 path, 文件已存在且 force 为 false 时返回错误
+func WriteTemplate(path string, force bool) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(path, flag, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists, use --force to overwrite it", path)
+		}
+		return err
+	}
+	if _, err := f.WriteString(Template); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
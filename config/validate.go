@@ -0,0 +1,98 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Validate 检查配置项的取值, 返回所有发现的错误, 每个错误以配置项名称开头
+func (c *AppConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	checkDuration := func(key, value string) {
+		if value == "" {
+			return
+		}
+		d, err := time.ParseDuration(value)
+		check(err == nil && d > 0, "%s: invalid duration %q, use a value like \"30s\", \"5m\" or \"24h\"", key, value)
+	}
+
+	check(c.AppID != 0, "app_id: required, get it from https://my.telegram.org")
+	check(c.AppHash != "", "app_hash: required, get it from https://my.telegram.org")
+	check(c.BotToken != "", "bot_token: required, create a bot with @BotFather to get one")
+	check(!slices.Contains(c.Plugin.Prefixes, ""), "plugin.prefixes: prefixes must not be empty strings")
+
+	switch strings.ToLower(c.Engine.Type) {
+	case "", "meilisearch":
+		check(c.Engine.Url != "", "engine.url: required for meilisearch, e.g. \"http://localhost:7700\"")
+		check(c.Engine.Index != "", "engine.index: must not be empty")
+	default:
+		errs = append(errs, fmt.Errorf("engine.type: unsupported engine type %q, only \"meilisearch\" is supported", c.Engine.Type))
+	}
+
+	if c.Ocr.Enable {
+		switch c.Ocr.Type {
+		case "paddle", "paddleocr":
+			u, err := url.ParseRequestURI(c.Ocr.Paddle.Url)
+			check(err == nil && u.Host != "", "ocr.paddle.url: invalid url %q, e.g. \"http://localhost:8000/ocr\"", c.Ocr.Paddle.Url)
+		default:
+			errs = append(errs, fmt.Errorf("ocr.type: unsupported ocr type %q, only \"paddle\" is supported", c.Ocr.Type))
+		}
+	}
+	check(c.Ocr.Paddle.Threshold >= 0 && c.Ocr.Paddle.Threshold <= 1, "ocr.paddle.threshold: must be between 0 and 1")
+	check(c.Ocr.Workers >= 0, "ocr.workers: must not be negative")
+	check(c.Ocr.QueueSize >= 0, "ocr.queue_size: must not be negative")
+
+	check(!c.Api.Enable || c.Api.Addr != "", "api.addr: required when api is enabled, e.g. \"127.0.0.1:39415\"")
+	check(!c.Metrics.Enable || strings.HasPrefix(c.Metrics.Path, "/"), "metrics.path: must start with /")
+
+	checkDuration("file_cache.ttl", c.FileCache.TTL)
+	if c.FileCache.MaxSize != "" {
+		_, err := humanize.ParseBytes(c.FileCache.MaxSize)
+		check(err == nil, "file_cache.max_size: invalid size %q, use a value like \"10GiB\"", c.FileCache.MaxSize)
+	}
+	switch strings.ToLower(c.FileCache.Eviction) {
+	case "", "lru", "lfu":
+	default:
+		errs = append(errs, fmt.Errorf("file_cache.eviction: expected \"lru\" or \"lfu\", got %q", c.FileCache.Eviction))
+	}
+
+	checkDuration("health.interval", c.Health.Interval)
+	checkDuration("health.alert_after", c.Health.AlertAfter)
+	check(c.Audit.RetentionDays >= 0, "audit.retention_days: must not be negative")
+	check(c.Analytics.RetentionDays >= 0, "analytics.retention_days: must not be negative")
+	check(c.Ingest.MaxPending >= 0, "ingest.max_pending: must not be negative")
+	check(c.Download.Workers >= 0, "download.workers: must not be negative")
+	check(c.Retention.MaxAgeDays >= 0, "retention.max_age_days: must not be negative")
+	check(c.Retention.MaxDocuments >= 0, "retention.max_documents: must not be negative")
+	checkDuration("retention.interval", c.Retention.Interval)
+	return errors.Join(errs...)
+}
+
+// Warnings 返回可以运行但可能不符合预期的配置
+func (c *AppConfig) Warnings() []string {
+	var warnings []string
+	if c.Api.Enable && c.Api.Key == "" {
+		warnings = append(warnings, "api.key: API is enabled without a key, anyone who can reach api.addr has full access. This should only be used for testing")
+	}
+	if len(c.Admins) == 0 {
+		warnings = append(warnings, "admins: no admins configured, only the user accounts can manage the bot")
+	}
+	if c.FileCache.Disable && c.Api.Enable {
+		warnings = append(warnings, "file_cache.disable: file cache is disabled, every media request from the API will be downloaded from Telegram")
+	}
+	if c.Metrics.Enable && c.Metrics.Addr == "" && !c.Api.Enable {
+		warnings = append(warnings, "metrics.addr: metrics are served on the API server but api is not enabled, set metrics.addr or enable api")
+	}
+	return warnings
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"slices"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -10,6 +11,8 @@ var (
 	watchedChatsIDMu = &sync.RWMutex{}
 	allChatIDs       = make([]int64, 0)
 	allChatIDsMu     = &sync.RWMutex{}
+	chatOwners       = make(map[int64]int64)
+	chatOwnersMu     = &sync.RWMutex{}
 )
 
 func Watching(chatID int64) bool {
@@ -32,3 +35,53 @@ func Indexed(chatID int64) bool {
 	defer allChatIDsMu.RUnlock()
 	return slices.Contains(allChatIDs, chatID)
 }
+
+// ChatOwner 返回负责该聊天的用户账号 ID, 0 表示主账号
+func ChatOwner(chatID int64) int64 {
+	chatOwnersMu.RLock()
+	defer chatOwnersMu.RUnlock()
+	return chatOwners[chatID]
+}
+
+func setChatOwner(chatID, ownerID int64) {
+	chatOwnersMu.Lock()
+	defer chatOwnersMu.Unlock()
+	chatOwners[chatID] = ownerID
+}
+
+func deleteChatOwner(chatID int64) {
+	chatOwnersMu.Lock()
+	defer chatOwnersMu.Unlock()
+	delete(chatOwners, chatID)
+}
+
+// indexRulesVersion 在索引规则被修改或删除时递增, rules 包据此使缓存的编译结果失效
+var indexRulesVersion atomic.Int64
+
+// IndexRulesVersion 返回索引规则的当前版本
+func IndexRulesVersion() int64 {
+	return indexRulesVersion.Load()
+}
+
+var (
+	neverIndexUsers   = make(map[int64]struct{})
+	neverIndexUsersMu = &sync.RWMutex{}
+)
+
+// NeverIndex 判断用户是否在永不索引列表中
+func NeverIndex(userID int64) bool {
+	neverIndexUsersMu.RLock()
+	defer neverIndexUsersMu.RUnlock()
+	_, ok := neverIndexUsers[userID]
+	return ok
+}
+
+func setNeverIndex(userID int64, never bool) {
+	neverIndexUsersMu.Lock()
+	defer neverIndexUsersMu.Unlock()
+	if never {
+		neverIndexUsers[userID] = struct{}{}
+	} else {
+		delete(neverIndexUsers, userID)
+	}
+}
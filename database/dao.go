@@ -2,8 +2,10 @@ package database
 
 import (
 	"context"
+	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 func UpsertUserInfo(ctx context.Context, userInfo *UserInfo) error {
@@ -173,16 +175,66 @@ func DeleteApiKey(ctx context.Context, id uint) error {
 	if err := db.WithContext(ctx).Where("id = ?", id).Delete(&ApiKey{}).Error; err != nil {
 		return err
 	}
+	if err := db.WithContext(ctx).Where("api_key_id = ?", id).Delete(&ApiKeyUsage{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// TouchApiKey 更新 key 的最后使用时间和 IP
+func TouchApiKey(ctx context.Context, id uint, usedAt time.Time, ip string) error {
+	if err := db.WithContext(ctx).Model(&ApiKey{}).Where("id = ?", id).
+		UpdateColumns(map[string]any{"last_used_at": usedAt, "last_used_ip": ip}).Error; err != nil {
+		return err
+	}
 	return nil
 }
 
-// GetUpdatesState 获取 updates state，如果不存在则创建一个默认的
-func GetUpdatesState(ctx context.Context) (*UpdatesState, error) {
+// GetApiKeyUsage 获取子 API key 某天的用量, 没有记录时返回零值
+func GetApiKeyUsage(ctx context.Context, apiKeyID uint, day string) (*ApiKeyUsage, error) {
+	usage := ApiKeyUsage{ApiKeyID: apiKeyID, Day: day}
+	if err := db.WithContext(ctx).Where("api_key_id = ? AND day = ?", apiKeyID, day).
+		Limit(1).Find(&usage).Error; err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// GetApiKeyUsagesByDay 获取所有子 API key 某天的用量, 以 ApiKeyID 为键
+func GetApiKeyUsagesByDay(ctx context.Context, day string) (map[uint]*ApiKeyUsage, error) {
+	var usages []*ApiKeyUsage
+	if err := db.WithContext(ctx).Where("day = ?", day).Find(&usages).Error; err != nil {
+		return nil, err
+	}
+	res := make(map[uint]*ApiKeyUsage, len(usages))
+	for _, u := range usages {
+		res[u.ApiKeyID] = u
+	}
+	return res, nil
+}
+
+// AddApiKeyUsage 将 delta 累加到对应的用量记录上
+func AddApiKeyUsage(ctx context.Context, delta *ApiKeyUsage) error {
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "api_key_id"}, {Name: "day"}},
+		DoUpdates: clause.Assignments(map[string]any{
+			"requests":     gorm.Expr("requests + ?", delta.Requests),
+			"searches":     gorm.Expr("searches + ?", delta.Searches),
+			"stream_bytes": gorm.Expr("stream_bytes + ?", delta.StreamBytes),
+		}),
+	}).Create(delta).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetUpdatesState 获取某个用户账号的 updates state，如果不存在则创建一个默认的
+func GetUpdatesState(ctx context.Context, accountID int64) (*UpdatesState, error) {
 	var state UpdatesState
-	if err := db.WithContext(ctx).First(&state).Error; err != nil {
+	if err := db.WithContext(ctx).Where("account_id = ?", accountID).First(&state).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			// 创建默认状态
-			state = UpdatesState{ID: 1, Pts: 0, Qts: 0, Date: 0, Seq: 0}
+			state = UpdatesState{AccountID: accountID}
 			if err := db.WithContext(ctx).Create(&state).Error; err != nil {
 				return nil, err
 			}
@@ -195,15 +247,67 @@ func GetUpdatesState(ctx context.Context) (*UpdatesState, error) {
 
 // UpdateUpdatesState 更新 updates state
 func UpdateUpdatesState(ctx context.Context, state *UpdatesState) error {
-	if state.ID == 0 {
-		state.ID = 1
-	}
 	if err := db.WithContext(ctx).Save(state).Error; err != nil {
 		return err
 	}
 	return nil
 }
 
+// ClaimLegacyUpdatesState 将旧版本 (单账号) 遗留的 updates state 归属到指定账号
+func ClaimLegacyUpdatesState(ctx context.Context, accountID int64) error {
+	var count int64
+	if err := db.WithContext(ctx).Model(&UpdatesState{}).Where("account_id = ?", accountID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	if err := db.WithContext(ctx).Model(&UpdatesState{}).
+		Where("account_id IS NULL OR account_id = 0").
+		Update("account_id", accountID).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func UpsertUserAccount(ctx context.Context, account *UserAccount) error {
+	if err := db.WithContext(ctx).Save(account).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetUserAccount(ctx context.Context, userID int64) (*UserAccount, error) {
+	var account UserAccount
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func GetUserAccountByName(ctx context.Context, name string) (*UserAccount, error) {
+	var account UserAccount
+	if err := db.WithContext(ctx).Where("name = ?", name).First(&account).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+func GetAllUserAccounts(ctx context.Context) ([]*UserAccount, error) {
+	var accounts []*UserAccount
+	if err := db.WithContext(ctx).Order("is_primary DESC, user_id").Find(&accounts).Error; err != nil {
+		return nil, err
+	}
+	return accounts, nil
+}
+
+func DeleteUserAccount(ctx context.Context, userID int64) error {
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Delete(&UserAccount{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
 func UpdateChannelPts(ctx context.Context, chatID int64, pts int) error {
 	chat, err := GetIndexChat(ctx, chatID)
 	if err != nil {
@@ -215,3 +319,350 @@ func UpdateChannelPts(ctx context.Context, chatID int64, pts int) error {
 	}
 	return nil
 }
+
+func UpsertRole(ctx context.Context, role *Role) error {
+	if err := db.WithContext(ctx).Save(role).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetRole(ctx context.Context, name string) (*Role, error) {
+	var role Role
+	if err := db.WithContext(ctx).Where("name = ?", name).First(&role).Error; err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func GetAllRoles(ctx context.Context) ([]*Role, error) {
+	var roles []*Role
+	if err := db.WithContext(ctx).Order("name").Find(&roles).Error; err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func UpsertUserRole(ctx context.Context, userRole *UserRole) error {
+	if err := db.WithContext(ctx).Save(userRole).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func GetUserRole(ctx context.Context, userID int64) (*UserRole, error) {
+	var userRole UserRole
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).First(&userRole).Error; err != nil {
+		return nil, err
+	}
+	return &userRole, nil
+}
+
+func GetAllUserRoles(ctx context.Context) ([]*UserRole, error) {
+	var userRoles []*UserRole
+	if err := db.WithContext(ctx).Order("role, user_id").Find(&userRoles).Error; err != nil {
+		return nil, err
+	}
+	return userRoles, nil
+}
+
+func DeleteUserRole(ctx context.Context, userID int64) error {
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Delete(&UserRole{}).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+func CreateAuditLog(ctx context.Context, auditLog *AuditLog) error {
+	if err := db.WithContext(ctx).Create(auditLog).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// AuditLogQuery 审计日志的查询条件, 零值表示不过滤
+type AuditLogQuery struct {
+	Source  string
+	Action  string
+	ActorID int64
+	Since   time.Time
+	Until   time.Time
+	Limit   int
+	Offset  int
+}
+
+// QueryAuditLogs 按时间倒序查询审计日志, 同时返回符合条件的总数
+func QueryAuditLogs(ctx context.Context, query AuditLogQuery) ([]*AuditLog, int64, error) {
+	tx := db.WithContext(ctx).Model(&AuditLog{})
+	if query.Source != "" {
+		tx = tx.Where("source = ?", query.Source)
+	}
+	if query.Action != "" {
+		tx = tx.Where("action = ?", query.Action)
+	}
+	if query.ActorID != 0 {
+		tx = tx.Where("actor_id = ?", query.ActorID)
+	}
+	if !query.Since.IsZero() {
+		tx = tx.Where("created_at >= ?", query.Since)
+	}
+	if !query.Until.IsZero() {
+		tx = tx.Where("created_at < ?", query.Until)
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var logs []*AuditLog
+	if err := tx.Order("created_at DESC, id DESC").Limit(query.Limit).Offset(query.Offset).Find(&logs).Error; err != nil {
+		return nil, 0, err
+	}
+	return logs, total, nil
+}
+
+// DeleteAuditLogsBefore 删除早于 t 的审计日志, 返回删除的条数
+func DeleteAuditLogsBefore(ctx context.Context, t time.Time) (int64, error) {
+	result := db.WithContext(ctx).Where("created_at < ?", t).Delete(&AuditLog{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+func CreateSearchLog(ctx context.Context, searchLog *SearchLog) error {
+	if err := db.WithContext(ctx).Create(searchLog).Error; err != nil {
+		return err
+	}
+	return nil
+}
+
+// DeleteSearchLogsBefore 删除早于 t 的搜索记录, 返回删除的条数
+func DeleteSearchLogsBefore(ctx context.Context, t time.Time) (int64, error) {
+	result := db.WithContext(ctx).Where("created_at < ?", t).Delete(&SearchLog{})
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return result.RowsAffected, nil
+}
+
+// SearchSummary 一段时间内搜索的总体统计
+type SearchSummary struct {
+	Searches     int64   `json:"searches"`
+	ZeroResults  int64   `json:"zero_results"`
+	Requesters   int64   `json:"requesters"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// SearchQueryStat 按查询聚合的搜索统计
+type SearchQueryStat struct {
+	QueryHash    string  `json:"query_hash"`
+	Query        string  `json:"query,omitempty"`
+	Count        int64   `json:"count"`
+	Requesters   int64   `json:"requesters"`
+	AvgHits      float64 `json:"avg_hits"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// SearchChatStat 按聊天聚合的搜索统计, ChatID 为 0 表示搜索多个或全部聊天
+type SearchChatStat struct {
+	ChatID       int64   `json:"chat_id"`
+	Count        int64   `json:"count"`
+	ZeroResults  int64   `json:"zero_results"`
+	Requesters   int64   `json:"requesters"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+func GetSearchSummary(ctx context.Context, since time.Time) (*SearchSummary, error) {
+	var summary SearchSummary
+	err := db.WithContext(ctx).Model(&SearchLog{}).
+		Select("COUNT(*) AS searches, COALESCE(SUM(CASE WHEN hits = 0 THEN 1 ELSE 0 END), 0) AS zero_results, "+
+			"COUNT(DISTINCT requester) AS requesters, COALESCE(AVG(latency_ms), 0) AS avg_latency_ms").
+		Where("created_at >= ?", since).
+		Scan(&summary).Error
+	if err != nil {
+		return nil, err
+	}
+	return &summary, nil
+}
+
+// GetTopSearchQueries 返回 since 之后搜索次数最多的查询. zeroResults 为 true 时只统计没有结果的搜索
+func GetTopSearchQueries(ctx context.Context, since time.Time, zeroResults bool, limit int) ([]*SearchQueryStat, error) {
+	tx := db.WithContext(ctx).Model(&SearchLog{}).
+		Select("query_hash, MAX(query) AS query, COUNT(*) AS count, COUNT(DISTINCT requester) AS requesters, "+
+			"AVG(hits) AS avg_hits, AVG(latency_ms) AS avg_latency_ms").
+		Where("created_at >= ?", since)
+	if zeroResults {
+		tx = tx.Where("hits = 0")
+	}
+	var stats []*SearchQueryStat
+	if err := tx.Group("query_hash").Order("count DESC, query_hash").Limit(limit).Scan(&stats).Error; err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// GetSearchChatStats 返回 since 之后各聊天的搜索次数, 按次数倒序
+func GetSearchChatStats(ctx context.Context, since time.Time, limit int) ([]*SearchChatStat, error) {
+	var stats []*SearchChatStat
+	err := db.WithContext(ctx).Model(&SearchLog{}).
+		Select("chat_id, COUNT(*) AS count, SUM(CASE WHEN hits = 0 THEN 1 ELSE 0 END) AS zero_results, "+
+			"COUNT(DISTINCT requester) AS requesters, AVG(latency_ms) AS avg_latency_ms").
+		Where("created_at >= ?", since).
+		Group("chat_id").Order("count DESC, chat_id").Limit(limit).
+		Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+func CreateIngestTask(ctx context.Context, task *IngestTask) error {
+	return db.WithContext(ctx).Create(task).Error
+}
+
+// GetIngestTasks 按写入顺序获取待处理的任务, 跳过 excludeChats 中的聊天
+func GetIngestTasks(ctx context.Context, excludeChats []int64, limit int) ([]*IngestTask, error) {
+	tx := db.WithContext(ctx).Order("id")
+	if len(excludeChats) > 0 {
+		tx = tx.Where("chat_id NOT IN ?", excludeChats)
+	}
+	var tasks []*IngestTask
+	if err := tx.Limit(limit).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func DeleteIngestTasks(ctx context.Context, ids []uint) error {
+	return db.WithContext(ctx).Delete(&IngestTask{}, ids).Error
+}
+
+// MarkIngestTasksFailed 增加任务的尝试次数并记录最后一次的错误
+func MarkIngestTasksFailed(ctx context.Context, ids []uint, lastError string) error {
+	return db.WithContext(ctx).Model(&IngestTask{}).Where("id IN ?", ids).Updates(map[string]any{
+		"attempts":   gorm.Expr("attempts + 1"),
+		"last_error": lastError,
+	}).Error
+}
+
+// GetIngestTaskChats 返回有待处理任务的聊天
+func GetIngestTaskChats(ctx context.Context) ([]int64, error) {
+	var chatIDs []int64
+	err := db.WithContext(ctx).Model(&IngestTask{}).Distinct("chat_id").Pluck("chat_id", &chatIDs).Error
+	return chatIDs, err
+}
+
+func CountIngestTasks(ctx context.Context) (int64, error) {
+	var count int64
+	err := db.WithContext(ctx).Model(&IngestTask{}).Count(&count).Error
+	return count, err
+}
+
+// GetResumableReindexJob 获取相同参数的未完成的重建索引任务
+func GetResumableReindexJob(ctx context.Context, chatID int64, ocr bool, since int64) (*ReindexJob, error) {
+	var job ReindexJob
+	if err := db.WithContext(ctx).
+		Where("chat_id = ? AND ocr = ? AND since = ? AND status <> ?", chatID, ocr, since, "done").
+		Order("id DESC").First(&job).Error; err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+func SaveReindexJob(ctx context.Context, job *ReindexJob) error {
+	return db.WithContext(ctx).Save(job).Error
+}
+
+func GetIndexRule(ctx context.Context, chatID int64) (*IndexRule, error) {
+	var rule IndexRule
+	if err := db.WithContext(ctx).Where("chat_id = ?", chatID).First(&rule).Error; err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+func UpsertIndexRule(ctx context.Context, rule *IndexRule) error {
+	defer indexRulesVersion.Add(1)
+	return db.WithContext(ctx).Save(rule).Error
+}
+
+func DeleteIndexRule(ctx context.Context, chatID int64) error {
+	defer indexRulesVersion.Add(1)
+	return db.WithContext(ctx).Where("chat_id = ?", chatID).Delete(&IndexRule{}).Error
+}
+
+func GetRetentionPolicy(ctx context.Context, chatID int64) (*RetentionPolicy, error) {
+	var policy RetentionPolicy
+	if err := db.WithContext(ctx).Where("chat_id = ?", chatID).First(&policy).Error; err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+func UpsertRetentionPolicy(ctx context.Context, policy *RetentionPolicy) error {
+	return db.WithContext(ctx).Save(policy).Error
+}
+
+func DeleteRetentionPolicy(ctx context.Context, chatID int64) error {
+	return db.WithContext(ctx).Where("chat_id = ?", chatID).Delete(&RetentionPolicy{}).Error
+}
+
+func GetAllNeverIndexUsers(ctx context.Context) ([]*NeverIndexUser, error) {
+	var users []*NeverIndexUser
+	if err := db.WithContext(ctx).Order("created_at").Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func AddNeverIndexUser(ctx context.Context, userID int64) error {
+	if err := db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&NeverIndexUser{UserID: userID}).Error; err != nil {
+		return err
+	}
+	setNeverIndex(userID, true)
+	return nil
+}
+
+func RemoveNeverIndexUser(ctx context.Context, userID int64) error {
+	if err := db.WithContext(ctx).Where("user_id = ?", userID).Delete(&NeverIndexUser{}).Error; err != nil {
+		return err
+	}
+	setNeverIndex(userID, false)
+	return nil
+}
+
+// CreateStreamNonce 保存一次性链接的 nonce, 并顺便清理已过期的 nonce
+func CreateStreamNonce(ctx context.Context, nonce string, expiresAt time.Time) error {
+	if err := db.WithContext(ctx).Where("expires_at < ?", time.Now()).Delete(&StreamNonce{}).Error; err != nil {
+		return err
+	}
+	return db.WithContext(ctx).Create(&StreamNonce{Nonce: nonce, ExpiresAt: expiresAt}).Error
+}
+
+// UseStreamNonce 使用一次性链接的 nonce. 未使用的 nonce 会绑定到 client,
+// 已绑定的 nonce 只有同一个 client 可以继续使用. nonce 不存在或已过期时返回 gorm.ErrRecordNotFound
+func UseStreamNonce(ctx context.Context, nonce, client string) (bool, error) {
+	now := time.Now()
+	// 条件更新保证并发的第一次访问中只有一个能绑定
+	if err := db.WithContext(ctx).Model(&StreamNonce{}).
+		Where("nonce = ? AND used_by = '' AND expires_at > ?", nonce, now).
+		Updates(map[string]any{"used_by": client, "used_at": now}).Error; err != nil {
+		return false, err
+	}
+	var record StreamNonce
+	if err := db.WithContext(ctx).Where("nonce = ? AND expires_at > ?", nonce, now).First(&record).Error; err != nil {
+		return false, err
+	}
+	return record.UsedBy == client, nil
+}
+
+// DeleteUserData 删除用户的 UserInfo 和其在所有聊天中的成员记录
+func DeleteUserData(ctx context.Context, userID int64) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("DELETE FROM index_chat_members WHERE user_chat_id = ?", userID).Error; err != nil {
+			return err
+		}
+		return tx.Where("chat_id = ?", userID).Delete(&UserInfo{}).Error
+	})
+}
@@ -2,6 +2,7 @@ package database
 
 import (
 	"context"
+	"errors"
 
 	"github.com/charmbracelet/log"
 	"github.com/ncruces/go-sqlite3/gormlite"
@@ -24,7 +25,10 @@ func InitDatabase(ctx context.Context) error {
 		return err
 	}
 	db = openDb
-	if err := db.AutoMigrate(&UserInfo{}, &IndexChat{}, &SubBot{}, &ApiKey{}, &UpdatesState{}); err != nil {
+	if err := db.AutoMigrate(&UserInfo{}, &IndexChat{}, &SubBot{}, &ApiKey{}, &UpdatesState{}, &UserAccount{}, &Role{}, &UserRole{}, &AuditLog{}, &ApiKeyUsage{}, &SearchLog{}, &IngestTask{}, &ReindexJob{}, &IndexRule{}, &RetentionPolicy{}, &NeverIndexUser{}, &StreamNonce{}); err != nil {
+		return err
+	}
+	if err := initDefaultRoles(ctx); err != nil {
 		return err
 	}
 	chats, err := GetAllIndexChats(ctx)
@@ -36,6 +40,49 @@ func InitDatabase(ctx context.Context) error {
 			watchedChatsID[chat.ChatID] = struct{}{}
 		}
 		allChatIDs = append(allChatIDs, chat.ChatID)
+		chatOwners[chat.ChatID] = chat.OwnerID
+	}
+	neverIndex, err := GetAllNeverIndexUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, user := range neverIndex {
+		neverIndexUsers[user.UserID] = struct{}{}
+	}
+	return nil
+}
+
+// Ping 检查数据库是否可用
+func Ping(ctx context.Context) error {
+	if db == nil {
+		return errors.New("database is not initialized")
+	}
+	return db.WithContext(ctx).Exec("SELECT 1").Error
+}
+
+const (
+	RoleOwner    = "owner"
+	RoleAdmin    = "admin"
+	RoleIndexer  = "indexer"
+	RoleSearcher = "searcher"
+)
+
+// 内置角色, 仅在数据库中不存在时创建, 之后可以通过 /roles 修改
+var defaultRoles = []Role{
+	{Name: RoleOwner, Commands: []string{"*"}, AllChats: true},
+	{Name: RoleAdmin, Commands: []string{"*"}, AllChats: true},
+	{Name: RoleIndexer, Commands: []string{
+		"start", "help", "search", "ls", "add", "del", "watch", "unwatch",
+		"watchdel", "unwatchdel", "ocrable", "unocrable", "dl", "syncpeers", "reindex", "rules",
+	}, AllChats: true},
+	{Name: RoleSearcher, Commands: []string{"start", "help", "search", "ls"}},
+}
+
+func initDefaultRoles(ctx context.Context) error {
+	for _, role := range defaultRoles {
+		if err := db.WithContext(ctx).Where(Role{Name: role.Name}).FirstOrCreate(&role).Error; err != nil {
+			return err
+		}
 	}
 	return nil
 }
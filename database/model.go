@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"slices"
+	"time"
 
 	"github.com/charmbracelet/log"
 	"gorm.io/gorm"
@@ -45,17 +46,53 @@ type IndexChat struct {
 	NoOcr    bool   `json:"no_ocr"`
 	Public   bool   `gorm:"default:false" json:"public"`
 	Pts      int    `gorm:"default:0" json:"pts"` // Channel message box sequence for updates
+	// 负责监听和读取该聊天的用户账号 ID, 0 表示主账号
+	OwnerID int64 `gorm:"default:0;index" json:"owner_id"`
 
 	Members []UserInfo `gorm:"many2many:index_chat_members;constraint:OnDelete:CASCADE;joinForeignKey:IndexChatID;joinReferences:UserChatID" json:"members"`
 }
 
 // UpdatesState 存储 Telegram updates 的状态信息，用于断线重连时获取错过的消息
+// 每个用户账号各有一条记录
 type UpdatesState struct {
-	ID   uint `gorm:"primaryKey"`
-	Pts  int  `json:"pts"`  // Common message box sequence (private chats, basic groups)
-	Qts  int  `json:"qts"`  // Secondary event sequence (secret chats, certain bot events)
-	Date int  `json:"date"` // Unix timestamp
-	Seq  int  `json:"seq"`  // Updates sequence number
+	ID        uint  `gorm:"primaryKey"`
+	AccountID int64 `gorm:"uniqueIndex" json:"account_id"` // 所属用户账号的 Telegram ID
+	Pts       int   `json:"pts"`                           // Common message box sequence (private chats, basic groups)
+	Qts       int   `json:"qts"`                           // Secondary event sequence (secret chats, certain bot events)
+	Date      int   `json:"date"`                          // Unix timestamp
+	Seq       int   `json:"seq"`                           // Updates sequence number
+}
+
+// UserAccount 表示一个用于索引的 Telegram 用户账号
+// 主账号使用 data/session_user.db, 其他账号使用 data/session_user_<name>.db
+type UserAccount struct {
+	UserID    int64  `gorm:"primaryKey" json:"user_id"`
+	Name      string `gorm:"uniqueIndex" json:"name"`
+	Username  string `json:"username"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	IsPrimary bool   `json:"is_primary"`
+}
+
+// Role 表示 bot 的一个角色
+// Commands 为该角色可以使用的 bot 命令, "*" 表示全部命令
+type Role struct {
+	Name     string   `gorm:"primaryKey" json:"name"`
+	Commands []string `gorm:"serializer:json;type:json" json:"commands"`
+	// 是否可以搜索和管理所有聊天, 否则只能搜索公开聊天和授权时指定的聊天, 只能管理授权时指定的聊天
+	AllChats bool `json:"all_chats"`
+}
+
+func (r *Role) HasCommand(cmd string) bool {
+	return slices.Contains(r.Commands, "*") || slices.Contains(r.Commands, cmd)
+}
+
+// UserRole 表示授予某个用户的角色
+type UserRole struct {
+	UserID int64  `gorm:"primaryKey" json:"user_id"`
+	Role   string `gorm:"index" json:"role"`
+	// 允许搜索和管理的聊天, 与 SubBot.ChatIDs 类似. 不为空时即使角色可以访问所有聊天, 也只能访问这些聊天 (和搜索公开聊天)
+	ChatIDs []int64 `gorm:"serializer:json;type:json" json:"chat_ids"`
 }
 
 type SubBot struct {
@@ -72,6 +109,70 @@ type ApiKey struct {
 	Name    string      `json:"name"`
 	KeyHash string      `gorm:"uniqueIndex;size:64" json:"-"`
 	Chats   []IndexChat `gorm:"many2many:api_key_chats;constraint:OnDelete:CASCADE;joinForeignKey:ApiKeyID;joinReferences:IndexChatID" json:"chats"`
+	// 每分钟请求数限制, 0 表示不限制
+	RateLimit int `json:"rate_limit"`
+	// 每日搜索次数配额, 0 表示不限制
+	DailySearchQuota int `json:"daily_search_quota"`
+	// 每日文件流量配额 (字节), 0 表示不限制
+	DailyStreamBytes int64 `json:"daily_stream_bytes"`
+	CreatedAt        time.Time `json:"created_at"`
+	// 创建者的 telegram 用户 ID
+	CreatedBy int64 `json:"created_by"`
+	// 过期时间, nil 表示永不过期
+	ExpiresAt *time.Time `json:"expires_at"`
+	Disabled  bool       `json:"disabled"`
+	// 最后一次使用的时间和 IP
+	LastUsedAt *time.Time `json:"last_used_at"`
+	LastUsedIP string     `json:"last_used_ip"`
+	// 允许的操作, 为空时使用 DefaultApiKeyOperations
+	Operations []string `gorm:"serializer:json" json:"operations"`
+}
+
+// 子 API key 可被授予的操作
+const (
+	ApiKeyOpSearch     = "search"
+	ApiKeyOpFetch      = "fetch"
+	ApiKeyOpFileStream = "filestream"
+	// 以用户账号在允许的聊天中回复消息
+	ApiKeyOpReply = "reply"
+	// 在允许的聊天之间转发消息
+	ApiKeyOpForward = "forward"
+)
+
+var ApiKeyOperations = []string{ApiKeyOpSearch, ApiKeyOpFetch, ApiKeyOpFileStream, ApiKeyOpReply, ApiKeyOpForward}
+
+// 未设置 Operations 的 key (包括旧版本创建的 key) 允许的操作
+var DefaultApiKeyOperations = []string{ApiKeyOpSearch, ApiKeyOpFetch, ApiKeyOpFileStream}
+
+// AllowedOperations 返回该 key 允许的操作
+func (a *ApiKey) AllowedOperations() []string {
+	if len(a.Operations) == 0 {
+		return DefaultApiKeyOperations
+	}
+	return a.Operations
+}
+
+func (a *ApiKey) HasOperation(op string) bool {
+	return slices.Contains(a.AllowedOperations(), op)
+}
+
+// Expired 返回该 key 在 t 时是否已过期
+func (a *ApiKey) Expired(t time.Time) bool {
+	return a.ExpiresAt != nil && !t.Before(*a.ExpiresAt)
+}
+
+// ApiKeyUsage 记录子 API key 每日 (UTC) 的用量
+type ApiKeyUsage struct {
+	ApiKeyID    uint   `gorm:"primaryKey" json:"api_key_id"`
+	Day         string `gorm:"primaryKey;size:10" json:"day"`
+	Requests    int64  `json:"requests"`
+	Searches    int64  `json:"searches"`
+	StreamBytes int64  `json:"stream_bytes"`
+}
+
+// UsageDay 返回 t 所在的用量统计日期
+func UsageDay(t time.Time) string {
+	return t.UTC().Format(time.DateOnly)
 }
 
 // ChatIDs 返回当前 ApiKey 可访问的聊天 ID 列表
@@ -117,6 +218,7 @@ func (ic *IndexChat) AfterSave(tx *gorm.DB) error {
 		log.FromContext(tx.Statement.Context).Warnf("AfterSave IndexChat: chat_id is 0")
 		return nil
 	}
+	setChatOwner(ic.ChatID, ic.OwnerID)
 	watchedChatsIDMu.Lock()
 	defer watchedChatsIDMu.Unlock()
 	if ic.Watching {
@@ -137,6 +239,7 @@ func (ic *IndexChat) BeforeDelete(tx *gorm.DB) error {
 		log.FromContext(tx.Statement.Context).Warnf("BeforeDelete IndexChat: chat_id is 0")
 		return nil
 	}
+	deleteChatOwner(ic.ChatID)
 	watchedChatsIDMu.Lock()
 	defer watchedChatsIDMu.Unlock()
 	delete(watchedChatsID, ic.ChatID)
@@ -147,3 +250,118 @@ func (ic *IndexChat) BeforeDelete(tx *gorm.DB) error {
 	}
 	return nil
 }
+
+// AuditLog 记录一次管理操作 (bot 管理命令或 master key 的 API 调用)
+type AuditLog struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// "bot" 或 "api"
+	Source string `gorm:"index" json:"source"`
+	// 执行者的 telegram 用户 ID, API 调用时为 0
+	ActorID int64 `gorm:"index" json:"actor_id"`
+	// 执行者的显示名称, API 调用时为请求 IP
+	Actor  string `json:"actor"`
+	Action string `gorm:"index" json:"action"`
+	Target string `json:"target"`
+	// 已脱敏的参数
+	Args   string `json:"args"`
+	Result string `gorm:"index" json:"result"`
+	Error  string `json:"error,omitempty"`
+}
+
+// SearchLog 记录一次搜索, 用于搜索分析. 翻页和筛选回调不记录
+type SearchLog struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `gorm:"index" json:"created_at"`
+	// "bot", "subbot", "inline" 或 "api"
+	Source string `gorm:"index" json:"source"`
+	// 搜索者, "user:<id>", "apikey:<id>" 或 "apikey:master". 开启用户哈希时 user 的 ID 为哈希值
+	Requester string `gorm:"index" json:"requester"`
+	// 规范化后的查询, 开启查询哈希时为空
+	Query string `json:"query,omitempty"`
+	// 规范化后查询的哈希, 用于聚合相同的查询
+	QueryHash string `gorm:"index;size:64" json:"query_hash"`
+	// 类型、用户等过滤条件 (JSON), 没有时为空
+	Filters string `json:"filters,omitempty"`
+	// 搜索的聊天, 搜索多个或全部聊天时为 0
+	ChatID int64 `gorm:"index" json:"chat_id"`
+	// 搜索的聊天数量, 0 表示全部聊天
+	ChatCount int   `json:"chat_count"`
+	Hits      int64 `json:"hits"`
+	LatencyMs int64 `json:"latency_ms"`
+}
+
+// IngestTask 是持久化的待写入搜索引擎的操作, 写入成功后删除
+type IngestTask struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	ChatID    int64     `gorm:"index" json:"chat_id"`
+	// "add", "delete", "ocr" 或 "delete_user"
+	Op string `json:"op"`
+	// add 时为文档列表的 JSON, delete 时为消息 ID 列表的 JSON, ocr 时为消息 ID 到 OCR 文本的 JSON, delete_user 时为用户 ID
+	Payload   []byte `json:"-"`
+	Attempts  int    `json:"attempts"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// ReindexJob 记录重建索引任务的进度, 中断后使用相同参数重新运行时从 LastID 继续
+type ReindexJob struct {
+	ID        uint      `gorm:"primaryKey;autoIncrement" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ChatID    int64     `gorm:"index" json:"chat_id"`
+	Ocr       bool      `json:"ocr"`
+	// 只处理此时间 (unix 秒) 之后的消息, 0 表示整个聊天
+	Since int64 `json:"since"`
+	// 已处理到的消息 ID, 从新到旧遍历, 0 表示尚未开始
+	LastID    int    `json:"last_id"`
+	Processed int    `json:"processed"`
+	Indexed   int    `json:"indexed"`
+	Ocred     int    `json:"ocred"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+}
+
+// IndexRule 是聊天的索引规则, 不符合规则的新消息不会被索引. 没有规则的聊天索引所有消息
+type IndexRule struct {
+	ChatID    int64     `gorm:"primaryKey" json:"chat_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// 不索引这些发送者的消息
+	IgnoreUsers []int64 `gorm:"serializer:json" json:"ignore_users"`
+	// 不索引 bot 发送或通过 inline bot 发送的消息
+	IgnoreBots bool `json:"ignore_bots"`
+	// 只索引这些类型的消息, 值与 types.MessageTypeToString 相同, 为空表示全部类型
+	Types []string `gorm:"serializer:json" json:"types"`
+	// 文本 (包括文件名等媒体信息) 的最小字符数, 0 表示不限制. 相册成员使用相册的说明文字
+	MinLength int `json:"min_length"`
+	// 只索引文本匹配该正则的消息, 为空表示不限制
+	Include string `json:"include"`
+	// 不索引文本匹配该正则的消息
+	Exclude string `json:"exclude"`
+}
+
+// RetentionPolicy 是聊天的消息保留策略, 覆盖配置文件中的 [retention] 默认值
+type RetentionPolicy struct {
+	ChatID    int64     `gorm:"primaryKey" json:"chat_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// 消息保留天数, 0 表示永久保留
+	MaxAgeDays int `json:"max_age_days"`
+	// 最多保留的文档数, 0 表示不限制
+	MaxDocuments int64 `json:"max_documents"`
+}
+
+// StreamNonce 是一次性文件流链接的 nonce.
+// 第一次访问时绑定到访问者的 IP, 之后只有该 IP 可以在过期前继续访问 (如播放器的 Range 请求)
+type StreamNonce struct {
+	Nonce     string    `gorm:"primaryKey" json:"nonce"`
+	ExpiresAt time.Time `gorm:"index" json:"expires_at"`
+	// 第一次访问者的 IP, 为空表示尚未使用
+	UsedBy string     `json:"used_by"`
+	UsedAt *time.Time `json:"used_at"`
+}
+
+// NeverIndexUser 是永不索引的用户, 通常来自 /forget. 这些用户的消息和用户信息都不会再被记录
+type NeverIndexUser struct {
+	UserID    int64     `gorm:"primaryKey" json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+}
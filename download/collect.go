@@ -0,0 +1,161 @@
+package download
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/types"
+	"github.com/krau/btts/userclient"
+)
+
+// maxSearchResults 是下载搜索结果时最多处理的命中数
+const maxSearchResults = 1000
+
+// mediaTypes 是可以下载的消息类型
+var mediaTypes = []types.MessageType{
+	types.MessageTypePhoto,
+	types.MessageTypeVideo,
+	types.MessageTypeDocument,
+	types.MessageTypeVoice,
+	types.MessageTypeAudio,
+}
+
+// item 是一条待下载的消息, Message 为空时由 worker 获取
+type item struct {
+	ChatID    int64
+	MessageID int
+	Message   *tg.Message
+}
+
+// collect 将需要下载的消息发送到 emit, emit 返回 false 时停止
+func collect(ctx context.Context, req Request, emit func(item) bool) error {
+	if req.Search != nil {
+		return collectSearch(ctx, *req.Search, emit)
+	}
+	return collectHistory(ctx, req.ChatID, req.StartID, req.EndID, emit)
+}
+
+// collectHistory 从新到旧遍历聊天历史中 [startID, endID] 范围内带有媒体的消息
+func collectHistory(ctx context.Context, chatID int64, startID, endID int, emit func(item) bool) error {
+	account := userclient.GetUserClientForChat(chatID)
+	inputPeer := account.TClient.PeerStorage.GetInputPeerById(chatID)
+	if inputPeer == nil {
+		return fmt.Errorf("failed to get input peer of chat %d", chatID)
+	}
+	offsetID := 0
+	if endID > 0 {
+		offsetID = endID + 1
+	}
+	for {
+		res, err := account.TClient.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:     inputPeer,
+			OffsetID: offsetID,
+			Limit:    100,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get messages: %w", err)
+		}
+		var msgClass []tg.MessageClass
+		switch msgs := res.(type) {
+		case *tg.MessagesMessages:
+			msgClass = msgs.GetMessages()
+		case *tg.MessagesMessagesSlice:
+			msgClass = msgs.GetMessages()
+		case *tg.MessagesChannelMessages:
+			msgClass = msgs.GetMessages()
+		default:
+			return fmt.Errorf("unsupported messages type: %T", res)
+		}
+		if len(msgClass) == 0 {
+			return nil
+		}
+		for _, m := range msgClass {
+			if m.GetID() < startID {
+				return nil
+			}
+			offsetID = m.GetID()
+			msg, ok := m.(*tg.Message)
+			if !ok || !hasMedia(msg.Media) {
+				continue
+			}
+			if !emit(item{ChatID: chatID, MessageID: msg.ID, Message: msg}) {
+				return nil
+			}
+		}
+	}
+}
+
+// collectSearch 遍历搜索结果中的媒体消息, 折叠的相册会展开为全部成员
+func collectSearch(ctx context.Context, req types.SearchRequest, emit func(item) bool) error {
+	limit := req.Limit
+	if limit <= 0 || limit > maxSearchResults {
+		limit = maxSearchResults
+	}
+	if len(req.TypeFilters) == 0 {
+		req.TypeFilters = mediaTypes
+	}
+	req.Limit = 100
+	searcher := engine.GetEngine()
+	var count int64
+	for count < limit {
+		resp, err := searcher.Search(ctx, req)
+		if err != nil {
+			return fmt.Errorf("failed to search: %w", err)
+		}
+		if len(resp.Hits) == 0 {
+			return nil
+		}
+		for _, hit := range resp.Hits {
+			docs := []*types.MessageDocument{&hit.MessageDocument}
+			if hit.GroupedID != 0 && hit.AlbumSize > 1 {
+				album, err := searcher.GetAlbumDocuments(ctx, hit.ChatID, hit.GroupedID)
+				if err == nil && len(album) > 0 {
+					docs = album
+				}
+			}
+			for _, doc := range docs {
+				if !emit(item{ChatID: doc.ChatID, MessageID: int(doc.ID)}) {
+					return nil
+				}
+			}
+			count++
+			if count >= limit {
+				return nil
+			}
+		}
+		req.Offset += int64(len(resp.Hits))
+		if resp.EstimatedTotalHits > 0 && req.Offset >= resp.EstimatedTotalHits {
+			return nil
+		}
+	}
+	return nil
+}
+
+func hasMedia(media tg.MessageMediaClass) bool {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		_, ok := m.Photo.(*tg.Photo)
+		return ok
+	case *tg.MessageMediaDocument:
+		_, ok := m.Document.(*tg.Document)
+		return ok
+	}
+	return false
+}
+
+// mediaKey 返回用于去重的媒体标识, 同一文件被多次转发时标识相同
+func mediaKey(media tg.MessageMediaClass) string {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		if photo, ok := m.Photo.(*tg.Photo); ok {
+			return fmt.Sprintf("photo:%d", photo.ID)
+		}
+	case *tg.MessageMediaDocument:
+		if doc, ok := m.Document.(*tg.Document); ok {
+			return fmt.Sprintf("document:%d", doc.ID)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,220 @@
+package download
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/google/uuid"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/types"
+)
+
+type Status string
+
+const (
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+// Request 描述一个下载任务.
+// Search 不为空时下载搜索结果中的媒体, 否则下载 ChatID 中 [StartID, EndID] 范围内的媒体, 范围为 0 表示不限制
+type Request struct {
+	ChatID  int64                `json:"chat_id,omitempty"`
+	StartID int                  `json:"start_id,omitempty"`
+	EndID   int                  `json:"end_id,omitempty"`
+	Search  *types.SearchRequest `json:"search,omitempty"`
+	// 保存目录, 为空时使用配置中的下载目录. 只供命令行等本地调用方使用, 不通过 api 接收或返回
+	Dir string `json:"-"`
+	// 并发下载数, 为 0 时使用配置中的并发数
+	Workers int `json:"workers,omitempty"`
+	// 完成后将本任务的文件打包为 ZIP
+	Zip bool `json:"zip,omitempty"`
+}
+
+func (r *Request) normalize() error {
+	if r.Search == nil && r.ChatID == 0 {
+		return errors.New("chat_id or search is required")
+	}
+	if r.StartID < 0 || r.EndID < 0 || (r.EndID > 0 && r.StartID > r.EndID) {
+		return fmt.Errorf("invalid message range %d-%d", r.StartID, r.EndID)
+	}
+	if r.Dir == "" {
+		r.Dir = config.C().Download.Dir
+	}
+	dir, err := filepath.Abs(r.Dir)
+	if err != nil {
+		return fmt.Errorf("invalid directory %q: %w", r.Dir, err)
+	}
+	r.Dir = dir
+	if r.Workers <= 0 {
+		r.Workers = config.C().Download.Workers
+	}
+	r.Workers = min(max(r.Workers, 1), 16)
+	return nil
+}
+
+// Progress 是下载任务的状态快照
+type Progress struct {
+	ID         string     `json:"id"`
+	Request    Request    `json:"request"`
+	Status     Status     `json:"status"`
+	Total      int        `json:"total"`   // 已发现的媒体消息数
+	Done       int        `json:"done"`    // 本次下载完成的文件数
+	Skipped    int        `json:"skipped"` // 已下载过或与其他消息重复的文件数
+	Failed     int        `json:"failed"`
+	Bytes      int64      `json:"bytes"` // 本次下载的字节数
+	Error      string     `json:"error,omitempty"`
+	ZipPath    string     `json:"-"`                  // ZIP 文件的绝对路径, 不通过 api 返回
+	ZipFile    string     `json:"zip_file,omitempty"` // ZIP 文件名, 位于保存目录中
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type Job struct {
+	mu       sync.Mutex
+	progress Progress
+	files    map[messageRef]string // 本任务涉及的消息及其文件 (相对于下载目录)
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Progress 返回任务当前状态的副本
+func (j *Job) Progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// Wait 等待任务结束
+func (j *Job) Wait() Progress {
+	<-j.done
+	return j.Progress()
+}
+
+// Cancel 取消任务, 已下载的部分会保留, 使用相同参数重新下载时会继续
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+func (j *Job) update(fn func(p *Progress)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	fn(&j.progress)
+}
+
+type messageRef struct {
+	ChatID    int64
+	MessageID int
+}
+
+func (j *Job) addFile(ref messageRef, rel string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.files[ref] = rel
+}
+
+// File 返回消息对应的文件相对于下载目录的路径, 重复的文件会指向第一次下载的位置
+func (j *Job) File(chatID int64, messageID int) (string, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	rel, ok := j.files[messageRef{chatID, messageID}]
+	return rel, ok
+}
+
+var (
+	jobs   = make(map[string]*Job)
+	jobsMu sync.RWMutex
+)
+
+// Start 在后台开始一个下载任务. 任务不会随 ctx 取消, 需要调用 Job.Cancel
+func Start(ctx context.Context, req Request) (*Job, error) {
+	if err := req.normalize(); err != nil {
+		return nil, err
+	}
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &Job{
+		progress: Progress{
+			ID:        uuid.NewString()[:8],
+			Request:   req,
+			Status:    StatusRunning,
+			CreatedAt: time.Now(),
+		},
+		files:  make(map[messageRef]string),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	jobsMu.Lock()
+	jobs[job.progress.ID] = job
+	jobsMu.Unlock()
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		job.run(jobCtx)
+	}()
+	return job, nil
+}
+
+// GetJob 根据 ID 获取下载任务
+func GetJob(id string) (*Job, bool) {
+	jobsMu.RLock()
+	defer jobsMu.RUnlock()
+	job, ok := jobs[id]
+	return job, ok
+}
+
+// ListJobs 返回所有下载任务的状态, 最新的在前
+func ListJobs() []Progress {
+	jobsMu.RLock()
+	res := make([]Progress, 0, len(jobs))
+	for _, job := range jobs {
+		res = append(res, job.Progress())
+	}
+	jobsMu.RUnlock()
+	slices.SortFunc(res, func(a, b Progress) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return res
+}
+
+func (j *Job) run(ctx context.Context) {
+	req := j.progress.Request
+	logger := log.FromContext(ctx).With("download_job", j.progress.ID)
+	logger.Info("Download job started", "chat_id", req.ChatID, "dir", req.Dir)
+
+	err := j.download(ctx, req)
+	if err == nil && req.Zip && ctx.Err() == nil {
+		var zipPath string
+		zipPath, err = j.pack(req.Dir)
+		j.update(func(p *Progress) {
+			p.ZipPath = zipPath
+			if zipPath != "" {
+				p.ZipFile = filepath.Base(zipPath)
+			}
+		})
+	}
+
+	now := time.Now()
+	j.update(func(p *Progress) {
+		p.FinishedAt = &now
+		switch {
+		case ctx.Err() != nil:
+			p.Status = StatusCanceled
+		case err != nil:
+			p.Status = StatusFailed
+			p.Error = err.Error()
+		default:
+			p.Status = StatusDone
+		}
+	})
+	p := j.Progress()
+	logger.Info("Download job finished", "status", p.Status, "done", p.Done, "skipped", p.Skipped, "failed", p.Failed, "bytes", p.Bytes, "error", p.Error)
+}
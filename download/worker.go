@@ -0,0 +1,324 @@
+package download
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/userclient"
+	"github.com/krau/btts/utils"
+)
+
+const manifestName = ".btts-download.json"
+
+// manifest 记录下载目录中已完成的文件, 用于按文件 ID 去重和中断后继续
+type manifest struct {
+	path     string
+	mu       sync.Mutex
+	Files    map[string]string `json:"files"` // media key -> 相对路径
+	inflight map[string]chan struct{}
+}
+
+var (
+	manifests   = make(map[string]*manifest)
+	manifestsMu sync.Mutex
+)
+
+// loadManifest 返回下载目录的 manifest, 同一目录的多个任务共享同一个实例
+func loadManifest(dir string) (*manifest, error) {
+	manifestsMu.Lock()
+	defer manifestsMu.Unlock()
+	if m, ok := manifests[dir]; ok {
+		return m, nil
+	}
+	m := &manifest{
+		path:     filepath.Join(dir, manifestName),
+		Files:    make(map[string]string),
+		inflight: make(map[string]chan struct{}),
+	}
+	data, err := os.ReadFile(m.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, m); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", m.path, err)
+		}
+		if m.Files == nil {
+			m.Files = make(map[string]string)
+		}
+	}
+	manifests[dir] = m
+	return m, nil
+}
+
+// claim 返回已下载的同一文件的相对路径. 没有时将 key 标记为下载中并返回 claimed,
+// 调用方下载结束后必须调用 finish; 其他 worker 会等待该文件下载结束
+func (m *manifest) claim(ctx context.Context, key string) (existing string, claimed bool, err error) {
+	dir := filepath.Dir(m.path)
+	for {
+		m.mu.Lock()
+		if rel, ok := m.Files[key]; ok {
+			if _, err := os.Stat(filepath.Join(dir, rel)); err == nil {
+				m.mu.Unlock()
+				return rel, false, nil
+			}
+			delete(m.Files, key)
+		}
+		ch, ok := m.inflight[key]
+		if !ok {
+			m.inflight[key] = make(chan struct{})
+			m.mu.Unlock()
+			return "", true, nil
+		}
+		m.mu.Unlock()
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return "", false, ctx.Err()
+		}
+	}
+}
+
+func (m *manifest) finish(key, rel string, ok bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if ch, exists := m.inflight[key]; exists {
+		close(ch)
+		delete(m.inflight, key)
+	}
+	if !ok {
+		return nil
+	}
+	m.Files[key] = rel
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmpPath := m.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, m.path)
+}
+
+func (j *Job) download(ctx context.Context, req Request) error {
+	if err := os.MkdirAll(req.Dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	mf, err := loadManifest(req.Dir)
+	if err != nil {
+		return err
+	}
+
+	items := make(chan item, req.Workers*2)
+	var collectErr error
+	go func() {
+		defer close(items)
+		collectErr = collect(ctx, req, func(it item) bool {
+			j.update(func(p *Progress) { p.Total++ })
+			select {
+			case items <- it:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		})
+	}()
+
+	var wg sync.WaitGroup
+	for range req.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range items {
+				if ctx.Err() != nil {
+					continue
+				}
+				j.process(ctx, mf, req.Dir, it)
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return collectErr
+}
+
+func (j *Job) process(ctx context.Context, mf *manifest, dir string, it item) {
+	logger := log.FromContext(ctx).With("chat_id", it.ChatID, "message_id", it.MessageID)
+	ectx := userclient.GetUserClientForChat(it.ChatID).GetContext()
+	msg := it.Message
+	if msg == nil {
+		var err error
+		msg, err = utils.GetMessageByID(ectx, it.ChatID, it.MessageID)
+		if err != nil {
+			logger.Warn("Failed to get message", "error", err)
+			j.update(func(p *Progress) { p.Failed++ })
+			return
+		}
+	}
+	key := mediaKey(msg.Media)
+	if key == "" {
+		j.update(func(p *Progress) { p.Skipped++ })
+		return
+	}
+	existing, claimed, err := mf.claim(ctx, key)
+	if err != nil {
+		return
+	}
+	if !claimed {
+		j.addFile(messageRef{it.ChatID, msg.ID}, existing)
+		j.update(func(p *Progress) { p.Skipped++ })
+		return
+	}
+
+	file, err := utils.FileFromMedia(msg.Media, ectx.Raw)
+	if err != nil {
+		mf.finish(key, "", false)
+		logger.Warn("Failed to get file from media", "error", err)
+		j.update(func(p *Progress) { p.Failed++ })
+		return
+	}
+	rel := filepath.Join(strconv.FormatInt(it.ChatID, 10), fmt.Sprintf("%d_%s", msg.ID, sanitizeFileName(file.Name())))
+	n, err := downloadFile(ctx, file, filepath.Join(dir, rel))
+	j.update(func(p *Progress) { p.Bytes += n })
+	if err != nil {
+		mf.finish(key, "", false)
+		if ctx.Err() == nil {
+			logger.Warn("Failed to download file", "error", err)
+			j.update(func(p *Progress) { p.Failed++ })
+		}
+		return
+	}
+	if err := mf.finish(key, rel, true); err != nil {
+		logger.Warn("Failed to save download manifest", "error", err)
+	}
+	j.addFile(messageRef{it.ChatID, msg.ID}, rel)
+	j.update(func(p *Progress) { p.Done++ })
+}
+
+// downloadFile 按分片下载文件到 path. 存在上次未完成的 .part 文件时从其已完成的分片继续, 返回本次下载的字节数
+func downloadFile(ctx context.Context, file utils.TGFile, path string) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	partPath := path + ".part"
+	f, err := os.OpenFile(partPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return 0, err
+	}
+	index := info.Size() / utils.FilePartSize
+	offset := index * utils.FilePartSize
+	start := offset
+	if err := f.Truncate(offset); err != nil {
+		f.Close()
+		return 0, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return 0, err
+	}
+	for file.Size() <= 0 || offset < file.Size() {
+		data, err := utils.DownloadPart(ctx, file, index)
+		if err != nil {
+			f.Close()
+			return offset - start, err
+		}
+		if _, err := f.Write(data); err != nil {
+			f.Close()
+			return offset - start, err
+		}
+		offset += int64(len(data))
+		index++
+		if len(data) < utils.FilePartSize {
+			break
+		}
+	}
+	if err := f.Close(); err != nil {
+		return offset - start, err
+	}
+	return offset - start, os.Rename(partPath, path)
+}
+
+func sanitizeFileName(name string) string {
+	name = filepath.Base(strings.TrimSpace(name))
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|':
+			return '_'
+		}
+		if r < 0x20 {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "" || name == "." || name == ".." {
+		return "file"
+	}
+	return name
+}
+
+// pack 将本任务涉及的文件打包到下载目录下的 btts_<id>.zip
+func (j *Job) pack(dir string) (string, error) {
+	j.mu.Lock()
+	unique := make(map[string]struct{}, len(j.files))
+	for _, rel := range j.files {
+		unique[rel] = struct{}{}
+	}
+	id := j.progress.ID
+	j.mu.Unlock()
+	files := slices.Sorted(maps.Keys(unique))
+	if len(files) == 0 {
+		return "", nil
+	}
+	zipPath := filepath.Join(dir, fmt.Sprintf("btts_%s.zip", id))
+	out, err := os.Create(zipPath)
+	if err != nil {
+		return "", err
+	}
+	zw := zip.NewWriter(out)
+	err = func() error {
+		for _, rel := range files {
+			src, err := os.Open(filepath.Join(dir, rel))
+			if err != nil {
+				return err
+			}
+			// 媒体文件通常已经压缩过, 直接存储
+			w, err := zw.CreateHeader(&zip.FileHeader{Name: filepath.ToSlash(rel), Method: zip.Store})
+			if err == nil {
+				_, err = io.Copy(w, src)
+			}
+			src.Close()
+			if err != nil {
+				return err
+			}
+		}
+		return zw.Close()
+	}()
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(zipPath)
+		return "", fmt.Errorf("failed to create zip: %w", err)
+	}
+	return zipPath, nil
+}
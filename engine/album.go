@@ -0,0 +1,99 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/types"
+	"github.com/krau/btts/utils/cache"
+)
+
+// 相册的消息可能分多次到达 (实时监听时每条消息单独处理), 且搜索引擎写入是异步的,
+// 因此在内存中短暂缓存相册的说明文字, 以便后到的成员能立即获得
+const albumCaptionCacheTTL = time.Minute * 10
+
+func albumCaptionCacheKey(chatID, groupedID int64) string {
+	return fmt.Sprintf("album_caption:%d:%d", chatID, groupedID)
+}
+
+// linkAlbums 在本批的相册成员之间共享说明文字, 不访问搜索引擎.
+//
+// captions 为本批消息中每个相册自带的说明文字, 本批中没有说明文字的相册从缓存中获取.
+func linkAlbums(ctx context.Context, chatID int64, docs []*types.MessageDocument, captions map[int64]string) {
+	for _, doc := range docs {
+		if doc.GroupedID == 0 {
+			continue
+		}
+		caption, ok := captions[doc.GroupedID]
+		if !ok {
+			caption, _ = cache.Get[string](albumCaptionCacheKey(chatID, doc.GroupedID))
+			captions[doc.GroupedID] = caption
+		}
+		doc.AlbumCaption = caption
+	}
+	for groupedID, caption := range captions {
+		if caption == "" {
+			continue
+		}
+		if err := cache.Set(albumCaptionCacheKey(chatID, groupedID), caption, albumCaptionCacheTTL); err != nil {
+			log.FromContext(ctx).Warn("Failed to cache album caption", "error", err)
+		}
+	}
+}
+
+// LinkIndexedAlbums 在 docs 与已索引的相册成员之间共享说明文字.
+//
+// docs 中没有说明文字的相册从已索引的成员获取;
+// 带有说明文字的相册回填已索引但还没有说明文字的成员, 回填的文档会追加到返回值中一并写入.
+// 读取失败时返回原来的 docs 和错误
+func LinkIndexedAlbums(ctx context.Context, searcher Searcher, chatID int64, docs []*types.MessageDocument) ([]*types.MessageDocument, error) {
+	inBatch := make(map[int64]struct{}, len(docs))
+	albums := make(map[int64][]*types.MessageDocument)
+	for _, doc := range docs {
+		inBatch[doc.ID] = struct{}{}
+		if doc.GroupedID != 0 {
+			albums[doc.GroupedID] = append(albums[doc.GroupedID], doc)
+		}
+	}
+	linked := docs
+	for groupedID, members := range albums {
+		indexed, err := searcher.GetAlbumDocuments(ctx, chatID, groupedID)
+		if err != nil {
+			return docs, fmt.Errorf("failed to get documents of album %d: %w", groupedID, err)
+		}
+		caption := ""
+		for _, doc := range members {
+			if doc.AlbumCaption != "" {
+				caption = doc.AlbumCaption
+				break
+			}
+		}
+		if caption == "" {
+			for _, doc := range indexed {
+				if doc.AlbumCaption != "" {
+					caption = doc.AlbumCaption
+					break
+				}
+			}
+		}
+		if caption == "" {
+			continue
+		}
+		if err := cache.Set(albumCaptionCacheKey(chatID, groupedID), caption, albumCaptionCacheTTL); err != nil {
+			log.FromContext(ctx).Warn("Failed to cache album caption", "error", err)
+		}
+		for _, doc := range members {
+			doc.AlbumCaption = caption
+		}
+		for _, doc := range indexed {
+			if _, ok := inBatch[doc.ID]; ok || doc.AlbumCaption == caption {
+				continue
+			}
+			doc.AlbumCaption = caption
+			linked = append(linked, doc)
+		}
+	}
+	return linked, nil
+}
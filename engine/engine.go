@@ -2,7 +2,9 @@ package engine
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/charmbracelet/log"
@@ -11,7 +13,6 @@ import (
 	"github.com/krau/btts/engine/meili"
 	"github.com/krau/btts/types"
 	"github.com/krau/btts/utils"
-	"github.com/krau/mygotg/ext"
 	"github.com/meilisearch/meilisearch-go"
 )
 
@@ -20,8 +21,26 @@ type Searcher interface {
 	DeleteIndex(ctx context.Context, chatID int64) error
 	AddDocuments(ctx context.Context, chatID int64, docs []*types.MessageDocument) error
 	DeleteDocuments(ctx context.Context, chatID int64, messageIds []int) error
+	// UpdateOcred 只更新已索引文档的 OCR 文本, key 为消息 ID
+	UpdateOcred(ctx context.Context, chatID int64, ocred map[int]string) error
 	Search(ctx context.Context, req types.SearchRequest) (*types.SearchResponse, error)
 	GetDocuments(ctx context.Context, chatID int64, messageIds []int) ([]*types.MessageDocument, error)
+	// GetAlbumDocuments 获取某个聊天中属于同一相册 (GroupedID) 的已索引文档
+	GetAlbumDocuments(ctx context.Context, chatID int64, groupedID int64) ([]*types.MessageDocument, error)
+	// ListDocuments 分页获取某个聊天的全部已索引文档, 不保证顺序, total 为文档总数
+	ListDocuments(ctx context.Context, chatID int64, offset, limit int64) (docs []*types.MessageDocument, total int64, err error)
+	// CountDocumentsBefore 统计聊天中时间 (unix 秒) 早于 before 的文档数
+	CountDocumentsBefore(ctx context.Context, chatID int64, before int64) (int64, error)
+	// DeleteDocumentsBefore 删除聊天中时间 (unix 秒) 早于 before 的文档
+	DeleteDocumentsBefore(ctx context.Context, chatID int64, before int64) error
+	// NthNewestTimestamp 返回聊天中按时间从新到旧排序的第 n 条 (从 0 开始) 文档的时间, 文档数不足时 ok 为 false
+	NthNewestTimestamp(ctx context.Context, chatID int64, n int64) (timestamp int64, ok bool, err error)
+	// CountUserDocuments 统计所有聊天中由 userID 发送的文档数
+	CountUserDocuments(ctx context.Context, userID int64) (int64, error)
+	// DeleteUserDocuments 删除所有聊天中由 userID 发送的文档
+	DeleteUserDocuments(ctx context.Context, userID int64) error
+	// Health 检查搜索引擎是否可用
+	Health(ctx context.Context) error
 }
 
 var _ Searcher = (*meili.Meilisearch)(nil)
@@ -42,29 +61,29 @@ func NewEngine(ctx context.Context) (Searcher, error) {
 	if instance != nil {
 		return instance, nil
 	}
-	log.FromContext(ctx).Debug("Initializing searcher", "engine_type", config.C.Engine.Type)
+	log.FromContext(ctx).Debug("Initializing searcher", "engine_type", config.C().Engine.Type)
 
 	var err error
-	engineType := strings.ToLower(config.C.Engine.Type)
+	engineType := strings.ToLower(config.C().Engine.Type)
 	if engineType == "" {
 		engineType = "meilisearch" // 默认使用 Meilisearch
 	}
 
 	switch engineType {
 	case "meilisearch":
-		sm := meilisearch.New(config.C.Engine.Url, meilisearch.WithAPIKey(config.C.Engine.Key))
+		sm := meilisearch.New(config.C().Engine.Url, meilisearch.WithAPIKey(config.C().Engine.Key))
 		_, err = sm.HealthWithContext(ctx)
 		if err != nil {
 			return nil, fmt.Errorf("meilisearch health check failed: %w", err)
 		}
 		instance = &meili.Meilisearch{
 			Client: sm,
-			Index:  config.C.Engine.Index,
+			Index:  config.C().Engine.Index,
 		}
 		log.FromContext(ctx).Info("Meilisearch engine initialized")
 
 	case "bleve":
-		// indexPath := config.C.Engine.Path
+		// indexPath := config.C().Engine.Path
 		// if indexPath == "" {
 		// 	indexPath = "data/bleve_indexes" // 默认路径
 		// }
@@ -75,48 +94,39 @@ func NewEngine(ctx context.Context) (Searcher, error) {
 		// log.FromContext(ctx).Info("Bleve engine initialized", "index_path", indexPath)
 		panic("not impl")
 	default:
-		return nil, fmt.Errorf("unsupported engine type: %s (supported: meilisearch, bleve)", config.C.Engine.Type)
+		return nil, fmt.Errorf("unsupported engine type: %s (supported: meilisearch, bleve)", config.C().Engine.Type)
 	}
 
 	return instance, nil
 }
 
+// DocumentsFromMessages 将消息转换为文档, 并与已索引的相册成员共享说明文字.
+// 用于直接写入搜索引擎的批量索引, 读取已索引的相册失败时只记录日志
 func DocumentsFromMessages(ctx context.Context,
 	messages []*tg.Message,
 	chatID, self int64,
-	ectx *ext.Context,
+	enableOcr bool) []*types.MessageDocument {
+	docs := BuildDocuments(ctx, messages, chatID, self, enableOcr)
+	if instance == nil {
+		return docs
+	}
+	docs, err := LinkIndexedAlbums(ctx, instance, chatID, docs)
+	if err != nil {
+		log.FromContext(ctx).Warn("Failed to link album captions", "chat_id", chatID, "error", err)
+	}
+	return docs
+}
+
+// BuildDocuments 将消息转换为文档, 只在本批消息和内存缓存中共享相册的说明文字, 不访问搜索引擎.
+// 用于经过 ingest 队列写入的消息, 以免搜索引擎变慢时阻塞更新的处理, 已索引的相册成员由写入循环关联
+func BuildDocuments(ctx context.Context,
+	messages []*tg.Message,
+	chatID, self int64,
 	enableOcr bool) []*types.MessageDocument {
 	docs := make([]*types.MessageDocument, 0, len(messages))
+	albumCaptions := make(map[int64]string)
 	for _, message := range messages {
-		var userID int64
-
-		chatPeer := message.GetPeerID()
-		switch chatPeer := chatPeer.(type) {
-		case *tg.PeerUser:
-			if message.GetOut() {
-				userID = self
-			} else {
-				userID = chatPeer.GetUserID()
-			}
-		case *tg.PeerChannel:
-			if message.GetPost() {
-				userID = chatPeer.GetChannelID()
-			} else {
-				if message.GetOut() {
-					userID = self
-				} else {
-					inputPeer := message.FromID
-					switch inp := inputPeer.(type) {
-					case *tg.PeerChat:
-						userID = inp.GetChatID()
-					case *tg.PeerUser:
-						userID = inp.GetUserID()
-					case *tg.PeerChannel:
-						userID = inp.GetChannelID()
-					}
-				}
-			}
-		}
+		userID := utils.MessageSenderID(message, self)
 		if userID == 0 {
 			log.FromContext(ctx).Debug("UserID is 0, skipping message", "message_id", message.GetID())
 			continue
@@ -124,30 +134,52 @@ func DocumentsFromMessages(ctx context.Context,
 
 		var msb strings.Builder
 		var messageType types.MessageType
-		var ocred string
 		media, ok := message.GetMedia()
 		if ok {
-			result := utils.ExtractMessageMediaText(ctx, ectx, media, enableOcr)
+			result := utils.ExtractMessageMediaText(media)
 			if result != nil {
 				msb.WriteString(result.Text)
-				ocred = result.Ocred
 				messageType = result.Type
 			}
 		}
 		msb.WriteString(message.GetMessage())
 		messageText := msb.String()
-		if messageText == "" && ocred == "" {
+		groupedID, _ := message.GetGroupedID()
+		if groupedID != 0 && message.GetMessage() != "" {
+			albumCaptions[groupedID] = message.GetMessage()
+		}
+		// 相册成员即使没有文本也需要索引, 以便共享相册的说明文字
+		// enableOcr 时图片的 OCR 文本在写入后由 ocr 包异步补充, 没有文本的图片也需要索引
+		if messageText == "" && groupedID == 0 && !(enableOcr && messageType == types.MessageTypePhoto) {
 			continue
 		}
 		docs = append(docs, &types.MessageDocument{
 			ID:        int64(message.GetID()),
 			Message:   messageText,
-			Ocred:     ocred,
 			Type:      int(messageType),
 			UserID:    userID,
 			ChatID:    chatID,
 			Timestamp: int64(message.GetDate()),
+			GroupedID: groupedID,
 		})
 	}
+	linkAlbums(ctx, chatID, docs, albumCaptions)
 	return docs
 }
+
+// ProbeMeilisearch 检查 Meilisearch 是否可用以及 key 是否有权限访问索引, 不会创建或修改索引.
+// 索引不存在时 indexExists 为 false, 启动时会自动创建
+func ProbeMeilisearch(ctx context.Context, url, key, index string) (indexExists bool, err error) {
+	client := meilisearch.New(url, meilisearch.WithAPIKey(key))
+	if _, err := client.HealthWithContext(ctx); err != nil {
+		return false, fmt.Errorf("meilisearch is not reachable: %w", err)
+	}
+	if _, err := client.GetIndexWithContext(ctx, index); err != nil {
+		var merr *meilisearch.Error
+		if errors.As(err, &merr) && merr.StatusCode == http.StatusNotFound {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get index %q, check engine.key: %w", index, err)
+	}
+	return true, nil
+}
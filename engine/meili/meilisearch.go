@@ -5,16 +5,22 @@ import (
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
 	"github.com/duke-git/lancet/v2/retry"
 	"github.com/duke-git/lancet/v2/slice"
+	"github.com/krau/btts/metrics"
 	"github.com/krau/btts/types"
 	"github.com/meilisearch/meilisearch-go"
 )
 
+// MaxTotalHits 是一次搜索最多可以翻页到的结果数, 需要不小于 export.MaxHits.
+// Meilisearch 默认只有 1000, 超出后的分页返回空结果
+const MaxTotalHits = 10000
+
 // meilisearch 内部使用 chat_id_message_id 作为文档 ID
 // 在接口中传递的 id 列表始终为 Telegram MessageID
 type MeilisearchMessageDocument struct {
@@ -33,42 +39,49 @@ type MeilisearchMessageDocument struct {
 	// Telegram MessageID
 	MessageID int64 `json:"message_id"`
 	Timestamp int64 `json:"timestamp"`
+	// Telegram GroupedID, 以字符串存储以避免超出 float64 精度
+	GroupedID    string `json:"grouped_id,omitempty"`
+	AlbumCaption string `json:"album_caption,omitempty"`
 }
 
 type MeiliSearchHit struct {
 	MeilisearchMessageDocument
 	Formatted struct {
-		ID        string `json:"id"`
-		Type      string `json:"type"`
-		Message   string `json:"message"`
-		Ocred     string `json:"ocred"`
-		UserID    string `json:"user_id"`
-		MessageID string `json:"message_id"`
-		ChatID    string `json:"chat_id"`
-		Timestamp string `json:"timestamp"`
+		ID           string `json:"id"`
+		Type         string `json:"type"`
+		Message      string `json:"message"`
+		Ocred        string `json:"ocred"`
+		UserID       string `json:"user_id"`
+		MessageID    string `json:"message_id"`
+		ChatID       string `json:"chat_id"`
+		Timestamp    string `json:"timestamp"`
+		AlbumCaption string `json:"album_caption"`
 	} `json:"_formatted"`
 }
 
 func (h *MeiliSearchHit) ToSearchHit() types.SearchHit {
 	return types.SearchHit{
 		MessageDocument: types.MessageDocument{
-			ID:          h.MessageID,
-			Type:        h.Type,
-			Message:     h.Message,
-			Ocred:       h.Ocred,
-			AIGenerated: h.AIGenerated,
-			UserID:      h.UserID,
-			ChatID:      h.ChatID,
-			Timestamp:   h.Timestamp,
+			ID:           h.MessageID,
+			Type:         h.Type,
+			Message:      h.Message,
+			Ocred:        h.Ocred,
+			AIGenerated:  h.AIGenerated,
+			UserID:       h.UserID,
+			ChatID:       h.ChatID,
+			Timestamp:    h.Timestamp,
+			GroupedID:    parseGroupedID(h.GroupedID),
+			AlbumCaption: h.AlbumCaption,
 		},
 		Formatted: types.SearchHitFormatted{
-			ID:        h.Formatted.MessageID,
-			Type:      h.Formatted.Type,
-			Message:   h.Formatted.Message,
-			Ocred:     h.Formatted.Ocred,
-			UserID:    h.Formatted.UserID,
-			ChatID:    h.Formatted.ChatID,
-			Timestamp: h.Formatted.Timestamp,
+			ID:           h.Formatted.MessageID,
+			Type:         h.Formatted.Type,
+			Message:      h.Formatted.Message,
+			Ocred:        h.Formatted.Ocred,
+			UserID:       h.Formatted.UserID,
+			ChatID:       h.Formatted.ChatID,
+			Timestamp:    h.Formatted.Timestamp,
+			AlbumCaption: h.Formatted.AlbumCaption,
 		},
 	}
 }
@@ -77,15 +90,17 @@ func docsFromMessages(docs []*types.MessageDocument) []*MeilisearchMessageDocume
 	meiliDocs := make([]*MeilisearchMessageDocument, len(docs))
 	for i, doc := range docs {
 		meiliDocs[i] = &MeilisearchMessageDocument{
-			ID:          fmt.Sprintf("%d_%d", doc.ChatID, doc.ID),
-			Type:        doc.Type,
-			Message:     doc.Message,
-			Ocred:       doc.Ocred,
-			AIGenerated: doc.AIGenerated,
-			UserID:      doc.UserID,
-			ChatID:      doc.ChatID,
-			MessageID:   doc.ID,
-			Timestamp:   doc.Timestamp,
+			ID:           fmt.Sprintf("%d_%d", doc.ChatID, doc.ID),
+			Type:         doc.Type,
+			Message:      doc.Message,
+			Ocred:        doc.Ocred,
+			AIGenerated:  doc.AIGenerated,
+			UserID:       doc.UserID,
+			ChatID:       doc.ChatID,
+			MessageID:    doc.ID,
+			Timestamp:    doc.Timestamp,
+			GroupedID:    formatGroupedID(doc.GroupedID),
+			AlbumCaption: doc.AlbumCaption,
 		}
 	}
 	return meiliDocs
@@ -95,19 +110,39 @@ func docsToMessages(docs []*MeilisearchMessageDocument) []*types.MessageDocument
 	messageDocs := make([]*types.MessageDocument, len(docs))
 	for i, doc := range docs {
 		messageDocs[i] = &types.MessageDocument{
-			ID:          doc.MessageID,
-			Type:        doc.Type,
-			Message:     doc.Message,
-			Ocred:       doc.Ocred,
-			AIGenerated: doc.AIGenerated,
-			UserID:      doc.UserID,
-			ChatID:      doc.ChatID,
-			Timestamp:   doc.Timestamp,
+			ID:           doc.MessageID,
+			Type:         doc.Type,
+			Message:      doc.Message,
+			Ocred:        doc.Ocred,
+			AIGenerated:  doc.AIGenerated,
+			UserID:       doc.UserID,
+			ChatID:       doc.ChatID,
+			Timestamp:    doc.Timestamp,
+			GroupedID:    parseGroupedID(doc.GroupedID),
+			AlbumCaption: doc.AlbumCaption,
 		}
 	}
 	return messageDocs
 }
 
+func formatGroupedID(groupedID int64) string {
+	if groupedID == 0 {
+		return ""
+	}
+	return strconv.FormatInt(groupedID, 10)
+}
+
+func parseGroupedID(groupedID string) int64 {
+	if groupedID == "" {
+		return 0
+	}
+	id, err := strconv.ParseInt(groupedID, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return id
+}
+
 func hitsToSearchHits(hits []*MeiliSearchHit) []types.SearchHit {
 	searchHits := make([]types.SearchHit, len(hits))
 	for i, hit := range hits {
@@ -133,6 +168,8 @@ func (m *Meilisearch) AddDocuments(ctx context.Context, chatID int64, docs []*ty
 	if err != nil {
 		return err
 	}
+	start := time.Now()
+	defer func() { metrics.ObserveIndex(chatID, len(docs), start, err) }()
 	retry.Retry(func() error {
 		_, err = m.Client.Index(m.Index).AddDocumentsWithContext(ctx, jsonData, &meilisearch.DocumentOptions{
 			PrimaryKey: new("id"),
@@ -142,6 +179,40 @@ func (m *Meilisearch) AddDocuments(ctx context.Context, chatID int64, docs []*ty
 	return err
 }
 
+// UpdateOcred implements engine.Searcher.
+func (m *Meilisearch) UpdateOcred(ctx context.Context, chatID int64, ocred map[int]string) (err error) {
+	// 部分更新, 不影响文档的其他字段
+	docs := make([]map[string]any, 0, len(ocred))
+	for id, text := range ocred {
+		docs = append(docs, map[string]any{
+			"id":    fmt.Sprintf("%d_%d", chatID, id),
+			"ocred": text,
+		})
+	}
+	if len(docs) == 0 {
+		return nil
+	}
+	retry.Retry(func() error {
+		_, err = m.Client.Index(m.Index).UpdateDocumentsWithContext(ctx, docs, &meilisearch.DocumentOptions{
+			PrimaryKey: new("id"),
+		})
+		return err
+	}, retry.Context(ctx), retry.RetryTimes(10), retry.RetryWithExponentialWithJitterBackoff(time.Second*3, 2, time.Second*2))
+	return err
+}
+
+// Health implements engine.Searcher.
+func (m *Meilisearch) Health(ctx context.Context) error {
+	health, err := m.Client.HealthWithContext(ctx)
+	if err != nil {
+		return err
+	}
+	if health.Status != "available" {
+		return fmt.Errorf("meilisearch status: %s", health.Status)
+	}
+	return nil
+}
+
 // CreateIndex implements engine.Searcher. 对于 Meilisearch 实现，这里创建/配置的是共享索引 m.Index，chatID 参数不会被使用。
 func (m *Meilisearch) CreateIndex(ctx context.Context, _ int64) error {
 	m.mu.Lock()
@@ -181,6 +252,7 @@ func meiliExpectedSettings() *meilisearch.Settings {
 			"chat_id",
 			"type",
 			"timestamp",
+			"grouped_id",
 		},
 		SortableAttributes: []string{
 			"timestamp",
@@ -189,8 +261,9 @@ func meiliExpectedSettings() *meilisearch.Settings {
 			"message_id",
 		},
 		SearchableAttributes: []string{
-			"message", "ocred", "aigenerated",
+			"message", "ocred", "aigenerated", "album_caption",
 		},
+		Pagination: &meilisearch.Pagination{MaxTotalHits: MaxTotalHits},
 	}
 }
 
@@ -200,7 +273,8 @@ func meiliSettingsEqual(current *meilisearch.Settings, expected *meilisearch.Set
 	}
 	return sameStringSet(current.FilterableAttributes, expected.FilterableAttributes) &&
 		sameStringSet(current.SortableAttributes, expected.SortableAttributes) &&
-		sameStringSet(current.SearchableAttributes, expected.SearchableAttributes)
+		sameStringSet(current.SearchableAttributes, expected.SearchableAttributes) &&
+		current.Pagination != nil && current.Pagination.MaxTotalHits == expected.Pagination.MaxTotalHits
 }
 
 func sameStringSet(a []string, b []string) bool {
@@ -243,6 +317,77 @@ func (m *Meilisearch) DeleteIndex(ctx context.Context, chatID int64) error {
 	return nil
 }
 
+// CountDocumentsBefore implements engine.Searcher.
+func (m *Meilisearch) CountDocumentsBefore(ctx context.Context, chatID int64, before int64) (int64, error) {
+	var resp meilisearch.DocumentsResult
+	err := m.Client.Index(m.Index).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+		Filter: fmt.Sprintf("chat_id = %d AND timestamp < %d", chatID, before),
+		Fields: []string{"id"},
+		Limit:  1,
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Total, nil
+}
+
+// DeleteDocumentsBefore implements engine.Searcher.
+func (m *Meilisearch) DeleteDocumentsBefore(ctx context.Context, chatID int64, before int64) error {
+	_, err := m.Client.Index(m.Index).DeleteDocumentsByFilterWithContext(ctx, fmt.Sprintf("chat_id = %d AND timestamp < %d", chatID, before), &meilisearch.DocumentOptions{
+		PrimaryKey: new("id"),
+	})
+	return err
+}
+
+// NthNewestTimestamp implements engine.Searcher. 获取文档时排序需要 Meilisearch v1.16 及以上
+func (m *Meilisearch) NthNewestTimestamp(ctx context.Context, chatID int64, n int64) (int64, bool, error) {
+	var resp meilisearch.DocumentsResult
+	err := m.Client.Index(m.Index).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+		Filter: fmt.Sprintf("chat_id = %d", chatID),
+		Sort:   []string{"timestamp:desc"},
+		Fields: []string{"timestamp"},
+		Offset: n,
+		Limit:  1,
+	}, &resp)
+	if err != nil {
+		return 0, false, err
+	}
+	hitBytes, err := json.Marshal(resp.Results)
+	if err != nil {
+		return 0, false, err
+	}
+	var docs []*MeilisearchMessageDocument
+	if err := json.Unmarshal(hitBytes, &docs); err != nil {
+		return 0, false, err
+	}
+	if len(docs) == 0 {
+		return 0, false, nil
+	}
+	return docs[0].Timestamp, true, nil
+}
+
+// CountUserDocuments implements engine.Searcher.
+func (m *Meilisearch) CountUserDocuments(ctx context.Context, userID int64) (int64, error) {
+	var resp meilisearch.DocumentsResult
+	err := m.Client.Index(m.Index).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+		Filter: fmt.Sprintf("user_id = %d", userID),
+		Fields: []string{"id"},
+		Limit:  1,
+	}, &resp)
+	if err != nil {
+		return 0, err
+	}
+	return resp.Total, nil
+}
+
+// DeleteUserDocuments implements engine.Searcher.
+func (m *Meilisearch) DeleteUserDocuments(ctx context.Context, userID int64) error {
+	_, err := m.Client.Index(m.Index).DeleteDocumentsByFilterWithContext(ctx, fmt.Sprintf("user_id = %d", userID), &meilisearch.DocumentOptions{
+		PrimaryKey: new("id"),
+	})
+	return err
+}
+
 // GetDocuments implements engine.Searcher.
 func (m *Meilisearch) GetDocuments(ctx context.Context, chatID int64, messageIds []int) ([]*types.MessageDocument, error) {
 	docIds := make([]string, 0, len(messageIds))
@@ -252,6 +397,8 @@ func (m *Meilisearch) GetDocuments(ctx context.Context, chatID int64, messageIds
 	var resp meilisearch.DocumentsResult
 	err := m.Client.Index(m.Index).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
 		Ids: docIds,
+		// 默认只返回 20 条
+		Limit: int64(len(docIds)),
 	}, &resp)
 	if err != nil {
 		return nil, err
@@ -279,7 +426,7 @@ func (m *Meilisearch) Search(ctx context.Context, req types.SearchRequest) (*typ
 		offset = 0
 	}
 	searchOnAttrs := []string{
-		"message",
+		"message", "album_caption",
 	}
 	if !req.DisableOcred {
 		searchOnAttrs = append(searchOnAttrs, "ocred")
@@ -292,6 +439,8 @@ func (m *Meilisearch) Search(ctx context.Context, req types.SearchRequest) (*typ
 		Limit:                limit,
 		AttributesToSearchOn: searchOnAttrs,
 		AttributesToCrop:     searchOnAttrs,
+		// 同一相册只返回一条结果
+		Distinct: "grouped_id",
 	}
 	if expr, err := req.FilterExpression(); err != nil {
 		return nil, err
@@ -316,9 +465,13 @@ func (m *Meilisearch) Search(ctx context.Context, req types.SearchRequest) (*typ
 	if err != nil {
 		return nil, err
 	}
+	searchHits := hitsToSearchHits(hits)
+	if err := m.fillAlbumSizes(ctx, searchHits); err != nil {
+		log.FromContext(ctx).Warn("Failed to count album items", "error", err)
+	}
 	return &types.SearchResponse{
 		Raw:                resp,
-		Hits:               hitsToSearchHits(hits),
+		Hits:               searchHits,
 		EstimatedTotalHits: resp.EstimatedTotalHits,
 		ProcessingTimeMs:   resp.ProcessingTimeMs,
 		Offset:             resp.Offset,
@@ -326,6 +479,83 @@ func (m *Meilisearch) Search(ctx context.Context, req types.SearchRequest) (*typ
 	}, nil
 }
 
+// GetAlbumDocuments implements engine.Searcher.
+func (m *Meilisearch) GetAlbumDocuments(ctx context.Context, chatID int64, groupedID int64) ([]*types.MessageDocument, error) {
+	var resp meilisearch.DocumentsResult
+	err := m.Client.Index(m.Index).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+		Filter: fmt.Sprintf("chat_id = %d AND grouped_id = %q", chatID, formatGroupedID(groupedID)),
+		Limit:  100,
+	}, &resp)
+	if err != nil {
+		return nil, err
+	}
+	hitBytes, err := json.Marshal(resp.Results)
+	if err != nil {
+		return nil, err
+	}
+	var docs []*MeilisearchMessageDocument
+	if err := json.Unmarshal(hitBytes, &docs); err != nil {
+		return nil, err
+	}
+	return docsToMessages(docs), nil
+}
+
+// ListDocuments implements engine.Searcher.
+func (m *Meilisearch) ListDocuments(ctx context.Context, chatID int64, offset, limit int64) ([]*types.MessageDocument, int64, error) {
+	var resp meilisearch.DocumentsResult
+	err := m.Client.Index(m.Index).GetDocumentsWithContext(ctx, &meilisearch.DocumentsQuery{
+		Filter: fmt.Sprintf("chat_id = %d", chatID),
+		Offset: offset,
+		Limit:  limit,
+	}, &resp)
+	if err != nil {
+		return nil, 0, err
+	}
+	hitBytes, err := json.Marshal(resp.Results)
+	if err != nil {
+		return nil, 0, err
+	}
+	var docs []*MeilisearchMessageDocument
+	if err := json.Unmarshal(hitBytes, &docs); err != nil {
+		return nil, 0, err
+	}
+	return docsToMessages(docs), resp.Total, nil
+}
+
+// fillAlbumSizes 通过 facet 统计结果中每个相册的成员数量
+func (m *Meilisearch) fillAlbumSizes(ctx context.Context, hits []types.SearchHit) error {
+	groupedIDs := make([]string, 0)
+	for _, hit := range hits {
+		if hit.GroupedID != 0 {
+			groupedIDs = append(groupedIDs, strconv.Quote(formatGroupedID(hit.GroupedID)))
+		}
+	}
+	if len(groupedIDs) == 0 {
+		return nil
+	}
+	groupedIDs = slice.Unique(groupedIDs)
+	resp, err := m.Client.Index(m.Index).SearchWithContext(ctx, "", &meilisearch.SearchRequest{
+		Limit:  0,
+		Filter: fmt.Sprintf("grouped_id IN [%s]", slice.Join(groupedIDs, ",")),
+		Facets: []string{"grouped_id"},
+	})
+	if err != nil {
+		return err
+	}
+	var distribution map[string]map[string]int
+	if err := json.Unmarshal(resp.FacetDistribution, &distribution); err != nil {
+		return err
+	}
+	counts := distribution["grouped_id"]
+	for i := range hits {
+		if hits[i].GroupedID == 0 {
+			continue
+		}
+		hits[i].AlbumSize = counts[formatGroupedID(hits[i].GroupedID)]
+	}
+	return nil
+}
+
 // func (m *Meilisearch) multiSearch(ctx context.Context, req types.SearchRequest) (*types.MessageSearchResponseV1, error) {
 // 	limit := req.Limit
 // 	offset := req.Offset
@@ -388,8 +618,8 @@ func (m *Meilisearch) Search(ctx context.Context, req types.SearchRequest) (*typ
 // }
 
 // [TODO] Embedder support
-// if config.C.Engine.Embedder.Name != "" {
-// 	embedSettings := config.C.Engine.Embedder
+// if config.C().Engine.Embedder.Name != "" {
+// 	embedSettings := config.C().Engine.Embedder
 // 	embedder := meilisearch.Embedder{
 // 		Source:           meilisearch.EmbedderSource(embedSettings.Source),
 // 		APIKey:           embedSettings.ApiKey,
@@ -419,6 +649,6 @@ func (m *Meilisearch) Search(ctx context.Context, req types.SearchRequest) (*typ
 // 		embedder.Model = embedSettings.Model
 // 	}
 // 	_, err = index.UpdateEmbeddersWithContext(ctx, map[string]meilisearch.Embedder{
-// 		config.C.Engine.Embedder.Name: embedder,
+// 		config.C().Engine.Embedder.Name: embedder,
 // 	})
 // }
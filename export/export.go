@@ -0,0 +1,170 @@
+package export
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/types"
+)
+
+type Format string
+
+const (
+	FormatJSON     Format = "json"
+	FormatCSV      Format = "csv"
+	FormatHTML     Format = "html"
+	FormatMarkdown Format = "md"
+)
+
+var Formats = []Format{FormatJSON, FormatCSV, FormatHTML, FormatMarkdown}
+
+// MaxHits 是单次导出最多包含的结果数
+const MaxHits = 5000
+
+// exportPageSize 是遍历搜索结果时每页的大小
+const exportPageSize = 200
+
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "json":
+		return FormatJSON, nil
+	case "csv":
+		return FormatCSV, nil
+	case "html", "htm":
+		return FormatHTML, nil
+	case "md", "markdown":
+		return FormatMarkdown, nil
+	}
+	return "", fmt.Errorf("unsupported export format: %s, expected one of json, csv, html, md", s)
+}
+
+func (f Format) ContentType() string {
+	switch f {
+	case FormatJSON:
+		return "application/json; charset=utf-8"
+	case FormatCSV:
+		return "text/csv; charset=utf-8"
+	case FormatHTML:
+		return "text/html; charset=utf-8"
+	case FormatMarkdown:
+		return "text/markdown; charset=utf-8"
+	}
+	return "application/octet-stream"
+}
+
+// FileName 返回导出文件的文件名
+func (f Format) FileName(t time.Time) string {
+	return fmt.Sprintf("btts_search_%s.%s", t.Format("20060102_150405"), f)
+}
+
+// Hit 是导出的一条搜索结果
+type Hit struct {
+	ChatID    int64     `json:"chat_id"`
+	ChatTitle string    `json:"chat_title"`
+	MessageID int64     `json:"message_id"`
+	UserID    int64     `json:"user_id"`
+	Sender    string    `json:"sender"`
+	Time      time.Time `json:"time"`
+	Type      string    `json:"type"`
+	Text      string    `json:"text"`
+	Link      string    `json:"link"`
+	AlbumSize int       `json:"album_size,omitempty"`
+}
+
+// Meta 是导出文件的附加信息
+type Meta struct {
+	Query      string    `json:"query"`
+	ExportedAt time.Time `json:"exported_at"`
+	Truncated  bool      `json:"truncated,omitempty"` // 未包含全部结果, 如超过 MaxHits
+	// 搜索引擎估计的结果总数
+	Total int64 `json:"total,omitempty"`
+}
+
+// Collect 从 req.Offset 开始遍历搜索结果的所有分页, 最多返回 MaxHits 条, 并填充发送者名称和聊天标题
+func Collect(ctx context.Context, searcher engine.Searcher, req types.SearchRequest) ([]Hit, Meta, error) {
+	meta := Meta{Query: req.Query, ExportedAt: time.Now()}
+	req.Limit = exportPageSize
+	names := NewNames()
+	hits := make([]Hit, 0)
+	start := req.Offset
+	for {
+		resp, err := searcher.Search(ctx, req)
+		if err != nil {
+			return nil, meta, err
+		}
+		meta.Total = max(meta.Total, resp.EstimatedTotalHits)
+		for _, hit := range resp.Hits {
+			if len(hits) >= MaxHits {
+				meta.Truncated = true
+				return hits, meta, nil
+			}
+			chatTitle := names.Chat(ctx, hit.ChatID)
+			sender := chatTitle
+			if hit.UserID != hit.ChatID {
+				// 频道消息或私聊对方的 UserID 与 ChatID 相同, 直接使用聊天标题
+				sender = names.User(ctx, hit.UserID)
+			}
+			hits = append(hits, Hit{
+				ChatID:    hit.ChatID,
+				ChatTitle: chatTitle,
+				MessageID: hit.ID,
+				UserID:    hit.UserID,
+				Sender:    sender,
+				Time:      time.Unix(hit.Timestamp, 0),
+				Type:      types.MessageTypeToString[types.MessageType(hit.Type)],
+				Text:      hit.FullText(),
+				Link:      hit.MessageLink(),
+				AlbumSize: hit.AlbumSize,
+			})
+		}
+		req.Offset += int64(len(resp.Hits))
+		if len(resp.Hits) < exportPageSize || (resp.EstimatedTotalHits > 0 && req.Offset >= resp.EstimatedTotalHits) {
+			// 搜索引擎限制了可翻页的结果数时, 之后的分页为空, 结果同样不完整
+			meta.Truncated = start+int64(len(hits)) < meta.Total
+			return hits, meta, nil
+		}
+	}
+}
+
+// Names 缓存查询过的用户名和聊天标题, 不是并发安全的
+type Names struct {
+	users map[int64]string
+	chats map[int64]string
+}
+
+func NewNames() *Names {
+	return &Names{users: make(map[int64]string), chats: make(map[int64]string)}
+}
+
+// User 返回用户的全名, 未知用户返回其 ID
+func (r *Names) User(ctx context.Context, userID int64) string {
+	if name, ok := r.users[userID]; ok {
+		return name
+	}
+	name := strconv.FormatInt(userID, 10)
+	if user, err := database.GetUserInfo(ctx, userID); err == nil {
+		if full := strings.TrimSpace(user.FirstName + " " + user.LastName); full != "" {
+			name = full
+		}
+	}
+	r.users[userID] = name
+	return name
+}
+
+// Chat 返回聊天标题, 未知聊天返回其 ID
+func (r *Names) Chat(ctx context.Context, chatID int64) string {
+	if title, ok := r.chats[chatID]; ok {
+		return title
+	}
+	title := strconv.FormatInt(chatID, 10)
+	if chat, err := database.GetIndexChat(ctx, chatID); err == nil && strings.TrimSpace(chat.Title) != "" {
+		title = strings.TrimSpace(chat.Title)
+	}
+	r.chats[chatID] = title
+	return title
+}
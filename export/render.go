@@ -0,0 +1,128 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Render 将结果按 format 写入 w
+func Render(w io.Writer, format Format, meta Meta, hits []Hit) error {
+	switch format {
+	case FormatJSON:
+		return renderJSON(w, meta, hits)
+	case FormatCSV:
+		return renderCSV(w, hits)
+	case FormatHTML:
+		return renderHTML(w, meta, hits)
+	case FormatMarkdown:
+		return renderMarkdown(w, meta, hits)
+	}
+	return fmt.Errorf("unsupported export format: %s", format)
+}
+
+func renderJSON(w io.Writer, meta Meta, hits []Hit) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Meta
+		Count int   `json:"count"`
+		Hits  []Hit `json:"hits"`
+	}{meta, len(hits), hits})
+}
+
+func renderCSV(w io.Writer, hits []Hit) error {
+	// UTF-8 BOM, 否则 Excel 打开时中文会乱码
+	if _, err := io.WriteString(w, "\ufeff"); err != nil {
+		return err
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"chat_id", "chat_title", "message_id", "user_id", "sender", "time", "type", "text", "link"}); err != nil {
+		return err
+	}
+	for _, h := range hits {
+		if err := cw.Write([]string{
+			strconv.FormatInt(h.ChatID, 10),
+			h.ChatTitle,
+			strconv.FormatInt(h.MessageID, 10),
+			strconv.FormatInt(h.UserID, 10),
+			h.Sender,
+			h.Time.Format(time.RFC3339),
+			h.Type,
+			h.Text,
+			h.Link,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func renderMarkdown(w io.Writer, meta Meta, hits []Hit) error {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# 搜索结果: %s\n\n", meta.Query)
+	fmt.Fprintf(&sb, "导出于 %s, 共 %d 条", meta.ExportedAt.Format(time.DateTime), len(hits))
+	if meta.Truncated {
+		fmt.Fprintf(&sb, " (仅包含前 %d 条)", MaxHits)
+	}
+	sb.WriteString("\n")
+	for _, h := range hits {
+		fmt.Fprintf(&sb, "\n### %s · %s · %s\n\n", h.Sender, h.ChatTitle, h.Time.Format(time.DateTime))
+		text := strings.TrimSpace(h.Text)
+		if text == "" {
+			text = "[" + h.Type + "]"
+		}
+		for line := range strings.SplitSeq(text, "\n") {
+			fmt.Fprintf(&sb, "> %s\n", line)
+		}
+		fmt.Fprintf(&sb, "\n[%s](%s)\n", h.Link, h.Link)
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var htmlTemplate = template.Must(template.New("export").Funcs(template.FuncMap{
+	"datetime": func(t time.Time) string { return t.Format(time.DateTime) },
+}).Parse(`<!DOCTYPE html>
+<html lang="zh">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>搜索结果: {{.Meta.Query}}</title>
+<style>
+body { font-family: -apple-system, "Segoe UI", "PingFang SC", "Microsoft YaHei", sans-serif; max-width: 860px; margin: 0 auto; padding: 24px; color: #222; background: #f5f5f5; }
+h1 { font-size: 1.4em; }
+.meta { color: #666; margin-bottom: 24px; }
+.hit { background: #fff; border-radius: 8px; padding: 12px 16px; margin-bottom: 12px; box-shadow: 0 1px 2px rgba(0,0,0,.08); }
+.head { font-size: .9em; color: #555; margin-bottom: 6px; }
+.sender { font-weight: 600; color: #2b6cb0; }
+.type { display: inline-block; font-size: .8em; background: #edf2f7; border-radius: 4px; padding: 0 6px; margin-left: 6px; }
+.text { white-space: pre-wrap; word-break: break-word; }
+a { color: #2b6cb0; font-size: .85em; }
+</style>
+</head>
+<body>
+<h1>搜索结果: {{.Meta.Query}}</h1>
+<div class="meta">导出于 {{datetime .Meta.ExportedAt}}, 共 {{len .Hits}} 条{{if .Meta.Truncated}} (仅包含前 {{.MaxHits}} 条){{end}}</div>
+{{range .Hits}}<div class="hit">
+<div class="head"><span class="sender">{{.Sender}}</span> · {{.ChatTitle}} · {{datetime .Time}}<span class="type">{{.Type}}</span></div>
+<div class="text">{{.Text}}</div>
+<a href="{{.Link}}" target="_blank" rel="noopener">{{.Link}}</a>
+</div>
+{{end}}</body>
+</html>
+`))
+
+func renderHTML(w io.Writer, meta Meta, hits []Hit) error {
+	return htmlTemplate.Execute(w, struct {
+		Meta    Meta
+		Hits    []Hit
+		MaxHits int
+	}{meta, hits, MaxHits})
+}
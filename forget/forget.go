@@ -0,0 +1,53 @@
+package forget
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ingest"
+)
+
+// Result 是删除一个用户数据的结果
+type Result struct {
+	UserID int64 `json:"user_id"`
+	// 删除的文档数
+	Deleted int64 `json:"deleted"`
+	// 是否已加入永不索引列表
+	NeverIndex bool `json:"never_index"`
+}
+
+// Forget 删除用户在所有聊天中已索引的消息, 以及其用户信息和成员记录.
+// neverIndex 为 true 时同时将用户加入永不索引列表, 之后其消息不会再被索引
+func Forget(ctx context.Context, searcher engine.Searcher, userID int64, neverIndex bool) (*Result, error) {
+	res := &Result{UserID: userID}
+	// 先加入列表, 避免删除期间又有新消息被索引
+	if neverIndex {
+		if err := database.AddNeverIndexUser(ctx, userID); err != nil {
+			return nil, fmt.Errorf("failed to add user to never-index list: %w", err)
+		}
+		res.NeverIndex = true
+	} else {
+		res.NeverIndex = database.NeverIndex(userID)
+	}
+	count, err := searcher.CountUserDocuments(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents: %w", err)
+	}
+	if count > 0 {
+		if err := searcher.DeleteUserDocuments(ctx, userID); err != nil {
+			return nil, fmt.Errorf("failed to delete documents: %w", err)
+		}
+	}
+	res.Deleted = count
+	// 队列中尚未写入的该用户的消息会在上面的删除之后才写入搜索引擎,
+	// 因此在这些聊天的队列末尾再删除一次
+	if err := ingest.DeleteUserDocuments(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to queue deletion of pending documents: %w", err)
+	}
+	if err := database.DeleteUserData(ctx, userID); err != nil {
+		return nil, fmt.Errorf("failed to delete user info: %w", err)
+	}
+	return res, nil
+}
@@ -0,0 +1,215 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+)
+
+type Status string
+
+const (
+	StatusUp       Status = "up"
+	StatusDown     Status = "down"
+	StatusDisabled Status = "disabled"
+)
+
+// ErrDisabled 由检查函数返回, 表示组件未启用
+var ErrDisabled = errors.New("disabled")
+
+const checkTimeout = 10 * time.Second
+
+// Check 是一个组件的健康检查. Required 的组件不可用时服务视为未就绪
+type Check struct {
+	Name     string
+	Required bool
+	Func     func(ctx context.Context) error
+}
+
+// ComponentStatus 是一个组件最近一次检查的结果
+type ComponentStatus struct {
+	Name      string     `json:"name"`
+	Status    Status     `json:"status"`
+	Required  bool       `json:"required"`
+	Error     string     `json:"error,omitempty"`
+	LatencyMs int64      `json:"latency_ms"`
+	DownSince *time.Time `json:"down_since,omitempty"`
+}
+
+// Report 是所有组件的检查结果. Status 为 "ok", "degraded" (可选组件不可用) 或 "down" (必需组件不可用)
+type Report struct {
+	Status     string            `json:"status"`
+	Ready      bool              `json:"ready"`
+	CheckedAt  time.Time         `json:"checked_at"`
+	Components []ComponentStatus `json:"components"`
+}
+
+// Notifier 用于发送告警
+type Notifier func(ctx context.Context, text string)
+
+var (
+	providers   []func() []Check
+	providersMu sync.RWMutex
+
+	latest   *Report
+	latestMu sync.RWMutex
+
+	// 各组件开始不可用的时间和是否已经告警, 只在监控循环中访问
+	downSince = make(map[string]time.Time)
+	alerted   = make(map[string]bool)
+)
+
+// Register 注册一组健康检查. provider 在每次检查时调用, 以便包含动态增减的组件 (如子 bot)
+func Register(provider func() []Check) {
+	providersMu.Lock()
+	defer providersMu.Unlock()
+	providers = append(providers, provider)
+}
+
+func checks() []Check {
+	providersMu.RLock()
+	defer providersMu.RUnlock()
+	var res []Check
+	for _, provider := range providers {
+		res = append(res, provider()...)
+	}
+	return res
+}
+
+// Run 并发执行所有健康检查
+func Run(ctx context.Context) Report {
+	cs := checks()
+	components := make([]ComponentStatus, len(cs))
+	var wg sync.WaitGroup
+	for i, c := range cs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cctx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+			start := time.Now()
+			err := c.Func(cctx)
+			components[i] = ComponentStatus{
+				Name:      c.Name,
+				Status:    StatusUp,
+				Required:  c.Required,
+				LatencyMs: time.Since(start).Milliseconds(),
+			}
+			switch {
+			case errors.Is(err, ErrDisabled):
+				components[i].Status = StatusDisabled
+			case err != nil:
+				components[i].Status = StatusDown
+				components[i].Error = err.Error()
+			}
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: "ok", Ready: true, CheckedAt: time.Now(), Components: components}
+	for _, c := range components {
+		if c.Status != StatusDown {
+			continue
+		}
+		if c.Required {
+			report.Status, report.Ready = "down", false
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+	return report
+}
+
+func store(report Report) {
+	latestMu.Lock()
+	defer latestMu.Unlock()
+	latest = &report
+}
+
+// Latest 返回最近一次检查的结果, 还没有检查过时立即检查
+func Latest(ctx context.Context) Report {
+	latestMu.RLock()
+	report := latest
+	latestMu.RUnlock()
+	if report != nil {
+		return *report
+	}
+	r := Run(ctx)
+	store(r)
+	return r
+}
+
+// Start 定期执行健康检查, 组件持续不可用超过 alert_after 时通过 notify 告警, 恢复后再次通知
+func Start(ctx context.Context, notify Notifier) {
+	interval, err := time.ParseDuration(config.C().Health.Interval)
+	if err != nil || interval <= 0 {
+		interval = 30 * time.Second
+	}
+	alertAfter, err := time.ParseDuration(config.C().Health.AlertAfter)
+	if err != nil || alertAfter < 0 {
+		alertAfter = 5 * time.Minute
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			report := Run(ctx)
+			messages := track(&report, alertAfter)
+			store(report)
+			if notify != nil && !config.C().Health.DisableAlert {
+				for _, text := range messages {
+					notify(ctx, text)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// track 更新各组件的不可用时间并填充到 report 中, 返回需要发送的告警和恢复通知
+func track(report *Report, alertAfter time.Duration) []string {
+	var messages []string
+	seen := make(map[string]bool, len(report.Components))
+	for i := range report.Components {
+		c := &report.Components[i]
+		seen[c.Name] = true
+		if c.Status != StatusDown {
+			if alerted[c.Name] {
+				messages = append(messages, fmt.Sprintf("✅ %s has recovered after %s", c.Name,
+					report.CheckedAt.Sub(downSince[c.Name]).Round(time.Second)))
+			}
+			delete(downSince, c.Name)
+			delete(alerted, c.Name)
+			continue
+		}
+		since, ok := downSince[c.Name]
+		if !ok {
+			since = report.CheckedAt
+			downSince[c.Name] = since
+			log.Warn("Component is unhealthy", "component", c.Name, "error", c.Error)
+		}
+		c.DownSince = &since
+		if !alerted[c.Name] && report.CheckedAt.Sub(since) >= alertAfter {
+			alerted[c.Name] = true
+			messages = append(messages, fmt.Sprintf("⚠️ %s has been unhealthy for %s: %s", c.Name,
+				report.CheckedAt.Sub(since).Round(time.Second), c.Error))
+		}
+	}
+	// 已移除的组件 (如删除的子 bot) 不再跟踪
+	for name := range downSince {
+		if !seen[name] {
+			delete(downSince, name)
+			delete(alerted, name)
+		}
+	}
+	return messages
+}
@@ -0,0 +1,462 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/metrics"
+	"github.com/krau/btts/types"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	opAdd    = "add"
+	opDelete = "delete"
+	opOcr    = "ocr"
+	// opDeleteUser 删除一个用户在所有聊天中的文档, 由 /forget 在有待写入任务的聊天中排入
+	opDeleteUser = "delete_user"
+
+	// fetchLimit 是每轮从队列中读取的任务数
+	fetchLimit = 1000
+	// maxBatchDocs 是单次写入搜索引擎的最大文档数
+	maxBatchDocs = 1000
+
+	minBackoff = time.Second
+	maxBackoff = time.Minute
+
+	// deletedTTL 是记住已删除消息的时间, 用于丢弃删除之后才完成的 OCR 结果
+	deletedTTL = time.Hour
+)
+
+var (
+	pending atomic.Int64
+	// wake 在有新任务时通知写入循环
+	wake = make(chan struct{}, 1)
+	// drained 在任务被写入后通知等待队列空间的调用方
+	drained   = make(chan struct{})
+	drainedMu sync.Mutex
+)
+
+func init() {
+	metrics.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "btts",
+		Name:      "ingest_queue_length",
+		Help:      "Number of pending operations in the ingestion queue.",
+	}, func() float64 { return float64(pending.Load()) }))
+}
+
+// AddDocuments 将文档持久化到队列中, 返回 nil 时文档已经落盘, 之后会被写入搜索引擎.
+// 队列已满时阻塞直到有空间或 ctx 结束
+func AddDocuments(ctx context.Context, chatID int64, docs []*types.MessageDocument) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(docs)
+	if err != nil {
+		return err
+	}
+	return enqueue(ctx, chatID, opAdd, payload)
+}
+
+// DeleteDocuments 将删除操作持久化到队列中, 与同一聊天的写入按顺序执行
+func DeleteDocuments(ctx context.Context, chatID int64, messageIDs []int) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(messageIDs)
+	if err != nil {
+		return err
+	}
+	return enqueue(ctx, chatID, opDelete, payload)
+}
+
+// UpdateOcred 将 OCR 结果持久化到队列中, 在同一聊天之前的写入完成后只更新文档的 OCR 文本
+func UpdateOcred(ctx context.Context, chatID int64, ocred map[int]string) error {
+	if len(ocred) == 0 {
+		return nil
+	}
+	payload, err := json.Marshal(ocred)
+	if err != nil {
+		return err
+	}
+	return enqueue(ctx, chatID, opOcr, payload)
+}
+
+// DeleteUserDocuments 在每个有待处理任务的聊天中排入删除用户文档的操作,
+// 使其在这些聊天中已入队的写入 (包括该用户的消息) 完成之后执行
+func DeleteUserDocuments(ctx context.Context, userID int64) error {
+	chatIDs, err := database.GetIngestTaskChats(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get pending chats: %w", err)
+	}
+	payload, err := json.Marshal(userID)
+	if err != nil {
+		return err
+	}
+	for _, chatID := range chatIDs {
+		if err := enqueue(ctx, chatID, opDeleteUser, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddDocumentsWait 与 AddDocuments 相同, 但写入队列失败时持续重试, 直到成功或 ctx 结束.
+// 用于实时更新: 如果跳过写入失败的更新, 之后的更新会推进 pts, 这条消息就不会再被 SyncMissedUpdates 重新获取
+func AddDocumentsWait(ctx context.Context, chatID int64, docs []*types.MessageDocument) error {
+	return retryEnqueue(ctx, func() error { return AddDocuments(ctx, chatID, docs) })
+}
+
+// DeleteDocumentsWait 与 DeleteDocuments 相同, 但写入队列失败时持续重试, 直到成功或 ctx 结束
+func DeleteDocumentsWait(ctx context.Context, chatID int64, messageIDs []int) error {
+	return retryEnqueue(ctx, func() error { return DeleteDocuments(ctx, chatID, messageIDs) })
+}
+
+func retryEnqueue(ctx context.Context, fn func() error) error {
+	backoff := time.Second
+	for {
+		err := fn()
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		log.FromContext(ctx).Warn("Failed to enqueue ingestion task, retrying", "error", err, "retry_in", backoff)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+// Pending 返回队列中等待写入的操作数
+func Pending() int64 {
+	return pending.Load()
+}
+
+func enqueue(ctx context.Context, chatID int64, op string, payload []byte) error {
+	if err := waitForSpace(ctx); err != nil {
+		return err
+	}
+	task := &database.IngestTask{
+		CreatedAt: time.Now(),
+		ChatID:    chatID,
+		Op:        op,
+		Payload:   payload,
+	}
+	if err := database.CreateIngestTask(ctx, task); err != nil {
+		return fmt.Errorf("failed to enqueue %s for chat %d: %w", op, chatID, err)
+	}
+	pending.Add(1)
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// waitForSpace 在队列达到上限时等待, 对上游形成背压
+func waitForSpace(ctx context.Context) error {
+	limit := int64(config.C().Ingest.MaxPending)
+	for limit > 0 && pending.Load() >= limit {
+		drainedMu.Lock()
+		ch := drained
+		drainedMu.Unlock()
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func notifyDrained() {
+	drainedMu.Lock()
+	close(drained)
+	drained = make(chan struct{})
+	drainedMu.Unlock()
+}
+
+// Start 在后台将队列中的操作批量写入搜索引擎. 写入失败时按聊天指数退避重试, 同一聊天的操作保持顺序
+func Start(ctx context.Context, searcher engine.Searcher) error {
+	count, err := database.CountIngestTasks(ctx)
+	if err != nil {
+		return err
+	}
+	pending.Store(count)
+	if count > 0 {
+		log.FromContext(ctx).Info("Resuming ingestion queue", "pending", count)
+	}
+	w := &writer{
+		searcher: searcher,
+		backoff:  make(map[int64]time.Duration),
+		retryAt:  make(map[int64]time.Time),
+		deleted:  make(map[messageRef]time.Time),
+	}
+	go w.run(ctx)
+	return nil
+}
+
+type writer struct {
+	searcher engine.Searcher
+	// 写入失败的聊天的当前退避时间和下次重试时间
+	backoff map[int64]time.Duration
+	retryAt map[int64]time.Time
+	// 最近删除的消息. 搜索引擎的部分更新会创建不存在的文档, 因此删除之后的 OCR 结果需要丢弃
+	deleted map[messageRef]time.Time
+}
+
+type messageRef struct {
+	ChatID    int64
+	MessageID int
+}
+
+func (w *writer) run(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	for {
+		n, err := w.flush(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("Failed to flush ingestion queue", "error", err)
+		}
+		if n > 0 {
+			continue
+		}
+		wait := time.Second
+		for _, t := range w.retryAt {
+			wait = min(wait, max(time.Until(t), 0))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// flush 处理一轮任务, 返回成功写入的任务数
+func (w *writer) flush(ctx context.Context) (int, error) {
+	now := time.Now()
+	for ref, t := range w.deleted {
+		if now.Sub(t) > deletedTTL {
+			delete(w.deleted, ref)
+		}
+	}
+	var waiting []int64
+	for chatID, t := range w.retryAt {
+		if now.Before(t) {
+			waiting = append(waiting, chatID)
+		}
+	}
+	tasks, err := database.GetIngestTasks(ctx, waiting, fetchLimit)
+	if err != nil {
+		return 0, err
+	}
+	if len(tasks) == 0 {
+		return 0, nil
+	}
+
+	byChat := make(map[int64][]*database.IngestTask)
+	for _, task := range tasks {
+		byChat[task.ChatID] = append(byChat[task.ChatID], task)
+	}
+	done := 0
+	for _, chatID := range slices.Sorted(maps.Keys(byChat)) {
+		n, err := w.flushChat(ctx, chatID, byChat[chatID])
+		done += n
+		if ctx.Err() != nil {
+			return done, ctx.Err()
+		}
+		if err != nil {
+			backoff := min(max(w.backoff[chatID]*2, minBackoff), maxBackoff)
+			w.backoff[chatID] = backoff
+			w.retryAt[chatID] = time.Now().Add(backoff)
+			log.FromContext(ctx).Warn("Failed to write to engine, will retry", "chat_id", chatID, "retry_in", backoff, "error", err)
+			continue
+		}
+		delete(w.backoff, chatID)
+		delete(w.retryAt, chatID)
+	}
+	return done, nil
+}
+
+// flushChat 将一个聊天的连续同类操作合并后按顺序写入, 遇到错误时停止, 之后的操作留到下次重试
+func (w *writer) flushChat(ctx context.Context, chatID int64, tasks []*database.IngestTask) (int, error) {
+	done := 0
+	for len(tasks) > 0 {
+		batch := []*database.IngestTask{tasks[0]}
+		for _, task := range tasks[1:] {
+			if task.Op != batch[0].Op {
+				break
+			}
+			batch = append(batch, task)
+		}
+		ids := make([]uint, 0, len(batch))
+		for _, task := range batch {
+			ids = append(ids, task.ID)
+		}
+		written, err := w.apply(ctx, chatID, batch)
+		if err != nil {
+			if err := database.MarkIngestTasksFailed(context.WithoutCancel(ctx), ids, err.Error()); err != nil {
+				log.FromContext(ctx).Error("Failed to record ingestion failure", "error", err)
+			}
+			return done, err
+		}
+		ids = ids[:written]
+		if err := database.DeleteIngestTasks(context.WithoutCancel(ctx), ids); err != nil {
+			// 任务会在下一轮重新写入, 添加和删除都是幂等的
+			return done, fmt.Errorf("failed to remove written tasks: %w", err)
+		}
+		pending.Add(-int64(len(ids)))
+		notifyDrained()
+		done += len(ids)
+		tasks = tasks[len(ids):]
+	}
+	return done, nil
+}
+
+// existingOcred 返回 ocred 中文档仍在索引中的部分
+func (w *writer) existingOcred(ctx context.Context, chatID int64, ocred map[int]string) (map[int]string, error) {
+	if len(ocred) == 0 {
+		return nil, nil
+	}
+	docs, err := w.searcher.GetDocuments(ctx, chatID, slices.Collect(maps.Keys(ocred)))
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[int]string, len(docs))
+	for _, doc := range docs {
+		if text, ok := ocred[int(doc.ID)]; ok {
+			existing[int(doc.ID)] = text
+		}
+	}
+	return existing, nil
+}
+
+// apply 将一批同类操作写入搜索引擎, 返回已处理的任务数 (受 maxBatchDocs 限制, 至少为 1)
+func (w *writer) apply(ctx context.Context, chatID int64, batch []*database.IngestTask) (int, error) {
+	switch op := batch[0].Op; op {
+	case opAdd:
+		var docs []*types.MessageDocument
+		written := 0
+		for _, task := range batch {
+			var taskDocs []*types.MessageDocument
+			if err := json.Unmarshal(task.Payload, &taskDocs); err != nil {
+				if written > 0 {
+					break
+				}
+				// 无法解析的任务永远不会成功, 直接丢弃以免阻塞该聊天
+				log.FromContext(ctx).Error("Dropping corrupted ingestion task", "task_id", task.ID, "error", err)
+				return 1, nil
+			}
+			if written > 0 && len(docs)+len(taskDocs) > maxBatchDocs {
+				break
+			}
+			docs = append(docs, taskDocs...)
+			written++
+		}
+		// 入队后才加入永不索引列表的用户, 其消息在写入前丢弃
+		docs = slices.DeleteFunc(docs, func(doc *types.MessageDocument) bool {
+			return database.NeverIndex(doc.UserID)
+		})
+		if len(docs) == 0 {
+			return written, nil
+		}
+		// 相册的说明文字可能在之前已索引的成员中, 或需要回填到它们
+		docs, err := engine.LinkIndexedAlbums(ctx, w.searcher, chatID, docs)
+		if err != nil {
+			return written, err
+		}
+		return written, w.searcher.AddDocuments(ctx, chatID, docs)
+	case opDelete:
+		var ids []int
+		written := 0
+		for _, task := range batch {
+			var taskIDs []int
+			if err := json.Unmarshal(task.Payload, &taskIDs); err != nil {
+				if written > 0 {
+					break
+				}
+				// 无法解析的任务永远不会成功, 直接丢弃以免阻塞该聊天
+				log.FromContext(ctx).Error("Dropping corrupted ingestion task", "task_id", task.ID, "error", err)
+				return 1, nil
+			}
+			if written > 0 && len(ids)+len(taskIDs) > maxBatchDocs {
+				break
+			}
+			ids = append(ids, taskIDs...)
+			written++
+		}
+		if err := w.searcher.DeleteDocuments(ctx, chatID, ids); err != nil {
+			return written, err
+		}
+		now := time.Now()
+		for _, id := range ids {
+			w.deleted[messageRef{chatID, id}] = now
+		}
+		return written, nil
+	case opOcr:
+		ocred := make(map[int]string)
+		written := 0
+		for _, task := range batch {
+			var taskOcred map[int]string
+			if err := json.Unmarshal(task.Payload, &taskOcred); err != nil {
+				if written > 0 {
+					break
+				}
+				// 无法解析的任务永远不会成功, 直接丢弃以免阻塞该聊天
+				log.FromContext(ctx).Error("Dropping corrupted ingestion task", "task_id", task.ID, "error", err)
+				return 1, nil
+			}
+			if written > 0 && len(ocred)+len(taskOcred) > maxBatchDocs {
+				break
+			}
+			for id, text := range taskOcred {
+				if _, ok := w.deleted[messageRef{chatID, id}]; !ok {
+					ocred[id] = text
+				}
+			}
+			written++
+		}
+		// 部分更新会创建不存在的文档, 生成没有 chat_id 等字段的残缺文档.
+		// 被保留策略、/forget 等按条件删除的文档不在 w.deleted 中, 因此只更新仍然存在的文档
+		existing, err := w.existingOcred(ctx, chatID, ocred)
+		if err != nil || len(existing) == 0 {
+			return written, err
+		}
+		return written, w.searcher.UpdateOcred(ctx, chatID, existing)
+	case opDeleteUser:
+		userIDs := make(map[int64]struct{})
+		for _, task := range batch {
+			var userID int64
+			if err := json.Unmarshal(task.Payload, &userID); err != nil {
+				// 无法解析的任务永远不会成功, 直接丢弃以免阻塞该聊天
+				log.FromContext(ctx).Error("Dropping corrupted ingestion task", "task_id", task.ID, "error", err)
+				continue
+			}
+			userIDs[userID] = struct{}{}
+		}
+		// 删除不限于当前聊天, 重复执行也没有影响
+		for userID := range userIDs {
+			if err := w.searcher.DeleteUserDocuments(ctx, userID); err != nil {
+				return len(batch), err
+			}
+		}
+		return len(batch), nil
+	default:
+		return 0, fmt.Errorf("unknown ingestion op %q in task %d", op, batch[0].ID)
+	}
+}
@@ -0,0 +1,327 @@
+package ingest
+
+import (
+	"context"
+	"encoding/json"
+	"maps"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/types"
+)
+
+const (
+	testChat      = 100
+	testForgotten = 400
+)
+
+func TestMain(m *testing.M) {
+	// 永不索引列表保存在数据库中, 在临时目录中初始化
+	dir, err := os.MkdirTemp("", "btts-ingest-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir("data", 0o755); err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+	if err := database.InitDatabase(ctx); err != nil {
+		panic(err)
+	}
+	if err := database.AddNeverIndexUser(ctx, testForgotten); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeSearcher 记录写入操作, 只实现写入循环用到的方法
+type fakeSearcher struct {
+	engine.Searcher
+	indexed      map[int64]*types.MessageDocument
+	added        [][]*types.MessageDocument
+	deleted      [][]int
+	ocred        []map[int]string
+	deletedUsers []int64
+}
+
+func newFakeSearcher(indexed ...*types.MessageDocument) *fakeSearcher {
+	s := &fakeSearcher{indexed: make(map[int64]*types.MessageDocument)}
+	for _, doc := range indexed {
+		s.indexed[doc.ID] = doc
+	}
+	return s
+}
+
+func (s *fakeSearcher) AddDocuments(_ context.Context, _ int64, docs []*types.MessageDocument) error {
+	s.added = append(s.added, docs)
+	return nil
+}
+
+func (s *fakeSearcher) DeleteDocuments(_ context.Context, _ int64, ids []int) error {
+	s.deleted = append(s.deleted, ids)
+	return nil
+}
+
+func (s *fakeSearcher) UpdateOcred(_ context.Context, _ int64, ocred map[int]string) error {
+	s.ocred = append(s.ocred, ocred)
+	return nil
+}
+
+func (s *fakeSearcher) DeleteUserDocuments(_ context.Context, userID int64) error {
+	s.deletedUsers = append(s.deletedUsers, userID)
+	return nil
+}
+
+func (s *fakeSearcher) GetDocuments(_ context.Context, _ int64, ids []int) ([]*types.MessageDocument, error) {
+	var docs []*types.MessageDocument
+	for _, id := range ids {
+		if doc, ok := s.indexed[int64(id)]; ok {
+			docs = append(docs, doc)
+		}
+	}
+	return docs, nil
+}
+
+func (s *fakeSearcher) GetAlbumDocuments(_ context.Context, _ int64, groupedID int64) ([]*types.MessageDocument, error) {
+	var docs []*types.MessageDocument
+	for _, doc := range s.indexed {
+		if doc.GroupedID == groupedID {
+			copied := *doc
+			docs = append(docs, &copied)
+		}
+	}
+	return docs, nil
+}
+
+func newWriter(searcher engine.Searcher) *writer {
+	return &writer{
+		searcher: searcher,
+		deleted:  make(map[messageRef]time.Time),
+	}
+}
+
+func newTask(t *testing.T, op string, payload any) *database.IngestTask {
+	t.Helper()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &database.IngestTask{ChatID: testChat, Op: op, Payload: data}
+}
+
+func docs(ids ...int64) []*types.MessageDocument {
+	res := make([]*types.MessageDocument, 0, len(ids))
+	for _, id := range ids {
+		res = append(res, &types.MessageDocument{ID: id, ChatID: testChat, UserID: 200, Message: "message"})
+	}
+	return res
+}
+
+func docIDs(docs []*types.MessageDocument) []int64 {
+	ids := make([]int64, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
+
+func TestApplyAdd(t *testing.T) {
+	corrupted := &database.IngestTask{ChatID: testChat, Op: opAdd, Payload: []byte("{")}
+	manyDocs := make([]int64, maxBatchDocs)
+	for i := range manyDocs {
+		manyDocs[i] = int64(1000 + i)
+	}
+	tests := []struct {
+		name     string
+		batch    func(t *testing.T) []*database.IngestTask
+		written  int
+		expected [][]int64
+	}{
+		{
+			name: "Coalesce consecutive tasks",
+			batch: func(t *testing.T) []*database.IngestTask {
+				return []*database.IngestTask{newTask(t, opAdd, docs(1, 2)), newTask(t, opAdd, docs(3))}
+			},
+			written:  2,
+			expected: [][]int64{{1, 2, 3}},
+		},
+		{
+			name: "Stop at maxBatchDocs",
+			batch: func(t *testing.T) []*database.IngestTask {
+				return []*database.IngestTask{newTask(t, opAdd, docs(1)), newTask(t, opAdd, docs(manyDocs...))}
+			},
+			written:  1,
+			expected: [][]int64{{1}},
+		},
+		{
+			name: "Oversized first task is written alone",
+			batch: func(t *testing.T) []*database.IngestTask {
+				return []*database.IngestTask{newTask(t, opAdd, docs(append(manyDocs, 1)...)), newTask(t, opAdd, docs(2))}
+			},
+			written:  1,
+			expected: [][]int64{append(manyDocs, 1)},
+		},
+		{
+			name: "Drop corrupted first task",
+			batch: func(t *testing.T) []*database.IngestTask {
+				return []*database.IngestTask{corrupted, newTask(t, opAdd, docs(1))}
+			},
+			written: 1,
+		},
+		{
+			name: "Stop before corrupted task",
+			batch: func(t *testing.T) []*database.IngestTask {
+				return []*database.IngestTask{newTask(t, opAdd, docs(1)), corrupted, newTask(t, opAdd, docs(2))}
+			},
+			written:  1,
+			expected: [][]int64{{1}},
+		},
+		{
+			name: "Drop never-index users",
+			batch: func(t *testing.T) []*database.IngestTask {
+				forgotten := docs(2)
+				forgotten[0].UserID = testForgotten
+				return []*database.IngestTask{newTask(t, opAdd, docs(1)), newTask(t, opAdd, forgotten)}
+			},
+			written:  2,
+			expected: [][]int64{{1}},
+		},
+		{
+			name: "Only never-index users",
+			batch: func(t *testing.T) []*database.IngestTask {
+				forgotten := docs(1)
+				forgotten[0].UserID = testForgotten
+				return []*database.IngestTask{newTask(t, opAdd, forgotten)}
+			},
+			written: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			searcher := newFakeSearcher()
+			written, err := newWriter(searcher).apply(context.Background(), testChat, tt.batch(t))
+			if err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+			if written != tt.written {
+				t.Errorf("apply() written = %d, expected %d", written, tt.written)
+			}
+			var got [][]int64
+			for _, added := range searcher.added {
+				got = append(got, docIDs(added))
+			}
+			if !slices.EqualFunc(got, tt.expected, slices.Equal) {
+				t.Errorf("AddDocuments() calls = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApplyAddLinksAlbums(t *testing.T) {
+	const groupedID = 42
+	searcher := newFakeSearcher(&types.MessageDocument{ID: 1, ChatID: testChat, UserID: 200, GroupedID: groupedID})
+	member := docs(2)
+	member[0].GroupedID = groupedID
+	member[0].AlbumCaption = "caption"
+	if _, err := newWriter(searcher).apply(context.Background(), testChat, []*database.IngestTask{newTask(t, opAdd, member)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(searcher.added) != 1 {
+		t.Fatalf("AddDocuments() called %d times, expected 1", len(searcher.added))
+	}
+	added := searcher.added[0]
+	if !slices.Equal(docIDs(added), []int64{2, 1}) {
+		t.Fatalf("AddDocuments() docs = %v, expected the new member and the indexed member", docIDs(added))
+	}
+	if added[1].AlbumCaption != "caption" {
+		t.Errorf("indexed member caption = %q, expected it to be backfilled", added[1].AlbumCaption)
+	}
+}
+
+func TestApplyDelete(t *testing.T) {
+	searcher := newFakeSearcher()
+	w := newWriter(searcher)
+	batch := []*database.IngestTask{newTask(t, opDelete, []int{1, 2}), newTask(t, opDelete, []int{3})}
+	written, err := w.apply(context.Background(), testChat, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 2 {
+		t.Errorf("apply() written = %d, expected 2", written)
+	}
+	if len(searcher.deleted) != 1 || !slices.Equal(searcher.deleted[0], []int{1, 2, 3}) {
+		t.Errorf("DeleteDocuments() calls = %v, expected [[1 2 3]]", searcher.deleted)
+	}
+	for _, id := range []int{1, 2, 3} {
+		if _, ok := w.deleted[messageRef{testChat, id}]; !ok {
+			t.Errorf("message %d is not remembered as deleted", id)
+		}
+	}
+}
+
+func TestApplyOcr(t *testing.T) {
+	searcher := newFakeSearcher(docs(1, 2, 3)...)
+	w := newWriter(searcher)
+	// 2 在队列中被删除, 4 已经不在索引中 (如被保留策略删除)
+	if _, err := w.apply(context.Background(), testChat, []*database.IngestTask{newTask(t, opDelete, []int{2})}); err != nil {
+		t.Fatal(err)
+	}
+	batch := []*database.IngestTask{
+		newTask(t, opOcr, map[int]string{1: "one", 2: "two"}),
+		newTask(t, opOcr, map[int]string{3: "three", 4: "four"}),
+	}
+	written, err := w.apply(context.Background(), testChat, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != 2 {
+		t.Errorf("apply() written = %d, expected 2", written)
+	}
+	expected := map[int]string{1: "one", 3: "three"}
+	if len(searcher.ocred) != 1 || !maps.Equal(searcher.ocred[0], expected) {
+		t.Errorf("UpdateOcred() calls = %v, expected [%v]", searcher.ocred, expected)
+	}
+
+	searcher.ocred = nil
+	if _, err := w.apply(context.Background(), testChat, []*database.IngestTask{newTask(t, opOcr, map[int]string{4: "four"})}); err != nil {
+		t.Fatal(err)
+	}
+	if len(searcher.ocred) != 0 {
+		t.Errorf("UpdateOcred() called for missing documents: %v", searcher.ocred)
+	}
+}
+
+func TestApplyDeleteUser(t *testing.T) {
+	searcher := newFakeSearcher()
+	batch := []*database.IngestTask{
+		newTask(t, opDeleteUser, int64(400)),
+		{ChatID: testChat, Op: opDeleteUser, Payload: []byte("not json")},
+		newTask(t, opDeleteUser, int64(400)),
+		newTask(t, opDeleteUser, int64(500)),
+	}
+	written, err := newWriter(searcher).apply(context.Background(), testChat, batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if written != len(batch) {
+		t.Errorf("apply() written = %d, expected %d", written, len(batch))
+	}
+	slices.Sort(searcher.deletedUsers)
+	if !slices.Equal(searcher.deletedUsers, []int64{400, 500}) {
+		t.Errorf("DeleteUserDocuments() calls = %v, expected each user once", searcher.deletedUsers)
+	}
+}
+
+func TestApplyUnknownOp(t *testing.T) {
+	if _, err := newWriter(newFakeSearcher()).apply(context.Background(), testChat, []*database.IngestTask{{Op: "rename"}}); err == nil {
+		t.Error("apply() error = nil, expected an error for an unknown op")
+	}
+}
@@ -0,0 +1,183 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "btts"
+
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+var (
+	indexedDocuments = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "indexed_documents_total",
+		Help:      "Number of documents added to the search engine, by chat.",
+	}, []string{"chat_id"})
+	indexDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "index_duration_seconds",
+		Help:      "Latency of adding a batch of documents to the search engine, including retries.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	})
+	indexErrors = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "index_errors_total",
+		Help:      "Number of document batches that failed to be added to the search engine.",
+	})
+	searchDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "search_duration_seconds",
+		Help:      "Search engine processing time, by surface (bot, inline, subbot, subbot_inline, api, api_export).",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"source"})
+	ocrDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ocr_duration_seconds",
+		Help:      "Duration of OCR calls, including downloading the image.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"type"})
+	ocrFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ocr_failures_total",
+		Help:      "Number of failed OCR calls.",
+	}, []string{"type"})
+	telegramRetries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_retries_total",
+		Help:      "Number of Telegram API calls retried by the retry middleware, by error type.",
+	}, []string{"error"})
+	floodWaits = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_flood_waits_total",
+		Help:      "Number of FLOOD_WAIT errors returned by Telegram.",
+	})
+	floodWaitSeconds = factory.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "telegram_flood_wait_seconds_total",
+		Help:      "Total time Telegram asked us to wait because of FLOOD_WAIT errors.",
+	})
+	catchupRunning = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "catchup_running",
+		Help:      "Whether the user account is catching up on missed updates.",
+	}, []string{"account"})
+	catchupMessages = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "catchup_messages_total",
+		Help:      "Number of missed messages received while catching up.",
+	}, []string{"account"})
+	catchupUpdates = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "catchup_updates_total",
+		Help:      "Number of other missed updates received while catching up.",
+	}, []string{"account"})
+	catchupLastCompleted = factory.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "catchup_last_completed_timestamp_seconds",
+		Help:      "Unix time of the last completed catch-up.",
+	}, []string{"account"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// MustRegister 注册其他包提供的 collector, 如由已有计数器导出的 CounterFunc
+func MustRegister(cs ...prometheus.Collector) {
+	registry.MustRegister(cs...)
+}
+
+// Handler 返回 Prometheus 格式的 metrics handler
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+}
+
+// ObserveIndex 记录一次向搜索引擎添加文档的结果
+func ObserveIndex(chatID int64, count int, start time.Time, err error) {
+	indexDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		indexErrors.Inc()
+		return
+	}
+	indexedDocuments.WithLabelValues(strconv.FormatInt(chatID, 10)).Add(float64(count))
+}
+
+// ObserveSearch 记录一次搜索在搜索引擎中的处理时间
+func ObserveSearch(source string, processingTimeMs int64) {
+	searchDuration.WithLabelValues(source).Observe(float64(processingTimeMs) / 1000)
+}
+
+// ObserveOcr 记录一次 OCR 调用的耗时和结果
+func ObserveOcr(ocrType string, start time.Time, err error) {
+	ocrDuration.WithLabelValues(ocrType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		ocrFailures.WithLabelValues(ocrType).Inc()
+	}
+}
+
+// IncTelegramRetry 记录一次 Telegram API 调用的重试
+func IncTelegramRetry(errType string) {
+	telegramRetries.WithLabelValues(errType).Inc()
+}
+
+// ObserveFloodWait 记录一次 FLOOD_WAIT 及需要等待的时间
+func ObserveFloodWait(d time.Duration) {
+	floodWaits.Inc()
+	floodWaitSeconds.Add(d.Seconds())
+}
+
+// StartCatchup 标记账号开始同步错过的更新, 返回的函数在同步结束时调用
+func StartCatchup(accountID int64) (done func(err error)) {
+	account := strconv.FormatInt(accountID, 10)
+	catchupRunning.WithLabelValues(account).Set(1)
+	return func(err error) {
+		catchupRunning.WithLabelValues(account).Set(0)
+		if err == nil {
+			catchupLastCompleted.WithLabelValues(account).SetToCurrentTime()
+		}
+	}
+}
+
+// AddCatchup 记录同步错过的更新时收到的消息和其他更新数
+func AddCatchup(accountID int64, messages, updates int) {
+	account := strconv.FormatInt(accountID, 10)
+	catchupMessages.WithLabelValues(account).Add(float64(messages))
+	catchupUpdates.WithLabelValues(account).Add(float64(updates))
+}
+
+// Serve 在配置的独立地址上提供 metrics, 未配置独立地址时由 api 服务在同一端口提供
+func Serve(ctx context.Context) {
+	if !config.C().Metrics.Enable || config.C().Metrics.Addr == "" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle(config.C().Metrics.Path, Handler())
+	server := &http.Server{Addr: config.C().Metrics.Addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	go func() {
+		log.FromContext(ctx).Info("Metrics server started", "addr", config.C().Metrics.Addr, "path", config.C().Metrics.Path)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.FromContext(ctx).Error("Metrics server stopped", "error", err)
+		}
+	}()
+}
@@ -6,7 +6,11 @@ import (
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/gotd/contrib/middleware/floodwait"
+	"github.com/gotd/td/bin"
 	"github.com/gotd/td/telegram"
+	"github.com/gotd/td/tg"
+	"github.com/gotd/td/tgerr"
+	"github.com/krau/btts/metrics"
 	"github.com/krau/btts/middlewares/recovery"
 	"github.com/krau/btts/middlewares/retry"
 )
@@ -16,9 +20,23 @@ func NewDefaultMiddlewares(ctx context.Context, timeout time.Duration) []telegra
 		recovery.New(ctx, newBackoff(timeout)),
 		retry.New(5),
 		floodwait.NewSimpleWaiter(),
+		floodWaitObserver(),
 	}
 }
 
+// floodWaitObserver 位于 floodwait 之后, 在 FLOOD_WAIT 被等待重试之前记录到 metrics
+func floodWaitObserver() telegram.Middleware {
+	return telegram.MiddlewareFunc(func(next tg.Invoker) telegram.InvokeFunc {
+		return func(ctx context.Context, input bin.Encoder, output bin.Decoder) error {
+			err := next.Invoke(ctx, input, output)
+			if d, ok := tgerr.AsFloodWait(err); ok {
+				metrics.ObserveFloodWait(d)
+			}
+			return err
+		}
+	})
+}
+
 func newBackoff(timeout time.Duration) backoff.BackOff {
 	b := backoff.NewExponentialBackOff()
 
@@ -9,6 +9,7 @@ import (
 	"github.com/gotd/td/telegram"
 	"github.com/gotd/td/tg"
 	"github.com/gotd/td/tgerr"
+	"github.com/krau/btts/metrics"
 )
 
 var internalErrors = []string{
@@ -33,6 +34,11 @@ func (r retry) Handle(next tg.Invoker) telegram.InvokeFunc {
 			if err := next.Invoke(ctx, input, output); err != nil {
 				if tgerr.Is(err, r.errors...) {
 					log.FromContext(ctx).Debug("retry middleware", "retries", retries, "error", err)
+					errType := "unknown"
+					if rpcErr, ok := tgerr.As(err); ok {
+						errType = rpcErr.Type
+					}
+					metrics.IncTelegramRetry(errType)
 					retries++
 					continue
 				}
@@ -0,0 +1,196 @@
+package ocr
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/ingest"
+	"github.com/krau/btts/metrics"
+	"github.com/krau/btts/utils"
+	"github.com/krau/mygotg/ext"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// jobTimeout 是单张图片下载和识别的超时时间
+	jobTimeout = 2 * time.Minute
+	// flushInterval 和 flushSize 控制 OCR 结果合并写入队列的频率
+	flushInterval = 2 * time.Second
+	flushSize     = 100
+)
+
+type job struct {
+	client    *ext.Context
+	chatID    int64
+	messageID int
+	media     tg.MessageMediaClass
+}
+
+type result struct {
+	chatID    int64
+	messageID int
+	text      string
+}
+
+var (
+	// jobs 在 Start 之前为 nil, 此时不会提交任何任务
+	jobs    chan job
+	results chan result
+	dropped atomic.Int64
+)
+
+func init() {
+	metrics.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: "btts",
+			Name:      "ocr_queue_length",
+			Help:      "Number of photos waiting for OCR.",
+		}, func() float64 { return float64(len(jobs)) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "btts",
+			Name:      "ocr_dropped_total",
+			Help:      "Number of photos skipped because the OCR queue was full.",
+		}, func() float64 { return float64(dropped.Load()) }),
+	)
+}
+
+// Start 启动 OCR worker. 未启用 OCR 时不做任何事
+func Start(ctx context.Context) {
+	if !config.C().Ocr.Enable {
+		return
+	}
+	workers := max(config.C().Ocr.Workers, 1)
+	jobs = make(chan job, max(config.C().Ocr.QueueSize, 1))
+	results = make(chan result, workers)
+	for range workers {
+		go worker(ctx)
+	}
+	go collect(ctx)
+	log.FromContext(ctx).Info("OCR workers started", "workers", workers, "queue_size", cap(jobs))
+}
+
+// Enabled 返回是否需要对聊天中的图片进行 OCR
+func Enabled(chat *database.IndexChat) bool {
+	return jobs != nil && chat != nil && !chat.NoOcr
+}
+
+// Submit 将消息中的图片加入 OCR 队列, 队列已满时跳过. 应在消息的文档写入或进入 ingest 队列之后调用,
+// 以保证 OCR 结果在文档之后写入. client 用于下载图片
+func Submit(ctx context.Context, client *ext.Context, chatID int64, messages []*tg.Message) {
+	if jobs == nil {
+		return
+	}
+	skipped := 0
+	for _, j := range photoJobs(client, chatID, messages) {
+		select {
+		case jobs <- j:
+		default:
+			skipped++
+		}
+	}
+	if skipped > 0 {
+		dropped.Add(int64(skipped))
+		log.FromContext(ctx).Warn("OCR queue is full, skipping photos", "chat_id", chatID, "count", skipped)
+	}
+}
+
+// SubmitWait 与 Submit 相同, 但在队列已满时等待, 用于添加历史消息等不要求实时的场景
+func SubmitWait(ctx context.Context, client *ext.Context, chatID int64, messages []*tg.Message) error {
+	if jobs == nil {
+		return nil
+	}
+	for _, j := range photoJobs(client, chatID, messages) {
+		select {
+		case jobs <- j:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func photoJobs(client *ext.Context, chatID int64, messages []*tg.Message) []job {
+	var res []job
+	for _, msg := range messages {
+		media, ok := msg.GetMedia()
+		if !ok {
+			continue
+		}
+		if _, ok := media.(*tg.MessageMediaPhoto); !ok {
+			continue
+		}
+		res = append(res, job{client: client, chatID: chatID, messageID: msg.GetID(), media: media})
+	}
+	return res
+}
+
+func worker(ctx context.Context) {
+	logger := log.FromContext(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-jobs:
+			jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+			text, err := utils.OcrPhoto(jobCtx, j.client, j.media)
+			cancel()
+			if err != nil {
+				logger.Warn("OCR failed", "chat_id", j.chatID, "message_id", j.messageID, "error", err)
+				continue
+			}
+			text = strings.TrimSpace(text)
+			if text == "" {
+				continue
+			}
+			select {
+			case results <- result{chatID: j.chatID, messageID: j.messageID, text: text}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
+
+// collect 按聊天合并 OCR 结果, 定期或积累到 flushSize 条时写入 ingest 队列
+func collect(ctx context.Context) {
+	pending := make(map[int64]map[int]string)
+	count := 0
+	flush := func() {
+		for chatID, ocred := range pending {
+			if err := ingest.UpdateOcred(context.WithoutCancel(ctx), chatID, ocred); err != nil {
+				log.FromContext(ctx).Error("Failed to enqueue OCR results", "chat_id", chatID, "count", len(ocred), "error", err)
+			}
+		}
+		clear(pending)
+		count = 0
+	}
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			// 尽量保存已经完成的结果
+			flush()
+			return
+		case r := <-results:
+			if pending[r.chatID] == nil {
+				pending[r.chatID] = make(map[int]string)
+			}
+			pending[r.chatID][r.messageID] = r.text
+			count++
+			if count >= flushSize {
+				flush()
+			}
+		case <-ticker.C:
+			if count > 0 {
+				flush()
+			}
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package reindex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"github.com/krau/btts/rules"
+	"github.com/krau/btts/userclient"
+	"github.com/krau/btts/utils"
+	"github.com/krau/mygotg/ext"
+	"gorm.io/gorm"
+)
+
+type Status string
+
+const (
+	StatusRunning  Status = "running"
+	StatusDone     Status = "done"
+	StatusFailed   Status = "failed"
+	StatusCanceled Status = "canceled"
+)
+
+const (
+	// batchSize 是每次从 Telegram 获取的消息数, 每批处理完成后保存一次进度
+	batchSize = 100
+	// ocrTimeout 是单张图片下载和识别的超时时间
+	ocrTimeout = 2 * time.Minute
+)
+
+type Options struct {
+	ChatID int64
+	// 重新对图片进行 OCR, 否则保留已索引的 OCR 文本
+	Ocr bool
+	// 只处理此时间之后的消息, 零值表示整个聊天
+	Since time.Time
+}
+
+// Progress 是重建索引任务的状态快照
+type Progress struct {
+	ID        uint      `json:"id"`
+	ChatID    int64     `json:"chat_id"`
+	Ocr       bool      `json:"ocr"`
+	Since     time.Time `json:"since,omitzero"`
+	Status    Status    `json:"status"`
+	Processed int       `json:"processed"` // 已获取的消息数
+	Indexed   int       `json:"indexed"`   // 已写入的文档数
+	Ocred     int       `json:"ocred"`     // 识别出文字的图片数
+	LastID    int       `json:"last_id"`   // 已处理到的消息 ID
+	Resumed   bool      `json:"resumed,omitempty"`
+	Error     string    `json:"error,omitempty"`
+	StartedAt time.Time `json:"started_at"`
+	// 结束时间, 运行中为 nil
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+type Job struct {
+	mu       sync.Mutex
+	record   *database.ReindexJob
+	progress Progress
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// Progress 返回任务当前状态的副本
+func (j *Job) Progress() Progress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.progress
+}
+
+// Wait 等待任务结束
+func (j *Job) Wait() Progress {
+	<-j.done
+	return j.Progress()
+}
+
+// Cancel 取消任务, 使用相同参数重新运行时从中断处继续
+func (j *Job) Cancel() {
+	j.cancel()
+}
+
+var (
+	// jobs 是每个聊天最近一次的任务
+	jobs   = make(map[int64]*Job)
+	jobsMu sync.Mutex
+)
+
+// GetJob 返回聊天正在运行或最近一次的重建索引任务
+func GetJob(chatID int64) (*Job, bool) {
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	job, ok := jobs[chatID]
+	return job, ok
+}
+
+// Start 在后台重新获取聊天的消息并更新索引. 同一聊天同时只能有一个任务,
+// 存在相同参数的未完成任务时从其中断处继续. 任务不会随 ctx 取消, 需要调用 Job.Cancel
+func Start(ctx context.Context, searcher engine.Searcher, opts Options) (*Job, error) {
+	if _, err := database.GetIndexChat(ctx, opts.ChatID); err != nil {
+		return nil, fmt.Errorf("chat %d is not indexed: %w", opts.ChatID, err)
+	}
+	if opts.Ocr && !config.C().Ocr.Enable {
+		return nil, errors.New("OCR is not enabled in config")
+	}
+	var since int64
+	if !opts.Since.IsZero() {
+		since = opts.Since.Unix()
+	}
+
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if job, ok := jobs[opts.ChatID]; ok && job.Progress().Status == StatusRunning {
+		return nil, fmt.Errorf("chat %d is already being reindexed", opts.ChatID)
+	}
+	record, err := database.GetResumableReindexJob(ctx, opts.ChatID, opts.Ocr, since)
+	resumed := err == nil
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		record = &database.ReindexJob{ChatID: opts.ChatID, Ocr: opts.Ocr, Since: since}
+	}
+	record.Status = string(StatusRunning)
+	record.Error = ""
+	if err := database.SaveReindexJob(ctx, record); err != nil {
+		return nil, fmt.Errorf("failed to save reindex job: %w", err)
+	}
+
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	job := &Job{
+		record: record,
+		progress: Progress{
+			ID:        record.ID,
+			ChatID:    record.ChatID,
+			Ocr:       record.Ocr,
+			Since:     opts.Since,
+			Status:    StatusRunning,
+			Processed: record.Processed,
+			Indexed:   record.Indexed,
+			Ocred:     record.Ocred,
+			LastID:    record.LastID,
+			Resumed:   resumed,
+			StartedAt: time.Now(),
+		},
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	jobs[opts.ChatID] = job
+
+	go func() {
+		defer close(job.done)
+		defer cancel()
+		job.run(jobCtx, searcher)
+	}()
+	return job, nil
+}
+
+func (j *Job) run(ctx context.Context, searcher engine.Searcher) {
+	logger := log.FromContext(ctx).With("chat_id", j.record.ChatID, "reindex_job", j.record.ID)
+	logger.Info("Reindex started", "ocr", j.record.Ocr, "since", j.record.Since, "last_id", j.record.LastID)
+
+	err := j.reindex(ctx, searcher)
+
+	now := time.Now()
+	j.mu.Lock()
+	j.progress.FinishedAt = &now
+	switch {
+	case ctx.Err() != nil:
+		j.progress.Status = StatusCanceled
+	case err != nil:
+		j.progress.Status = StatusFailed
+		j.progress.Error = err.Error()
+	default:
+		j.progress.Status = StatusDone
+	}
+	j.record.Status = string(j.progress.Status)
+	j.record.Error = j.progress.Error
+	p := j.progress
+	j.mu.Unlock()
+	if err := database.SaveReindexJob(context.WithoutCancel(ctx), j.record); err != nil {
+		logger.Error("Failed to save reindex job", "error", err)
+	}
+	logger.Info("Reindex finished", "status", p.Status, "processed", p.Processed, "indexed", p.Indexed, "ocred", p.Ocred, "error", p.Error)
+}
+
+// reindex 从 LastID 开始从新到旧遍历聊天历史, 每批写入后保存进度.
+// FLOOD_WAIT 由用户客户端的 floodwait 中间件等待后重试
+func (j *Job) reindex(ctx context.Context, searcher engine.Searcher) error {
+	chatID := j.record.ChatID
+	account := userclient.GetUserClientForChat(chatID)
+	inputPeer := account.TClient.PeerStorage.GetInputPeerById(chatID)
+	if inputPeer == nil {
+		return fmt.Errorf("failed to get input peer of chat %d", chatID)
+	}
+	ectx := account.GetContext()
+	offsetID := j.record.LastID
+	for {
+		res, err := account.TClient.API().MessagesGetHistory(ctx, &tg.MessagesGetHistoryRequest{
+			Peer:     inputPeer,
+			OffsetID: offsetID,
+			Limit:    batchSize,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to get messages: %w", err)
+		}
+		var msgClass []tg.MessageClass
+		var users []tg.UserClass
+		switch msgs := res.(type) {
+		case *tg.MessagesMessages:
+			msgClass = msgs.GetMessages()
+			users = msgs.GetUsers()
+		case *tg.MessagesMessagesSlice:
+			msgClass = msgs.GetMessages()
+			users = msgs.GetUsers()
+		case *tg.MessagesChannelMessages:
+			msgClass = msgs.GetMessages()
+			users = msgs.GetUsers()
+		default:
+			return fmt.Errorf("unsupported messages type: %T", res)
+		}
+		if len(msgClass) == 0 {
+			return nil
+		}
+
+		batch := make([]*tg.Message, 0, len(msgClass))
+		reachedSince := false
+		for _, m := range msgClass {
+			msg, ok := m.(*tg.Message)
+			if ok && j.record.Since > 0 && int64(msg.Date) < j.record.Since {
+				reachedSince = true
+				break
+			}
+			offsetID = m.GetID()
+			if ok {
+				batch = append(batch, msg)
+			}
+		}
+		indexed, ocred, err := j.indexBatch(ctx, searcher, ectx, batch, rules.NewBots(users...))
+		if err != nil {
+			return err
+		}
+
+		j.mu.Lock()
+		j.record.LastID = offsetID
+		j.record.Processed += len(batch)
+		j.record.Indexed += indexed
+		j.record.Ocred += ocred
+		j.progress.LastID = j.record.LastID
+		j.progress.Processed = j.record.Processed
+		j.progress.Indexed = j.record.Indexed
+		j.progress.Ocred = j.record.Ocred
+		j.mu.Unlock()
+		if err := database.SaveReindexJob(context.WithoutCancel(ctx), j.record); err != nil {
+			return fmt.Errorf("failed to save progress: %w", err)
+		}
+		if reachedSince {
+			return nil
+		}
+	}
+}
+
+// indexBatch 按索引规则过滤一批消息后重新提取文本并写入搜索引擎, 返回写入的文档数和识别出文字的图片数
+func (j *Job) indexBatch(ctx context.Context, searcher engine.Searcher, ectx *ext.Context, messages []*tg.Message, bots rules.Bots) (int, int, error) {
+	chatID := j.record.ChatID
+	messages = rules.Get(ctx, chatID).Filter(messages, ectx.Self.ID, bots)
+	if len(messages) == 0 {
+		return 0, 0, nil
+	}
+	docs := engine.DocumentsFromMessages(ctx, messages, chatID, ectx.Self.ID, j.record.Ocr)
+	if len(docs) == 0 {
+		return 0, 0, nil
+	}
+	ids := make([]int, 0, len(docs))
+	for _, doc := range docs {
+		ids = append(ids, int(doc.ID))
+	}
+	indexed, err := searcher.GetDocuments(ctx, chatID, ids)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to get indexed documents: %w", err)
+	}
+	existing := make(map[int64]string, len(indexed))
+	for _, doc := range indexed {
+		existing[doc.ID] = doc.Ocred
+	}
+	var ocredTexts map[int]string
+	if j.record.Ocr {
+		ocredTexts = ocrMessages(ctx, ectx, messages)
+	}
+	for _, doc := range docs {
+		if text, ok := ocredTexts[int(doc.ID)]; ok {
+			doc.Ocred = text
+		} else {
+			// 未重新识别或识别失败时保留原有的 OCR 文本
+			doc.Ocred = existing[doc.ID]
+		}
+	}
+	if err := searcher.AddDocuments(ctx, chatID, docs); err != nil {
+		return 0, 0, fmt.Errorf("failed to add documents: %w", err)
+	}
+	return len(docs), len(ocredTexts), nil
+}
+
+// ocrMessages 并发识别消息中的图片, 返回识别出文字的消息 ID 到文本的映射
+func ocrMessages(ctx context.Context, ectx *ext.Context, messages []*tg.Message) map[int]string {
+	logger := log.FromContext(ctx)
+	res := make(map[int]string)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, max(config.C().Ocr.Workers, 1))
+	for _, msg := range messages {
+		media, ok := msg.GetMedia()
+		if !ok {
+			continue
+		}
+		if _, ok := media.(*tg.MessageMediaPhoto); !ok {
+			continue
+		}
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return res
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			ocrCtx, cancel := context.WithTimeout(ctx, ocrTimeout)
+			defer cancel()
+			text, err := utils.OcrPhoto(ocrCtx, ectx, media)
+			if err != nil {
+				logger.Warn("OCR failed", "message_id", msg.ID, "error", err)
+				return
+			}
+			if text == "" {
+				return
+			}
+			mu.Lock()
+			res[msg.ID] = text
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return res
+}
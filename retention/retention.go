@@ -0,0 +1,166 @@
+package retention
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+	"gorm.io/gorm"
+)
+
+// Policy 是一个聊天生效的保留策略
+type Policy struct {
+	// 消息保留天数, 0 表示永久保留
+	MaxAgeDays int `json:"max_age_days"`
+	// 最多保留的文档数, 0 表示不限制
+	MaxDocuments int64 `json:"max_documents"`
+	// 是否为聊天单独设置的策略, 否则为配置文件中的默认值
+	Custom bool `json:"custom"`
+}
+
+func (p Policy) Enabled() bool {
+	return p.MaxAgeDays > 0 || p.MaxDocuments > 0
+}
+
+func (p Policy) String() string {
+	if !p.Enabled() {
+		return "keep forever"
+	}
+	s := ""
+	if p.MaxAgeDays > 0 {
+		s = fmt.Sprintf("max age %d days", p.MaxAgeDays)
+	}
+	if p.MaxDocuments > 0 {
+		if s != "" {
+			s += ", "
+		}
+		s += fmt.Sprintf("max %d documents", p.MaxDocuments)
+	}
+	return s
+}
+
+// GetPolicy 返回聊天生效的保留策略, 没有单独设置时使用配置文件中的默认值
+func GetPolicy(ctx context.Context, chatID int64) (Policy, error) {
+	policy, err := database.GetRetentionPolicy(ctx, chatID)
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return Policy{}, err
+		}
+		return Policy{
+			MaxAgeDays:   config.C().Retention.MaxAgeDays,
+			MaxDocuments: config.C().Retention.MaxDocuments,
+		}, nil
+	}
+	return Policy{MaxAgeDays: policy.MaxAgeDays, MaxDocuments: policy.MaxDocuments, Custom: true}, nil
+}
+
+// Result 是对一个聊天执行 (或预演) 保留策略的结果
+type Result struct {
+	ChatID int64  `json:"chat_id"`
+	Policy Policy `json:"policy"`
+	// 早于此时间的文档会被删除, 零值表示没有需要删除的文档
+	Cutoff time.Time `json:"cutoff,omitzero"`
+	// 删除 (或预演时将会删除) 的文档数
+	Deleted int64  `json:"deleted"`
+	DryRun  bool   `json:"dry_run"`
+	Error   string `json:"error,omitempty"`
+}
+
+// runMu 避免定时任务和手动执行同时删除
+var runMu sync.Mutex
+
+// Run 对 chatIDs 中的聊天执行保留策略, chatIDs 为空时处理所有已索引的聊天.
+// dryRun 时只统计将会删除的文档数, 不做任何修改
+func Run(ctx context.Context, searcher engine.Searcher, dryRun bool, chatIDs ...int64) []Result {
+	runMu.Lock()
+	defer runMu.Unlock()
+	if len(chatIDs) == 0 {
+		chatIDs = database.AllChatIDs()
+	}
+	results := make([]Result, 0, len(chatIDs))
+	for _, chatID := range chatIDs {
+		if ctx.Err() != nil {
+			break
+		}
+		res := Result{ChatID: chatID, DryRun: dryRun}
+		if err := apply(ctx, searcher, &res); err != nil {
+			res.Error = err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+func apply(ctx context.Context, searcher engine.Searcher, res *Result) error {
+	policy, err := GetPolicy(ctx, res.ChatID)
+	if err != nil {
+		return fmt.Errorf("failed to get retention policy: %w", err)
+	}
+	res.Policy = policy
+	var cutoff int64
+	if policy.MaxAgeDays > 0 {
+		cutoff = time.Now().AddDate(0, 0, -policy.MaxAgeDays).Unix()
+	}
+	if policy.MaxDocuments > 0 {
+		// 保留最新的 MaxDocuments 条文档, 与最后一条同一秒的文档也会保留
+		ts, ok, err := searcher.NthNewestTimestamp(ctx, res.ChatID, policy.MaxDocuments-1)
+		if err != nil {
+			return fmt.Errorf("failed to get documents: %w", err)
+		}
+		if ok {
+			cutoff = max(cutoff, ts)
+		}
+	}
+	if cutoff == 0 {
+		return nil
+	}
+	count, err := searcher.CountDocumentsBefore(ctx, res.ChatID, cutoff)
+	if err != nil {
+		return fmt.Errorf("failed to count documents: %w", err)
+	}
+	if count == 0 {
+		return nil
+	}
+	res.Cutoff = time.Unix(cutoff, 0)
+	res.Deleted = count
+	if res.DryRun {
+		return nil
+	}
+	if err := searcher.DeleteDocumentsBefore(ctx, res.ChatID, cutoff); err != nil {
+		res.Deleted = 0
+		return fmt.Errorf("failed to delete documents: %w", err)
+	}
+	return nil
+}
+
+// Start 定期对所有聊天执行保留策略, 间隔由 retention.interval 配置
+func Start(ctx context.Context, searcher engine.Searcher) {
+	interval, err := time.ParseDuration(config.C().Retention.Interval)
+	if err != nil || interval <= 0 {
+		interval = time.Hour
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			for _, res := range Run(ctx, searcher, false) {
+				if res.Error != "" {
+					log.FromContext(ctx).Error("Failed to apply retention policy", "chat_id", res.ChatID, "error", res.Error)
+				} else if res.Deleted > 0 {
+					log.FromContext(ctx).Info("Deleted expired documents", "chat_id", res.ChatID, "deleted", res.Deleted, "cutoff", res.Cutoff, "policy", res.Policy)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
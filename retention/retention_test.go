@@ -0,0 +1,175 @@
+package retention
+
+import (
+	"context"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/engine"
+)
+
+func TestMain(m *testing.M) {
+	// 聊天的保留策略保存在数据库中, 在临时目录中初始化
+	dir, err := os.MkdirTemp("", "btts-retention-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir("data", 0o755); err != nil {
+		panic(err)
+	}
+	if err := database.InitDatabase(context.Background()); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// fakeSearcher 保存一个聊天中文档的时间 (unix 秒), 只实现保留策略用到的方法
+type fakeSearcher struct {
+	engine.Searcher
+	timestamps []int64
+}
+
+func (s *fakeSearcher) NthNewestTimestamp(_ context.Context, _ int64, n int64) (int64, bool, error) {
+	sorted := slices.Sorted(slices.Values(s.timestamps))
+	slices.Reverse(sorted)
+	if n >= int64(len(sorted)) {
+		return 0, false, nil
+	}
+	return sorted[n], true, nil
+}
+
+func (s *fakeSearcher) CountDocumentsBefore(_ context.Context, _ int64, before int64) (int64, error) {
+	var count int64
+	for _, ts := range s.timestamps {
+		if ts < before {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *fakeSearcher) DeleteDocumentsBefore(_ context.Context, _ int64, before int64) error {
+	s.timestamps = slices.DeleteFunc(s.timestamps, func(ts int64) bool { return ts < before })
+	return nil
+}
+
+func daysAgo(days int) int64 {
+	return time.Now().AddDate(0, 0, -days).Unix()
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     *database.RetentionPolicy
+		timestamps []int64
+		dryRun     bool
+		// 期望的截止时间, 0 表示没有需要删除的文档
+		cutoff  func() int64
+		deleted int64
+	}{
+		{
+			name:       "No policy",
+			timestamps: []int64{daysAgo(365), daysAgo(1)},
+			cutoff:     func() int64 { return 0 },
+		},
+		{
+			name:       "Max age",
+			policy:     &database.RetentionPolicy{MaxAgeDays: 7},
+			timestamps: []int64{daysAgo(10), daysAgo(8), daysAgo(1)},
+			cutoff:     func() int64 { return daysAgo(7) },
+			deleted:    2,
+		},
+		{
+			name:       "Max age, nothing expired",
+			policy:     &database.RetentionPolicy{MaxAgeDays: 7},
+			timestamps: []int64{daysAgo(6), daysAgo(1)},
+			cutoff:     func() int64 { return 0 },
+		},
+		{
+			name:       "Max documents",
+			policy:     &database.RetentionPolicy{MaxDocuments: 2},
+			timestamps: []int64{400, 100, 300, 200},
+			cutoff:     func() int64 { return 300 },
+			deleted:    2,
+		},
+		{
+			name:       "Max documents keeps documents in the same second",
+			policy:     &database.RetentionPolicy{MaxDocuments: 1},
+			timestamps: []int64{100, 200, 300, 300},
+			cutoff:     func() int64 { return 300 },
+			deleted:    2,
+		},
+		{
+			name:       "Max documents not reached",
+			policy:     &database.RetentionPolicy{MaxDocuments: 5},
+			timestamps: []int64{100, 200},
+			cutoff:     func() int64 { return 0 },
+		},
+		{
+			name:       "Both, max age is stricter",
+			policy:     &database.RetentionPolicy{MaxAgeDays: 7, MaxDocuments: 3},
+			timestamps: []int64{daysAgo(30), daysAgo(20), daysAgo(10), daysAgo(1)},
+			cutoff:     func() int64 { return daysAgo(7) },
+			deleted:    3,
+		},
+		{
+			name:       "Both, max documents is stricter",
+			policy:     &database.RetentionPolicy{MaxAgeDays: 30, MaxDocuments: 1},
+			timestamps: []int64{daysAgo(20), daysAgo(10), daysAgo(1)},
+			cutoff:     func() int64 { return daysAgo(1) },
+			deleted:    2,
+		},
+		{
+			name:       "Dry run",
+			policy:     &database.RetentionPolicy{MaxDocuments: 1},
+			timestamps: []int64{100, 200},
+			dryRun:     true,
+			cutoff:     func() int64 { return 200 },
+			deleted:    1,
+		},
+	}
+	ctx := context.Background()
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chatID := int64(1000 + i)
+			if tt.policy != nil {
+				tt.policy.ChatID = chatID
+				if err := database.UpsertRetentionPolicy(ctx, tt.policy); err != nil {
+					t.Fatal(err)
+				}
+			}
+			searcher := &fakeSearcher{timestamps: slices.Clone(tt.timestamps)}
+			expected := tt.cutoff()
+			res := &Result{ChatID: chatID, DryRun: tt.dryRun}
+			if err := apply(ctx, searcher, res); err != nil {
+				t.Fatalf("apply() error = %v", err)
+			}
+
+			if expected == 0 {
+				if !res.Cutoff.IsZero() {
+					t.Errorf("Cutoff = %v, expected zero", res.Cutoff)
+				}
+			} else if got := res.Cutoff.Unix(); got < expected || got > expected+5 {
+				// 按天数计算的截止时间取自 apply 执行时的当前时间
+				t.Errorf("Cutoff = %d, expected %d", got, expected)
+			}
+			if res.Deleted != tt.deleted {
+				t.Errorf("Deleted = %d, expected %d", res.Deleted, tt.deleted)
+			}
+			remaining := int64(len(searcher.timestamps))
+			if tt.dryRun && remaining != int64(len(tt.timestamps)) {
+				t.Errorf("dry run deleted %d documents", int64(len(tt.timestamps))-remaining)
+			}
+			if !tt.dryRun && remaining != int64(len(tt.timestamps))-tt.deleted {
+				t.Errorf("%d documents remaining, expected %d", remaining, int64(len(tt.timestamps))-tt.deleted)
+			}
+		})
+	}
+}
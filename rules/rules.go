@@ -0,0 +1,212 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/log"
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/database"
+	"github.com/krau/btts/types"
+	"github.com/krau/btts/utils"
+	"gorm.io/gorm"
+)
+
+// Rule 是编译后的聊天索引规则
+type Rule struct {
+	*database.IndexRule
+	include *regexp.Regexp
+	exclude *regexp.Regexp
+	types   map[types.MessageType]struct{}
+}
+
+// Compile 校验并编译索引规则
+func Compile(r *database.IndexRule) (*Rule, error) {
+	rule := &Rule{IndexRule: r}
+	var err error
+	if r.Include != "" {
+		if rule.include, err = regexp.Compile(r.Include); err != nil {
+			return nil, fmt.Errorf("invalid include pattern: %w", err)
+		}
+	}
+	if r.Exclude != "" {
+		if rule.exclude, err = regexp.Compile(r.Exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude pattern: %w", err)
+		}
+	}
+	if r.MinLength < 0 {
+		return nil, errors.New("min length must not be negative")
+	}
+	if len(r.Types) > 0 {
+		rule.types = make(map[types.MessageType]struct{}, len(r.Types))
+		for _, name := range r.Types {
+			t, ok := types.MessageTypeFromString[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown message type: %s", name)
+			}
+			rule.types[t] = struct{}{}
+		}
+	}
+	return rule, nil
+}
+
+type cachedRule struct {
+	rule    *Rule
+	version int64
+}
+
+// 编译后的规则缓存, 实时消息的每次索引都会读取规则. 规则被修改后 database.IndexRulesVersion 变化, 缓存随之失效
+var (
+	ruleCache   = make(map[int64]cachedRule)
+	ruleCacheMu sync.RWMutex
+)
+
+// Get 返回聊天的索引规则, 聊天没有规则时返回 nil.
+// 读取或编译失败时记录日志并返回 nil, 即索引所有消息, 以免因规则问题丢失消息
+func Get(ctx context.Context, chatID int64) *Rule {
+	// 在读取之前获取版本, 读取期间规则被修改时缓存的结果会在下次被丢弃
+	version := database.IndexRulesVersion()
+	ruleCacheMu.RLock()
+	cached, ok := ruleCache[chatID]
+	ruleCacheMu.RUnlock()
+	if ok && cached.version == version {
+		return cached.rule
+	}
+	rule, err := load(ctx, chatID)
+	if err != nil {
+		// 读取失败时不缓存, 下次重试
+		log.FromContext(ctx).Warn("Failed to get index rule", "chat_id", chatID, "error", err)
+		return nil
+	}
+	ruleCacheMu.Lock()
+	ruleCache[chatID] = cachedRule{rule: rule, version: version}
+	ruleCacheMu.Unlock()
+	return rule
+}
+
+// load 读取并编译聊天的索引规则, 没有规则或规则无效时返回 nil
+func load(ctx context.Context, chatID int64) (*Rule, error) {
+	r, err := database.GetIndexRule(ctx, chatID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	rule, err := Compile(r)
+	if err != nil {
+		log.FromContext(ctx).Warn("Invalid index rule, ignoring it", "chat_id", chatID, "error", err)
+		return nil, nil
+	}
+	return rule, nil
+}
+
+// Bots 是已知为 bot 的用户 ID 集合, 从 Telegram 返回的用户实体中收集
+type Bots map[int64]struct{}
+
+func NewBots(users ...tg.UserClass) Bots {
+	b := make(Bots)
+	b.Add(users...)
+	return b
+}
+
+func (b Bots) Add(users ...tg.UserClass) {
+	for _, u := range users {
+		if user, ok := u.(*tg.User); ok && user.Bot {
+			b[user.ID] = struct{}{}
+		}
+	}
+}
+
+// Filter 返回符合规则的消息, r 为 nil 时只过滤永不索引的用户. self 是当前用户账号的 ID
+func (r *Rule) Filter(messages []*tg.Message, self int64, bots Bots) []*tg.Message {
+	return slices.DeleteFunc(slices.Clone(messages), func(msg *tg.Message) bool {
+		return !r.Allow(msg, self, bots)
+	})
+}
+
+// Allow 检查一条消息是否应该被索引, 永不索引列表中的用户的消息总是被拒绝.
+// 文本规则检查消息自身的文本和媒体信息 (不包括 OCR 文本), 没有文本的相册成员会共享相册的说明文字, 因此不检查文本规则
+func (r *Rule) Allow(msg *tg.Message, self int64, bots Bots) bool {
+	senderID := utils.MessageSenderID(msg, self)
+	if database.NeverIndex(senderID) {
+		return false
+	}
+	if r == nil {
+		return true
+	}
+	if slices.Contains(r.IgnoreUsers, senderID) {
+		return false
+	}
+	if r.IgnoreBots {
+		if _, ok := bots[senderID]; ok {
+			return false
+		}
+		if _, ok := msg.GetViaBotID(); ok {
+			return false
+		}
+	}
+
+	var sb strings.Builder
+	messageType := types.MessageTypeText
+	if media, ok := msg.GetMedia(); ok {
+		if result := utils.ExtractMessageMediaText(media); result != nil {
+			sb.WriteString(result.Text)
+			messageType = result.Type
+		}
+	}
+	if r.types != nil {
+		if _, ok := r.types[messageType]; !ok {
+			return false
+		}
+	}
+
+	sb.WriteString(msg.GetMessage())
+	text := sb.String()
+	if groupedID, _ := msg.GetGroupedID(); groupedID != 0 && text == "" {
+		return true
+	}
+	if utf8.RuneCountInString(strings.TrimSpace(text)) < r.MinLength {
+		return false
+	}
+	if r.include != nil && !r.include.MatchString(text) {
+		return false
+	}
+	if r.exclude != nil && r.exclude.MatchString(text) {
+		return false
+	}
+	return true
+}
+
+// String 返回规则的可读描述
+func (r *Rule) String() string {
+	var sb strings.Builder
+	if len(r.IgnoreUsers) > 0 {
+		fmt.Fprintf(&sb, "Ignored users: %v\n", r.IgnoreUsers)
+	}
+	if r.IgnoreBots {
+		sb.WriteString("Ignore bots: yes\n")
+	}
+	if len(r.Types) > 0 {
+		fmt.Fprintf(&sb, "Types: %s\n", strings.Join(r.Types, ", "))
+	}
+	if r.MinLength > 0 {
+		fmt.Fprintf(&sb, "Min length: %d\n", r.MinLength)
+	}
+	if r.Include != "" {
+		fmt.Fprintf(&sb, "Include: %s\n", r.Include)
+	}
+	if r.Exclude != "" {
+		fmt.Fprintf(&sb, "Exclude: %s\n", r.Exclude)
+	}
+	if sb.Len() == 0 {
+		return "No rules, all messages are indexed"
+	}
+	return strings.TrimSpace(sb.String())
+}
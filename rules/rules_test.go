@@ -0,0 +1,283 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/gotd/td/tg"
+	"github.com/krau/btts/database"
+)
+
+const (
+	testSelf      = 1
+	testChannel   = 100
+	testUser      = 200
+	testBot       = 300
+	testForgotten = 400
+)
+
+func TestMain(m *testing.M) {
+	// 永不索引列表保存在数据库中, 在临时目录中初始化
+	dir, err := os.MkdirTemp("", "btts-rules-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	if err := os.Mkdir("data", 0o755); err != nil {
+		panic(err)
+	}
+	ctx := context.Background()
+	if err := database.InitDatabase(ctx); err != nil {
+		panic(err)
+	}
+	if err := database.AddNeverIndexUser(ctx, testForgotten); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func newMessage(sender int64, text string) *tg.Message {
+	return &tg.Message{
+		ID:      1,
+		PeerID:  &tg.PeerChannel{ChannelID: testChannel},
+		FromID:  &tg.PeerUser{UserID: sender},
+		Message: text,
+	}
+}
+
+func withMedia(msg *tg.Message, media tg.MessageMediaClass) *tg.Message {
+	msg.SetMedia(media)
+	return msg
+}
+
+func withGroupedID(msg *tg.Message, groupedID int64) *tg.Message {
+	msg.SetGroupedID(groupedID)
+	return msg
+}
+
+func withViaBot(msg *tg.Message, botID int64) *tg.Message {
+	msg.SetViaBotID(botID)
+	return msg
+}
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      database.IndexRule
+		expectErr bool
+	}{
+		{name: "Empty rule", rule: database.IndexRule{}},
+		{name: "Valid rule", rule: database.IndexRule{Types: []string{"text", "photo"}, MinLength: 3, Include: "^go", Exclude: "spam"}},
+		{name: "Invalid include", rule: database.IndexRule{Include: "("}, expectErr: true},
+		{name: "Invalid exclude", rule: database.IndexRule{Exclude: "[a-"}, expectErr: true},
+		{name: "Negative min length", rule: database.IndexRule{MinLength: -1}, expectErr: true},
+		{name: "Unknown type", rule: database.IndexRule{Types: []string{"hologram"}}, expectErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Compile(&tt.rule)
+			if (err != nil) != tt.expectErr {
+				t.Errorf("Compile() error = %v, expectErr %v", err, tt.expectErr)
+			}
+		})
+	}
+}
+
+func TestAllow(t *testing.T) {
+	bots := NewBots(&tg.User{ID: testBot, Bot: true}, &tg.User{ID: testUser})
+	tests := []struct {
+		name     string
+		rule     *database.IndexRule
+		message  *tg.Message
+		expected bool
+	}{
+		{
+			name:     "No rule",
+			message:  newMessage(testUser, "hello"),
+			expected: true,
+		},
+		{
+			name:     "No rule, never-index user",
+			message:  newMessage(testForgotten, "hello"),
+			expected: false,
+		},
+		{
+			name:     "Empty rule, never-index user",
+			rule:     &database.IndexRule{},
+			message:  newMessage(testForgotten, "hello"),
+			expected: false,
+		},
+		{
+			name:     "Ignored user",
+			rule:     &database.IndexRule{IgnoreUsers: []int64{testUser}},
+			message:  newMessage(testUser, "hello"),
+			expected: false,
+		},
+		{
+			name:     "Bot allowed",
+			rule:     &database.IndexRule{},
+			message:  newMessage(testBot, "hello"),
+			expected: true,
+		},
+		{
+			name:     "Bot ignored",
+			rule:     &database.IndexRule{IgnoreBots: true},
+			message:  newMessage(testBot, "hello"),
+			expected: false,
+		},
+		{
+			name:     "Via bot ignored",
+			rule:     &database.IndexRule{IgnoreBots: true},
+			message:  withViaBot(newMessage(testUser, "hello"), testBot),
+			expected: false,
+		},
+		{
+			name:     "User not ignored as bot",
+			rule:     &database.IndexRule{IgnoreBots: true},
+			message:  newMessage(testUser, "hello"),
+			expected: true,
+		},
+		{
+			name:     "Type allowed",
+			rule:     &database.IndexRule{Types: []string{"photo"}},
+			message:  withMedia(newMessage(testUser, "a photo"), &tg.MessageMediaPhoto{}),
+			expected: true,
+		},
+		{
+			name:     "Type rejected",
+			rule:     &database.IndexRule{Types: []string{"photo"}},
+			message:  newMessage(testUser, "just text"),
+			expected: false,
+		},
+		{
+			name:     "Min length reached",
+			rule:     &database.IndexRule{MinLength: 5},
+			message:  newMessage(testUser, "你好世界啊"),
+			expected: true,
+		},
+		{
+			name:     "Min length not reached",
+			rule:     &database.IndexRule{MinLength: 5},
+			message:  newMessage(testUser, "  hi  "),
+			expected: false,
+		},
+		{
+			name:     "Include matched",
+			rule:     &database.IndexRule{Include: "(?i)golang"},
+			message:  newMessage(testUser, "I like GoLang"),
+			expected: true,
+		},
+		{
+			name:     "Include not matched",
+			rule:     &database.IndexRule{Include: "(?i)golang"},
+			message:  newMessage(testUser, "I like rust"),
+			expected: false,
+		},
+		{
+			name:     "Exclude matched",
+			rule:     &database.IndexRule{Exclude: "^/"},
+			message:  newMessage(testUser, "/start"),
+			expected: false,
+		},
+		{
+			name:     "Exclude not matched",
+			rule:     &database.IndexRule{Exclude: "^/"},
+			message:  newMessage(testUser, "start"),
+			expected: true,
+		},
+		{
+			name:     "Album member without caption skips text rules",
+			rule:     &database.IndexRule{MinLength: 5, Include: "caption"},
+			message:  withGroupedID(withMedia(newMessage(testUser, ""), &tg.MessageMediaPhoto{}), 42),
+			expected: true,
+		},
+		{
+			name:     "Album member with caption checks text rules",
+			rule:     &database.IndexRule{Include: "caption"},
+			message:  withGroupedID(withMedia(newMessage(testUser, "something else"), &tg.MessageMediaPhoto{}), 42),
+			expected: false,
+		},
+		{
+			name:     "Album member still checks type",
+			rule:     &database.IndexRule{Types: []string{"text"}},
+			message:  withGroupedID(withMedia(newMessage(testUser, ""), &tg.MessageMediaPhoto{}), 42),
+			expected: false,
+		},
+		{
+			name:     "Album member still checks never-index",
+			rule:     &database.IndexRule{},
+			message:  withGroupedID(withMedia(newMessage(testForgotten, ""), &tg.MessageMediaPhoto{}), 42),
+			expected: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var rule *Rule
+			if tt.rule != nil {
+				var err error
+				rule, err = Compile(tt.rule)
+				if err != nil {
+					t.Fatalf("Compile() error = %v", err)
+				}
+			}
+			if got := rule.Allow(tt.message, testSelf, bots); got != tt.expected {
+				t.Errorf("Allow() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	rule, err := Compile(&database.IndexRule{IgnoreBots: true, MinLength: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	messages := []*tg.Message{
+		newMessage(testUser, "kept"),
+		newMessage(testBot, "from bot"),
+		newMessage(testUser, "no"),
+		newMessage(testForgotten, "forgotten"),
+		newMessage(testUser, "also kept"),
+	}
+	filtered := rule.Filter(messages, testSelf, NewBots(&tg.User{ID: testBot, Bot: true}))
+	if len(filtered) != 2 || filtered[0] != messages[0] || filtered[1] != messages[4] {
+		t.Errorf("Filter() returned %d messages, expected the first and the last", len(filtered))
+	}
+	if len(messages) != 5 || messages[1].FromID.(*tg.PeerUser).UserID != testBot {
+		t.Error("Filter() modified the input slice")
+	}
+}
+
+func TestGetInvalidatesCache(t *testing.T) {
+	ctx := context.Background()
+	const chatID = 12345
+	if rule := Get(ctx, chatID); rule != nil {
+		t.Fatalf("Get() = %v, expected nil for a chat without rules", rule)
+	}
+	if err := database.UpsertIndexRule(ctx, &database.IndexRule{ChatID: chatID, MinLength: 3}); err != nil {
+		t.Fatal(err)
+	}
+	rule := Get(ctx, chatID)
+	if rule == nil || rule.MinLength != 3 {
+		t.Fatalf("Get() = %v, expected the saved rule", rule)
+	}
+	if cached := Get(ctx, chatID); cached != rule {
+		t.Error("Get() did not return the cached rule")
+	}
+	if err := database.UpsertIndexRule(ctx, &database.IndexRule{ChatID: chatID, MinLength: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if rule := Get(ctx, chatID); rule == nil || rule.MinLength != 5 {
+		t.Errorf("Get() = %v, expected the updated rule", rule)
+	}
+	if err := database.DeleteIndexRule(ctx, chatID); err != nil {
+		t.Fatal(err)
+	}
+	if rule := Get(ctx, chatID); rule != nil {
+		t.Errorf("Get() = %v, expected nil after deleting the rule", rule)
+	}
+}
@@ -8,17 +8,21 @@ import (
 	"syscall"
 
 	"github.com/charmbracelet/log"
+	"github.com/gotd/td/tg"
 	"github.com/krau/btts/bot"
-	"github.com/krau/btts/config"
 	"github.com/krau/btts/userclient"
 	"github.com/krau/btts/utils"
+	"github.com/krau/mygotg/ext"
 )
 
 type TGFileFileReader struct {
 	RC       io.ReadCloser
-	Size     int64
+	Size     int64 // total size of the file, may be -1 or 0 if unknown
 	Name     string
 	FilePath string // set when backed by a disk cache file
+	// Offset and Length describe the byte range served by RC when it is a partial read
+	Offset int64
+	Length int64
 }
 
 func (r *TGFileFileReader) Read(p []byte) (n int, err error) {
@@ -29,11 +33,34 @@ func (r *TGFileFileReader) Close() error {
 	return r.RC.Close()
 }
 
-func GetTGFileReader(ctx context.Context, chatID int64, messageId int) (*TGFileFileReader, error) {
+// TGFileInfo 是文件的基本信息
+type TGFileInfo struct {
+	Name string
+	Size int64
+}
+
+// GetTGFileInfo 获取文件的名称和大小, 不会开始下载
+func GetTGFileInfo(ctx context.Context, chatID int64, messageId int) (*TGFileInfo, error) {
+	if fileCacheEnabled() {
+		initFileCache()
+		if e := fileCache.peek(chatID, messageId); e != nil {
+			return &TGFileInfo{Name: e.Name, Size: e.Size}, nil
+		}
+	}
+	tf, err := getTGFile(chatID, messageId)
+	if err != nil {
+		return nil, err
+	}
+	return &TGFileInfo{Name: tf.Name(), Size: tf.Size()}, nil
+}
+
+// GetTGFileReader 获取文件内容. length 大于 0 或 offset 大于 0 时只读取 [offset, offset+length) 区间,
+// 此时按分片从 Telegram 下载所需部分. 命中完整的磁盘缓存时总是返回整个文件 (FilePath 不为空)
+func GetTGFileReader(ctx context.Context, chatID int64, messageId int, offset, length int64) (*TGFileFileReader, error) {
 	logger := log.FromContext(ctx)
 
 	// Try disk cache first
-	if config.C.FileCache.Disable {
+	if fileCacheEnabled() {
 		initFileCache()
 		cached, err := getCachedFileReader(chatID, messageId)
 		if err == nil && cached != nil {
@@ -42,25 +69,29 @@ func GetTGFileReader(ctx context.Context, chatID int64, messageId int) (*TGFileF
 		}
 	}
 
-	ectx := bot.GetBot().GetContext()
-	msg, err := utils.GetMessageByID(ectx, chatID, messageId)
-	if err != nil || msg == nil {
-		ectx = userclient.GetUserClient().GetContext()
-		msg, err = utils.GetMessageByID(ectx, chatID, messageId)
-	}
+	tf, err := getTGFile(chatID, messageId)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get message %d in chat %d: %w", messageId, chatID, err)
+		return nil, err
 	}
-	if msg.Media == nil {
-		return nil, fmt.Errorf("message %d in chat %d has no media", messageId, chatID)
+	if offset > 0 || (length > 0 && length < tf.Size()) {
+		if tf.Size() <= 0 {
+			return nil, fmt.Errorf("range read is not supported for files with unknown size")
+		}
+		if offset >= tf.Size() {
+			return nil, fmt.Errorf("offset %d out of range for file size %d", offset, tf.Size())
+		}
+		if length <= 0 || offset+length > tf.Size() {
+			length = tf.Size() - offset
+		}
+		return &TGFileFileReader{
+			RC:     newRangeReader(ctx, tf, chatID, messageId, offset, length),
+			Size:   tf.Size(),
+			Name:   tf.Name(),
+			Offset: offset,
+			Length: length,
+		}, nil
 	}
 
-	media := msg.Media
-
-	tf, err := utils.FileFromMedia(media, ectx.Raw)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get file from media: %w", err)
-	}
 	pr, pw := io.Pipe()
 	go func() {
 		defer pw.Close()
@@ -75,16 +106,47 @@ func GetTGFileReader(ctx context.Context, chatID int64, messageId int) (*TGFileF
 		}
 	}()
 	result := &TGFileFileReader{
-		RC:   pr,
-		Size: tf.Size(),
-		Name: tf.Name(),
+		RC:     pr,
+		Size:   tf.Size(),
+		Name:   tf.Name(),
+		Length: tf.Size(),
 	}
 
 	// Wrap with disk cache if enabled
-	if config.C.FileCache.Disable {
+	if fileCacheEnabled() {
 		initFileCache()
 		result = wrapWithCache(ctx, result, chatID, messageId)
 	}
 
 	return result, nil
 }
+
+// getTGFile 获取消息中的文件
+func getTGFile(chatID int64, messageId int) (utils.TGFile, error) {
+	media, ectx, err := getMessageMedia(chatID, messageId)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := utils.FileFromMedia(media, ectx.Raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get file from media: %w", err)
+	}
+	return tf, nil
+}
+
+// getMessageMedia 获取消息中的媒体, 优先使用 bot 获取消息, 失败时使用拥有该聊天的用户账号
+func getMessageMedia(chatID int64, messageId int) (tg.MessageMediaClass, *ext.Context, error) {
+	ectx := bot.GetBot().GetContext()
+	msg, err := utils.GetMessageByID(ectx, chatID, messageId)
+	if err != nil || msg == nil {
+		ectx = userclient.GetUserClientForChat(chatID).GetContext()
+		msg, err = utils.GetMessageByID(ectx, chatID, messageId)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get message %d in chat %d: %w", messageId, chatID, err)
+	}
+	if msg.Media == nil {
+		return nil, nil, fmt.Errorf("message %d in chat %d has no media", messageId, chatID)
+	}
+	return msg.Media, ectx, nil
+}
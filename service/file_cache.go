@@ -1,60 +1,441 @@
 package service
 
 import (
+	"cmp"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/charmbracelet/log"
+	"github.com/dustin/go-humanize"
 	"github.com/krau/btts/config"
 )
 
+const (
+	FileCacheEvictionLRU = "lru"
+	FileCacheEvictionLFU = "lfu"
+)
+
 var (
 	fileCacheTTL  time.Duration
 	fileCacheOnce sync.Once
+	fileCache     = &fileCacheManager{entries: make(map[string]*FileCacheEntry), parts: make(map[string]*filePartsEntry)}
 )
 
 type fileCacheMeta struct {
-	Name     string `json:"name"`
-	Size     int64  `json:"size"`
-	Complete bool   `json:"complete"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Complete   bool      `json:"complete"`
+	Hits       int64     `json:"hits"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// FileCacheEntry 是一个已完成的缓存文件
+type FileCacheEntry struct {
+	ChatID     int64     `json:"chat_id"`
+	MessageID  int       `json:"message_id"`
+	Name       string    `json:"name"`
+	Size       int64     `json:"size"`
+	Hits       int64     `json:"hits"`
+	LastAccess time.Time `json:"last_access"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+func (e *FileCacheEntry) key() string {
+	return fileCacheKey(e.ChatID, e.MessageID)
+}
+
+// filePartsEntry 是一个尚未缓存完整的文件已缓存的分片, 分片齐全后合并为 FileCacheEntry
+type filePartsEntry struct {
+	chatID    int64
+	messageID int
+	// 文件的分片总数, 启动时从磁盘加载的记录在下次写入分片前为 0
+	total      int64
+	parts      map[int64]int64 // 分片序号 -> 大小
+	size       int64
+	lastAccess time.Time
+}
+
+func (p *filePartsEntry) key() string {
+	return fileCacheKey(p.chatID, p.messageID)
+}
+
+// FileCacheStats 是文件缓存的统计信息, Hits/Misses/Evictions 为本次启动以来的计数
+type FileCacheStats struct {
+	Enabled bool `json:"enabled"`
+	Entries int  `json:"entries"`
+	// 只缓存了部分分片的文件数
+	PartialFiles int `json:"partial_files"`
+	// 包括分片在内的缓存总大小
+	Size      int64  `json:"size"`
+	MaxSize   int64  `json:"max_size"`
+	Eviction  string `json:"eviction"`
+	Hits      int64  `json:"hits"`
+	Misses    int64  `json:"misses"`
+	Evictions int64  `json:"evictions"`
+}
+
+// fileCacheManager 维护缓存文件和分片的索引, 负责过期清理和按容量淘汰
+type fileCacheManager struct {
+	mu        sync.Mutex
+	entries   map[string]*FileCacheEntry
+	parts     map[string]*filePartsEntry
+	size      int64
+	maxSize   int64
+	eviction  string
+	hits      atomic.Int64
+	misses    atomic.Int64
+	evictions atomic.Int64
+}
+
+func fileCacheEnabled() bool {
+	return !config.C().FileCache.Disable
 }
 
 func initFileCache() {
 	fileCacheOnce.Do(func() {
-		ttl, err := time.ParseDuration(config.C.FileCache.TTL)
+		ttl, err := time.ParseDuration(config.C().FileCache.TTL)
 		if err != nil {
 			ttl = 24 * time.Hour
 		}
 		fileCacheTTL = ttl
-		dir := config.C.FileCache.Dir
+		if config.C().FileCache.MaxSize != "" {
+			maxSize, err := humanize.ParseBytes(config.C().FileCache.MaxSize)
+			if err != nil {
+				log.Errorf("Invalid file cache max size %q, cache size is unlimited: %v", config.C().FileCache.MaxSize, err)
+			}
+			fileCache.maxSize = int64(maxSize)
+		}
+		fileCache.eviction = strings.ToLower(config.C().FileCache.Eviction)
+		if fileCache.eviction != FileCacheEvictionLFU {
+			fileCache.eviction = FileCacheEvictionLRU
+		}
+		dir := config.C().FileCache.Dir
 		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
 			log.Errorf("Failed to create file cache directory %s: %v", dir, err)
 		}
+		fileCache.load()
 		go fileCacheCleanupLoop()
 	})
 }
 
+func fileCacheKey(chatID int64, messageID int) string {
+	return fmt.Sprintf("%d_%d", chatID, messageID)
+}
+
 func fileCachePath(chatID int64, messageID int) string {
-	return filepath.Join(config.C.FileCache.Dir, fmt.Sprintf("%d_%d.cache", chatID, messageID))
+	return filepath.Join(config.C().FileCache.Dir, fileCacheKey(chatID, messageID)+".cache")
 }
 
 func fileCacheMetaPath(chatID int64, messageID int) string {
-	return filepath.Join(config.C.FileCache.Dir, fmt.Sprintf("%d_%d.meta", chatID, messageID))
+	return filepath.Join(config.C().FileCache.Dir, fileCacheKey(chatID, messageID)+".meta")
 }
 
 func fileCacheTmpPath(chatID int64, messageID int) string {
 	// Include goroutine-unique suffix to avoid conflicts from concurrent requests for the same file
-	return filepath.Join(config.C.FileCache.Dir, fmt.Sprintf("%d_%d.%d.cache.tmp", chatID, messageID, time.Now().UnixNano()))
+	return filepath.Join(config.C().FileCache.Dir, fmt.Sprintf("%d_%d.%d.cache.tmp", chatID, messageID, time.Now().UnixNano()))
+}
+
+// load 扫描缓存目录重建索引, 丢弃没有 meta 或不完整的缓存文件
+func (m *fileCacheManager) load() {
+	dir := config.C().FileCache.Dir
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Errorf("Failed to read file cache directory: %v", err)
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var partsDirs []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() && strings.HasSuffix(name, ".parts") {
+			partsDirs = append(partsDirs, name)
+			continue
+		}
+		if entry.IsDir() || !strings.HasSuffix(name, ".cache") {
+			continue
+		}
+		var chatID int64
+		var messageID int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, ".cache"), "%d_%d", &chatID, &messageID); err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		meta, err := readCacheMeta(chatID, messageID)
+		if err != nil || !meta.Complete {
+			removeCacheFiles(chatID, messageID)
+			continue
+		}
+		lastAccess := meta.LastAccess
+		if lastAccess.IsZero() {
+			lastAccess = info.ModTime()
+		}
+		e := &FileCacheEntry{
+			ChatID:     chatID,
+			MessageID:  messageID,
+			Name:       meta.Name,
+			Size:       info.Size(),
+			Hits:       meta.Hits,
+			LastAccess: lastAccess,
+			CreatedAt:  info.ModTime(),
+		}
+		m.entries[e.key()] = e
+		m.size += e.Size
+	}
+	for _, name := range partsDirs {
+		m.loadPartsLocked(name)
+	}
+	m.evictLocked(0, "")
+	log.Info("File cache loaded", "entries", len(m.entries), "partial_files", len(m.parts), "size", humanize.IBytes(uint64(m.size)))
+}
+
+// loadPartsLocked 将启动前留下的分片目录加入索引
+func (m *fileCacheManager) loadPartsLocked(name string) {
+	dir := filepath.Join(config.C().FileCache.Dir, name)
+	p := &filePartsEntry{parts: make(map[int64]int64)}
+	if _, err := fmt.Sscanf(strings.TrimSuffix(name, ".parts"), "%d_%d", &p.chatID, &p.messageID); err != nil {
+		return
+	}
+	if _, ok := m.entries[p.key()]; ok {
+		os.RemoveAll(dir)
+		return
+	}
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		index, err := strconv.ParseInt(strings.TrimSuffix(f.Name(), ".part"), 10, 64)
+		if err != nil || !strings.HasSuffix(f.Name(), ".part") {
+			continue
+		}
+		info, err := f.Info()
+		if err != nil {
+			continue
+		}
+		p.parts[index] = info.Size()
+		p.size += info.Size()
+		if info.ModTime().After(p.lastAccess) {
+			p.lastAccess = info.ModTime()
+		}
+	}
+	if len(p.parts) == 0 {
+		os.RemoveAll(dir)
+		return
+	}
+	m.parts[p.key()] = p
+	m.size += p.size
+}
+
+// get 返回未过期的缓存项并记录一次访问
+func (m *fileCacheManager) get(chatID int64, messageID int) *FileCacheEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[fileCacheKey(chatID, messageID)]
+	if !ok {
+		return nil
+	}
+	if time.Since(e.CreatedAt) > fileCacheTTL {
+		m.removeLocked(e)
+		return nil
+	}
+	e.Hits++
+	e.LastAccess = time.Now()
+	if err := writeCacheMeta(chatID, messageID, &fileCacheMeta{
+		Name:       e.Name,
+		Size:       e.Size,
+		Complete:   true,
+		Hits:       e.Hits,
+		LastAccess: e.LastAccess,
+	}); err != nil {
+		log.Warn("Failed to update cache meta file", "error", err)
+	}
+	copied := *e
+	return &copied
+}
+
+// peek 返回未过期的缓存项, 不记录访问
+func (m *fileCacheManager) peek(chatID int64, messageID int) *FileCacheEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[fileCacheKey(chatID, messageID)]
+	if !ok || time.Since(e.CreatedAt) > fileCacheTTL {
+		return nil
+	}
+	copied := *e
+	return &copied
+}
+
+// add 将一个新完成的缓存文件加入索引, 必要时淘汰旧的缓存
+func (m *fileCacheManager) add(e *FileCacheEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if old, ok := m.entries[e.key()]; ok {
+		m.size -= old.Size
+	}
+	m.entries[e.key()] = e
+	m.size += e.Size
+	m.evictLocked(0, e.key())
+}
+
+// addPart 记录一个新写入的分片, 必要时淘汰其他缓存, 返回该文件的分片是否已经齐全
+func (m *fileCacheManager) addPart(chatID int64, messageID int, index, size, total int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := fileCacheKey(chatID, messageID)
+	p, ok := m.parts[key]
+	if !ok {
+		p = &filePartsEntry{chatID: chatID, messageID: messageID, parts: make(map[int64]int64)}
+		m.parts[key] = p
+	}
+	if old, ok := p.parts[index]; ok {
+		p.size -= old
+		m.size -= old
+	}
+	p.total = total
+	p.parts[index] = size
+	p.size += size
+	m.size += size
+	p.lastAccess = time.Now()
+	m.evictLocked(0, key)
+	return int64(len(p.parts)) >= p.total
+}
+
+// touchParts 记录一次对已缓存分片的访问
+func (m *fileCacheManager) touchParts(chatID int64, messageID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.parts[fileCacheKey(chatID, messageID)]; ok {
+		p.lastAccess = time.Now()
+	}
+}
+
+// removeParts 删除文件的全部分片
+func (m *fileCacheManager) removeParts(chatID int64, messageID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p, ok := m.parts[fileCacheKey(chatID, messageID)]; ok {
+		m.removePartsLocked(p)
+	}
+}
+
+func (m *fileCacheManager) removePartsLocked(p *filePartsEntry) {
+	delete(m.parts, p.key())
+	m.size -= p.size
+	os.RemoveAll(fileCachePartsDir(p.chatID, p.messageID))
+}
+
+func (m *fileCacheManager) hasParts(chatID int64, messageID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.parts[fileCacheKey(chatID, messageID)]
+	return ok
 }
 
-// fileCacheCleanupLoop periodically scans the cache directory and removes expired files.
+// reserve 为即将写入的 size 字节腾出空间. 超过最大容量的文件不会被缓存
+func (m *fileCacheManager) reserve(size int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.maxSize > 0 && size > m.maxSize {
+		return false
+	}
+	m.evictLocked(size, "")
+	return true
+}
+
+// evictLocked 按淘汰策略删除缓存文件和分片, 直到剩余容量至少为 reserve 字节. keep 为正在写入的缓存, 不会被淘汰.
+// 分片没有命中次数, LFU 时总是先于完整的缓存文件被淘汰
+func (m *fileCacheManager) evictLocked(reserve int64, keep string) {
+	if m.maxSize <= 0 || m.size+reserve <= m.maxSize {
+		return
+	}
+	type candidate struct {
+		hits       int64
+		lastAccess time.Time
+		remove     func()
+	}
+	candidates := make([]candidate, 0, len(m.entries)+len(m.parts))
+	for key, e := range m.entries {
+		if key != keep {
+			candidates = append(candidates, candidate{e.Hits, e.LastAccess, func() { m.removeLocked(e) }})
+		}
+	}
+	for key, p := range m.parts {
+		if key != keep {
+			candidates = append(candidates, candidate{0, p.lastAccess, func() { m.removePartsLocked(p) }})
+		}
+	}
+	slices.SortFunc(candidates, func(a, b candidate) int {
+		if m.eviction == FileCacheEvictionLFU {
+			if c := cmp.Compare(a.hits, b.hits); c != 0 {
+				return c
+			}
+		}
+		return a.lastAccess.Compare(b.lastAccess)
+	})
+	for _, c := range candidates {
+		if m.size+reserve <= m.maxSize {
+			break
+		}
+		c.remove()
+		m.evictions.Add(1)
+	}
+}
+
+func (m *fileCacheManager) removeLocked(e *FileCacheEntry) {
+	delete(m.entries, e.key())
+	m.size -= e.Size
+	if p, ok := m.parts[e.key()]; ok {
+		m.removePartsLocked(p)
+	}
+	removeCacheFiles(e.ChatID, e.MessageID)
+}
+
+func (m *fileCacheManager) removeExpired() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if time.Since(e.CreatedAt) > fileCacheTTL {
+			m.removeLocked(e)
+		}
+	}
+	// 在 TTL 内没有再被访问的分片不太可能被补齐
+	for _, p := range m.parts {
+		if time.Since(p.lastAccess) > fileCacheTTL {
+			m.removePartsLocked(p)
+		}
+	}
+}
+
+func (m *fileCacheManager) contains(chatID int64, messageID int) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, ok := m.entries[fileCacheKey(chatID, messageID)]
+	return ok
+}
+
+func removeCacheFiles(chatID int64, messageID int) {
+	if err := os.Remove(fileCachePath(chatID, messageID)); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to remove cache file: %v", err)
+	}
+	os.Remove(fileCacheMetaPath(chatID, messageID))
+	os.RemoveAll(fileCachePartsDir(chatID, messageID))
+}
+
+// fileCacheCleanupLoop periodically removes expired entries and stale files.
 func fileCacheCleanupLoop() {
 	cleanExpiredCache()
 
@@ -67,7 +448,10 @@ func fileCacheCleanupLoop() {
 }
 
 func cleanExpiredCache() {
-	dir := config.C.FileCache.Dir
+	fileCache.removeExpired()
+	cleanExpiredThumbs()
+
+	dir := config.C().FileCache.Dir
 	entries, err := os.ReadDir(dir)
 	if err != nil {
 		log.Errorf("Failed to read file cache directory: %v", err)
@@ -75,10 +459,22 @@ func cleanExpiredCache() {
 	}
 	now := time.Now()
 	for _, entry := range entries {
+		name := entry.Name()
 		if entry.IsDir() {
+			// Part directories not tracked by the index are orphans, tracked ones expire in removeExpired
+			if strings.HasSuffix(name, ".parts") {
+				var chatID int64
+				var messageID int
+				if _, err := fmt.Sscanf(strings.TrimSuffix(name, ".parts"), "%d_%d", &chatID, &messageID); err != nil || fileCache.hasParts(chatID, messageID) {
+					continue
+				}
+				info, err := entry.Info()
+				if err == nil && now.Sub(info.ModTime()) > fileCacheTTL {
+					os.RemoveAll(filepath.Join(dir, name))
+				}
+			}
 			continue
 		}
-		name := entry.Name()
 		// Clean up stale .tmp files (older than TTL)
 		if strings.Contains(name, ".cache.tmp") {
 			info, err := entry.Info()
@@ -93,17 +489,14 @@ func cleanExpiredCache() {
 		if !strings.HasSuffix(name, ".cache") {
 			continue
 		}
-		info, err := entry.Info()
-		if err != nil {
+		// Files not tracked by the index (e.g. failed removal on Windows) are orphans
+		var chatID int64
+		var messageID int
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, ".cache"), "%d_%d", &chatID, &messageID); err != nil {
 			continue
 		}
-		if now.Sub(info.ModTime()) > fileCacheTTL {
-			cachePath := filepath.Join(dir, name)
-			metaPath := strings.TrimSuffix(cachePath, ".cache") + ".meta"
-			if err := os.Remove(cachePath); err != nil {
-				log.Errorf("Failed to remove expired cache file %s: %v", cachePath, err)
-			}
-			os.Remove(metaPath)
+		if !fileCache.contains(chatID, messageID) {
+			removeCacheFiles(chatID, messageID)
 		}
 	}
 }
@@ -129,43 +522,103 @@ func writeCacheMeta(chatID int64, messageID int, meta *fileCacheMeta) error {
 }
 
 // getCachedFileReader returns a TGFileFileReader for a cached file if it exists and is not expired.
-// Lock-free: only reads finalized .cache files (never .tmp), so no conflict with ongoing writes.
+// Only finalized .cache files (never .tmp) are tracked, so no conflict with ongoing writes.
 func getCachedFileReader(chatID int64, messageID int) (*TGFileFileReader, error) {
-	path := fileCachePath(chatID, messageID)
-
-	info, err := os.Stat(path)
-	if err != nil {
-		return nil, nil // cache miss
-	}
-	if time.Since(info.ModTime()) > fileCacheTTL {
-		os.Remove(path)
-		os.Remove(fileCacheMetaPath(chatID, messageID))
-		return nil, nil // expired
-	}
-	meta, err := readCacheMeta(chatID, messageID)
-	if err != nil {
-		// No meta → cache is unusable (can't determine filename/MIME).
-		os.Remove(path)
-		return nil, nil
-	}
-	if !meta.Complete {
-		// Incomplete cache (partial read last time) → discard and re-download.
-		os.Remove(path)
-		os.Remove(fileCacheMetaPath(chatID, messageID))
+	e := fileCache.get(chatID, messageID)
+	if e == nil {
+		fileCache.misses.Add(1)
 		return nil, nil
 	}
+	path := fileCachePath(chatID, messageID)
 	f, err := os.Open(path)
 	if err != nil {
+		fileCache.misses.Add(1)
+		fileCache.mu.Lock()
+		fileCache.removeLocked(e)
+		fileCache.mu.Unlock()
 		return nil, nil
 	}
+	fileCache.hits.Add(1)
 	return &TGFileFileReader{
 		RC:       f,
-		Size:     info.Size(),
-		Name:     meta.Name,
+		Size:     e.Size,
+		Name:     e.Name,
 		FilePath: path,
+		Length:   e.Size,
 	}, nil
 }
 
+// GetFileCacheStats 返回文件缓存的统计信息
+func GetFileCacheStats() FileCacheStats {
+	stats := FileCacheStats{Enabled: fileCacheEnabled()}
+	if !stats.Enabled {
+		return stats
+	}
+	initFileCache()
+	fileCache.mu.Lock()
+	defer fileCache.mu.Unlock()
+	stats.Entries = len(fileCache.entries)
+	stats.PartialFiles = len(fileCache.parts)
+	stats.Size = fileCache.size
+	stats.MaxSize = fileCache.maxSize
+	stats.Eviction = fileCache.eviction
+	stats.Hits = fileCache.hits.Load()
+	stats.Misses = fileCache.misses.Load()
+	stats.Evictions = fileCache.evictions.Load()
+	return stats
+}
+
+// GetFileCacheEntries 返回所有缓存项, 按最近访问时间倒序
+func GetFileCacheEntries() []FileCacheEntry {
+	if !fileCacheEnabled() {
+		return nil
+	}
+	initFileCache()
+	fileCache.mu.Lock()
+	defer fileCache.mu.Unlock()
+	res := make([]FileCacheEntry, 0, len(fileCache.entries))
+	for _, e := range fileCache.entries {
+		res = append(res, *e)
+	}
+	slices.SortFunc(res, func(a, b FileCacheEntry) int {
+		return b.LastAccess.Compare(a.LastAccess)
+	})
+	return res
+}
+
+// PurgeFileCache 删除缓存项并返回删除的数量. chatID 为 0 时删除全部, messageID 为 0 时删除该聊天的全部缓存
+func PurgeFileCache(chatID int64, messageID int) int {
+	if !fileCacheEnabled() {
+		return 0
+	}
+	initFileCache()
+	purgeThumbs(chatID, messageID)
+	fileCache.mu.Lock()
+	defer fileCache.mu.Unlock()
+	purged := 0
+	for _, e := range fileCache.entries {
+		if chatID != 0 && e.ChatID != chatID {
+			continue
+		}
+		if messageID != 0 && e.MessageID != messageID {
+			continue
+		}
+		fileCache.removeLocked(e)
+		purged++
+	}
+	for _, p := range fileCache.parts {
+		if chatID != 0 && p.chatID != chatID {
+			continue
+		}
+		if messageID != 0 && p.messageID != messageID {
+			continue
+		}
+		fileCache.removePartsLocked(p)
+		purged++
+	}
+	return purged
+}
+
 // cacheWriter wraps the original reader and simultaneously writes data to a .tmp cache file.
 // On Close, the .tmp file handle is closed first, then renamed to .cache atomically.
 // No locks are held during streaming — the caller gets data immediately as it arrives.
@@ -219,46 +672,77 @@ func (cw *cacheWriter) Close() error {
 	// Close the file handle FIRST — releases the Windows file lock before rename
 	cw.cacheFile.Close()
 
-	cachePath := fileCachePath(cw.chatID, cw.messageID)
-
+	// Only complete downloads are kept, partial reads would never be served anyway
 	complete := cw.fileSize > 0 && cw.written == cw.fileSize
-
-	if cw.written > 0 && !cw.writeErr {
-		// If a finalized cache already exists (another concurrent request won the race),
-		// just discard our tmp file.
-		if _, err := os.Stat(cachePath); err == nil {
-			os.Remove(cw.tmpPath)
-		} else if err := os.Rename(cw.tmpPath, cachePath); err != nil {
-			cw.logger.Warn("Failed to finalize cache file, will retry next time", "error", err)
-			os.Remove(cw.tmpPath)
-		} else {
-			meta := &fileCacheMeta{
-				Name:     cw.fileName,
-				Size:     cw.fileSize,
-				Complete: complete,
-			}
-			if err := writeCacheMeta(cw.chatID, cw.messageID, meta); err != nil {
-				cw.logger.Error("Failed to write cache meta file", "error", err)
-			}
-		}
-	} else {
+	if !complete || cw.writeErr {
 		os.Remove(cw.tmpPath)
+		return origErr
 	}
+	// If a finalized cache already exists (another concurrent request won the race),
+	// just discard our tmp file.
+	if fileCache.contains(cw.chatID, cw.messageID) {
+		os.Remove(cw.tmpPath)
+		return origErr
+	}
+	finalizeCacheFile(cw.tmpPath, cw.chatID, cw.messageID, cw.fileName, cw.written, cw.logger)
 	return origErr
 }
 
+// finalizeCacheFile 将完整下载的临时文件移动为缓存文件并加入索引
+func finalizeCacheFile(tmpPath string, chatID int64, messageID int, fileName string, size int64, logger *log.Logger) {
+	if info, err := os.Stat(tmpPath); err != nil || info.Size() != size {
+		os.Remove(tmpPath)
+		return
+	}
+	if !fileCache.reserve(size) {
+		os.Remove(tmpPath)
+		return
+	}
+	cachePath := fileCachePath(chatID, messageID)
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		logger.Warn("Failed to finalize cache file, will retry next time", "error", err)
+		os.Remove(tmpPath)
+		return
+	}
+	now := time.Now()
+	meta := &fileCacheMeta{
+		Name:       fileName,
+		Size:       size,
+		Complete:   true,
+		LastAccess: now,
+	}
+	if err := writeCacheMeta(chatID, messageID, meta); err != nil {
+		logger.Error("Failed to write cache meta file", "error", err)
+		os.Remove(cachePath)
+		return
+	}
+	fileCache.add(&FileCacheEntry{
+		ChatID:     chatID,
+		MessageID:  messageID,
+		Name:       fileName,
+		Size:       size,
+		LastAccess: now,
+		CreatedAt:  now,
+	})
+}
+
 // wrapWithCache wraps a TGFileFileReader so that read data is streamed to the caller
 // and simultaneously written to a disk cache file. No locks are held during streaming.
 func wrapWithCache(ctx context.Context, reader *TGFileFileReader, chatID int64, messageID int) *TGFileFileReader {
 	logger := log.FromContext(ctx)
+	if fileCache.maxSize > 0 && reader.Size > fileCache.maxSize {
+		logger.Debug("File is larger than the cache, serving without cache", "size", reader.Size)
+		return reader
+	}
 	cw, err := newCacheWriter(reader.RC, chatID, messageID, reader.Name, reader.Size, logger)
 	if err != nil {
 		logger.Error("Failed to create cache writer, serving without cache", "error", err)
 		return reader
 	}
 	return &TGFileFileReader{
-		RC:   cw,
-		Size: reader.Size,
-		Name: reader.Name,
+		RC:     cw,
+		Size:   reader.Size,
+		Name:   reader.Name,
+		Length: reader.Length,
 	}
 }
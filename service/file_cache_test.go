@@ -0,0 +1,205 @@
+package service
+
+import (
+	"os"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	// 默认配置的缓存目录为空, 缓存文件相对于工作目录, 在临时目录中运行
+	dir, err := os.MkdirTemp("", "btts-service-test")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chdir(dir); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// newTestEntry 返回一个 1 字节的缓存文件, 消息 ID 同时作为 key 区分
+func newTestEntry(t *testing.T, messageID int, hits int64, lastAccess time.Time) *FileCacheEntry {
+	t.Helper()
+	if err := os.WriteFile(fileCachePath(1, messageID), []byte("x"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return &FileCacheEntry{ChatID: 1, MessageID: messageID, Size: 1, Hits: hits, LastAccess: lastAccess}
+}
+
+func newTestParts(t *testing.T, messageID int, lastAccess time.Time) *filePartsEntry {
+	t.Helper()
+	if err := os.MkdirAll(fileCachePartsDir(1, messageID), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return &filePartsEntry{chatID: 1, messageID: messageID, total: 2, parts: map[int64]int64{0: 1}, size: 1, lastAccess: lastAccess}
+}
+
+func TestEvictLocked(t *testing.T) {
+	now := time.Now()
+	ago := func(minutes int) time.Time { return now.Add(-time.Duration(minutes) * time.Minute) }
+	tests := []struct {
+		name     string
+		eviction string
+		maxSize  int64
+		reserve  int64
+		keep     int
+		entries  func(t *testing.T) []*FileCacheEntry
+		parts    func(t *testing.T) []*filePartsEntry
+		// 淘汰后剩余的消息 ID
+		expected []int
+	}{
+		{
+			name:     "Unlimited",
+			eviction: FileCacheEvictionLRU,
+			reserve:  100,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 0, ago(3)), newTestEntry(t, 2, 0, ago(2))}
+			},
+			expected: []int{1, 2},
+		},
+		{
+			name:     "Within max size",
+			eviction: FileCacheEvictionLRU,
+			maxSize:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 0, ago(3)), newTestEntry(t, 2, 0, ago(2))}
+			},
+			expected: []int{1, 2},
+		},
+		{
+			name:     "LRU evicts least recently used",
+			eviction: FileCacheEvictionLRU,
+			maxSize:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 10, ago(3)), newTestEntry(t, 2, 0, ago(1)), newTestEntry(t, 3, 5, ago(2))}
+			},
+			expected: []int{2, 3},
+		},
+		{
+			name:     "LRU makes room for reserve",
+			eviction: FileCacheEvictionLRU,
+			maxSize:  3,
+			reserve:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 0, ago(3)), newTestEntry(t, 2, 0, ago(1)), newTestEntry(t, 3, 0, ago(2))}
+			},
+			expected: []int{2},
+		},
+		{
+			name:     "LRU treats parts like files",
+			eviction: FileCacheEvictionLRU,
+			maxSize:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 0, ago(3)), newTestEntry(t, 2, 0, ago(1))}
+			},
+			parts: func(t *testing.T) []*filePartsEntry {
+				return []*filePartsEntry{newTestParts(t, 3, ago(2))}
+			},
+			expected: []int{2, 3},
+		},
+		{
+			name:     "LFU evicts least frequently used",
+			eviction: FileCacheEvictionLFU,
+			maxSize:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 10, ago(3)), newTestEntry(t, 2, 0, ago(1)), newTestEntry(t, 3, 5, ago(2))}
+			},
+			expected: []int{1, 3},
+		},
+		{
+			name:     "LFU breaks ties by last access",
+			eviction: FileCacheEvictionLFU,
+			maxSize:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 1, ago(1)), newTestEntry(t, 2, 1, ago(3)), newTestEntry(t, 3, 5, ago(2))}
+			},
+			expected: []int{1, 3},
+		},
+		{
+			name:     "LFU evicts parts first",
+			eviction: FileCacheEvictionLFU,
+			maxSize:  2,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 1, ago(3)), newTestEntry(t, 2, 1, ago(2))}
+			},
+			parts: func(t *testing.T) []*filePartsEntry {
+				return []*filePartsEntry{newTestParts(t, 3, ago(1))}
+			},
+			expected: []int{1, 2},
+		},
+		{
+			name:     "Keep is never evicted",
+			eviction: FileCacheEvictionLRU,
+			maxSize:  2,
+			keep:     1,
+			entries: func(t *testing.T) []*FileCacheEntry {
+				return []*FileCacheEntry{newTestEntry(t, 1, 0, ago(3)), newTestEntry(t, 2, 0, ago(2)), newTestEntry(t, 3, 0, ago(1))}
+			},
+			expected: []int{1, 3},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &fileCacheManager{
+				entries:  make(map[string]*FileCacheEntry),
+				parts:    make(map[string]*filePartsEntry),
+				maxSize:  tt.maxSize,
+				eviction: tt.eviction,
+			}
+			var all []int
+			for _, e := range tt.entries(t) {
+				m.entries[e.key()] = e
+				m.size += e.Size
+				all = append(all, e.MessageID)
+			}
+			if tt.parts != nil {
+				for _, p := range tt.parts(t) {
+					m.parts[p.key()] = p
+					m.size += p.size
+					all = append(all, p.messageID)
+				}
+			}
+			t.Cleanup(func() {
+				for _, id := range all {
+					removeCacheFiles(1, id)
+				}
+			})
+			keep := ""
+			if tt.keep != 0 {
+				keep = fileCacheKey(1, tt.keep)
+			}
+
+			m.evictLocked(tt.reserve, keep)
+
+			var remaining []int
+			for _, e := range m.entries {
+				remaining = append(remaining, e.MessageID)
+			}
+			for _, p := range m.parts {
+				remaining = append(remaining, p.messageID)
+			}
+			slices.Sort(remaining)
+			if !slices.Equal(remaining, tt.expected) {
+				t.Errorf("remaining = %v, expected %v", remaining, tt.expected)
+			}
+			if m.size != int64(len(remaining)) {
+				t.Errorf("size = %d, expected %d", m.size, len(remaining))
+			}
+			if got := m.evictions.Load(); got != int64(len(all)-len(remaining)) {
+				t.Errorf("evictions = %d, expected %d", got, len(all)-len(remaining))
+			}
+			// 被淘汰的缓存同时从磁盘删除
+			for _, id := range all {
+				_, errFile := os.Stat(fileCachePath(1, id))
+				_, errParts := os.Stat(fileCachePartsDir(1, id))
+				onDisk := errFile == nil || errParts == nil
+				if onDisk != slices.Contains(remaining, id) {
+					t.Errorf("message %d on disk = %v, expected %v", id, onDisk, !onDisk)
+				}
+			}
+		})
+	}
+}
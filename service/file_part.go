@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/utils"
+)
+
+// 正在合并分片的文件, 避免重复合并
+var assemblingParts sync.Map
+
+func fileCachePartsDir(chatID int64, messageID int) string {
+	return filepath.Join(config.C().FileCache.Dir, fileCacheKey(chatID, messageID)+".parts")
+}
+
+func fileCachePartPath(chatID int64, messageID int, index int64) string {
+	return filepath.Join(fileCachePartsDir(chatID, messageID), strconv.FormatInt(index, 10)+".part")
+}
+
+func partCount(size int64) int64 {
+	return (size + utils.FilePartSize - 1) / utils.FilePartSize
+}
+
+// rangeReader 按分片读取文件的 [pos, end) 区间, 优先使用已缓存的分片,
+// 从 Telegram 下载的分片会写入缓存, 所有分片齐全后合并为完整的缓存文件
+type rangeReader struct {
+	ctx       context.Context
+	file      utils.TGFile
+	chatID    int64
+	messageID int
+	pos       int64
+	end       int64
+	buf       []byte
+	cache     bool
+}
+
+func newRangeReader(ctx context.Context, file utils.TGFile, chatID int64, messageID int, offset, length int64) *rangeReader {
+	return &rangeReader{
+		ctx:       ctx,
+		file:      file,
+		chatID:    chatID,
+		messageID: messageID,
+		pos:       offset,
+		end:       offset + length,
+		// 超过缓存容量的文件不缓存分片
+		cache: fileCacheEnabled() && (fileCache.maxSize <= 0 || file.Size() <= fileCache.maxSize),
+	}
+}
+
+func (r *rangeReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		if r.pos >= r.end {
+			return 0, io.EOF
+		}
+		index := r.pos / utils.FilePartSize
+		data, err := r.part(index)
+		if err != nil {
+			return 0, err
+		}
+		partStart := index * utils.FilePartSize
+		skip := r.pos - partStart
+		if skip >= int64(len(data)) {
+			return 0, io.ErrUnexpectedEOF
+		}
+		r.buf = data[skip:min(int64(len(data)), r.end-partStart)]
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.pos += int64(n)
+	return n, nil
+}
+
+func (r *rangeReader) Close() error {
+	return nil
+}
+
+func (r *rangeReader) part(index int64) ([]byte, error) {
+	if r.cache {
+		if data, err := os.ReadFile(fileCachePartPath(r.chatID, r.messageID, index)); err == nil {
+			fileCache.touchParts(r.chatID, r.messageID)
+			return data, nil
+		}
+	}
+	data, err := utils.DownloadPart(r.ctx, r.file, index)
+	if err != nil {
+		return nil, err
+	}
+	if r.cache {
+		if err := writeCachePart(r.chatID, r.messageID, index, data); err != nil {
+			log.FromContext(r.ctx).Warn("Failed to write cache part", "error", err)
+		} else if fileCache.addPart(r.chatID, r.messageID, index, int64(len(data)), partCount(r.file.Size())) {
+			go assembleCacheParts(r.chatID, r.messageID, r.file.Name(), r.file.Size())
+		}
+	}
+	return data, nil
+}
+
+func writeCachePart(chatID int64, messageID int, index int64, data []byte) error {
+	if err := os.MkdirAll(fileCachePartsDir(chatID, messageID), os.ModePerm); err != nil {
+		return err
+	}
+	path := fileCachePartPath(chatID, messageID, index)
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, time.Now().UnixNano())
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// assembleCacheParts 将已齐全的分片合并为完整的缓存文件, 由 fileCache.addPart 判断分片是否齐全
+func assembleCacheParts(chatID int64, messageID int, fileName string, size int64) {
+	key := fileCacheKey(chatID, messageID)
+	if _, loaded := assemblingParts.LoadOrStore(key, struct{}{}); loaded {
+		return
+	}
+	defer assemblingParts.Delete(key)
+
+	if fileCache.contains(chatID, messageID) {
+		fileCache.removeParts(chatID, messageID)
+		return
+	}
+	tmpPath := fileCacheTmpPath(chatID, messageID)
+	if err := concatParts(tmpPath, chatID, messageID, partCount(size)); err != nil {
+		log.Warn("Failed to assemble cache parts", "chat_id", chatID, "message_id", messageID, "error", err)
+		os.Remove(tmpPath)
+		return
+	}
+	// 先释放分片占用的容量, 再为合并后的文件腾出空间
+	fileCache.removeParts(chatID, messageID)
+	finalizeCacheFile(tmpPath, chatID, messageID, fileName, size, log.Default())
+}
+
+func concatParts(dst string, chatID int64, messageID int, count int64) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for i := range count {
+		part, err := os.Open(fileCachePartPath(chatID, messageID, i))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, part)
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package service
+
+import (
+	"github.com/krau/btts/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 文件缓存本身已经维护了命中计数, 直接导出, 不重复计数
+func init() {
+	metrics.MustRegister(
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "btts",
+			Name:      "file_cache_hits_total",
+			Help:      "Number of file stream requests served from the file cache.",
+		}, func() float64 { return float64(fileCache.hits.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "btts",
+			Name:      "file_cache_misses_total",
+			Help:      "Number of file stream requests not found in the file cache.",
+		}, func() float64 { return float64(fileCache.misses.Load()) }),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: "btts",
+			Name:      "file_cache_evictions_total",
+			Help:      "Number of files evicted from the file cache to stay under max_size.",
+		}, func() float64 { return float64(fileCache.evictions.Load()) }),
+	)
+}
@@ -0,0 +1,135 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/krau/btts/config"
+	"github.com/krau/btts/utils"
+)
+
+const DefaultThumbSize = 320
+
+// thumbSizes 是缩略图请求尺寸的档位, 请求的尺寸会向上取整到最近的档位, 避免磁盘缓存按任意尺寸膨胀
+var thumbSizes = []int{100, 320, 800, 1280, 2560}
+
+var ErrNoThumbnail = errors.New("no thumbnail available")
+
+// NormalizeThumbSize 将请求的尺寸取整到档位, 小于等于 0 时使用默认尺寸
+func NormalizeThumbSize(size int) int {
+	if size <= 0 {
+		return DefaultThumbSize
+	}
+	for _, s := range thumbSizes {
+		if size <= s {
+			return s
+		}
+	}
+	return thumbSizes[len(thumbSizes)-1]
+}
+
+func thumbCacheDir() string {
+	return filepath.Join(config.C().FileCache.Dir, "thumbs")
+}
+
+func thumbCachePath(chatID int64, messageID int, size int) string {
+	return filepath.Join(thumbCacheDir(), fmt.Sprintf("%d_%d_%d.jpg", chatID, messageID, size))
+}
+
+// GetThumbnail 获取消息中图片或视频等文档的缩略图, 选择长边不小于 size 的最小尺寸.
+// 启用文件缓存时缩略图会缓存在缓存目录的 thumbs 子目录下
+func GetThumbnail(ctx context.Context, chatID int64, messageID int, size int) ([]byte, error) {
+	logger := log.FromContext(ctx)
+	size = NormalizeThumbSize(size)
+
+	cacheEnabled := fileCacheEnabled()
+	path := thumbCachePath(chatID, messageID, size)
+	if cacheEnabled {
+		initFileCache()
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) <= fileCacheTTL {
+			if data, err := os.ReadFile(path); err == nil {
+				return data, nil
+			}
+		}
+	}
+
+	media, ectx, err := getMessageMedia(chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	tf, err := utils.ThumbFromMedia(media, ectx.Raw, size)
+	if err != nil {
+		logger.Debug("No thumbnail for message", "chat_id", chatID, "message_id", messageID, "error", err)
+		return nil, ErrNoThumbnail
+	}
+	var buf bytes.Buffer
+	if _, err := utils.NewDownloader(tf).Stream(ctx, &buf); err != nil {
+		return nil, fmt.Errorf("failed to download thumbnail: %w", err)
+	}
+	data := buf.Bytes()
+
+	if cacheEnabled {
+		if err := writeThumbCache(path, data); err != nil {
+			logger.Warn("Failed to cache thumbnail", "chat_id", chatID, "message_id", messageID, "error", err)
+		}
+	}
+	return data, nil
+}
+
+func writeThumbCache(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0644); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// cleanExpiredThumbs 删除超过缓存 TTL 的缩略图
+func cleanExpiredThumbs() {
+	dir := thumbCacheDir()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > fileCacheTTL {
+			os.Remove(filepath.Join(dir, entry.Name()))
+		}
+	}
+}
+
+// purgeThumbs 删除指定消息的缩略图缓存, 参数含义同 PurgeFileCache
+func purgeThumbs(chatID int64, messageID int) {
+	pattern := "*.jpg"
+	switch {
+	case chatID != 0 && messageID != 0:
+		pattern = fmt.Sprintf("%d_%d_*.jpg", chatID, messageID)
+	case chatID != 0:
+		pattern = fmt.Sprintf("%d_*.jpg", chatID)
+	}
+	matches, err := filepath.Glob(filepath.Join(thumbCacheDir(), pattern))
+	if err != nil {
+		return
+	}
+	for _, m := range matches {
+		os.Remove(m)
+	}
+}
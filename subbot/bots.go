@@ -74,8 +74,8 @@ func NewSubBot(ctx context.Context, token string, chats []int64) (*SubBot, error
 	})
 	go func() {
 		tclient, err := mygotg.NewClient(
-			config.C.AppID,
-			config.C.AppHash,
+			config.C().AppID,
+			config.C().AppHash,
 			mygotg.ClientTypeBot(token),
 			&mygotg.ClientOpts{
 				Session:          session.SqlSession(gormlite.Open(fmt.Sprintf("data/session_%s.db", sessionName))),
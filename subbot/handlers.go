@@ -12,6 +12,7 @@ import (
 	"github.com/gotd/td/telegram/message/inline"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
+	"github.com/krau/btts/analytics"
 	"github.com/krau/btts/config"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/engine"
@@ -25,10 +26,10 @@ import (
 
 func CheckAdmin(ctx *ext.Context, update *ext.Update) bool {
 	userID := update.GetUserChat().GetID()
-	if userID == userclient.GetUserClient().TClient.Self.ID {
+	if userclient.IsAccount(userID) {
 		return true
 	}
-	if slice.Contain(config.C.Admins, userID) {
+	if slice.Contain(config.C().Admins, userID) {
 		return true
 	}
 	return false
@@ -150,11 +151,12 @@ func SearchHandler(ctx *ext.Context, update *ext.Update) error {
 		ctx.Reply(update, ext.ReplyTextString("Failed to search"), nil)
 		return dispatcher.EndGroups
 	}
+	analytics.RecordSearch(ctx, analytics.SourceSubBot, analytics.UserRequester(userId), req, resp)
 	if len(resp.Hits) == 0 {
 		ctx.Reply(update, ext.ReplyTextString("No results found"), nil)
 		return dispatcher.EndGroups
 	}
-	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, req)
+	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, req, false)
 	if err != nil {
 		log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 		return dispatcher.EndGroups
@@ -260,7 +262,7 @@ func SearchCallbackHandler(ctx *ext.Context, update *ext.Update) error {
 	text, entities := eb.Complete()
 	editReq.SetEntities(entities)
 	editReq.SetMessage(text)
-	markup, err := utils.BuildSearchReplyMarkup(ctx, page, data)
+	markup, err := utils.BuildSearchReplyMarkup(ctx, page, data, false)
 	if err != nil {
 		log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
@@ -387,7 +389,7 @@ func FilterCallbackHandler(ctx *ext.Context, update *ext.Update) error {
 	text, entities := eb.Complete()
 	editReq.SetEntities(entities)
 	editReq.SetMessage(text)
-	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, data)
+	markup, err := utils.BuildSearchReplyMarkup(ctx, 1, data, false)
 	if err != nil {
 		log.FromContext(ctx).Errorf("Failed to build reply markup: %v", err)
 		ctx.AnswerCallback(&tg.MessagesSetBotCallbackAnswerRequest{
@@ -416,8 +418,8 @@ func InlineQueryHandler(ctx *ext.Context, update *ext.Update) error {
 		logger.Errorf("Failed to get sub bot: %v", err)
 		return dispatcher.EndGroups
 	}
-	if userID == userclient.GetUserClient().TClient.Self.ID ||
-		slice.Contain(config.C.Admins, userID) {
+	if userclient.IsAccount(userID) ||
+		slice.Contain(config.C().Admins, userID) {
 		// admin
 		chatIds = sb.ChatIDs
 	} else {
@@ -427,16 +429,17 @@ func InlineQueryHandler(ctx *ext.Context, update *ext.Update) error {
 		return dispatcher.EndGroups
 	}
 	query := update.InlineQuery.GetQuery()
-	resp, err := engine.GetEngine().Search(ctx,
-		types.SearchRequest{
-			Limit:   48,
-			Query:   query,
-			ChatIDs: chatIds,
-		})
+	req := types.SearchRequest{
+		Limit:   48,
+		Query:   query,
+		ChatIDs: chatIds,
+	}
+	resp, err := engine.GetEngine().Search(ctx, req)
 	if err != nil {
 		logger.Errorf("Failed to search: %v", err)
 		return dispatcher.EndGroups
 	}
+	analytics.RecordInlineSearch(ctx, analytics.SourceSubBotInline, analytics.UserRequester(userID), req, resp)
 	results := make([]inline.ResultOption, 0, len(resp.Hits))
 	for _, hit := range resp.Hits {
 		userName := hit.Formatted.UserID
@@ -84,34 +84,50 @@ type MessageDocument struct {
 	UserID    int64 `json:"user_id"`
 	ChatID    int64 `json:"chat_id"`
 	Timestamp int64 `json:"timestamp"`
+	// Telegram GroupedID, 同一相册中的消息共享该值, 0 表示不属于相册
+	GroupedID int64 `json:"grouped_id,omitempty"`
+	// 相册的说明文字, 相册中通常只有一条消息带有 caption, 索引时会共享给所有成员
+	AlbumCaption string `json:"album_caption,omitempty"`
 }
 
 type SearchHit struct {
 	MessageDocument
 	Formatted SearchHitFormatted `json:"_formatted"`
+	// 相册折叠后的成员数量, 非相册消息为 0
+	AlbumSize int `json:"album_size,omitempty"`
 }
 
 type SearchHitFormatted struct {
-	ID          string `json:"id"`
-	Type        string `json:"type"`
-	Message     string `json:"message"`
-	Ocred       string `json:"ocred"`
-	AIGenerated string `json:"aigenerated"`
-	UserID      string `json:"user_id"`
-	ChatID      string `json:"chat_id"`
-	Timestamp   string `json:"timestamp"`
+	ID           string `json:"id"`
+	Type         string `json:"type"`
+	Message      string `json:"message"`
+	Ocred        string `json:"ocred"`
+	AIGenerated  string `json:"aigenerated"`
+	UserID       string `json:"user_id"`
+	ChatID       string `json:"chat_id"`
+	Timestamp    string `json:"timestamp"`
+	AlbumCaption string `json:"album_caption"`
 }
 
-func (s SearchHit) MessageLink() string {
-	return fmt.Sprintf("https://t.me/c/%d/%d", s.ChatID, s.ID)
+func (d MessageDocument) MessageLink() string {
+	return fmt.Sprintf("https://t.me/c/%d/%d", d.ChatID, d.ID)
 }
 
 func (s SearchHit) FullFormattedText() string {
-	return strings.TrimSpace(s.Formatted.Message + " " + s.Formatted.Ocred + " " + s.Formatted.AIGenerated)
+	message := s.Formatted.Message
+	if strings.TrimSpace(message) == "" {
+		message = s.Formatted.AlbumCaption
+	}
+	return strings.TrimSpace(message + " " + s.Formatted.Ocred + " " + s.Formatted.AIGenerated)
 }
 
-func (s SearchHit) FullText() string {
-	return strings.TrimSpace(s.Message + " " + s.Ocred + " " + s.AIGenerated)
+// FullText 返回消息的全部文本, 相册成员自身没有文本时使用相册的说明文字
+func (d MessageDocument) FullText() string {
+	message := d.Message
+	if strings.TrimSpace(message) == "" {
+		message = d.AlbumCaption
+	}
+	return strings.TrimSpace(message + " " + d.Ocred + " " + d.AIGenerated)
 }
 
 type SearchResponse struct {
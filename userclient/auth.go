@@ -2,6 +2,7 @@ package userclient
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -55,3 +56,22 @@ func (t *terminalAuthConversator) AuthStatus(authStatus mygotg.AuthStatus) {
 	default:
 	}
 }
+
+var errLoginRequired = errors.New("account is not logged in")
+
+// noAuthConversator 用于后台启动已登录的账号, 会话失效时直接返回错误而不是等待终端输入
+type noAuthConversator struct{}
+
+func (noAuthConversator) AskPhoneNumber() (string, error) {
+	return "", errLoginRequired
+}
+
+func (noAuthConversator) AskCode() (string, error) {
+	return "", errLoginRequired
+}
+
+func (noAuthConversator) AskPassword() (string, error) {
+	return "", errLoginRequired
+}
+
+func (noAuthConversator) AuthStatus(mygotg.AuthStatus) {}
@@ -1,8 +1,14 @@
 package userclient
 
 import (
+	"cmp"
 	"context"
+	"errors"
+	"fmt"
+	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"sync"
 	"time"
 
@@ -27,8 +33,20 @@ import (
 	"github.com/ncruces/go-sqlite3/gormlite"
 )
 
-var uc *UserClient
+const PrimaryAccountName = "main"
 
+var (
+	uc        *UserClient // 主账号
+	clients   = make(map[int64]*UserClient)
+	clientsMu sync.RWMutex
+
+	globalIgnoreUsers   = make([]int64, 0)
+	globalIgnoreUsersMu sync.RWMutex
+
+	accountNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+)
+
+// GetUserClient 返回主账号的用户客户端
 func GetUserClient() *UserClient {
 	if uc == nil {
 		panic("UserClient is not initialized, call NewUserClient first")
@@ -36,12 +54,107 @@ func GetUserClient() *UserClient {
 	return uc
 }
 
+// GetUserClientByID 根据账号的 Telegram ID 获取已启动的用户客户端
+func GetUserClientByID(userID int64) (*UserClient, bool) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	c, ok := clients[userID]
+	return c, ok
+}
+
+// GetUserClients 返回所有已启动的用户客户端, 主账号在最前
+func GetUserClients() []*UserClient {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	res := make([]*UserClient, 0, len(clients))
+	for _, c := range clients {
+		res = append(res, c)
+	}
+	slices.SortFunc(res, func(a, b *UserClient) int {
+		if a.Primary != b.Primary {
+			if a.Primary {
+				return -1
+			}
+			return 1
+		}
+		return cmp.Compare(a.ID(), b.ID())
+	})
+	return res
+}
+
+// GetUserClientForChat 返回负责该聊天的用户客户端, 所属账号未启动时使用主账号
+func GetUserClientForChat(chatID int64) *UserClient {
+	if c, ok := GetUserClientByID(database.ChatOwner(chatID)); ok {
+		return c
+	}
+	return GetUserClient()
+}
+
+// FindUserClient 根据账号名称或 Telegram ID 查找已启动的用户客户端
+func FindUserClient(nameOrID string) (*UserClient, bool) {
+	for _, c := range GetUserClients() {
+		if c.Name == nameOrID || fmt.Sprintf("%d", c.ID()) == nameOrID {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// IsAccount 判断 userID 是否为本实例的某个用户账号
+func IsAccount(userID int64) bool {
+	_, ok := GetUserClientByID(userID)
+	return ok
+}
+
+func AddGlobalIgnoreUser(userID int64) {
+	globalIgnoreUsersMu.Lock()
+	defer globalIgnoreUsersMu.Unlock()
+	globalIgnoreUsers = append(globalIgnoreUsers, userID)
+}
+
+func RemoveGlobalIgnoreUser(userID int64) {
+	globalIgnoreUsersMu.Lock()
+	defer globalIgnoreUsersMu.Unlock()
+	if idx := slices.Index(globalIgnoreUsers, userID); idx != -1 {
+		globalIgnoreUsers = slices.Delete(globalIgnoreUsers, idx, idx+1)
+	}
+}
+
+func IsGlobalIgnoredUser(userID int64) bool {
+	globalIgnoreUsersMu.RLock()
+	defer globalIgnoreUsersMu.RUnlock()
+	return slices.Contains(globalIgnoreUsers, userID)
+}
+
 type UserClient struct {
-	TClient           *mygotg.Client
-	logger            *zap.Logger
-	GlobalIgnoreUsers []int64
-	ectx              *ext.Context // created by TClient.CreateContext()
-	mu                sync.Mutex
+	TClient *mygotg.Client
+	// 账号名称, 主账号为 PrimaryAccountName
+	Name    string
+	Primary bool
+	logger  *zap.Logger
+	ectx    *ext.Context // created by TClient.CreateContext()
+}
+
+// ID 返回账号的 Telegram ID
+func (u *UserClient) ID() int64 {
+	return u.TClient.Self.ID
+}
+
+// Owns 判断该账号是否负责某个聊天
+func (u *UserClient) Owns(chatID int64) bool {
+	owner := database.ChatOwner(chatID)
+	if owner == u.ID() {
+		return true
+	}
+	if _, ok := GetUserClientByID(owner); ok {
+		return false
+	}
+	// 未指定或所属账号未启动的聊天由主账号负责
+	return u.Primary
+}
+
+func (u *UserClient) watching(chatID int64) bool {
+	return database.Watching(chatID) && u.Owns(chatID)
 }
 
 func (u *UserClient) GetContext() *ext.Context {
@@ -52,28 +165,25 @@ func (u *UserClient) GetContext() *ext.Context {
 }
 
 func (u *UserClient) StartWatch(ctx context.Context) {
+	client := u
 	// 启动时同步错过的消息
-	if !config.C.SkipCatchup {
+	if !config.C().SkipCatchup {
 		if err := u.SyncMissedUpdates(ctx); err != nil {
 			log.FromContext(ctx).Error("Failed to sync missed updates", "error", err)
 		}
 	}
 	disp := u.TClient.Dispatcher
-	disp.AddHandlerToGroup(handlers.NewAnyUpdate(func(ctx *ext.Context, update *ext.Update) error {
-		u.updateStateFromUpdates(ctx, update.UpdateClass)
-		return dispatcher.SkipCurrentGroup
-	}), 0)
 	disp.AddHandlerToGroup(handlers.NewAnyUpdate(func(ctx *ext.Context, u *ext.Update) error {
 		switch update := u.UpdateClass.(type) {
 		case *tg.UpdateDeleteChannelMessages:
 			chatID := update.GetChannelID()
-			if !database.Watching(chatID) {
+			if !client.watching(chatID) {
 				return dispatcher.SkipCurrentGroup
 			}
 			return dispatcher.ContinueGroups
 		case *tg.UpdateChannelParticipant:
 			chatID := update.GetChannelID()
-			if chatID == 0 || !database.Watching(chatID) {
+			if chatID == 0 || !client.watching(chatID) {
 				return dispatcher.SkipCurrentGroup
 			}
 			_, ok1 := update.GetPrevParticipant()
@@ -131,7 +241,7 @@ func (u *UserClient) StartWatch(ctx context.Context) {
 			}
 			chatID = pu.GetUserID()
 		}
-		if !database.Watching(chatID) {
+		if !client.watching(chatID) {
 			return dispatcher.SkipCurrentGroup
 		}
 		return dispatcher.ContinueGroups
@@ -143,7 +253,7 @@ func (u *UserClient) StartWatch(ctx context.Context) {
 		if m == nil {
 			return false
 		}
-		if !config.C.Plugin.Enable {
+		if !config.C().Plugin.Enable {
 			return false
 		}
 		return true
@@ -153,6 +263,13 @@ func (u *UserClient) StartWatch(ctx context.Context) {
 		}
 		return dispatcher.SkipCurrentGroup
 	}), 3)
+
+	// 最后更新 updates state, 消息或删除未能写入队列时之前的 handler 会返回 EndGroups,
+	// 此时 state 不会推进, 下次启动时可以通过 SyncMissedUpdates 重新获取
+	disp.AddHandlerToGroup(handlers.NewAnyUpdate(func(ctx *ext.Context, update *ext.Update) error {
+		u.updateStateFromUpdates(ctx, update.UpdateClass)
+		return dispatcher.SkipCurrentGroup
+	}), 4)
 }
 
 func (u *UserClient) Close() error {
@@ -162,28 +279,110 @@ func (u *UserClient) Close() error {
 	return nil
 }
 
-func (u *UserClient) AddGlobalIgnoreUser(userID int64) {
-	u.mu.Lock()
-	defer u.mu.Unlock()
-	u.GlobalIgnoreUsers = append(u.GlobalIgnoreUsers, userID)
+// NewUserClient 创建主账号的用户客户端, 首次运行时在终端中登录
+func NewUserClient(ctx context.Context) (*UserClient, error) {
+	log.FromContext(ctx).Debug("Initializing user client")
+	if uc != nil {
+		return uc, nil
+	}
+	client, err := newUserClient(ctx, PrimaryAccountName, &terminalAuthConversator{})
+	if err != nil {
+		return nil, err
+	}
+	client.Primary = true
+	if err := registerAccount(ctx, client); err != nil {
+		return nil, err
+	}
+	if err := database.ClaimLegacyUpdatesState(ctx, client.ID()); err != nil {
+		return nil, fmt.Errorf("failed to migrate updates state: %w", err)
+	}
+	uc = client
+	return uc, nil
+}
+
+// LoginAccount 在终端中登录一个额外的用户账号并保存, 账号已存在时直接使用已有会话
+func LoginAccount(ctx context.Context, name string) (*UserClient, error) {
+	if name == PrimaryAccountName {
+		return NewUserClient(ctx)
+	}
+	if !accountNameRegexp.MatchString(name) {
+		return nil, fmt.Errorf("invalid account name: %s", name)
+	}
+	if c, ok := FindUserClient(name); ok {
+		return c, nil
+	}
+	client, err := newUserClient(ctx, name, &terminalAuthConversator{})
+	if err != nil {
+		return nil, err
+	}
+	if err := registerAccount(ctx, client); err != nil {
+		return nil, err
+	}
+	return client, nil
 }
 
-func (u *UserClient) RemoveGlobalIgnoreUser(userID int64) {
-	u.mu.Lock()
-	defer u.mu.Unlock()
-	for i, id := range u.GlobalIgnoreUsers {
-		if id == userID {
-			u.GlobalIgnoreUsers = append(u.GlobalIgnoreUsers[:i], u.GlobalIgnoreUsers[i+1:]...)
-			break
+// StartAccounts 启动数据库中保存的所有额外账号, 这些账号必须已通过 LoginAccount 登录
+func StartAccounts(ctx context.Context) error {
+	accounts, err := database.GetAllUserAccounts(ctx)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, account := range accounts {
+		if account.IsPrimary || IsAccount(account.UserID) {
+			continue
+		}
+		if _, err := os.Stat(sessionPath(account.Name)); err != nil {
+			errs = append(errs, fmt.Errorf("session of account %s not found, login with `btts account add %s` first", account.Name, account.Name))
+			continue
+		}
+		client, err := newUserClient(ctx, account.Name, noAuthConversator{})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to start account %s: %w", account.Name, err))
+			continue
+		}
+		if err := registerAccount(ctx, client); err != nil {
+			errs = append(errs, err)
+			continue
 		}
+		log.FromContext(ctx).Info("User account started", "name", account.Name, "user_id", client.ID())
 	}
+	return errors.Join(errs...)
 }
 
-func NewUserClient(ctx context.Context) (*UserClient, error) {
-	log.FromContext(ctx).Debug("Initializing user client")
-	if uc != nil {
-		return uc, nil
+func sessionPath(name string) string {
+	if name == PrimaryAccountName {
+		return filepath.Join("data", "session_user.db")
 	}
+	return filepath.Join("data", fmt.Sprintf("session_user_%s.db", name))
+}
+
+func logPath(name string) string {
+	if name == PrimaryAccountName {
+		return filepath.Join("data", "logs", "client.jsonl")
+	}
+	return filepath.Join("data", "logs", fmt.Sprintf("client_%s.jsonl", name))
+}
+
+func registerAccount(ctx context.Context, client *UserClient) error {
+	self := client.TClient.Self
+	if err := database.UpsertUserAccount(ctx, &database.UserAccount{
+		UserID:    self.ID,
+		Name:      client.Name,
+		Username:  self.Username,
+		FirstName: self.FirstName,
+		LastName:  self.LastName,
+		IsPrimary: client.Primary,
+	}); err != nil {
+		return fmt.Errorf("failed to save user account: %w", err)
+	}
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	clients[self.ID] = client
+	return nil
+}
+
+func newUserClient(ctx context.Context, name string, conversator mygotg.AuthConversator) (*UserClient, error) {
 	res := make(chan struct {
 		client *UserClient
 		err    error
@@ -192,7 +391,7 @@ func NewUserClient(ctx context.Context) (*UserClient, error) {
 		tclientLog := zap.New(zapcore.NewCore(
 			zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()),
 			zapcore.AddSync(&lumberjack.Logger{
-				Filename:   filepath.Join("data", "logs", "client.jsonl"),
+				Filename:   logPath(name),
 				MaxBackups: 7,
 				MaxSize:    10,
 				MaxAge:     7,
@@ -200,12 +399,12 @@ func NewUserClient(ctx context.Context) (*UserClient, error) {
 			zap.DebugLevel,
 		))
 		tclient, err := mygotg.NewClient(
-			config.C.AppID,
-			config.C.AppHash,
+			config.C().AppID,
+			config.C().AppHash,
 			mygotg.ClientTypePhone(""),
 			&mygotg.ClientOpts{
-				Session:          session.SqlSession(gormlite.Open("data/session_user.db")),
-				AuthConversator:  &terminalAuthConversator{},
+				Session:          session.SqlSession(gormlite.Open(sessionPath(name))),
+				AuthConversator:  conversator,
 				Logger:           tclientLog,
 				Context:          ctx,
 				DisableCopyright: true,
@@ -218,6 +417,7 @@ func NewUserClient(ctx context.Context) (*UserClient, error) {
 				client *UserClient
 				err    error
 			}{nil, err}
+			return
 		}
 		res <- struct {
 			client *UserClient
@@ -226,10 +426,10 @@ func NewUserClient(ctx context.Context) (*UserClient, error) {
 			client *UserClient
 			err    error
 		}{&UserClient{
-			TClient:           tclient,
-			logger:            tclientLog,
-			GlobalIgnoreUsers: make([]int64, 0),
-			ectx:              tclient.CreateContext(),
+			TClient: tclient,
+			Name:    name,
+			logger:  tclientLog,
+			ectx:    tclient.CreateContext(),
 		}, nil})
 	}()
 
@@ -240,7 +440,6 @@ func NewUserClient(ctx context.Context) (*UserClient, error) {
 		if r.err != nil {
 			return nil, r.err
 		}
-		uc = r.client
-		return uc, nil
+		return r.client, nil
 	}
 }
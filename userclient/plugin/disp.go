@@ -20,7 +20,7 @@ func Dispatcher(ctx *ext.Context, u *ext.Update) error {
 	if u.EffectiveMessage.Out {
 		text := u.EffectiveMessage.GetMessage()
 		matched, command, args := func() (bool, string, string) {
-			for _, prefix := range config.C.Plugin.Prefixes {
+			for _, prefix := range config.C().Plugin.Prefixes {
 				if strings.HasPrefix(text, prefix) {
 					// example: ,echo hello world
 					// command: echo
@@ -11,6 +11,8 @@ import (
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ocr"
+	"github.com/krau/btts/rules"
 	"github.com/krau/mygotg/storage"
 )
 
@@ -485,6 +487,8 @@ func (u *UserClient) exportChatHistory(ctx context.Context, client *takeout.Clie
 
 	totalMessages := 0
 	metadataUpdated := false
+	enableOcr := false
+	var rule *rules.Rule
 	batchSize := 100
 
 	// 遍历每个 range
@@ -543,6 +547,9 @@ func (u *UserClient) exportChatHistory(ctx context.Context, client *takeout.Clie
 					logger.Warn("Failed to update chat metadata", "chat_id", chatID, "error", err)
 				}
 				metadataUpdated = true
+				chatDB, _ := database.GetIndexChat(ctx, chatID)
+				enableOcr = ocr.Enabled(chatDB)
+				rule = rules.Get(ctx, chatID)
 			}
 
 			// 更新用户信息
@@ -550,17 +557,23 @@ func (u *UserClient) exportChatHistory(ctx context.Context, client *takeout.Clie
 				logger.Warn("Failed to update users info", "error", err)
 			}
 
-			// 获取 ext.Context 来调用 DocumentsFromMessages
+			// 获取 ext.Context 用于下载 OCR 的图片
 			ectx := u.GetContext()
 
 			// 转换为文档并批量索引
-			docs := engine.DocumentsFromMessages(ctx, messages, chatID, ectx.Self.ID, ectx, false)
+			indexable := rule.Filter(messages, ectx.Self.ID, rules.NewBots(users...))
+			docs := engine.DocumentsFromMessages(ctx, indexable, chatID, ectx.Self.ID, enableOcr)
 			if len(docs) > 0 {
 				if err := eng.AddDocuments(ctx, chatID, docs); err != nil {
 					logger.Error("Failed to add documents", "chat_id", chatID, "error", err)
 				} else {
 					totalMessages += len(docs)
 					logger.Debug("Indexed messages", "chat_id", chatID, "count", len(docs), "range", rangeIdx+1)
+					if enableOcr {
+						if err := ocr.SubmitWait(ctx, ectx, chatID, indexable); err != nil {
+							logger.Warn("Failed to submit OCR jobs", "chat_id", chatID, "error", err)
+						}
+					}
 				}
 			}
 
@@ -597,6 +610,8 @@ func (u *UserClient) exportChatHistoryWithoutRanges(ctx context.Context, client
 	totalMessages := 0
 	batchSize := 100
 	metadataUpdated := false
+	enableOcr := false
+	var rule *rules.Rule
 	api := tg.NewClient(client)
 
 	for {
@@ -640,6 +655,9 @@ func (u *UserClient) exportChatHistoryWithoutRanges(ctx context.Context, client
 				logger.Warn("Failed to update chat metadata", "chat_id", chatID, "error", err)
 			}
 			metadataUpdated = true
+			chatDB, _ := database.GetIndexChat(ctx, chatID)
+			enableOcr = ocr.Enabled(chatDB)
+			rule = rules.Get(ctx, chatID)
 		}
 
 		if err := u.updateUsersInfo(ctx, users); err != nil {
@@ -647,13 +665,19 @@ func (u *UserClient) exportChatHistoryWithoutRanges(ctx context.Context, client
 		}
 
 		ectx := u.GetContext()
-		docs := engine.DocumentsFromMessages(ctx, messages, chatID, ectx.Self.ID, ectx, false)
+		indexable := rule.Filter(messages, ectx.Self.ID, rules.NewBots(users...))
+		docs := engine.DocumentsFromMessages(ctx, indexable, chatID, ectx.Self.ID, enableOcr)
 		if len(docs) > 0 {
 			if err := eng.AddDocuments(ctx, chatID, docs); err != nil {
 				logger.Error("Failed to add documents", "chat_id", chatID, "error", err)
 			} else {
 				totalMessages += len(docs)
 				logger.Debug("Indexed messages", "chat_id", chatID, "count", len(docs))
+				if enableOcr {
+					if err := ocr.SubmitWait(ctx, ectx, chatID, indexable); err != nil {
+						logger.Warn("Failed to submit OCR jobs", "chat_id", chatID, "error", err)
+					}
+				}
 			}
 		}
 
@@ -728,6 +752,7 @@ func (u *UserClient) updateChatMetadataFromEntities(ctx context.Context, dialog
 		chat = &database.IndexChat{
 			ChatID:   chatID,
 			Watching: enableWatching,
+			OwnerID:  u.ID(),
 		}
 	}
 	// 已有记录不改变 watching 状态
@@ -771,6 +796,9 @@ func (u *UserClient) updateChatMetadataFromEntities(ctx context.Context, dialog
 func (u *UserClient) updateUsersInfo(ctx context.Context, users []tg.UserClass) error {
 	for _, userClass := range users {
 		if user, ok := userClass.(*tg.User); ok {
+			if database.NeverIndex(user.ID) {
+				continue
+			}
 			userDB, err := database.GetUserInfo(ctx, user.ID)
 			if err == nil {
 				// 已存在，更新信息
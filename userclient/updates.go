@@ -9,15 +9,21 @@ import (
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ingest"
+	"github.com/krau/btts/metrics"
+	"github.com/krau/btts/ocr"
+	"github.com/krau/btts/rules"
 )
 
 // SyncMissedUpdates 在客户端启动时同步错过的消息
-func (u *UserClient) SyncMissedUpdates(ctx context.Context) error {
+func (u *UserClient) SyncMissedUpdates(ctx context.Context) (err error) {
 	logger := log.FromContext(ctx)
 	logger.Info("Syncing missed updates...")
+	done := metrics.StartCatchup(u.ID())
+	defer func() { done(err) }()
 
 	// 获取当前保存的状态
-	state, err := database.GetUpdatesState(ctx)
+	state, err := database.GetUpdatesState(ctx, u.ID())
 	if err != nil {
 		return fmt.Errorf("failed to get updates state: %w", err)
 	}
@@ -68,8 +74,10 @@ func (u *UserClient) SyncMissedUpdates(ctx context.Context) error {
 			// 完整的差异，处理并返回
 			totalMessages += len(d.NewMessages)
 			totalUpdates += len(d.OtherUpdates)
-			if err := u.processDifference(ctx, d.NewMessages, d.OtherUpdates); err != nil {
-				logger.Error("Failed to process difference", "error", err)
+			metrics.AddCatchup(u.ID(), len(d.NewMessages), len(d.OtherUpdates))
+			if err := u.processDifference(ctx, d.NewMessages, d.OtherUpdates, d.Users); err != nil {
+				// 不推进 state, 下次同步时重新获取
+				return fmt.Errorf("failed to process difference: %w", err)
 			}
 			// 更新状态
 			state.Pts = d.State.Pts
@@ -86,8 +94,9 @@ func (u *UserClient) SyncMissedUpdates(ctx context.Context) error {
 			// 差异太大，分片返回
 			totalMessages += len(d.NewMessages)
 			totalUpdates += len(d.OtherUpdates)
-			if err := u.processDifference(ctx, d.NewMessages, d.OtherUpdates); err != nil {
-				logger.Error("Failed to process difference slice", "error", err)
+			metrics.AddCatchup(u.ID(), len(d.NewMessages), len(d.OtherUpdates))
+			if err := u.processDifference(ctx, d.NewMessages, d.OtherUpdates, d.Users); err != nil {
+				return fmt.Errorf("failed to process difference slice: %w", err)
 			}
 			// 更新中间状态并继续获取
 			state.Pts = d.IntermediateState.Pts
@@ -121,21 +130,23 @@ func (u *UserClient) fetchCurrentState(ctx context.Context) error {
 		return fmt.Errorf("failed to get state: %w", err)
 	}
 
-	state := &database.UpdatesState{
-		ID:   1,
-		Pts:  stateResp.Pts,
-		Qts:  stateResp.Qts,
-		Date: stateResp.Date,
-		Seq:  stateResp.Seq,
+	state, err := database.GetUpdatesState(ctx, u.ID())
+	if err != nil {
+		return fmt.Errorf("failed to get updates state: %w", err)
 	}
+	state.Pts = stateResp.Pts
+	state.Qts = stateResp.Qts
+	state.Date = stateResp.Date
+	state.Seq = stateResp.Seq
 
 	return database.UpdateUpdatesState(ctx, state)
 }
 
-// processDifference 处理 getDifference 返回的消息和更新
-func (u *UserClient) processDifference(ctx context.Context, newMessages []tg.MessageClass, otherUpdates []tg.UpdateClass) error {
+// processDifference 将 getDifference 返回的消息和删除写入队列, 写入失败时返回错误, 调用方不应推进 state
+func (u *UserClient) processDifference(ctx context.Context, newMessages []tg.MessageClass, otherUpdates []tg.UpdateClass, users []tg.UserClass) error {
 	logger := log.FromContext(ctx)
 	ectx := u.GetContext()
+	bots := rules.NewBots(users...)
 
 	// 按 chatID 分组消息
 	messagesByChat := make(map[int64][]*tg.Message)
@@ -148,7 +159,7 @@ func (u *UserClient) processDifference(ctx context.Context, newMessages []tg.Mes
 
 		// 检查是否是被监听的聊天
 		chatID := u.getChatIDFromMessage(msg)
-		if chatID == 0 || !database.Watching(chatID) {
+		if chatID == 0 || !u.watching(chatID) {
 			continue
 		}
 
@@ -157,12 +168,26 @@ func (u *UserClient) processDifference(ctx context.Context, newMessages []tg.Mes
 
 	// 批量添加每个聊天的消息到索引
 	for chatID, messages := range messagesByChat {
-		docs := engine.DocumentsFromMessages(ctx, messages, chatID, ectx.Self.ID, ectx, false)
+		chatDB, err := database.GetIndexChat(ctx, chatID)
+		if err != nil {
+			logger.Warn("Failed to get chat", "error", err, "chat_id", chatID)
+		}
+		messages = rules.Get(ctx, chatID).Filter(messages, ectx.Self.ID, bots)
+		if len(messages) == 0 {
+			continue
+		}
+		enableOcr := ocr.Enabled(chatDB)
+		docs := engine.BuildDocuments(ctx, messages, chatID, ectx.Self.ID, enableOcr)
 		if len(docs) > 0 {
-			if err := engine.GetEngine().AddDocuments(ctx, chatID, docs); err != nil {
-				logger.Error("Failed to add documents", "error", err, "chat_id", chatID, "count", len(docs))
-			} else {
-				logger.Info("Indexed missed messages", "chat_id", chatID, "count", len(docs))
+			if err := ingest.AddDocuments(ctx, chatID, docs); err != nil {
+				return fmt.Errorf("failed to enqueue documents for chat %d: %w", chatID, err)
+			}
+			logger.Info("Queued missed messages", "chat_id", chatID, "count", len(docs))
+		}
+		if enableOcr {
+			// 错过的消息可能很多, 等待队列空间而不是跳过, 以保证它们都会被 OCR
+			if err := ocr.SubmitWait(ctx, ectx, chatID, messages); err != nil {
+				return fmt.Errorf("failed to submit OCR jobs for chat %d: %w", chatID, err)
 			}
 		}
 	}
@@ -172,22 +197,22 @@ func (u *UserClient) processDifference(ctx context.Context, newMessages []tg.Mes
 		switch update := updateClass.(type) {
 		case *tg.UpdateDeleteChannelMessages:
 			chatID := update.GetChannelID()
-			if database.Watching(chatID) {
+			if u.watching(chatID) {
 				chatDB, err := database.GetIndexChat(ctx, chatID)
 				if err != nil {
 					logger.Error("Failed to get chat", "error", err, "chat_id", chatID)
 					continue
 				}
 				if !chatDB.NoDelete {
-					if err := engine.GetEngine().DeleteDocuments(ctx, chatID, update.GetMessages()); err != nil {
-						logger.Error("Failed to delete documents", "error", err, "chat_id", chatID)
+					if err := ingest.DeleteDocuments(ctx, chatID, update.GetMessages()); err != nil {
+						return fmt.Errorf("failed to enqueue deletion for chat %d: %w", chatID, err)
 					}
 				}
 			}
 		case *tg.UpdateChannelTooLong:
 			// 某个 channel 的更新太多，需要单独处理
 			chatID := update.GetChannelID()
-			if database.Watching(chatID) {
+			if u.watching(chatID) {
 				logger.Info("Channel has too many updates, syncing separately", "chat_id", chatID)
 				// 在后台异步处理
 				go func() {
@@ -266,8 +291,9 @@ func (u *UserClient) syncChannelDifference(ctx context.Context, channelID int64)
 
 		case *tg.UpdatesChannelDifference:
 			totalMessages += len(d.NewMessages)
-			if err := u.processDifference(ctx, d.NewMessages, d.OtherUpdates); err != nil {
-				logger.Error("Failed to process channel difference", "error", err)
+			metrics.AddCatchup(u.ID(), len(d.NewMessages), len(d.OtherUpdates))
+			if err := u.processDifference(ctx, d.NewMessages, d.OtherUpdates, d.Users); err != nil {
+				return fmt.Errorf("failed to process channel difference: %w", err)
 			}
 			pts = d.Pts
 			if err := database.UpdateChannelPts(ctx, channelID, pts); err != nil {
@@ -287,8 +313,8 @@ func (u *UserClient) syncChannelDifference(ctx context.Context, channelID int64)
 			// 处理返回的新消息
 			if len(d.Messages) > 0 {
 				totalMessages += len(d.Messages)
-				if err := u.processDifference(ctx, d.Messages, nil); err != nil {
-					logger.Error("Failed to process messages from too long difference", "error", err)
+				if err := u.processDifference(ctx, d.Messages, nil, d.Users); err != nil {
+					return fmt.Errorf("failed to process messages from too long difference: %w", err)
 				}
 			}
 
@@ -334,7 +360,7 @@ type PtsUpdate interface {
 func (u *UserClient) updateStateFromUpdates(ctx context.Context, update tg.UpdateClass) {
 	logger := log.FromContext(ctx)
 
-	state, err := database.GetUpdatesState(ctx)
+	state, err := database.GetUpdatesState(ctx, u.ID())
 	if err != nil {
 		logger.Error("Failed to get updates state", "error", err)
 		return
@@ -374,7 +400,7 @@ func (u *UserClient) updateStateFromUpdates(ctx context.Context, update tg.Updat
 					}
 				}
 			}
-			if channelID != 0 && database.Indexed(channelID) {
+			if channelID != 0 && database.Indexed(channelID) && u.Owns(channelID) {
 				if err := database.UpdateChannelPts(ctx, channelID, pts); err != nil {
 					logger.Error("Failed to update channel pts", "error", err, "channel_id", channelID)
 				}
@@ -5,10 +5,12 @@ import (
 	"strings"
 
 	"github.com/charmbracelet/log"
-	"github.com/duke-git/lancet/v2/slice"
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/database"
 	"github.com/krau/btts/engine"
+	"github.com/krau/btts/ingest"
+	"github.com/krau/btts/ocr"
+	"github.com/krau/btts/rules"
 	"github.com/krau/mygotg/dispatcher"
 	"github.com/krau/mygotg/ext"
 )
@@ -116,18 +118,37 @@ func WatchHandler(ctx *ext.Context, u *ext.Update) error {
 		}
 	}
 
+	if database.NeverIndex(userDB.ChatID) {
+		return dispatcher.SkipCurrentGroup
+	}
 	if err := database.UpsertUserInfo(ctx, userDB); err != nil {
 		log.Warnf("Failed to upsert user info: %v", err)
 	}
-	if slice.Contain(uc.GlobalIgnoreUsers, userDB.ChatID) {
+	if IsGlobalIgnoredUser(userDB.ChatID) {
 		return dispatcher.SkipCurrentGroup
 	}
 	if err := database.AddMemberToIndexChat(ctx, chatDB.ChatID, userDB); err != nil {
 		log.Warnf("Failed to add member to index chat: %v", err)
 	}
-	docs := engine.DocumentsFromMessages(ctx, []*tg.Message{u.EffectiveMessage.Message}, chatDB.ChatID, ctx.Self.ID, ctx, !chatDB.NoOcr)
-	if err := engine.GetEngine().AddDocuments(ctx, chatDB.ChatID, docs); err != nil {
-		log.Errorf("Failed to add documents: %v", err)
+	bots := make(rules.Bots)
+	if u.Entities != nil {
+		for _, user := range u.Entities.Users {
+			bots.Add(user)
+		}
+	}
+	messages := rules.Get(ctx, chatDB.ChatID).Filter([]*tg.Message{u.EffectiveMessage.Message}, ctx.Self.ID, bots)
+	if len(messages) == 0 {
+		return dispatcher.SkipCurrentGroup
+	}
+	enableOcr := ocr.Enabled(chatDB)
+	docs := engine.BuildDocuments(ctx, messages, chatDB.ChatID, ctx.Self.ID, enableOcr)
+	if err := ingest.AddDocumentsWait(ctx, chatDB.ChatID, docs); err != nil {
+		// 只有在关闭时才会失败, 不推进 updates state, 下次启动时通过 SyncMissedUpdates 重新获取
+		log.Errorf("Failed to enqueue documents: %v", err)
+		return dispatcher.EndGroups
+	}
+	if enableOcr {
+		ocr.Submit(ctx, ctx, chatDB.ChatID, messages)
 	}
 	return dispatcher.SkipCurrentGroup
 }
@@ -147,8 +168,9 @@ func DeleteHandler(ctx *ext.Context, u *ext.Update) error {
 	if chatDB.NoDelete {
 		return dispatcher.SkipCurrentGroup
 	}
-	if err := engine.GetEngine().DeleteDocuments(ctx, chatID, update.GetMessages()); err != nil {
-		log.Errorf("Failed to delete documents: %v", err)
+	if err := ingest.DeleteDocumentsWait(ctx, chatID, update.GetMessages()); err != nil {
+		log.Errorf("Failed to enqueue document deletion: %v", err)
+		return dispatcher.EndGroups
 	}
 	return dispatcher.SkipCurrentGroup
 }
@@ -49,3 +49,7 @@ func Get[T any](key string) (T, bool) {
 	}
 	return vT, true
 }
+
+func Delete(key string) {
+	cache.Del(key)
+}
@@ -1,8 +1,10 @@
 package utils
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
+	"slices"
 
 	"github.com/gabriel-vasile/mimetype"
 	"github.com/gotd/td/telegram/downloader"
@@ -115,3 +117,80 @@ func FileFromMedia(media tg.MessageMediaClass, client downloader.Client) (TGFile
 	}
 	return nil, fmt.Errorf("unsupported media type: %T", media)
 }
+
+type photoThumb struct {
+	Type string
+	Dim  int // 长边像素
+	Size int64
+}
+
+// pickPhotoThumb 返回长边不小于 size 的最小缩略图, 都小于 size 时返回最大的一个
+func pickPhotoThumb(sizes []tg.PhotoSizeClass, size int) *photoThumb {
+	thumbs := make([]photoThumb, 0, len(sizes))
+	for _, s := range sizes {
+		switch ps := s.(type) {
+		case *tg.PhotoSize:
+			thumbs = append(thumbs, photoThumb{Type: ps.Type, Dim: max(ps.W, ps.H), Size: int64(ps.Size)})
+		case *tg.PhotoCachedSize:
+			thumbs = append(thumbs, photoThumb{Type: ps.Type, Dim: max(ps.W, ps.H), Size: int64(len(ps.Bytes))})
+		case *tg.PhotoSizeProgressive:
+			var total int64
+			if len(ps.Sizes) > 0 {
+				total = int64(ps.Sizes[len(ps.Sizes)-1])
+			}
+			thumbs = append(thumbs, photoThumb{Type: ps.Type, Dim: max(ps.W, ps.H), Size: total})
+		}
+		// PhotoStrippedSize 和 PhotoPathSize 无法单独下载, 忽略
+	}
+	if len(thumbs) == 0 {
+		return nil
+	}
+	slices.SortFunc(thumbs, func(a, b photoThumb) int {
+		return cmp.Compare(a.Dim, b.Dim)
+	})
+	for _, t := range thumbs {
+		if t.Dim >= size {
+			return &t
+		}
+	}
+	return &thumbs[len(thumbs)-1]
+}
+
+// ThumbFromMedia 返回图片或文档 (视频等) 的缩略图文件, 按 pickPhotoThumb 的规则选择尺寸
+func ThumbFromMedia(media tg.MessageMediaClass, client downloader.Client, size int) (TGFile, error) {
+	switch m := media.(type) {
+	case *tg.MessageMediaPhoto:
+		photo, ok := m.Photo.AsNotEmpty()
+		if !ok {
+			return nil, errors.New("photo is empty")
+		}
+		thumb := pickPhotoThumb(photo.Sizes, size)
+		if thumb == nil {
+			return nil, errors.New("photo has no downloadable size")
+		}
+		location := &tg.InputPhotoFileLocation{
+			ID:            photo.GetID(),
+			AccessHash:    photo.GetAccessHash(),
+			FileReference: photo.GetFileReference(),
+			ThumbSize:     thumb.Type,
+		}
+		return NewTGFile(location, client, thumb.Size, fmt.Sprintf("thumb_%d_%s.jpg", photo.GetID(), thumb.Type)), nil
+	case *tg.MessageMediaDocument:
+		document, ok := m.Document.AsNotEmpty()
+		if !ok {
+			return nil, errors.New("document is empty")
+		}
+		thumb := pickPhotoThumb(document.Thumbs, size)
+		if thumb == nil {
+			return nil, errors.New("document has no thumbnail")
+		}
+		location := &tg.InputDocumentFileLocation{
+			ID:            document.GetID(),
+			AccessHash:    document.GetAccessHash(),
+			FileReference: document.GetFileReference(),
+			ThumbSize:     thumb.Type,
+		}
+		return NewTGFile(location, client, thumb.Size, fmt.Sprintf("thumb_%d_%s.jpg", document.GetID(), thumb.Type)), nil
+	}
+	return nil, fmt.Errorf("unsupported media type for thumbnail: %T", media)
+}
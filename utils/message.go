@@ -8,16 +8,17 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/charmbracelet/log"
 	"github.com/duke-git/lancet/v2/slice"
 	"github.com/gotd/td/telegram/message/styling"
 	"github.com/gotd/td/tg"
 	"github.com/krau/btts/config"
 	"github.com/krau/btts/database"
+	"github.com/krau/btts/metrics"
 	"github.com/krau/btts/types"
 	"github.com/krau/btts/utils/cache"
 	"github.com/krau/mygotg/ext"
@@ -64,7 +65,7 @@ func paddleOcr(ctx context.Context, client *ext.Context, media tg.MessageMediaCl
 	if err != nil {
 		return "", err
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.C.Ocr.Paddle.Url, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, config.C().Ocr.Paddle.Url, bytes.NewReader(jsonData))
 	if err != nil {
 		return "", err
 	}
@@ -84,7 +85,7 @@ func paddleOcr(ctx context.Context, client *ext.Context, media tg.MessageMediaCl
 	var ocrText strings.Builder
 	for _, ocr := range result.Result.OcrResults {
 		for i, text := range ocr.PrunedResult.RecTexts {
-			if i < len(ocr.PrunedResult.RecScores) && ocr.PrunedResult.RecScores[i] < config.C.Ocr.Paddle.Threshold {
+			if i < len(ocr.PrunedResult.RecScores) && ocr.PrunedResult.RecScores[i] < config.C().Ocr.Paddle.Threshold {
 				continue
 			}
 			ocrText.WriteString(text + " ")
@@ -93,13 +94,59 @@ func paddleOcr(ctx context.Context, client *ext.Context, media tg.MessageMediaCl
 	return strings.TrimSpace(ocrText.String()), nil
 }
 
+// PingOcr 检查 OCR 服务是否可达. PaddleX 服务提供 /health, 其他实现可能没有该路径,
+// 因此只要服务有响应且不是 5xx 就认为可用
+func PingOcr(ctx context.Context) error {
+	switch config.C().Ocr.Type {
+	case "paddle", "paddleocr":
+	default:
+		return fmt.Errorf("unsupported ocr type: %s", config.C().Ocr.Type)
+	}
+	return PingPaddleOcr(ctx, config.C().Ocr.Paddle.Url)
+}
+
+// PingPaddleOcr 请求 PaddleOCR 服务地址所在主机的 /health
+func PingPaddleOcr(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid paddle ocr url: %w", err)
+	}
+	u.Path, u.RawQuery = "/health", ""
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("paddle ocr health check failed with status: %s", resp.Status)
+	}
+	return nil
+}
+
 type MessageMediaExtractResult struct {
-	Text  string
-	Ocred string
-	Type  types.MessageType
+	Text string
+	Type types.MessageType
+}
+
+// OcrPhoto 下载图片并调用配置的 OCR 服务识别其中的文字
+func OcrPhoto(ctx context.Context, client *ext.Context, media tg.MessageMediaClass) (string, error) {
+	switch config.C().Ocr.Type {
+	case "paddle", "paddleocr":
+		start := time.Now()
+		text, err := paddleOcr(ctx, client, media)
+		metrics.ObserveOcr("paddle", start, err)
+		return text, err
+	default:
+		return "", fmt.Errorf("unsupported ocr type: %s", config.C().Ocr.Type)
+	}
 }
 
-func ExtractMessageMediaText(ctx context.Context, client *ext.Context, media tg.MessageMediaClass, enableOcr bool) *MessageMediaExtractResult {
+// ExtractMessageMediaText 提取媒体中可索引的文本, 不进行 OCR, 图片的 OCR 由 ocr 包异步完成
+func ExtractMessageMediaText(media tg.MessageMediaClass) *MessageMediaExtractResult {
 	result := &MessageMediaExtractResult{
 		Type: types.MessageTypeText,
 	}
@@ -107,21 +154,6 @@ func ExtractMessageMediaText(ctx context.Context, client *ext.Context, media tg.
 	switch m := media.(type) {
 	case *tg.MessageMediaPhoto:
 		result.Type = types.MessageTypePhoto
-		if config.C.Ocr.Enable && enableOcr {
-			switch config.C.Ocr.Type {
-			case "paddle", "paddleocr":
-				ocrText, err := paddleOcr(ctx, client, media)
-				if ocrText != "" && err == nil {
-					result.Ocred = ocrText
-					log.FromContext(ctx).Debug("Paddle OCR succeeded", "text", ocrText)
-				} else if err != nil {
-					log.FromContext(ctx).Warnf("Paddle OCR failed: %v", err)
-				}
-			default:
-				// do nothing
-			}
-
-		}
 	case *tg.MessageMediaDocument:
 		docClass, ok := m.GetDocument()
 		if !ok {
@@ -232,7 +264,8 @@ func ExtractMessageMediaText(ctx context.Context, client *ext.Context, media tg.
 	return result
 }
 
-func BuildSearchReplyMarkup(ctx context.Context, currentPage int64, data types.SearchRequest) (*tg.ReplyInlineMarkup, error) {
+// BuildSearchReplyMarkup 构建搜索结果的按钮, export 为 true 时添加导出按钮, 只有注册了 export 回调的 bot 才应该开启
+func BuildSearchReplyMarkup(ctx context.Context, currentPage int64, data types.SearchRequest, export bool) (*tg.ReplyInlineMarkup, error) {
 	cacheid := xid.New().String()
 	if err := cache.Set(cacheid, data, cache.DefaultTTL); err != nil {
 		return nil, err
@@ -256,7 +289,7 @@ func BuildSearchReplyMarkup(ctx context.Context, currentPage int64, data types.S
 		Buttons: mtbuttons[4:],
 	}
 
-	return &tg.ReplyInlineMarkup{
+	markup := &tg.ReplyInlineMarkup{
 		Rows: []tg.KeyboardButtonRow{
 			*messageTypeFilterRow1,
 			*messageTypeFilterRow2,
@@ -277,7 +310,18 @@ func BuildSearchReplyMarkup(ctx context.Context, currentPage int64, data types.S
 				},
 			},
 		},
-	}, nil
+	}
+	if export {
+		markup.Rows = append(markup.Rows, tg.KeyboardButtonRow{
+			Buttons: []tg.KeyboardButtonClass{
+				&tg.KeyboardButtonCallback{Text: "导出 JSON", Data: fmt.Appendf(nil, "export json %s", cacheid)},
+				&tg.KeyboardButtonCallback{Text: "CSV", Data: fmt.Appendf(nil, "export csv %s", cacheid)},
+				&tg.KeyboardButtonCallback{Text: "HTML", Data: fmt.Appendf(nil, "export html %s", cacheid)},
+				&tg.KeyboardButtonCallback{Text: "Markdown", Data: fmt.Appendf(nil, "export md %s", cacheid)},
+			},
+		})
+	}
+	return markup, nil
 }
 
 func BuildResultStyling(ctx context.Context, resp *types.SearchResponse, botUsername ...string) []styling.StyledTextOption {
@@ -327,7 +371,11 @@ func BuildResultStyling(ctx context.Context, resp *types.SearchResponse, botUser
 			}
 			return fmt.Sprintf("https://t.me/%s/?start=fav_%d_%d", botUsername[0], hit.ChatID, hit.ID)
 		}()
-		hitFormattedMsg := types.MessageTypeToEmoji[types.MessageType(hit.Type)] + " " + strings.ReplaceAll(hit.FullFormattedText(), "\n", " ")
+		hitFormattedMsg := types.MessageTypeToEmoji[types.MessageType(hit.Type)] + " "
+		if hit.AlbumSize > 1 {
+			hitFormattedMsg += fmt.Sprintf("[相册 %d 项] ", hit.AlbumSize)
+		}
+		hitFormattedMsg += strings.ReplaceAll(hit.FullFormattedText(), "\n", " ")
 		resultStyling = append(resultStyling, styling.TextURL(hitFormattedMsg, msgLink))
 	}
 
@@ -375,3 +423,31 @@ func GetMessageByID(ctx *ext.Context, chatID int64, msgID int) (*tg.Message, err
 	cache.Set(key, tgm, cache.DefaultTTL)
 	return tgm, nil
 }
+
+// MessageSenderID 返回索引时记录的消息发送者 ID: 自己发送的消息为 self, 频道的帖子为频道 ID,
+// 无法确定发送者时返回 0
+func MessageSenderID(message *tg.Message, self int64) int64 {
+	switch chatPeer := message.GetPeerID().(type) {
+	case *tg.PeerUser:
+		if message.GetOut() {
+			return self
+		}
+		return chatPeer.GetUserID()
+	case *tg.PeerChannel:
+		if message.GetPost() {
+			return chatPeer.GetChannelID()
+		}
+		if message.GetOut() {
+			return self
+		}
+		switch from := message.FromID.(type) {
+		case *tg.PeerChat:
+			return from.GetChatID()
+		case *tg.PeerUser:
+			return from.GetUserID()
+		case *tg.PeerChannel:
+			return from.GetChannelID()
+		}
+	}
+	return 0
+}
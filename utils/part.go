@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/gotd/td/tg"
+)
+
+// FilePartSize 是按分片下载时的分片大小.
+// upload.getFile 要求 offset 和 limit 对齐且单次请求不跨越 1MB 边界, 按 1MB 对齐可以同时满足
+const FilePartSize = 1024 * 1024
+
+// DownloadPart 下载文件的第 index 个分片, 最后一个分片可能不足 FilePartSize
+func DownloadPart(ctx context.Context, file TGFile, index int64) ([]byte, error) {
+	res, err := file.Client().UploadGetFile(ctx, &tg.UploadGetFileRequest{
+		Location: file.Location(),
+		Offset:   index * FilePartSize,
+		Limit:    FilePartSize,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download part %d: %w", index, err)
+	}
+	switch r := res.(type) {
+	case *tg.UploadFile:
+		return r.Bytes, nil
+	case *tg.UploadFileCDNRedirect:
+		return nil, errors.New("CDN redirect is not supported for partial downloads")
+	default:
+		return nil, fmt.Errorf("unexpected upload file type: %T", res)
+	}
+}